@@ -1,26 +1,81 @@
 package config
 
 import (
+	"time"
+
 	"github.com/negbie/logp"
 )
 
 var Cfg Config
 
 type Config struct {
-	Iface         *InterfacesConfig
-	Logging       *logp.Logging
-	Bench         bool
-	Mode          string
-	Dedup         bool
-	Filter        string
-	Discard       string
-	DiscardMethod string
-	Zip           bool
-	HepServer     string
-	HepNodePW     string
-	HepNodeID     uint
-	Network       string
-	Protobuf      bool
+	Iface              *InterfacesConfig
+	Logging            *logp.Logging
+	Bench              bool
+	DryRun             int
+	ExtractCall        string
+	ExtractFile        string
+	WarmupSeconds      int
+	Mode               string
+	CaptureMode        string
+	Dedup              bool
+	DedupWindow        time.Duration
+	TimeSource         string
+	MaxClockSkew       time.Duration
+	FlowCache          bool
+	WithDSCP           bool
+	RTPEvents          bool
+	VADGap             time.Duration
+	CallMOS            bool
+	MOSCodecs          string
+	SweepInterval      time.Duration
+	IdleTimeout        time.Duration
+	Radius             bool
+	RequireCallID      bool
+	ExportParseErrors  bool
+	Trunks             string
+	SetupBuckets       string
+	SIPRTT             bool
+	CorrelationID      string
+	CacheDumpAddr      string
+	SIPPorts           string
+	StrictSIP          bool
+	TolerantSIP        bool
+	MaxSIPHeaders      int
+	Filter             string
+	Discard            string
+	DiscardMethod      string
+	ReloadFile         string
+	Zip                bool
+	HepServer          string
+	HepNodePW          string
+	HepNodeID          uint
+	HepNodeIDs         string
+	LocalIPs           string
+	ProtoTypes         string
+	Network            string
+	Protobuf           bool
+	HepVersion         int
+	HeaderChunks       string
+	ExportFragments    bool
+	StartEvent         bool
+	SplitUDP           bool
+	MessageHash        bool
+	Version            string
+	SDPConflict        string
+	MirrorHeaders      string
+	MaxPayloadExport   int
+	Scan               bool
+	ScanPatterns       string
+	ScanRate           int
+	DialogCID          bool
+	RTPQoS             time.Duration
+	MediaStatsInterval time.Duration
+	IncludeIPs         string
+	ExcludeIPs         string
+	ExportWireLength   bool
+	ExportMetadata     bool
+	CDRAddr            string
 }
 
 type InterfacesConfig struct {
@@ -32,6 +87,7 @@ type InterfacesConfig struct {
 	PortRange    string `config:"port_range"`
 	WithVlan     bool   `config:"with_vlan"`
 	WithErspan   bool   `config:"with_erspan"`
+	WithL2TP     bool   `config:"with_l2tp"`
 	Snaplen      int    `config:"snaplen"`
 	BufferSizeMb int    `config:"buffer_size_mb"`
 	ReadSpeed    bool   `config:"top_speed"`
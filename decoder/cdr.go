@@ -0,0 +1,141 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+const (
+	// cdrTTL keeps a CDR for SIP which arrives later like the SDPCache
+	cdrTTL = 43200
+	// maxCDRBody limits the size of one push of CDRs
+	maxCDRBody = 1 << 20
+	// cdrTimeout limits the time to read a push and to write the answer
+	cdrTimeout = 30 * time.Second
+)
+
+// serveCDR accepts CDRs of a billing system by HTTP POST under /cdr.
+func (d *Decoder) serveCDR(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cdr", d.handleCDR)
+	logp.Info("Accept CDRs on http://%s/cdr", addr)
+	srv := &http.Server{Addr: addr, Handler: mux, ReadTimeout: cdrTimeout, WriteTimeout: cdrTimeout}
+	if err := srv.ListenAndServe(); err != nil {
+		logp.Err("cdr: %v", err)
+	}
+}
+
+// handleCDR reads one or more JSON objects with a call_id like
+//
+//	{"call_id":"a84b4c76e66710","account":"1001","trunk":"carrierA","rate":0.01}
+//
+// The objects before an invalid one are kept.
+func (d *Decoder) handleCDR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "CDRs must be sent by POST", http.StatusMethodNotAllowed)
+		return
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxCDRBody))
+	var n int
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("CDR %d: %v", n+1, err), http.StatusBadRequest)
+			return
+		}
+		if err := d.addCDR(raw, time.Now()); err != nil {
+			http.Error(w, fmt.Sprintf("CDR %d: %v", n+1, err), http.StatusBadRequest)
+			return
+		}
+		n++
+	}
+	logp.Debug("cdr", "Added %d CDRs from %s", n, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addCDR sends the CDR as HEP log correlated to the Call-ID if the SIP of
+// the call was seen before. Otherwise it is cached until the SIP comes.
+func (d *Decoder) addCDR(raw []byte, now time.Time) error {
+	// Numbers like account IDs are kept as they were sent
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return err
+	}
+	callID, _ := fields["call_id"].(string)
+	if callID == "" {
+		return fmt.Errorf("missing call_id")
+	}
+	fields["type"] = "cdr"
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	logp.Debug("cdr", "Found CallID: %s in CDR: %s", callID, string(data))
+
+	d.cdrMu.Lock()
+	cid, err := d.CDRSeenCache.Get([]byte(callID))
+	if err != nil {
+		err = d.CDRCache.Set([]byte(callID), data, cdrTTL)
+		d.cdrMu.Unlock()
+		return err
+	}
+	d.cdrMu.Unlock()
+	d.sendCDR(cid, data, now)
+	return nil
+}
+
+// flushCDR sends the cached CDR of the call the first time one of its SIP
+// packets is seen and marks the call as seen.
+func (d *Decoder) flushCDR(pkt *Packet) {
+	// CDRs come by Call-ID, but are sent with the dialog key of -dcid
+	cid := d.sipCID(pkt)
+	if cid == nil {
+		return
+	}
+	callID := DialogCallID(cid)
+	d.cdrMu.Lock()
+	if _, err := d.CDRSeenCache.Get(callID); err == nil {
+		d.cdrMu.Unlock()
+		return
+	}
+	if err := d.CDRSeenCache.Set(callID, cid, cdrTTL); err != nil {
+		logp.Warn("%v", err)
+	}
+	cdr, err := d.CDRCache.Get(callID)
+	if err == nil {
+		d.CDRCache.Del(callID)
+	}
+	d.cdrMu.Unlock()
+	if err == nil {
+		d.sendCDR(cid, cdr, time.Unix(int64(pkt.Tsec), int64(pkt.Tmsec)*1000))
+	}
+}
+
+// sendCDR sends the CDR log of a call with the time ts.
+func (d *Decoder) sendCDR(callID, data []byte, ts time.Time) {
+	d.sendEvent(&Packet{
+		Version:   0x02,
+		Protocol:  0x11,
+		SrcIP:     net.IPv4(127, 0, 0, 1).To4(),
+		DstIP:     net.IPv4(127, 0, 0, 1).To4(),
+		Tsec:      uint32(ts.Unix()),
+		Tmsec:     uint32(ts.Nanosecond() / 1000),
+		ProtoType: 100,
+		NodeID:    d.NodeID,
+		NodePW:    d.loadFilters().nodePW,
+		Payload:   data,
+		CID:       callID,
+	})
+}
@@ -2,10 +2,14 @@ package decoder
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"net"
 	"strconv"
+	"strings"
 
+	"github.com/negbie/heplify/config"
 	"github.com/negbie/heplify/protos"
 	"github.com/negbie/logp"
 )
@@ -14,14 +18,20 @@ import (
 // It will do this only for SIP messages which have the strings "c=IN IP4 " and "m=audio " in the SDP body.
 // If there is one rtcp attribute in the SDP body it will use it as RTCP port. Otherwise it will add 1 to
 // the RTP source port. These data will be used for the SDPCache as key:value pairs.
+// Media grouped with a=group:BUNDLE share one transport, so only the port of the bundle
+// is cached and with a=rtcp-mux RTCP is expected on that same port.
+// Bodies with Content-Encoding gzip are decompressed first. Lines may end with CRLF or a bare LF.
+// Of multipart bodies only the SDP part with Content-Disposition session is used.
 func (d *Decoder) cacheSDPIPPort(payload []byte) {
+	payload = sessionSDP(gunzipBody(payload))
 	if posSDPIP, posSDPPort := bytes.Index(payload, []byte("c=IN IP")), bytes.Index(payload, []byte("m=audio ")); posSDPIP > 0 && posSDPPort > 0 {
 		var callID []byte
 		var ipPort bytes.Buffer
+		bundle, bundled := protos.ParseSDPBundle(payload)
 
 		restIP := payload[posSDPIP:]
 		// Minimum IPv4 length of "c=IN IP4 1.1.1.1" = 16
-		if posRestIP := bytes.Index(restIP, []byte("\r\n")); posRestIP >= 16 {
+		if posRestIP := indexLineEnd(restIP); posRestIP >= 16 {
 			ipPort.Write(restIP[len("c=IN IP")+2 : posRestIP])
 		} else {
 			logp.Debug("sdpwarn", "No end or fishy SDP IP in '%s'", string(restIP))
@@ -31,19 +41,33 @@ func (d *Decoder) cacheSDPIPPort(payload []byte) {
 		if posRTCPPort := bytes.Index(payload, []byte("a=rtcp:")); posRTCPPort > 0 {
 			restRTCPPort := payload[posRTCPPort:]
 			// Minimum RTCP port length of "a=rtcp:1000" = 11
-			if posRestRTCPPort := bytes.Index(restRTCPPort, []byte("\r\n")); posRestRTCPPort >= 11 {
+			if posRestRTCPPort := indexLineEnd(restRTCPPort); posRestRTCPPort >= 11 {
 				ipPort.Write(restRTCPPort[len("a=rtcp:"):posRestRTCPPort])
 			} else {
 				logp.Debug("sdpwarn", "No end or fishy SDP RTCP Port in '%s'", string(restRTCPPort))
 				return
 			}
+		} else if bundled && bundle.Port != "" {
+			port, err := strconv.Atoi(bundle.Port)
+			if err != nil {
+				logp.Debug("sdpwarn", "Fishy SDP BUNDLE Port in '%s'", bundle.Port)
+				return
+			}
+			if !bundle.RTCPMux {
+				port++
+			}
+			ipPort.WriteString(strconv.Itoa(port))
 		} else {
 			restPort := payload[posSDPPort:]
-			// Minimum RTCP port length of "m=audio 1000" = 12
-			if posRestPort := bytes.Index(restPort, []byte(" RTP")); posRestPort >= 12 {
-				ipPort.Write(restPort[len("m=audio "):posRestPort])
-				lastNum := len(ipPort.Bytes()) - 1
-				ipPort.Bytes()[lastNum] = byte(uint32(ipPort.Bytes()[lastNum]) + 1)
+			// The port ends before the proto like RTP/AVP or UDP/TLS/RTP/SAVPF of WebRTC
+			if posRestPort := bytes.IndexByte(restPort[len("m=audio "):], ' '); posRestPort > 0 {
+				port, err := strconv.Atoi(string(restPort[len("m=audio ") : len("m=audio ")+posRestPort]))
+				// Port 0 disables the media
+				if err != nil || port <= 0 {
+					logp.Debug("sdpwarn", "Fishy SDP RTP Port in '%s'", string(restPort))
+					return
+				}
+				ipPort.WriteString(strconv.Itoa(port + 1))
 			} else {
 				logp.Debug("sdpwarn", "No end or fishy SDP RTP Port in '%s'", string(restPort))
 				return
@@ -53,7 +77,7 @@ func (d *Decoder) cacheSDPIPPort(payload []byte) {
 		if posCallID := bytes.Index(payload, []byte("Call-ID: ")); posCallID > 0 {
 			restCallID := payload[posCallID:]
 			// Minimum Call-ID length of "Call-ID: a" = 10
-			if posRestCallID := bytes.Index(restCallID, []byte("\r\n")); posRestCallID >= 10 {
+			if posRestCallID := indexLineEnd(restCallID); posRestCallID >= 10 {
 				callID = restCallID[len("Call-ID: "):posRestCallID]
 			} else {
 				logp.Debug("sdpwarn", "No end or fishy Call-ID in '%s'", string(restCallID))
@@ -62,7 +86,7 @@ func (d *Decoder) cacheSDPIPPort(payload []byte) {
 		} else if posCallID := bytes.Index(payload, []byte("Call-ID:")); posCallID > 0 {
 			restCallID := payload[posCallID:]
 			// Minimum Call-ID length of "Call-ID:a" = 9
-			if posRestCallID := bytes.Index(restCallID, []byte("\r\n")); posRestCallID >= 9 {
+			if posRestCallID := indexLineEnd(restCallID); posRestCallID >= 9 {
 				callID = restCallID[len("Call-ID:"):posRestCallID]
 			} else {
 				logp.Debug("sdpwarn", "No end or fishy Call-ID in '%s'", string(restCallID))
@@ -71,7 +95,7 @@ func (d *Decoder) cacheSDPIPPort(payload []byte) {
 		} else if posID := bytes.Index(payload, []byte("i: ")); posID > 0 {
 			restID := payload[posID:]
 			// Minimum Call-ID length of "i: a" = 4
-			if posRestID := bytes.Index(restID, []byte("\r\n")); posRestID >= 4 {
+			if posRestID := indexLineEnd(restID); posRestID >= 4 {
 				callID = restID[len("i: "):posRestID]
 			} else {
 				logp.Debug("sdpwarn", "No end or fishy Call-ID in '%s'", string(restID))
@@ -82,19 +106,364 @@ func (d *Decoder) cacheSDPIPPort(payload []byte) {
 			return
 		}
 
-		logp.Debug("sdp", "Add to SDPCache key=%s, value=%s", ipPort.String(), string(callID))
-		err := d.SDPCache.Set(ipPort.Bytes(), callID, 120)
+		if config.Cfg.DialogCID {
+			callID = d.dialogKey(payload, callID)
+		}
+
+		if !d.cacheSDPOrigin(callID, payload) {
+			if _, err := d.SDPCache.Get(ipPort.Bytes()); err == nil {
+				logp.Debug("sdp", "Skip retransmitted SDP of %s", string(callID))
+				return
+			}
+		}
+
+		d.setSDPCallID(ipPort.Bytes(), callID)
+
+		d.cacheSDPCandidates(callID, payload)
+		d.cacheSDPDTLS(callID, payload)
+		d.cacheSDPCrypto(callID, payload)
+		d.cacheSDPCodec(callID, payload)
+		if config.Cfg.RTPEvents {
+			d.cacheSDPEvent(callID, payload)
+		}
+		if d.vad.streams != nil {
+			d.cacheSDPComfortNoise(callID, payload)
+		}
+		if bundled {
+			d.cacheSDPBundle(callID, bundle)
+		}
+	}
+}
+
+// cacheSDPOrigin will remember the o= session-id and version of the SDP with the CallID as key.
+// It returns false if the same session version was already seen, so retransmissions don't refresh
+// the cache while a re-INVITE with a new version and maybe new media is added again.
+// Each side of a call has its own session-id, so both are kept.
+func (d *Decoder) cacheSDPOrigin(callID, payload []byte) bool {
+	origin, ok := protos.ParseSDPOrigin(payload)
+	if !ok {
+		return true
+	}
+	key := originKey(callID, origin)
+	if version, err := d.SDPCache.Get(key); err == nil && string(version) == origin.Version {
+		return false
+	}
+	logp.Debug("sdp", "Add to SDPCache key=%s, value=%s", string(key), origin.Version)
+	err := d.SDPCache.Set(key, []byte(origin.Version), 43200)
+	if err != nil {
+		logp.Warn("%v", err)
+	}
+	return true
+}
+
+// SDPVersion returns the last seen SDP version of a session in a call or nil.
+func (d *Decoder) SDPVersion(callID []byte, origin protos.SDPOrigin) []byte {
+	version, err := d.SDPCache.Get(originKey(callID, origin))
+	if err != nil {
+		return nil
+	}
+	return version
+}
+
+// cacheSDPBundle will add the bundled media types like "audio video"
+// to the SDPCache with the CallID as key.
+func (d *Decoder) cacheSDPBundle(callID []byte, bundle protos.SDPBundle) {
+	media := strings.Join(bundle.Media, " ")
+	logp.Debug("sdp", "Add to SDPCache key=%s, value=%s", string(callID), media)
+	err := d.SDPCache.Set(bundleKey(callID), []byte(media), 43200)
+	if err != nil {
+		logp.Warn("%v", err)
+	}
+}
+
+// SDPBundle returns the bundled media types of a call or nil.
+func (d *Decoder) SDPBundle(callID []byte) []byte {
+	media, err := d.SDPCache.Get(bundleKey(callID))
+	if err != nil {
+		return nil
+	}
+	return media
+}
+
+// cacheSDPCodec will add the first audio codec of the SDP like PCMA/8000 to the
+// SDPCache with the CallID as key. The answer comes after the offer, so the
+// cache ends up with the negotiated codec.
+func (d *Decoder) cacheSDPCodec(callID, payload []byte) {
+	for _, media := range protos.ParseSDPMedia(payload) {
+		if media.Media != "audio" || media.Port == "0" {
+			continue
+		}
+		codec := media.Codec()
+		if codec == "" {
+			return
+		}
+		logp.Debug("sdp", "Add to SDPCache key=%s, value=%s", string(callID), codec)
+		err := d.SDPCache.Set(codecKey(callID), []byte(codec), 43200)
 		if err != nil {
 			logp.Warn("%v", err)
 		}
+		return
+	}
+}
+
+// SDPCodec returns the negotiated audio codec of a call like PCMA/8000 or nil.
+func (d *Decoder) SDPCodec(callID []byte) []byte {
+	codec, err := d.SDPCache.Get(codecKey(callID))
+	if err != nil {
+		return nil
 	}
+	return codec
+}
+
+// cacheSDPEvent will add the payload type of telephone-event in the first
+// audio section to the SDPCache with the CallID as key.
+func (d *Decoder) cacheSDPEvent(callID, payload []byte) {
+	for _, media := range protos.ParseSDPMedia(payload) {
+		if media.Media != "audio" || media.Port == "0" {
+			continue
+		}
+		pt := media.EventPayloadType()
+		if pt < 0 {
+			return
+		}
+		logp.Debug("sdp", "Add to SDPCache key=%s, value=%d", string(callID), pt)
+		err := d.SDPCache.Set(eventKey(callID), []byte(strconv.Itoa(pt)), 43200)
+		if err != nil {
+			logp.Warn("%v", err)
+		}
+		return
+	}
+}
+
+// correlateRTPEvent will decode RFC 4733 DTMF events of RTP packets whose payload
+// type matches the telephone-event of the call. The call is found by the SSRC in
+// the RTCPCache or by the media address in the SDPCache. Only the end of an
+// event is returned once, its retransmissions are skipped.
+func (d *Decoder) correlateRTPEvent(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payload []byte) ([]byte, []byte, byte) {
+	if len(payload) < 16 {
+		return nil, nil, 0
+	}
+	callID, err := d.RTCPCache.Get(payload[8:12])
+	if err != nil {
+		if callID = d.MediaCallID(srcIP, srcPort); callID == nil {
+			if callID = d.MediaCallID(dstIP, dstPort); callID == nil {
+				return nil, nil, 0
+			}
+		}
+	}
+	pt, err := d.SDPCache.Get(eventKey(callID))
+	if err != nil {
+		return nil, nil, 0
+	}
+	payloadType, err := strconv.Atoi(string(pt))
+	if err != nil {
+		return nil, nil, 0
+	}
+	event, ok := protos.ParseRTPEvent(payload, payloadType)
+	if !ok || !event.End {
+		return nil, nil, 0
+	}
+	// The end packet is sent three times with the same timestamp
+	key := []byte(fmt.Sprintf("rtp-event %d %d", event.SSRC, event.Timestamp))
+	if _, err := d.SDPCache.Get(key); err == nil {
+		return nil, nil, 0
+	}
+	if err := d.SDPCache.Set(key, nil, 10); err != nil {
+		logp.Warn("%v", err)
+	}
+	data, err := json.Marshal(&struct {
+		Type string `json:"type"`
+		protos.RTPEvent
+	}{"rtp-event", event})
+	if err != nil {
+		logp.Warn("%v", err)
+		return nil, nil, 0
+	}
+	logp.Debug("rtp", "Found DTMF %s of %s", string(data), string(callID))
+	return data, callID, 100
+}
+
+// maxSDPCallIDs limits the calls remembered per SDP media address with -sdpc multi
+const maxSDPCallIDs = 4
+
+// setSDPCallID will add callID to the SDPCache for the SDP media address key.
+// When another call already announced the same address, e.g. behind a NAT
+// with port reuse, the conflict is counted and resolved by -sdpc: overwrite
+// replaces the older call, keep-first ignores the new one and multi keeps
+// the newest maxSDPCallIDs calls newline separated, newest first.
+func (d *Decoder) setSDPCallID(key, callID []byte) {
+	value := callID
+	if old, err := d.SDPCache.Get(key); err == nil && !bytes.Equal(old, callID) {
+		ids := sdpCallIDs(old)
+		known := false
+		for _, id := range ids {
+			if bytes.Equal(id, callID) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			d.sdpConflictCount++
+			logp.Debug("sdpwarn", "SDP of %s reuses %s of %s", string(callID), string(key), string(old))
+		}
+		switch config.Cfg.SDPConflict {
+		case "keep-first":
+			return
+		case "multi":
+			value = append([]byte{}, callID...)
+			for i := 0; i < len(ids) && i < maxSDPCallIDs-1; i++ {
+				if !bytes.Equal(ids[i], callID) {
+					value = append(append(value, '\n'), ids[i]...)
+				}
+			}
+			logp.Debug("sdp", "Add to SDPCache key=%s, value=%q", string(key), string(value))
+			if err := d.SDPCache.Set(key, value, 120); err != nil {
+				logp.Warn("%v", err)
+			}
+			return
+		}
+	}
+
+	d.invalidateRTCP(key, callID)
+	logp.Debug("sdp", "Add to SDPCache key=%s, value=%s", string(key), string(value))
+	if err := d.SDPCache.Set(key, value, 120); err != nil {
+		logp.Warn("%v", err)
+	}
+}
+
+// sdpCallIDs splits the SDPCache value of a media address into its Call-IDs,
+// newest first. Only -sdpc multi stores more than one.
+func sdpCallIDs(value []byte) [][]byte {
+	return bytes.Split(value, []byte{'\n'})
+}
+
+// pickSDPCallID returns the Call-ID of ids for the RTCP payload. With several
+// calls on one media address it prefers the call whose SSRC is reported on in
+// the report blocks, otherwise the call with the newest SDP.
+func (d *Decoder) pickSDPCallID(ids [][]byte, payload []byte) []byte {
+	if len(ids) == 1 {
+		return ids[0]
+	}
+	ssrc := make([]byte, 4)
+	for _, block := range protos.RTCPReportBlocks(payload) {
+		binary.BigEndian.PutUint32(ssrc, block.SourceSsrc)
+		corrID, err := d.RTCPCache.Get(ssrc)
+		if err != nil {
+			continue
+		}
+		for _, id := range ids {
+			if bytes.Equal(id, corrID) {
+				logp.Debug("rtcp", "Pick %s of %d calls by reported ssrc=%d", string(id), len(ids), block.SourceSsrc)
+				return id
+			}
+		}
+	}
+	return ids[0]
+}
+
+// MediaCallID returns the Call-ID of the SDP which announced ip and port for
+// RTP or RTCP or nil. The SDPCache is keyed by the RTCP port, which is the RTP
+// port plus one unless RTCP is multiplexed.
+func (d *Decoder) MediaCallID(ip net.IP, port uint16) []byte {
+	ipString := ip.String()
+	for _, p := range []int{int(port), int(port) + 1} {
+		if callID, err := d.SDPCache.Get([]byte(ipString + strconv.Itoa(p))); err == nil {
+			return sdpCallIDs(callID)[0]
+		}
+	}
+	return nil
+}
+
+// cacheSDPCandidates will add the addresses of the UDP ICE candidates of the audio
+// sections to the SDPCache with the CallID as value. Behind a NAT WebRTC media
+// flows between srflx or relay candidates and not the c= and m= address. RTP
+// and RTCP share one candidate port with rtcp-mux, so the ports are kept as is.
+func (d *Decoder) cacheSDPCandidates(callID, payload []byte) {
+	for _, media := range protos.ParseSDPMedia(payload) {
+		if media.Media != "audio" || media.Port == "0" {
+			continue
+		}
+		for _, c := range media.Candidates {
+			// mDNS candidates like 1f4a.local hide the address
+			ip := net.ParseIP(c.Address)
+			if c.Transport != "udp" || c.Port == 0 || ip == nil {
+				continue
+			}
+			key := []byte(ip.String() + strconv.Itoa(c.Port))
+			logp.Debug("sdp", "Add %s candidate to SDPCache key=%s, value=%s", c.Type, string(key), string(callID))
+			if err := d.SDPCache.Set(key, callID, 120); err != nil {
+				logp.Warn("%v", err)
+			}
+		}
+	}
+}
+
+// cacheSDPDTLS will add the DTLS fingerprint and setup role of every media section
+// as JSON to the SDPCache with the CallID as key. Later offers and answers of the
+// same call overwrite it.
+func (d *Decoder) cacheSDPDTLS(callID, payload []byte) {
+	dtls := protos.ParseSDPDTLS(payload)
+	if dtls == nil {
+		return
+	}
+	data, err := json.Marshal(dtls)
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	logp.Debug("sdp", "Add to SDPCache key=%s, value=%s", string(callID), string(data))
+	err = d.SDPCache.Set(dtlsKey(callID), data, 43200)
+	if err != nil {
+		logp.Warn("%v", err)
+	}
+}
+
+// SDPDTLS returns the cached DTLS parameters of a call as JSON or nil.
+func (d *Decoder) SDPDTLS(callID []byte) []byte {
+	data, err := d.SDPCache.Get(dtlsKey(callID))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// cacheSDPCrypto will add the SDES-SRTP crypto suites of every media section
+// as JSON to the SDPCache with the CallID as key. The keys themselves are not
+// cached, so this only tells whether and how the media was encrypted.
+func (d *Decoder) cacheSDPCrypto(callID, payload []byte) {
+	cryptos := protos.ParseSDPCrypto(payload)
+	if cryptos == nil {
+		return
+	}
+	data, err := json.Marshal(cryptos)
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	logp.Debug("sdp", "Add to SDPCache key=%s, value=%s", string(callID), string(data))
+	err = d.SDPCache.Set(cryptoKey(callID), data, 43200)
+	if err != nil {
+		logp.Warn("%v", err)
+	}
+}
+
+// SDPCrypto returns the cached SDES-SRTP crypto suites of a call as JSON or nil.
+func (d *Decoder) SDPCrypto(callID []byte) []byte {
+	data, err := d.SDPCache.Get(cryptoKey(callID))
+	if err != nil {
+		return nil
+	}
+	return data
 }
 
 // correlateRTCP will try to correlate RTCP data with SIP messages.
 // First it will look inside the longlive RTCPCache with the ssrc as key.
 // If it can't find a value it will look inside the shortlive SDPCache with (SDPIP+RTCPPort) as key.
 // If it finds a value inside the SDPCache it will add it to the RTCPCache with the ssrc as key.
-func (d *Decoder) correlateRTCP(srcIP net.IP, srcPort uint16, payload []byte) ([]byte, []byte, byte) {
+// When the FlowCache is enabled every correlated media 5-tuple is remembered, so packets of the
+// same flow still correlate when their source doesn't match the SDP, e.g. behind a NAT.
+// Such asymmetric RTCP is counted and its reports are marked with "asymmetric":true.
+func (d *Decoder) correlateRTCP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payload []byte) ([]byte, []byte, byte) {
 	srcIPString := srcIP.String()
 	srcPortString := strconv.Itoa(int(srcPort))
 	keySDP := []byte(srcIPString + srcPortString)
@@ -109,21 +478,119 @@ func (d *Decoder) correlateRTCP(srcIP net.IP, srcPort uint16, payload []byte) ([
 
 	if corrID, err := d.RTCPCache.Get(keyRTCP); err == nil && keyRTCP != nil {
 		logp.Debug("rtcp", "Found '%d:%s' in RTCPCache srcIP=%s, srcPort=%s, payload=%s", keyRTCP, string(corrID), srcIPString, srcPortString, string(jsonRTCP))
+		d.cacheFlow(srcIP, srcPort, dstIP, dstPort, corrID)
+		if _, err := d.RTCPCache.Get(asymKey(keyRTCP)); err == nil {
+			d.asymCount++
+			jsonRTCP = markAsymmetric(jsonRTCP)
+		}
 		return jsonRTCP, corrID, 5
 	} else if corrID, err := d.SDPCache.Get(keySDP); err == nil {
+		corrID = d.pickSDPCallID(sdpCallIDs(corrID), payload)
 		logp.Debug("rtcp", "Found '%s:%s' in SDPCache srcIP=%s, srcPort=%s, payload=%s", string(keySDP), string(corrID), srcIPString, srcPortString, string(jsonRTCP))
 		err = d.RTCPCache.Set(keyRTCP, corrID, 43200)
 		if err != nil {
 			logp.Warn("%v", err)
 			return nil, nil, 0
 		}
+		d.indexRTCP(keySDP, keyRTCP)
+		d.cacheFlow(srcIP, srcPort, dstIP, dstPort, corrID)
 		return jsonRTCP, corrID, 5
+	} else if d.FlowCache != nil {
+		keyFlow := flowKey(srcIP, srcPort, dstIP, dstPort)
+		if corrID, err := d.FlowCache.Get(keyFlow); err == nil {
+			logp.Debug("rtcp", "Found '%s:%s' in FlowCache payload=%s", string(keyFlow), string(corrID), string(jsonRTCP))
+			logp.Debug("rtcpwarn", "Asymmetric RTCP of %s from %s:%s which isn't in the SDP", string(corrID), srcIPString, srcPortString)
+			if keyRTCP != nil {
+				err = d.RTCPCache.Set(keyRTCP, corrID, 43200)
+				if err != nil {
+					logp.Warn("%v", err)
+				}
+				if err = d.RTCPCache.Set(asymKey(keyRTCP), []byte{1}, 43200); err != nil {
+					logp.Warn("%v", err)
+				}
+			}
+			d.asymCount++
+			return markAsymmetric(jsonRTCP), corrID, 5
+		}
 	}
 
 	logp.Debug("rtcpwarn", "No correlationID for srcIP=%s, srcPort=%s, payload=%s", srcIPString, srcPortString, string(jsonRTCP))
 	return nil, nil, 0
 }
 
+// markAsymmetric will add "asymmetric":true to a RTCP report whose sender
+// isn't the address of the SDP. This is typical for media behind a NAT.
+func markAsymmetric(report []byte) []byte {
+	if len(report) < 2 || report[0] != '{' {
+		return report
+	}
+	fields := `{"asymmetric":true`
+	if report[1] != '}' {
+		fields += ","
+	}
+	return append([]byte(fields), report[1:]...)
+}
+
+// maxSSRCsPerMedia limits the SSRCs remembered per SDP media address
+const maxSSRCsPerMedia = 8
+
+// indexRTCP will remember which SSRCs were correlated by the SDP media
+// address keySDP, so a later SDP for that address can invalidate them.
+func (d *Decoder) indexRTCP(keySDP, ssrc []byte) {
+	if len(ssrc) != 4 {
+		return
+	}
+	key := ssrcIndexKey(keySDP)
+	ssrcs, _ := d.RTCPCache.Get(key)
+	for i := 0; i+4 <= len(ssrcs); i += 4 {
+		if bytes.Equal(ssrcs[i:i+4], ssrc) {
+			return
+		}
+	}
+	if len(ssrcs) >= 4*maxSSRCsPerMedia {
+		ssrcs = ssrcs[4:]
+	}
+	err := d.RTCPCache.Set(key, append(ssrcs, ssrc...), 43200)
+	if err != nil {
+		logp.Warn("%v", err)
+	}
+}
+
+// invalidateRTCP will remove the SSRC to CallID mappings learned from an older
+// SDP for the media address keySDP. After a re-INVITE or a new call reusing
+// the address the SSRCs are learned again from the new SDP, so RTCP of the new
+// media session is not correlated to the old call.
+func (d *Decoder) invalidateRTCP(keySDP, callID []byte) {
+	key := ssrcIndexKey(keySDP)
+	ssrcs, err := d.RTCPCache.Get(key)
+	if err != nil {
+		return
+	}
+	for i := 0; i+4 <= len(ssrcs); i += 4 {
+		ssrc := ssrcs[i : i+4]
+		if old, err := d.RTCPCache.Get(ssrc); err == nil {
+			logp.Debug("rtcp", "Delete from RTCPCache key=%d, value=%s for new SDP of %s", ssrc, string(old), string(callID))
+			d.RTCPCache.Del(ssrc)
+			d.RTCPCache.Del(asymKey(ssrc))
+		}
+	}
+	d.RTCPCache.Del(key)
+}
+
+// cacheFlow will add the media 5-tuple of a correlated packet to the FlowCache.
+// It does nothing if the FlowCache is disabled.
+func (d *Decoder) cacheFlow(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, corrID []byte) {
+	if d.FlowCache == nil {
+		return
+	}
+	keyFlow := flowKey(srcIP, srcPort, dstIP, dstPort)
+	logp.Debug("rtcp", "Add to FlowCache key=%s, value=%s", string(keyFlow), string(corrID))
+	err := d.FlowCache.Set(keyFlow, corrID, 43200)
+	if err != nil {
+		logp.Warn("%v", err)
+	}
+}
+
 func (d *Decoder) correlateLOG(payload []byte) ([]byte, []byte, byte) {
 	var callID []byte
 	if posID := bytes.Index(payload, []byte("ID=")); posID > 0 {
@@ -193,3 +660,45 @@ func (d *Decoder) correlateNG(payload []byte) ([]byte, []byte, byte) {
 	}
 	return nil, nil, 0
 }
+
+// correlateRadius will decode RADIUS accounting and correlate it by the SIP Call-ID.
+// The Call-ID is taken from a Cisco-AVPair call-id or else from the Acct-Session-Id,
+// which SIP proxies like Kamailio set to the Call-ID. Accounting-Responses carry
+// neither, so the Call-ID of the request is kept in the SIPCache by its identifier.
+func (d *Decoder) correlateRadius(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payload []byte) ([]byte, []byte, byte) {
+	r, err := protos.ParseRadius(payload)
+	if err != nil {
+		logp.Debug("radius", "%v", err)
+		return nil, nil, 0
+	}
+	var callID []byte
+	if r.Code == "Accounting-Response" {
+		key := radiusKey(dstIP, dstPort, r.Identifier)
+		if callID, err = d.SIPCache.Get(key); err != nil {
+			return nil, nil, 0
+		}
+		d.SIPCache.Del(key)
+		r.CallID = string(callID)
+	} else {
+		if r.CallID == "" {
+			r.CallID = r.AcctSessionID
+		}
+		if r.CallID == "" {
+			return nil, nil, 0
+		}
+		callID = []byte(r.CallID)
+		if err := d.SIPCache.Set(radiusKey(srcIP, srcPort, r.Identifier), callID, 10); err != nil {
+			logp.Warn("%v", err)
+		}
+	}
+	data, err := json.Marshal(&struct {
+		Type string `json:"type"`
+		*protos.Radius
+	}{"radius", r})
+	if err != nil {
+		logp.Warn("%v", err)
+		return nil, nil, 0
+	}
+	logp.Debug("radius", "Found CallID: %s in RADIUS: %s", string(callID), string(data))
+	return data, callID, 100
+}
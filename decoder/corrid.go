@@ -0,0 +1,45 @@
+package decoder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/logp"
+)
+
+// setCorrID attaches the configured correlation ID to SIP packets and to the
+// packets which are correlated to a Call-ID like RTCP, logs and NG messages.
+// It is either a fingerprint of the Call-ID or the value of a SIP header which
+// is remembered by Call-ID so the correlated packets get the same ID. With
+// -dcid the Call-ID of all of them is the dialog key.
+func (d *Decoder) setCorrID(pkt *Packet, isSIP bool) {
+	callID := pkt.CID
+	if callID == nil {
+		return
+	}
+	header := config.Cfg.CorrelationID
+	if !isSIP || strings.EqualFold(header, "callid") {
+		if corrID, err := d.CorrCache.Get(callID); err == nil {
+			pkt.CorrID = corrID
+		} else {
+			pkt.CorrID = callIDFingerprint(callID)
+		}
+		return
+	}
+	if corrID := extractHeader(pkt.Payload, header, header); corrID != nil {
+		pkt.CorrID = cloneBytes(corrID)
+		if err := d.CorrCache.Set(callID, pkt.CorrID, 43200); err != nil {
+			logp.Warn("%v", err)
+		}
+	} else if corrID, err := d.CorrCache.Get(callID); err == nil {
+		pkt.CorrID = corrID
+	} else {
+		pkt.CorrID = callIDFingerprint(callID)
+	}
+}
+
+// callIDFingerprint returns a stable hex fingerprint of a Call-ID.
+func callIDFingerprint(callID []byte) []byte {
+	return []byte(fmt.Sprintf("%016x", fastHash(callID)))
+}
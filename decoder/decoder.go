@@ -5,13 +5,16 @@ import (
 	"net"
 	"os"
 	"runtime/debug"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coocood/freecache"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/negbie/heplify/config"
 	"github.com/negbie/heplify/ip4defrag"
+	"github.com/negbie/heplify/ownlayers"
 	"github.com/negbie/heplify/protos"
 	"github.com/negbie/logp"
 )
@@ -22,25 +25,80 @@ type Decoder struct {
 	NodeID    uint32
 	NodePW    []byte
 	CSeq      []byte
-	Filter    []string
 	LayerType gopacket.LayerType
 	defragger *ip4defrag.IPv4Defragmenter
 	SIPCache  *freecache.Cache
 	SDPCache  *freecache.Cache
 	RTCPCache *freecache.Cache
+	FlowCache *freecache.Cache
+	// InviteCache holds the time of initial INVITEs by Call-ID for the call setup delay
+	InviteCache *freecache.Cache
+	// CorrCache holds the correlation ID taken from a SIP header by Call-ID
+	CorrCache *freecache.Cache
+	// RTTCache holds the time of requests with a Timestamp header
+	RTTCache *freecache.Cache
+	// CDRSeenCache holds the dialog key by Call-ID of the calls whose SIP
+	// was seen. Floods of calls evict only these and not pending CDRs.
+	CDRSeenCache *freecache.Cache
+	// CDRCache holds the CDRs pushed with -cdr by Call-ID until the
+	// SIP of the call is seen
+	CDRCache   *freecache.Cache
+	cdrMu      sync.Mutex
+	ifaces     map[int]string
+	responses  responseStats
+	drops      dropStats
+	setup      setupStats
+	rtt        rttStats
+	maxSkew    time.Duration
+	nodeIDs    map[string]uint32
+	localIPs   []net.IP
+	hdrChunks  []headerChunk
+	protoTypes map[string]byte
+	sipPorts   *sipPorts
+	quic       quicStats
+	vad        vadStats
+	mos        mosStats
+	sweep      sweepStats
+	stacked    []*Packet
+	mirror     mirrorStats
+	scan       scanStats
+	qos        qosStats
+	// fragDropped holds the dropped fragments of the defragger already in fragDropCount
+	fragDropped uint64
+	// mediaClocks anchors the RTP timestamps by SSRC for -ts rtp
+	mediaClocks map[uint32]mediaClock
+	// linkLayers holds the first layer of capture interfaces
+	// whose link type differs, like in pcapng files
+	linkLayers map[int]gopacket.LayerType
+	// filters holds the -fi, -di, -dim, -tg and -hp settings which are reloaded on SIGHUP
+	filters atomic.Value
+	// events holds the function which sends the HEP logs of the decoder itself
+	events atomic.Value
+	// weights holds the function which reweights the HEP servers on reload
+	weights atomic.Value
 }
 
 type Stats struct {
-	fragCount     int
-	dupCount      int
-	dnsCount      int
-	ip4Count      int
-	ip6Count      int
-	rtcpCount     int
-	rtcpFailCount int
-	tcpCount      int
-	udpCount      int
-	unknownCount  int
+	asymCount        int
+	bodyLenCount     int
+	fragDropCount    int
+	fragCount        int
+	dupCount         int
+	dnsCount         int
+	ip4Count         int
+	ip6Count         int
+	parseErrCount    int
+	noCallIDCount    int
+	noCookieCount    int
+	rtcpCount        int
+	rtcpFailCount    int
+	scanCount        int
+	sdpConflictCount int
+	skewCount        int
+	tcpCount         int
+	truncCount       int
+	udpCount         int
+	unknownCount     int
 }
 
 type Packet struct {
@@ -58,49 +116,331 @@ type Packet struct {
 	Payload   []byte
 	CID       []byte
 	Vlan      uint16
+	// IfaceName and IfaceIndex identify the capture interface.
+	// They stay empty if the capture source doesn't tell it.
+	IfaceName  string
+	IfaceIndex int
+	// Trunk is the name of the configured IP range the packet belongs to
+	Trunk string
+	// L2TPTunnelID and L2TPSessionID are set for packets captured inside L2TP
+	L2TPTunnelID  uint16
+	L2TPSessionID uint32
+	// InDialog is set for SIP requests with a To tag
+	InDialog bool
+	// NoMagicCookie is set for SIP messages whose topmost Via branch lacks
+	// the RFC 3261 magic cookie, e.g. from legacy or spoofing devices
+	NoMagicCookie bool
+	// CorrID is the correlation ID selected with -cid. It is
+	// the same for SIP and the packets correlated to it.
+	CorrID []byte
+	// TTL is the IPv4 TTL or the IPv6 hop limit
+	TTL uint8
+	// TOS is the IPv4 ToS or the IPv6 traffic class byte
+	// with the DSCP in the upper 6 and the ECN in the lower 2 bits
+	TOS uint8
+	// Reassembled is set for IPv4 datagrams which were put together
+	// from Fragments fragments. Fragmented SIP hints at MTU problems.
+	Reassembled bool
+	Fragments   int
+	// LocalIP is the address of a multi-homed capture host given
+	// with -lip which sent or received the packet
+	LocalIP net.IP
+	// HeaderChunks holds the SIP headers selected with -hhc
+	HeaderChunks []HeaderChunk
+	// Hash is the SHA1 of a SIP message without its volatile headers
+	// which is sent with -hmh for dedup
+	Hash []byte
+	// Scan is the reason a SIP request was flagged as scanner traffic
+	// with -scan, "pattern" or "rate"
+	Scan string
+	// BodyLengthMismatch is set for SIP messages whose body length differs
+	// from their Content-Length, e.g. when truncated or from a buggy UA
+	BodyLengthMismatch bool
+	// WireLength is the length of the frame on the wire, which
+	// may be longer than the captured bytes
+	WireLength uint32
+	// PPPoESessionID is set for packets captured inside a PPPoE
+	// session to correlate them with the subscriber
+	PPPoESessionID uint16
+}
+
+// HeaderChunk is a SIP header value sent in its own HEP chunk.
+type HeaderChunk struct {
+	Type  uint16
+	Value []byte
 }
 
 func NewDecoder(datalink layers.LinkType) *Decoder {
-	var lt gopacket.LayerType
 	host, err := os.Hostname()
 	if err != nil {
 		host = "heplify-host"
 	}
 
-	switch datalink {
-	case layers.LinkTypeEthernet:
-		lt = layers.LayerTypeEthernet
-	case layers.LinkTypeLinuxSLL:
-		lt = layers.LayerTypeLinuxSLL
-	default:
-		lt = layers.LayerTypeEthernet
-	}
-
 	debug.SetGCPercent(50)
 
 	d := &Decoder{
 		Host:      host,
 		NodeID:    uint32(config.Cfg.HepNodeID),
 		NodePW:    []byte(config.Cfg.HepNodePW),
-		LayerType: lt,
+		LayerType: linkLayerType(datalink),
 		defragger: ip4defrag.NewIPv4Defragmenter(),
 		SIPCache:  freecache.NewCache(20 * 1024 * 1024), // 20 MB
 		SDPCache:  freecache.NewCache(30 * 1024 * 1024), // 30 MB
 		RTCPCache: freecache.NewCache(30 * 1024 * 1024), // 30 MB
-		Filter:    strings.Split(strings.ToUpper(config.Cfg.DiscardMethod), ","),
+		ifaces:    make(map[int]string),
+	}
+
+	ownlayers.StrictResponseCodes = config.Cfg.StrictSIP
+	ownlayers.TolerantBlankLines = config.Cfg.TolerantSIP
+	ownlayers.TruncateHeaders = config.Cfg.TolerantSIP
+	if config.Cfg.MaxSIPHeaders > 0 {
+		ownlayers.MaxSIPHeaders = config.Cfg.MaxSIPHeaders
+	}
+
+	// PCAP files are replayed with their old timestamps on purpose
+	if config.Cfg.Iface == nil || config.Cfg.Iface.ReadFile == "" {
+		d.maxSkew = config.Cfg.MaxClockSkew
+	}
+
+	if config.Cfg.HepNodeIDs != "" {
+		if d.nodeIDs, err = parseNodeIDs(config.Cfg.HepNodeIDs); err != nil {
+			logp.Err("ignore HEP node IDs: %v", err)
+		}
+	}
+
+	if config.Cfg.LocalIPs != "" {
+		if d.localIPs, err = parseLocalIPs(config.Cfg.LocalIPs); err != nil {
+			logp.Err("ignore local IPs: %v", err)
+		}
+	}
+
+	if config.Cfg.HeaderChunks != "" {
+		if d.hdrChunks, err = parseHeaderChunks(config.Cfg.HeaderChunks); err != nil {
+			logp.Err("ignore header chunks: %v", err)
+		}
+	}
+
+	if config.Cfg.MirrorHeaders != "" {
+		if d.mirror.strip, err = parseMirrorHeaders(config.Cfg.MirrorHeaders); err != nil {
+			logp.Err("ignore mirror headers: %v", err)
+		}
+	}
+
+	if config.Cfg.Scan {
+		d.scan.patterns = parseScanPatterns(config.Cfg.ScanPatterns)
+		d.scan.requests = make(map[string]int)
+		d.scan.reported = make(map[string]uint32)
+	}
+
+	if config.Cfg.ProtoTypes != "" {
+		if d.protoTypes, err = parseProtoTypes(config.Cfg.ProtoTypes); err != nil {
+			logp.Err("ignore HEP payload types: %v", err)
+		}
+	}
+
+	f, err := newFilters(config.Cfg.Filter, config.Cfg.Discard, config.Cfg.DiscardMethod, config.Cfg.Trunks, config.Cfg.IncludeIPs, config.Cfg.ExcludeIPs)
+	if err != nil {
+		logp.Err("ignore trunks and IP filters: %v", err)
+		f, _ = newFilters(config.Cfg.Filter, config.Cfg.Discard, config.Cfg.DiscardMethod, "", "", "")
+	}
+	f.nodePW = d.NodePW
+	d.filters.Store(f)
+
+	if config.Cfg.ReloadFile != "" {
+		go d.reloadOnSIGHUP()
+	}
+
+	if config.Cfg.FlowCache {
+		d.FlowCache = freecache.NewCache(20 * 1024 * 1024) // 20 MB
+	}
+
+	if config.Cfg.SetupBuckets != "" {
+		if d.setup.buckets, err = ParseSetupBuckets(config.Cfg.SetupBuckets); err != nil {
+			logp.Err("ignore call setup buckets: %v", err)
+		} else {
+			d.InviteCache = freecache.NewCache(10 * 1024 * 1024) // 10 MB
+		}
+	}
+
+	if config.Cfg.SIPPorts != "" {
+		ports := config.Cfg.SIPPorts
+		if config.Cfg.Iface != nil && config.Cfg.Iface.PortRange != "" {
+			ports += "," + config.Cfg.Iface.PortRange
+		}
+		if ranges, err := parsePorts(ports); err != nil {
+			logp.Err("ignore SIP ports: %v", err)
+		} else {
+			d.sipPorts = &sipPorts{ranges: ranges, unexpected: make(map[uint16]bool)}
+		}
 	}
 
-	go d.flushFragments()
+	if config.Cfg.SIPRTT {
+		d.RTTCache = freecache.NewCache(10 * 1024 * 1024) // 10 MB
+	}
+
+	if config.Cfg.CorrelationID != "" {
+		d.CorrCache = freecache.NewCache(10 * 1024 * 1024) // 10 MB
+	}
+
+	if config.Cfg.VADGap > 0 {
+		d.vad = vadStats{threshold: config.Cfg.VADGap, streams: make(map[uint32]*vadStream)}
+	}
+
+	if config.Cfg.RTPQoS > 0 {
+		d.qos = qosStats{interval: config.Cfg.RTPQoS, streams: make(map[uint32]*qosStream)}
+	}
+
+	if config.Cfg.CallMOS {
+		d.mos = mosStats{calls: make(map[string]*mosCall), interval: config.Cfg.MediaStatsInterval}
+		if d.mos.codecs, err = ParseCodecImpairments(config.Cfg.MOSCodecs); err != nil {
+			logp.Err("ignore MOS codec parameters: %v", err)
+		}
+	}
+
+	if config.Cfg.CDRAddr != "" {
+		d.CDRCache = freecache.NewCache(10 * 1024 * 1024)     // 10 MB
+		d.CDRSeenCache = freecache.NewCache(10 * 1024 * 1024) // 10 MB
+		go d.serveCDR(config.Cfg.CDRAddr)
+	}
+
+	if config.Cfg.CacheDumpAddr != "" {
+		go d.serveCacheDump(config.Cfg.CacheDumpAddr)
+	}
+
+	if config.Cfg.SweepInterval > 0 {
+		d.sweep.maxIdle = config.Cfg.IdleTimeout
+		d.sweep.evicted = make(map[string]int)
+		go d.sweepTicker(config.Cfg.SweepInterval)
+	} else {
+		go d.flushFragments()
+	}
 	go d.printStats()
 	return d
 }
 
+// captureMedia tells if the SDPCache is filled and RTCP and RTP are decoded.
+func captureMedia() bool {
+	return config.Cfg.Mode != "SIP" && config.Cfg.CaptureMode != "signaling"
+}
+
+// DLT_RAW is reported by live captures as 12 or 14 on OpenBSD
+// instead of the LINKTYPE_RAW of pcap files.
+const (
+	linkTypeDLTRaw    layers.LinkType = 12
+	linkTypeDLTRawBSD layers.LinkType = 14
+)
+
+func linkLayerType(datalink layers.LinkType) gopacket.LayerType {
+	switch datalink {
+	case layers.LinkTypeEthernet:
+		return layers.LayerTypeEthernet
+	case layers.LinkTypeLinuxSLL:
+		return layers.LayerTypeLinuxSLL
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		// BSD loopback like the npcap loopback adapter on Windows
+		return layers.LayerTypeLoopback
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4, layers.LinkTypeIPv6, linkTypeDLTRaw, linkTypeDLTRawBSD:
+		// Raw IP without link layer, rawLayerType picks the version by packet
+		return layers.LayerTypeIPv4
+	default:
+		return layers.LayerTypeEthernet
+	}
+}
+
+// SetInterface names the capture interface index and sets its link type,
+// so packets of one capture can come from interfaces with different link types.
+func (d *Decoder) SetInterface(index int, name string, datalink layers.LinkType) {
+	if d.ifaces == nil {
+		d.ifaces = make(map[int]string)
+	}
+	if d.linkLayers == nil {
+		d.linkLayers = make(map[int]gopacket.LayerType)
+	}
+	d.ifaces[index] = name
+	d.linkLayers[index] = linkLayerType(datalink)
+}
+
+// LinkLayer returns the first layer of packets from the capture interface index.
+func (d *Decoder) LinkLayer(index int) gopacket.LayerType {
+	if lt, ok := d.linkLayers[index]; ok {
+		return lt
+	}
+	return d.LayerType
+}
+
+// rawLayerType returns the first layer of raw IP packets by the version
+// in the first nibble of data. Other link layers are returned as they are.
+func rawLayerType(lt gopacket.LayerType, data []byte) gopacket.LayerType {
+	if lt != layers.LayerTypeIPv4 || len(data) == 0 || data[0]>>4 != 6 {
+		return lt
+	}
+	return layers.LayerTypeIPv6
+}
+
 func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) (*Packet, error) {
+	if d.sweep.evicted != nil {
+		d.checkSweep(ci.Timestamp)
+	}
+	d.stacked = nil
+	pkt, err := d.process(data, ci)
+	if pkt == nil {
+		return pkt, err
+	}
+	return d.finishPacket(pkt), err
+}
+
+// finishPacket sets what depends on the final HEP payload type of pkt.
+func (d *Decoder) finishPacket(pkt *Packet) *Packet {
+	isSIP := pkt.ProtoType == 1
+	if d.protoTypes != nil {
+		pkt.ProtoType = d.protoType(pkt)
+	}
+	// Homer correlates by the correlation ID chunk, so SIP carries
+	// its Call-ID there like the packets correlated to it.
+	if isSIP {
+		d.sipCID(pkt)
+	}
+	if d.CorrCache != nil {
+		d.setCorrID(pkt, isSIP)
+	}
+	return pkt
+}
+
+func (d *Decoder) process(data []byte, ci *gopacket.CaptureInfo) (*Packet, error) {
+	f := d.loadFilters()
 	pkt := &Packet{
 		NodeID: d.NodeID,
-		NodePW: d.NodePW,
+		NodePW: f.nodePW,
 		Tsec:   uint32(ci.Timestamp.Unix()),
 		Tmsec:  uint32(ci.Timestamp.Nanosecond() / 1000),
+		// The length of the outer frame, also for tunneled packets
+		WireLength: uint32(ci.Length),
+	}
+
+	if d.maxSkew > 0 {
+		if now := time.Now(); ci.Timestamp.Before(now.Add(-d.maxSkew)) || ci.Timestamp.After(now.Add(d.maxSkew)) {
+			d.skewCount++
+			logp.Debug("skew", "Replace timestamp %v which is too far from %v", ci.Timestamp, now)
+			pkt.Tsec = uint32(now.Unix())
+			pkt.Tmsec = uint32(now.Nanosecond() / 1000)
+		}
+	}
+
+	if ci.InterfaceIndex > 0 {
+		pkt.IfaceIndex = ci.InterfaceIndex
+		pkt.IfaceName = d.ifaceName(ci.InterfaceIndex)
+	}
+
+	// A frame cut by the snaplen is still decoded when its IP and
+	// transport headers are complete, only the payload is missing
+	truncated := ci.CaptureLength < ci.Length
+	if truncated {
+		d.truncCount++
+		logp.Debug("truncated", "Packet truncated by snaplen, captured %d of %d bytes", ci.CaptureLength, ci.Length)
+	}
+
+	if d.mirror.strip != nil && d.LinkLayer(ci.InterfaceIndex) == layers.LayerTypeEthernet {
+		data = d.stripMirror(data)
 	}
 
 	if len(data) > 42 {
@@ -108,83 +448,132 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) (*Packet, error
 			_, err := d.SIPCache.Get(data[42:])
 			if err == nil {
 				d.dupCount++
-				return nil, nil
+				return d.dropPacket(dropDuplicate)
 			}
 			err = d.SIPCache.Set(data[42:], nil, 1)
 			if err != nil {
 				logp.Warn("%v", err)
 			}
 		}
-		if config.Cfg.Filter != "" {
-			if !bytes.Contains(data[42:], []byte(config.Cfg.Filter)) {
-				return nil, nil
+		if f.filter != "" {
+			if !bytes.Contains(data[42:], []byte(f.filter)) {
+				return d.dropPacket(dropFilter)
 			}
 		}
-		if config.Cfg.Discard != "" {
-			if bytes.Contains(data[42:], []byte(config.Cfg.Discard)) {
-				return nil, nil
+		if f.discard != "" {
+			if bytes.Contains(data[42:], []byte(f.discard)) {
+				return d.dropPacket(dropDiscard)
 			}
 		}
 		logp.Debug("payload", "\n%s", string(data[42:]))
 	}
 
-	if config.Cfg.DiscardMethod != "" {
-		d.parseCSeq(data)
-		for _, v := range d.Filter {
-			if string(d.CSeq) == v {
-				return nil, nil
-			}
-		}
+	// With -us each SIP message of the datagram is checked after the split
+	if !config.Cfg.SplitUDP && d.discardMethod(f.methods, data) {
+		return d.dropPacket(dropMethod)
 	}
 
-	packet := gopacket.NewPacket(data, d.LayerType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	packet := gopacket.NewPacket(data, rawLayerType(d.LinkLayer(ci.InterfaceIndex), data), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
 	logp.Debug("layer", "\n%v", packet)
 
 	if greLayer := packet.Layer(layers.LayerTypeGRE); greLayer != nil {
 		gre, ok := greLayer.(*layers.GRE)
 		if !ok {
-			return nil, nil
+			return d.dropPacket(dropLayer)
 		}
 
 		if config.Cfg.Iface.WithErspan {
-			packet = gopacket.NewPacket(gre.Payload[8:], d.LayerType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+			erspan := &ownlayers.ERSPAN{}
+			if err := erspan.DecodeFromBytes(gre.Payload, gopacket.NilDecodeFeedback); err != nil {
+				logp.Debug("layer", "%v", err)
+				return d.parseError(pkt, err.Error(), gre.Payload)
+			}
+			if config.Cfg.TimeSource == "erspan" {
+				if ts, ok := erspan.Time(); ok {
+					pkt.Tsec = uint32(ts.Unix())
+					pkt.Tmsec = uint32(ts.Nanosecond() / 1000)
+				}
+			}
+			packet = gopacket.NewPacket(erspan.Payload, d.LayerType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
 		} else {
 			packet = gopacket.NewPacket(gre.Payload, d.LayerType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
 		}
 		logp.Debug("layer", "\nlayer inside GRE\n%v", packet)
 	}
 
+	if config.Cfg.Iface != nil && config.Cfg.Iface.WithL2TP {
+		if l2tp := decodeL2TP(packet); l2tp != nil {
+			pkt.L2TPTunnelID = l2tp.TunnelID
+			pkt.L2TPSessionID = l2tp.SessionID
+			packet = gopacket.NewPacket(l2tp.Payload, l2tp.NextLayerType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+			logp.Debug("layer", "\nlayer inside L2TP\n%v", packet)
+		}
+	}
+
+	if config.Cfg.ExportParseErrors {
+		if errLayer := lowerLayerError(packet); errLayer != nil {
+			return d.parseError(pkt, errLayer.Error().Error(), data)
+		}
+	}
+
 	if dot1qLayer := packet.Layer(layers.LayerTypeDot1Q); dot1qLayer != nil {
 		dot1q, ok := dot1qLayer.(*layers.Dot1Q)
 		if !ok {
-			return nil, nil
+			return d.dropPacket(dropLayer)
 		}
 		pkt.Vlan = dot1q.VLANIdentifier
 	}
 
+	// gopacket decodes the PPP inside the PPPoE session down to IPv4 or IPv6
+	if pppoeLayer := packet.Layer(layers.LayerTypePPPoE); pppoeLayer != nil {
+		pppoe, ok := pppoeLayer.(*layers.PPPoE)
+		if !ok {
+			return d.dropPacket(dropLayer)
+		}
+		if pppoe.Code == layers.PPPoECodeSession {
+			pkt.PPPoESessionID = pppoe.SessionId
+		}
+	}
+
 	if ipv4Layer := packet.Layer(layers.LayerTypeIPv4); ipv4Layer != nil {
 		ip4, ok := ipv4Layer.(*layers.IPv4)
-		ip4Len := ip4.Length
 		if !ok {
-			return nil, nil
+			return d.dropPacket(dropLayer)
+		}
+
+		// The cut payload of a fragment would break the reassembly
+		if truncated && (ip4.Flags&layers.IPv4MoreFragments != 0 || ip4.FragOffset > 0) {
+			return d.dropPacket(dropTruncated)
+		}
+		if !truncated && int(ip4.Length) > len(ip4.Contents)+len(ip4.Payload) {
+			d.truncCount++
+			logp.Debug("truncated", "IPv4 length %d exceeds captured %d bytes", ip4.Length, len(ip4.Contents)+len(ip4.Payload))
+			return d.parseError(pkt, "IPv4 length exceeds captured bytes", data)
 		}
 
 		pkt.Version = 0x02
 		pkt.Protocol = uint8(ip4.Protocol)
 		pkt.SrcIP = ip4.SrcIP
 		pkt.DstIP = ip4.DstIP
+		pkt.TTL = ip4.TTL
+		pkt.TOS = ip4.TOS
 		d.ip4Count++
 
-		ip4New, err := d.defragger.DefragIPv4WithTimestamp(ip4, ci.Timestamp)
+		ip4New, fragments, err := d.defragger.DefragIPv4Fragments(ip4, ci.Timestamp)
 		if err != nil {
+			// A full fragment list drops all fragments of the datagram
+			dropped := d.defragger.Dropped()
+			d.fragDropCount += int(dropped - d.fragDropped)
+			d.fragDropped = dropped
 			logp.Debug("fragment", "%v", err)
-			return nil, nil
+			return d.dropPacket(dropBadFragment)
 		} else if ip4New == nil {
 			d.fragCount++
-			return nil, nil
+			return d.dropPacket(dropFragment)
 		}
 
-		if ip4New.Length != ip4Len {
+		// The defragmenter returns the untouched layer for unfragmented packets
+		if ip4New != ip4 {
 			logp.Debug("fragment", "Fragmented packet layers:\n%v\nFragmented packet payload:\n%v\nRe-assembled packet payload:\n%v\nRe-assembled packet length:\n%v\n\n",
 				packet, string(packet.ApplicationLayer().Payload()), string(ip4New.Payload[8:]), ip4New.Length,
 			)
@@ -193,11 +582,13 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) (*Packet, error
 			pkt.Protocol = uint8(ip4New.Protocol)
 			pkt.SrcIP = ip4New.SrcIP
 			pkt.DstIP = ip4New.DstIP
+			pkt.Reassembled = true
+			pkt.Fragments = fragments
 
 			pb, ok := packet.(gopacket.PacketBuilder)
 			if !ok {
 				logp.Err("Not a PacketBuilder")
-				return nil, nil
+				return d.dropPacket(dropLayer)
 			}
 			nextDecoder := ip4New.NextLayerType()
 			nextDecoder.Decode(ip4New.Payload, pb)
@@ -207,20 +598,66 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) (*Packet, error
 	if ipv6Layer := packet.Layer(layers.LayerTypeIPv6); ipv6Layer != nil {
 		ip6, ok := ipv6Layer.(*layers.IPv6)
 		if !ok {
-			return nil, nil
+			return d.dropPacket(dropLayer)
+		}
+
+		// Length is 0 for jumbograms. It counts the Hop-by-Hop
+		// header which gopacket cuts off the payload.
+		captured := len(ip6.Payload)
+		if ip6.HopByHop != nil {
+			captured += ip6.HopByHop.ActualLength
+		}
+		if !truncated && ip6.Length > 0 && int(ip6.Length) > captured {
+			d.truncCount++
+			logp.Debug("truncated", "IPv6 payload length %d exceeds captured %d bytes", ip6.Length, captured)
+			return d.parseError(pkt, "IPv6 payload length exceeds captured bytes", data)
 		}
 
+		protocol, frag := ipv6Transport(packet, ip6)
 		pkt.Version = 0x0a
-		pkt.Protocol = uint8(ip6.NextHeader)
+		pkt.Protocol = uint8(protocol)
 		pkt.SrcIP = ip6.SrcIP
 		pkt.DstIP = ip6.DstIP
+		pkt.TTL = ip6.HopLimit
+		pkt.TOS = ip6.TrafficClass
 		d.ip6Count++
+
+		// There is no IPv6 defragmenter yet, so fragments are only counted
+		if frag != nil {
+			d.fragCount++
+			logp.Debug("fragment", "IPv6 fragment id=%d offset=%d more=%v from %v", frag.Identification, frag.FragmentOffset, frag.MoreFragments, ip6.SrcIP)
+			return d.dropPacket(dropFragment)
+		}
+	}
+
+	if truncated {
+		switch {
+		case pkt.Version == 0, !transportComplete(packet, pkt.Protocol):
+			logp.Debug("truncated", "Headers cut by snaplen, captured %d of %d bytes", ci.CaptureLength, ci.Length)
+			return d.dropPacket(dropTruncated)
+		}
+	}
+
+	if f.excludeIPs != nil && f.excludeIPs.Match(pkt.SrcIP, pkt.DstIP) != "" {
+		return d.dropPacket(dropIPFilter)
+	}
+	if f.includeIPs != nil && f.includeIPs.Match(pkt.SrcIP, pkt.DstIP) == "" {
+		return d.dropPacket(dropIPFilter)
+	}
+	if f.trunks != nil {
+		pkt.Trunk = f.trunks.Match(pkt.SrcIP, pkt.DstIP)
+	}
+	if d.localIPs != nil {
+		pkt.LocalIP = d.localIP(pkt)
+	}
+	if d.nodeIDs != nil {
+		pkt.NodeID = d.nodeID(pkt)
 	}
 
 	if udpLayer := packet.Layer(layers.LayerTypeUDP); udpLayer != nil {
 		udp, ok := udpLayer.(*layers.UDP)
 		if !ok {
-			return nil, nil
+			return d.dropPacket(dropLayer)
 		}
 
 		pkt.SrcPort = uint16(udp.SrcPort)
@@ -234,37 +671,76 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) (*Packet, error
 				if pkt.Payload != nil && pkt.CID != nil {
 					return pkt, nil
 				}
-				return nil, nil
+				return d.dropPacket(dropLog)
 			} else if udp.SrcPort == 2223 || udp.DstPort == 2223 {
 				pkt.Payload, pkt.CID, pkt.ProtoType = d.correlateNG(udp.Payload)
 				if pkt.Payload != nil {
 					return pkt, nil
 				}
-				return nil, nil
+				return d.dropPacket(dropLog)
+			}
+		}
+		if config.Cfg.Radius && (udp.SrcPort == 1813 || udp.DstPort == 1813) {
+			pkt.Payload, pkt.CID, pkt.ProtoType = d.correlateRadius(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, udp.Payload)
+			if pkt.Payload != nil {
+				return pkt, nil
 			}
+			return d.dropPacket(dropRadius)
+		}
+		if d.checkQUIC(pkt, ci.Timestamp) {
+			logp.Debug("quic", "Skip QUIC packet from %v:%d to %v:%d", pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort)
+			return d.dropPacket(dropQUIC)
 		}
-		if config.Cfg.Mode != "SIP" {
-			d.cacheSDPIPPort(udp.Payload)
-			if (udp.Payload[0]&0xc0)>>6 == 2 {
+		if captureMedia() {
+			if !config.Cfg.SplitUDP {
+				d.cacheSDPIPPort(udp.Payload)
+			}
+			if len(udp.Payload) > 1 && (udp.Payload[0]&0xc0)>>6 == 2 {
 				if (udp.Payload[1] == 200 || udp.Payload[1] == 201 || udp.Payload[1] == 207) && udp.SrcPort%2 != 0 && udp.DstPort%2 != 0 {
-					pkt.Payload, pkt.CID, pkt.ProtoType = d.correlateRTCP(pkt.SrcIP, pkt.SrcPort, udp.Payload)
+					pkt.Payload, pkt.CID, pkt.ProtoType = d.correlateRTCP(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, udp.Payload)
 					if pkt.Payload != nil {
+						if config.Cfg.TimeSource == "rtp" {
+							d.setMediaTime(pkt, udp.Payload, true, ci.Timestamp)
+						}
+						if d.mos.calls != nil {
+							d.trackMOS(pkt.CID, pkt, udp.Payload, ci.Timestamp)
+						}
+						if d.vad.streams != nil {
+							pkt.Payload = d.addVAD(pkt.Payload, udp.Payload)
+						}
+						if config.Cfg.WithDSCP {
+							pkt.Payload = addDSCP(pkt.Payload, pkt)
+						}
 						d.rtcpCount++
 						return pkt, nil
 					}
 					d.rtcpFailCount++
-					return nil, nil
+					return d.dropPacket(dropRTCP)
 				} else if udp.SrcPort%2 == 0 && udp.DstPort%2 == 0 {
 					logp.Debug("rtp", "\n%v", protos.NewRTP(udp.Payload))
+					if d.vad.streams != nil {
+						d.trackVAD(pkt, udp.Payload, ci.Timestamp)
+					}
+					if d.qos.streams != nil {
+						d.trackQoS(pkt, udp.Payload, ci.Timestamp)
+					}
+					if config.Cfg.RTPEvents {
+						if pkt.Payload, pkt.CID, pkt.ProtoType = d.correlateRTPEvent(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, udp.Payload); pkt.Payload != nil {
+							if config.Cfg.TimeSource == "rtp" {
+								d.setMediaTime(pkt, udp.Payload, false, ci.Timestamp)
+							}
+							return pkt, nil
+						}
+					}
 					pkt.Payload = nil
-					return nil, nil
+					return d.dropPacket(dropRTP)
 				}
 			}
 		}
 	} else if tcpLayer := packet.Layer(layers.LayerTypeTCP); tcpLayer != nil {
 		tcp, ok := tcpLayer.(*layers.TCP)
 		if !ok {
-			return nil, nil
+			return d.dropPacket(dropLayer)
 		}
 
 		pkt.SrcPort = uint16(tcp.SrcPort)
@@ -277,9 +753,9 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) (*Packet, error
 			if pkt.Payload != nil && pkt.CID != nil {
 				return pkt, nil
 			}
-			return nil, nil
+			return d.dropPacket(dropLog)
 		}
-		if config.Cfg.Mode != "SIP" {
+		if captureMedia() {
 			d.cacheSDPIPPort(tcp.Payload)
 		}
 	}
@@ -287,7 +763,7 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) (*Packet, error
 	if dnsLayer := packet.Layer(layers.LayerTypeDNS); dnsLayer != nil {
 		dns, ok := dnsLayer.(*layers.DNS)
 		if !ok {
-			return nil, nil
+			return d.dropPacket(dropLayer)
 		}
 
 		pkt.ProtoType = 53
@@ -301,10 +777,123 @@ func (d *Decoder) Process(data []byte, ci *gopacket.CaptureInfo) (*Packet, error
 		pkt.ProtoType = 1
 	}
 
+	if pkt.ProtoType == 1 {
+		if config.Cfg.SplitUDP && pkt.Protocol == 17 {
+			d.splitStacked(pkt)
+			if captureMedia() {
+				d.cacheSDPIPPort(pkt.Payload)
+			}
+		}
+		if pkt, err := d.processSIP(pkt); pkt == nil {
+			return pkt, err
+		}
+	}
+
 	if pkt.Payload != nil {
 		return pkt, nil
 	}
 
 	d.unknownCount++
-	return nil, nil
+	return d.dropPacket(dropUnknown)
+}
+
+// lowerLayerError returns the decode error of the link, network or
+// transport layer. Errors above like from the SIP decoder of gopacket
+// are left out, so the payload is still sent. The decoding of a lazy
+// packet only goes on past the transport layer if that one is broken.
+func lowerLayerError(packet gopacket.Packet) gopacket.ErrorLayer {
+	switch t := packet.TransportLayer().(type) {
+	case *layers.UDP:
+		if len(t.Contents) == 8 {
+			return nil
+		}
+	case *layers.TCP:
+		if t.DataOffset >= 5 && len(t.Contents) == int(t.DataOffset)*4 {
+			return nil
+		}
+	}
+	return packet.ErrorLayer()
+}
+
+// discardMethod tells if the CSeq method of the SIP message in data is
+// one of the methods dropped with -dim.
+func (d *Decoder) discardMethod(methods []string, data []byte) bool {
+	if len(methods) == 0 {
+		return false
+	}
+	d.parseCSeq(data)
+	for _, v := range methods {
+		if string(d.CSeq) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// transportComplete reports whether the UDP or TCP header was captured.
+// gopacket keeps the layer even when decoding its header failed.
+func transportComplete(packet gopacket.Packet, protocol uint8) bool {
+	switch protocol {
+	case 17:
+		udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		return ok && len(udp.Contents) == 8
+	case 6:
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		return ok && tcp.DataOffset >= 5 && len(tcp.Contents) == int(tcp.DataOffset)*4
+	}
+	return true
+}
+
+// processSIP checks and tracks a SIP message. It returns nil for dropped ones.
+func (d *Decoder) processSIP(pkt *Packet) (*Packet, error) {
+	if config.Cfg.RequireCallID && ExtractCallID(pkt.Payload) == nil {
+		d.noCallIDCount++
+		logp.Debug("sipwarn", "Drop SIP packet without Call-ID:\n%s", string(pkt.Payload))
+		return d.dropPacket(dropNoCallID)
+	}
+
+	if config.Cfg.SplitUDP && d.discardMethod(d.loadFilters().methods, pkt.Payload) {
+		return d.dropPacket(dropMethod)
+	}
+
+	if config.Cfg.CaptureMode == "media" {
+		return d.dropPacket(dropSignaling)
+	}
+
+	pkt.InDialog = isInDialog(pkt.Payload)
+	if !hasMagicCookie(pkt.Payload) {
+		pkt.NoMagicCookie = true
+		d.noCookieCount++
+		logp.Debug("sipwarn", "Via branch without magic cookie from %v:%d", pkt.SrcIP, pkt.SrcPort)
+	}
+	// A TCP segment may end in the middle of a message
+	if pkt.Protocol != 6 && bodyLengthMismatch(pkt.Payload) {
+		pkt.BodyLengthMismatch = true
+		d.bodyLenCount++
+		logp.Debug("sipwarn", "Content-Length differs from the body length from %v:%d", pkt.SrcIP, pkt.SrcPort)
+	}
+	d.checkSIPPort(pkt)
+	if d.scan.requests != nil {
+		d.checkScan(pkt)
+	}
+	if d.hdrChunks != nil {
+		pkt.HeaderChunks = d.headerChunks(pkt.Payload)
+	}
+	if config.Cfg.MessageHash {
+		pkt.Hash = messageHash(pkt.Payload)
+	}
+	d.countResponse(pkt.Payload)
+	if d.InviteCache != nil {
+		d.trackSetup(pkt)
+	}
+	if d.RTTCache != nil {
+		d.trackRTT(pkt)
+	}
+	if d.mos.calls != nil {
+		d.finishMOS(pkt)
+	}
+	if d.CDRCache != nil {
+		d.flushCDR(pkt)
+	}
+	return pkt, nil
 }
@@ -1,12 +1,27 @@
 package decoder
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/coocood/freecache"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/negbie/heplify/config"
+	"github.com/negbie/heplify/ip4defrag"
+	"github.com/negbie/heplify/protos"
 )
 
 var rawPacket = []byte{0x0, 0xa, 0xa0, 0x0, 0xbe, 0xa8, 0x0, 0x26, 0x52, 0xe, 0xd3, 0x41, 0x8, 0x0, 0x45, 0x0, 0x2, 0xbd, 0xa1, 0xc3, 0x0, 0x0, 0x3e, 0x11, 0x69, 0x26, 0xc0, 0xa8, 0xf7, 0xfa, 0xc0, 0xa8, 0xf5, 0xfa, 0x13, 0xc4, 0x13, 0xc4, 0x2, 0xa9, 0x0, 0x0, 0x53, 0x49, 0x50, 0x2f, 0x32, 0x2e, 0x30, 0x20, 0x32, 0x30, 0x30, 0x20, 0x4f, 0x4b, 0xd, 0xa, 0x43, 0x61, 0x6c, 0x6c, 0x2d, 0x49, 0x44, 0x3a, 0x20, 0x42, 0x43, 0x30, 0x39, 0x39, 0x38, 0x38, 0x34, 0x40, 0x36, 0x64, 0x66, 0x63, 0x66, 0x66, 0x65, 0x38, 0xd, 0xa, 0x43, 0x53, 0x65, 0x71, 0x3a, 0x20, 0x32, 0x31, 0x35, 0x38, 0x33, 0x34, 0x34, 0x38, 0x39, 0x20, 0x4f, 0x50, 0x54, 0x49, 0x4f, 0x4e, 0x53, 0xd, 0xa, 0x46, 0x72, 0x6f, 0x6d, 0x3a, 0x20, 0x3c, 0x73, 0x69, 0x70, 0x3a, 0x31, 0x39, 0x32, 0x2e, 0x31, 0x36, 0x38, 0x2e, 0x31, 0x31, 0x31, 0x2e, 0x31, 0x31, 0x31, 0x3a, 0x35, 0x30, 0x36, 0x30, 0x3e, 0x3b, 0x74, 0x61, 0x67, 0x3d, 0x36, 0x64, 0x66, 0x63, 0x66, 0x66, 0x65, 0x38, 0x2b, 0x31, 0x2b, 0x62, 0x30, 0x61, 0x39, 0x30, 0x30, 0x30, 0x33, 0x2b, 0x63, 0x39, 0x65, 0x66, 0x63, 0x32, 0x30, 0x62, 0xd, 0xa, 0x54, 0x6f, 0x3a, 0x20, 0x3c, 0x73, 0x69, 0x70, 0x3a, 0x31, 0x39, 0x32, 0x2e, 0x31, 0x36, 0x38, 0x2e, 0x31, 0x31, 0x31, 0x2e, 0x31, 0x31, 0x31, 0x3a, 0x35, 0x30, 0x36, 0x30, 0x3b, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x3d, 0x75, 0x64, 0x70, 0x3e, 0x3b, 0x74, 0x61, 0x67, 0x3d, 0x31, 0x38, 0x30, 0x34, 0x61, 0x34, 0x37, 0x64, 0x2b, 0x31, 0x2b, 0x65, 0x31, 0x30, 0x35, 0x30, 0x34, 0x37, 0x30, 0x2b, 0x62, 0x31, 0x32, 0x38, 0x61, 0x35, 0x36, 0x39, 0xd, 0xa, 0x56, 0x69, 0x61, 0x3a, 0x20, 0x53, 0x49, 0x50, 0x2f, 0x32, 0x2e, 0x30, 0x2f, 0x55, 0x44, 0x50, 0x20, 0x31, 0x39, 0x32, 0x2e, 0x31, 0x36, 0x38, 0x2e, 0x31, 0x31, 0x31, 0x2e, 0x31, 0x31, 0x31, 0x3a, 0x35, 0x30, 0x36, 0x30, 0x3b, 0x62, 0x72, 0x61, 0x6e, 0x63, 0x68, 0x3d, 0x7a, 0x39, 0x68, 0x47, 0x34, 0x62, 0x4b, 0x2b, 0x32, 0x31, 0x66, 0x31, 0x31, 0x33, 0x65, 0x37, 0x65, 0x33, 0x64, 0x30, 0x34, 0x63, 0x38, 0x34, 0x36, 0x31, 0x34, 0x38, 0x61, 0x39, 0x61, 0x64, 0x37, 0x36, 0x30, 0x37, 0x61, 0x65, 0x66, 0x61, 0x31, 0x2b, 0x36, 0x64, 0x66, 0x63, 0x66, 0x66, 0x65, 0x38, 0x2b, 0x31, 0xd, 0xa, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x3a, 0x20, 0x61, 0x61, 0x61, 0x61, 0x61, 0x61, 0xd, 0xa, 0x43, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x2d, 0x4c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x3a, 0x20, 0x37, 0x38, 0xd, 0xa, 0x43, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x2d, 0x54, 0x79, 0x70, 0x65, 0x3a, 0x20, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x73, 0x64, 0x70, 0xd, 0xa, 0x53, 0x75, 0x70, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x64, 0x3a, 0x20, 0x31, 0x30, 0x30, 0x72, 0x65, 0x6c, 0x2c, 0x20, 0x74, 0x69, 0x6d, 0x65, 0x72, 0xd, 0xa, 0x41, 0x63, 0x63, 0x65, 0x70, 0x74, 0x2d, 0x4c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x3a, 0x20, 0x65, 0x6e, 0xd, 0xa, 0x41, 0x63, 0x63, 0x65, 0x70, 0x74, 0x2d, 0x45, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x3a, 0x20, 0x69, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0xd, 0xa, 0x41, 0x63, 0x63, 0x65, 0x70, 0x74, 0x3a, 0x20, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x73, 0x64, 0x70, 0x2c, 0x20, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x69, 0x73, 0x75, 0x70, 0x2c, 0x20, 0x6d, 0x75, 0x6c, 0x74, 0x69, 0x70, 0x61, 0x72, 0x74, 0x2f, 0x6d, 0x69, 0x78, 0x65, 0x64, 0xd, 0xa, 0x41, 0x6c, 0x6c, 0x6f, 0x77, 0x3a, 0x20, 0x49, 0x4e, 0x56, 0x49, 0x54, 0x45, 0x2c, 0x20, 0x41, 0x43, 0x4b, 0x2c, 0x20, 0x43, 0x41, 0x4e, 0x43, 0x45, 0x4c, 0x2c, 0x20, 0x42, 0x59, 0x45, 0x2c, 0x20, 0x4f, 0x50, 0x54, 0x49, 0x4f, 0x4e, 0x53, 0x2c, 0x20, 0x4e, 0x4f, 0x54, 0x49, 0x46, 0x59, 0x2c, 0x20, 0x50, 0x52, 0x41, 0x43, 0x4b, 0x2c, 0x20, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x2c, 0x20, 0x49, 0x4e, 0x46, 0x4f, 0x2c, 0x20, 0x52, 0x45, 0x46, 0x45, 0x52, 0xd, 0xa, 0xd, 0xa, 0x76, 0x3d, 0x30, 0xd, 0xa, 0x6f, 0x3d, 0x2d, 0x20, 0x30, 0x20, 0x30, 0x20, 0x49, 0x4e, 0x20, 0x49, 0x50, 0x34, 0x20, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0xd, 0xa, 0x73, 0x3d, 0x2d, 0xd, 0xa, 0x63, 0x3d, 0x49, 0x4e, 0x20, 0x49, 0x50, 0x34, 0x20, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0x2e, 0x30, 0xd, 0xa, 0x74, 0x3d, 0x30, 0x20, 0x30, 0xd, 0xa, 0x6d, 0x3d, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x20, 0x30, 0x20, 0x52, 0x54, 0x50, 0x2f, 0x41, 0x56, 0x50, 0x20, 0x38}
@@ -23,3 +38,2045 @@ func BenchmarkProcess(b *testing.B) {
 		_ = val
 	}
 }
+
+func TestFlowKey(t *testing.T) {
+	a := net.ParseIP("192.168.1.1")
+	b := net.ParseIP("10.0.0.1")
+	if string(flowKey(a, 5005, b, 6001)) != string(flowKey(b, 6001, a, 5005)) {
+		t.Errorf("flowKey is not direction independent")
+	}
+	if string(flowKey(a, 5005, b, 6001)) == string(flowKey(a, 5007, b, 6001)) {
+		t.Errorf("flowKey ignores the source port")
+	}
+}
+
+func serializeIPv4UDP(t *testing.T, ip4 *layers.IPv4, payload []byte) []byte {
+	udp := &layers.UDP{SrcPort: 5060, DstPort: 5060}
+	udp.SetNetworkLayerForChecksum(ip4)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buf, opts, ip4, udp, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func serializeEthernet(t *testing.T, ip4 *layers.IPv4, payload []byte) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x0a, 0xa0, 0x00, 0xbe, 0xa8},
+		DstMAC:       net.HardwareAddr{0x00, 0x26, 0x52, 0x0e, 0xd3, 0x41},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buf, opts, eth, ip4, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessIPv6ExtensionHeaders(t *testing.T) {
+	sip := []byte("OPTIONS sip:bob@example.com SIP/2.0\r\nCall-ID: ext6@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	newPacket := func(next layers.IPProtocol, ext []byte) []byte {
+		ip6 := &layers.IPv6{Version: 6, NextHeader: next, HopLimit: 64, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2")}
+		udp := &layers.UDP{SrcPort: 5060, DstPort: 5060}
+		udp.SetNetworkLayerForChecksum(ip6)
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, opts, udp, gopacket.Payload(sip)); err != nil {
+			t.Fatal(err)
+		}
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x0a, 0xa0, 0x00, 0xbe, 0xa8},
+			DstMAC:       net.HardwareAddr{0x00, 0x26, 0x52, 0x0e, 0xd3, 0x41},
+			EthernetType: layers.EthernetTypeIPv6,
+		}
+		payload := append(append([]byte{}, ext...), buf.Bytes()...)
+		buf = gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip6, gopacket.Payload(payload)); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	// Destination Options and Hop-by-Hop with a PadN option in front of UDP
+	padN := []byte{0x11, 0x00, 0x01, 0x04, 0x00, 0x00, 0x00, 0x00}
+	d := NewDecoder(layers.LinkTypeEthernet)
+	for _, next := range []layers.IPProtocol{layers.IPProtocolIPv6Destination, layers.IPProtocolIPv6HopByHop} {
+		data := newPacket(next, padN)
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+		pkt, err := d.Process(data, &ci)
+		if err != nil || pkt == nil || pkt.Version != 0x0a || pkt.Protocol != 0x11 || pkt.SrcPort != 5060 || string(pkt.CID) != "ext6@host" {
+			t.Errorf("Process() with %v header = %+v, %v", next, pkt, err)
+		}
+	}
+	if d.truncCount != 0 {
+		t.Errorf("truncCount = %d", d.truncCount)
+	}
+
+	// The first fragment is counted but not decoded
+	data := newPacket(layers.IPProtocolIPv6Fragment, []byte{0x11, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x2a})
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	if pkt, _ := d.Process(data, &ci); pkt != nil || d.fragCount != 1 {
+		t.Errorf("Process() of an IPv6 fragment = %+v, fragCount %d", pkt, d.fragCount)
+	}
+}
+
+func TestProcessRawIP(t *testing.T) {
+	sip := []byte("OPTIONS sip:bob@example.com SIP/2.0\r\nCall-ID: raw@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	ip6 := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolUDP, HopLimit: 64, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2")}
+	udp := &layers.UDP{SrcPort: 5060, DstPort: 5060}
+	udp.SetNetworkLayerForChecksum(ip6)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip6, udp, gopacket.Payload(sip)); err != nil {
+		t.Fatal(err)
+	}
+	raw6 := buf.Bytes()
+
+	d := NewDecoder(layers.LinkTypeRaw)
+	// Live captures report DLT_RAW
+	d.SetInterface(1, "tun0", 12)
+	for _, tt := range []struct {
+		data    []byte
+		iface   int
+		version byte
+	}{
+		{serializeIPv4UDP(t, ip4, sip), 0, 0x02},
+		{raw6, 0, 0x0a},
+		{raw6, 1, 0x0a},
+	} {
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(tt.data), Length: len(tt.data), InterfaceIndex: tt.iface}
+		pkt, err := d.Process(tt.data, &ci)
+		if err != nil || pkt == nil || pkt.Version != tt.version || pkt.SrcPort != 5060 || string(pkt.CID) != "raw@host" {
+			t.Errorf("Process() of raw IP version %#x = %+v, %v", tt.version, pkt, err)
+		}
+	}
+}
+
+func TestProcessPPPoE(t *testing.T) {
+	sip := []byte("OPTIONS sip:bob@example.com SIP/2.0\r\nCall-ID: pppoe@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{100, 64, 0, 7}, DstIP: net.IP{10, 0, 0, 2}}
+	ip := serializeIPv4UDP(t, ip4, sip)
+	frame := []byte{0x00, 0x26, 0x52, 0x0e, 0xd3, 0x41, 0x00, 0x0a, 0xa0, 0x00, 0xbe, 0xa8, 0x88, 0x64,
+		0x11, 0x00, 0x12, 0x34, 0x00, 0x00, 0x00, 0x21}
+	binary.BigEndian.PutUint16(frame[18:20], uint16(len(ip)+2))
+	data := append(frame, ip...)
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	pkt, err := d.Process(data, &ci)
+	if err != nil || pkt == nil || pkt.PPPoESessionID != 0x1234 || !pkt.SrcIP.Equal(ip4.SrcIP) || string(pkt.CID) != "pppoe@host" {
+		t.Errorf("Process() of PPPoE = %+v, %v", pkt, err)
+	}
+}
+
+func TestProcessIPv4Options(t *testing.T) {
+	sip := rawPacket[42:]
+	routerAlert := []layers.IPv4Option{{OptionType: 0x94, OptionLength: 4, OptionData: []byte{0x00, 0x00}}}
+	newIP4 := func() *layers.IPv4 {
+		return &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			TOS:      0xb9,
+			Id:       0x1234,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    net.IP{192, 168, 247, 250},
+			DstIP:    net.IP{192, 168, 245, 250},
+			Options:  routerAlert,
+		}
+	}
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+
+	// Unfragmented packet with IHL=6
+	data := serializeEthernet(t, newIP4(), serializeIPv4UDP(t, newIP4(), sip)[24:])
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	pkt, err := d.Process(data, &ci)
+	if err != nil || pkt == nil {
+		t.Fatalf("Process() = %v, %v", pkt, err)
+	}
+	if pkt.SrcPort != 5060 || pkt.DstPort != 5060 || string(pkt.Payload) != string(sip) {
+		t.Errorf("wrong packet %d->%d payload %q", pkt.SrcPort, pkt.DstPort, pkt.Payload)
+	}
+	if pkt.TTL != 64 {
+		t.Errorf("TTL = %d, want 64", pkt.TTL)
+	}
+	if pkt.DSCP() != 46 || pkt.ECN() != 1 {
+		t.Errorf("DSCP/ECN = %d/%d, want 46/1", pkt.DSCP(), pkt.ECN())
+	}
+	if pkt.Reassembled || pkt.Fragments != 0 {
+		t.Errorf("unfragmented packet marked as reassembled from %d fragments", pkt.Fragments)
+	}
+
+	// Same packet split into two fragments, both with IHL=6
+	datagram := serializeIPv4UDP(t, newIP4(), sip)[24:]
+	first, second := newIP4(), newIP4()
+	first.Flags = layers.IPv4MoreFragments
+	second.FragOffset = 200 / 8
+
+	data = serializeEthernet(t, first, datagram[:200])
+	ci = gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	pkt, err = d.Process(data, &ci)
+	if err != nil || pkt != nil {
+		t.Fatalf("first fragment Process() = %v, %v", pkt, err)
+	}
+
+	data = serializeEthernet(t, second, datagram[200:])
+	ci = gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	pkt, err = d.Process(data, &ci)
+	if err != nil || pkt == nil {
+		t.Fatalf("second fragment Process() = %v, %v", pkt, err)
+	}
+	if pkt.SrcPort != 5060 || string(pkt.Payload) != string(sip) {
+		t.Errorf("wrong reassembled packet %d payload %q", pkt.SrcPort, pkt.Payload)
+	}
+	if !pkt.Reassembled || pkt.Fragments != 2 {
+		t.Errorf("Reassembled = %v from %d fragments, want 2", pkt.Reassembled, pkt.Fragments)
+	}
+}
+
+func TestProcessFragmentListLimit(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	for i := 0; i < ip4defrag.IPv4MaximumFragmentListLen; i++ {
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Id: 7, Flags: layers.IPv4MoreFragments, FragOffset: uint16(i),
+			Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+		data := serializeEthernet(t, ip4, make([]byte, 8))
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+		if pkt, _ := d.Process(data, &ci); pkt != nil {
+			t.Fatalf("Process() of fragment %d = %+v", i, pkt)
+		}
+	}
+	// The full list drops every fragment of the datagram
+	if d.fragDropCount != ip4defrag.IPv4MaximumFragmentListLen {
+		t.Errorf("fragDropCount = %d, want %d", d.fragDropCount, ip4defrag.IPv4MaximumFragmentListLen)
+	}
+}
+
+func TestExtractCallID(t *testing.T) {
+	if callID := ExtractCallID(rawPacket[42:]); string(callID) != "BC099884@6dfcffe8" {
+		t.Errorf("ExtractCallID() = %q", callID)
+	}
+	if callID := ExtractCallID([]byte("BYE sip:a@b SIP/2.0\r\ni:abc@host\r\n\r\n")); string(callID) != "abc@host" {
+		t.Errorf("ExtractCallID() compact = %q", callID)
+	}
+	if callID := ExtractCallID([]byte("BYE sip:a@b SIP/2.0\r\n\r\nCall-ID: body")); callID != nil {
+		t.Errorf("ExtractCallID() from body = %q", callID)
+	}
+}
+
+func TestIsInDialog(t *testing.T) {
+	for _, tt := range []struct {
+		sip  string
+		want bool
+	}{
+		{"INVITE sip:bob@b SIP/2.0\r\nTo: <sip:bob@b>\r\nFrom: <sip:a@b>;tag=1\r\n\r\n", false},
+		{"BYE sip:bob@b SIP/2.0\r\nt: <sip:bob@b>;tag=2\r\n\r\n", true},
+		{"SIP/2.0 200 OK\r\nTo: <sip:bob@b>;tag=2\r\n\r\n", false},
+	} {
+		if got := isInDialog([]byte(tt.sip)); got != tt.want {
+			t.Errorf("isInDialog(%q) = %v", tt.sip, got)
+		}
+	}
+}
+
+func TestHasMagicCookie(t *testing.T) {
+	for _, tt := range []struct {
+		sip  string
+		want bool
+	}{
+		{"INVITE sip:bob@b SIP/2.0\r\nVia: SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK74bf9\r\n\r\n", true},
+		{"INVITE sip:bob@b SIP/2.0\r\nv: SIP/2.0/UDP 10.0.0.1;branch=74bf9, SIP/2.0/UDP 10.0.0.2;branch=z9hG4bK1\r\n\r\n", false},
+		{"INVITE sip:bob@b SIP/2.0\r\nVia: SIP/2.0/UDP 10.0.0.1\r\n\r\n", false},
+	} {
+		if got := hasMagicCookie([]byte(tt.sip)); got != tt.want {
+			t.Errorf("hasMagicCookie(%q) = %v", tt.sip, got)
+		}
+	}
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(rawPacket), Length: len(rawPacket)}
+	if pkt, _ := d.Process(rawPacket, &ci); pkt == nil || pkt.NoMagicCookie {
+		t.Errorf("compliant Via branch flagged: %v", pkt)
+	}
+}
+
+func TestBodyLengthMismatch(t *testing.T) {
+	for _, tt := range []struct {
+		sip  string
+		want bool
+	}{
+		{"MESSAGE sip:bob@b SIP/2.0\r\nContent-Length: 5\r\n\r\nhello", false},
+		{"MESSAGE sip:bob@b SIP/2.0\r\nl: 11\r\n\r\nhello", true},
+		{"MESSAGE sip:bob@b SIP/2.0\r\nContent-Length: 0\r\n\r\nhello", true},
+		{"MESSAGE sip:bob@b SIP/2.0\r\nContent-Length: five\r\n\r\nhello", true},
+		{"MESSAGE sip:bob@b SIP/2.0\r\n\r\nhello", false},
+		{"OPTIONS sip:bob@b SIP/2.0\r\nContent-Length: 0\r\n\r\n", false},
+		{"MESSAGE sip:bob@b SIP/2.0\nContent-Length: 5\n\nhello", false},
+		{"MESSAGE sip:bob@b SIP/2.0\nContent-Length: 7\n\nhello", true},
+		{"MESSAGE sip:bob@b SIP/2.0\r\nContent-Length: 6\r\n\r\nhe\n\nlo", false},
+	} {
+		if got := bodyLengthMismatch([]byte(tt.sip)); got != tt.want {
+			t.Errorf("bodyLengthMismatch(%q) = %v", tt.sip, got)
+		}
+	}
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	sip := []byte("MESSAGE sip:bob@b SIP/2.0\r\nCall-ID: cl@host\r\nCSeq: 1 MESSAGE\r\nContent-Length: 20\r\n\r\nhello")
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	data := serializeEthernet(t, ip4, serializeIPv4UDP(t, ip4, sip)[20:])
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	if pkt, _ := d.Process(data, &ci); pkt == nil || !pkt.BodyLengthMismatch || d.bodyLenCount != 1 {
+		t.Errorf("Process() = %+v, bodyLenCount %d", pkt, d.bodyLenCount)
+	}
+
+	// The rest of the body may come in the next TCP segment
+	ip4.Protocol = layers.IPProtocolTCP
+	tcp := &layers.TCP{SrcPort: 5060, DstPort: 5060, ACK: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip4)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, tcp, gopacket.Payload(sip)); err != nil {
+		t.Fatal(err)
+	}
+	data = serializeEthernet(t, ip4, buf.Bytes())
+	ci = gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	if pkt, _ := d.Process(data, &ci); pkt == nil || pkt.BodyLengthMismatch || d.bodyLenCount != 1 {
+		t.Errorf("Process() of TCP = %+v, bodyLenCount %d", pkt, d.bodyLenCount)
+	}
+}
+
+func TestCacheSDPDTLS(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	invite := []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: webrtc-1@example.com\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\r\n" +
+		"o=- 1 1 IN IP4 10.0.0.1\r\n" +
+		"a=fingerprint:SHA-256 4a:ad:b9:b1:3f:82\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 40000 RTP/SAVPF 111\r\n" +
+		"a=setup:actpass\r\n" +
+		"m=video 40002 RTP/SAVPF 96\r\n" +
+		"a=fingerprint:sha-1 00:11:22\r\n" +
+		"a=setup:active\r\n")
+
+	d.cacheSDPIPPort(invite)
+	want := `[{"media":"audio","fingerprint_hash":"sha-256","fingerprint":"4A:AD:B9:B1:3F:82","setup":"actpass"},` +
+		`{"media":"video","fingerprint_hash":"sha-1","fingerprint":"00:11:22","setup":"active"}]`
+	if got := d.SDPDTLS([]byte("webrtc-1@example.com")); string(got) != want {
+		t.Errorf("SDPDTLS() = %s, want %s", got, want)
+	}
+}
+
+func TestCacheSDPLineFeed(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.cacheSDPIPPort([]byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: lf-1@example.com\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\n" +
+		"c=IN IP4 10.0.0.1\n" +
+		"m=audio 40000 RTP/AVP 0\n"))
+	if callID := d.MediaCallID(net.IP{10, 0, 0, 1}, 40000); string(callID) != "lf-1@example.com" {
+		t.Errorf("MediaCallID() with LF SDP = %q", callID)
+	}
+
+	d.cacheSDPIPPort([]byte("INVITE sip:bob@example.com SIP/2.0\n" +
+		"i: lf-2@example.com\n" +
+		"Content-Type: application/sdp\n\n" +
+		"v=0\n" +
+		"c=IN IP4 10.0.0.2\n" +
+		"m=audio 40000 RTP/AVP 0\n" +
+		"a=rtcp:40005\n"))
+	if callID, _ := d.SDPCache.Get([]byte("10.0.0.240005")); string(callID) != "lf-2@example.com" {
+		t.Errorf("SDPCache with LF message = %q", callID)
+	}
+}
+
+func TestCacheSDPCrypto(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.cacheSDPIPPort([]byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: sdes-1@example.com\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 40000 RTP/SAVP 0\r\n" +
+		"a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|2^20|1:32\r\n" +
+		"a=crypto:2 aes_cm_128_hmac_sha1_32 inline:NzB4d1BINUAvLEw6UzF3WSJ+PSdFcGdUJShpX1Zj|2^20|1:32\r\n" +
+		"a=crypto:bad\r\n"))
+
+	want := `[{"media":"audio","tag":1,"suite":"AES_CM_128_HMAC_SHA1_80"},{"media":"audio","tag":2,"suite":"AES_CM_128_HMAC_SHA1_32"}]`
+	got := d.SDPCrypto([]byte("sdes-1@example.com"))
+	if string(got) != want {
+		t.Errorf("SDPCrypto() = %s, want %s", got, want)
+	}
+	if bytes.Contains(got, []byte("inline")) {
+		t.Error("key material must not be cached")
+	}
+}
+
+func TestProcessRequireCallID(t *testing.T) {
+	config.Cfg.RequireCallID = true
+	defer func() { config.Cfg.RequireCallID = false }()
+
+	newIP4 := func() *layers.IPv4 {
+		return &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    net.IP{192, 168, 247, 250},
+			DstIP:    net.IP{192, 168, 245, 250},
+		}
+	}
+	d := NewDecoder(layers.LinkTypeEthernet)
+
+	for _, tt := range []struct {
+		sip  string
+		keep bool
+	}{
+		{"ACK sip:bob@example.com SIP/2.0\r\nCSeq: 1 ACK\r\n\r\n", false},
+		{"ACK sip:bob@example.com SIP/2.0\r\ni: abc@host\r\nCSeq: 1 ACK\r\n\r\n", true},
+	} {
+		data := serializeEthernet(t, newIP4(), serializeIPv4UDP(t, newIP4(), []byte(tt.sip))[20:])
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+		pkt, err := d.Process(data, &ci)
+		if err != nil || (pkt != nil) != tt.keep {
+			t.Errorf("Process(%q) = %v, %v", tt.sip, pkt, err)
+		}
+	}
+	if d.noCallIDCount != 1 {
+		t.Errorf("noCallIDCount = %d, want 1", d.noCallIDCount)
+	}
+}
+
+func TestProcessL2TP(t *testing.T) {
+	iface := config.Cfg.Iface
+	config.Cfg.Iface = &config.InterfacesConfig{WithL2TP: true}
+	defer func() { config.Cfg.Iface = iface }()
+
+	inner := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	l2tp := append([]byte{0x00, 0x02, 0x00, 0x07, 0x00, 0x09, 0xff, 0x03, 0x00, 0x21},
+		serializeIPv4UDP(t, inner, rawPacket[42:])...)
+
+	outer := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{192, 168, 247, 250},
+		DstIP:    net.IP{192, 168, 245, 250},
+	}
+	udp := &layers.UDP{SrcPort: 1701, DstPort: 1701}
+	udp.SetNetworkLayerForChecksum(outer)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, udp, gopacket.Payload(l2tp)); err != nil {
+		t.Fatal(err)
+	}
+	data := serializeEthernet(t, outer, buf.Bytes())
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	pkt, err := d.Process(data, &ci)
+	if err != nil || pkt == nil {
+		t.Fatalf("Process() = %v, %v", pkt, err)
+	}
+	if !pkt.SrcIP.Equal(inner.SrcIP) || pkt.DstPort != 5060 {
+		t.Errorf("packet not decoded from inner IP: %v:%d", pkt.SrcIP, pkt.DstPort)
+	}
+	if pkt.L2TPTunnelID != 7 || pkt.L2TPSessionID != 9 {
+		t.Errorf("L2TP IDs = %d/%d, want 7/9", pkt.L2TPTunnelID, pkt.L2TPSessionID)
+	}
+}
+
+func TestTrackSetup(t *testing.T) {
+	buckets, err := ParseSetupBuckets("100ms, 1s,3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseSetupBuckets("1s,100ms"); err == nil {
+		t.Errorf("descending buckets should fail")
+	}
+
+	d := &Decoder{InviteCache: freecache.NewCache(1024 * 1024)}
+	d.setup.buckets = buckets
+	sip := func(firstLine, to, cseq string, ms uint32) *Packet {
+		payload := firstLine + "\r\nCall-ID: setup@host\r\nTo: " + to + "\r\nCSeq: " + cseq + "\r\n\r\n"
+		return &Packet{Payload: []byte(payload), Tsec: 100 + ms/1000, Tmsec: ms % 1000 * 1000, Trunk: "carrierA"}
+	}
+
+	d.trackSetup(sip("INVITE sip:bob@example.com SIP/2.0", "<sip:bob@example.com>", "1 INVITE", 0))
+	// Retransmission keeps the first timestamp
+	d.trackSetup(sip("INVITE sip:bob@example.com SIP/2.0", "<sip:bob@example.com>", "1 INVITE", 500))
+	d.trackSetup(sip("SIP/2.0 100 Trying", "<sip:bob@example.com>", "1 INVITE", 600))
+	d.trackSetup(sip("SIP/2.0 180 Ringing", "<sip:bob@example.com>;tag=a", "1 INVITE", 1500))
+	// Only the first 18x or 2xx is measured
+	d.trackSetup(sip("SIP/2.0 200 OK", "<sip:bob@example.com>;tag=a", "1 INVITE", 9000))
+
+	hists := d.SetupDelays()
+	h, ok := hists["1xx carrierA"]
+	if len(hists) != 1 || !ok {
+		t.Fatalf("SetupDelays() = %v", hists)
+	}
+	if h.Counts[2] != 1 || h.Sum != 1500*time.Millisecond {
+		t.Errorf("histogram = %v", h)
+	}
+	if len(d.SetupDelays()) != 0 {
+		t.Errorf("SetupDelays() should reset the histograms")
+	}
+}
+
+func TestSetCorrID(t *testing.T) {
+	defer func() { config.Cfg.CorrelationID = "" }()
+	d := &Decoder{CorrCache: freecache.NewCache(1024 * 1024)}
+	invite := []byte("INVITE sip:bob@b SIP/2.0\r\nCall-ID: abc@host\r\nX-Corr: 42\r\n\r\n")
+	bye := []byte("BYE sip:bob@b SIP/2.0\r\nCall-ID: abc@host\r\n\r\n")
+
+	config.Cfg.CorrelationID = "callid"
+	sip := &Packet{ProtoType: 1, Payload: invite}
+	d.finishPacket(sip)
+	rtcp := &Packet{ProtoType: 5, CID: []byte("abc@host")}
+	d.finishPacket(rtcp)
+	if len(sip.CorrID) != 16 || string(sip.CorrID) != string(rtcp.CorrID) {
+		t.Errorf("fingerprints differ: %q, %q", sip.CorrID, rtcp.CorrID)
+	}
+
+	config.Cfg.CorrelationID = "x-corr"
+	for _, pkt := range []*Packet{{ProtoType: 1, Payload: invite}, {ProtoType: 1, Payload: bye}, {ProtoType: 5, CID: []byte("abc@host")}} {
+		d.finishPacket(pkt)
+		if string(pkt.CorrID) != "42" {
+			t.Errorf("CorrID = %q, want 42", pkt.CorrID)
+		}
+	}
+}
+
+func TestCheckSIPPort(t *testing.T) {
+	if _, err := parsePorts("5090-5060"); err == nil {
+		t.Error("descending range should fail")
+	}
+	ranges, err := parsePorts("5070, 8080-8090,5060-5061")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Decoder{sipPorts: &sipPorts{ranges: ranges, unexpected: make(map[uint16]bool)}}
+	for _, pkt := range []*Packet{
+		{SrcPort: 40000, DstPort: 8085},
+		{SrcPort: 5061, DstPort: 5061},
+		{SrcPort: 40000, DstPort: 5080},
+		{SrcPort: 5080, DstPort: 41000},
+	} {
+		d.checkSIPPort(pkt)
+	}
+	if len(d.sipPorts.unexpected) != 1 || !d.sipPorts.unexpected[5080] {
+		t.Errorf("unexpected ports = %v, want 5080", d.sipPorts.unexpected)
+	}
+}
+
+func TestTrackRTT(t *testing.T) {
+	d := &Decoder{RTTCache: freecache.NewCache(1024 * 1024)}
+	sip := func(firstLine, timestamp string, ms uint32) *Packet {
+		payload := firstLine + "\r\nCall-ID: rtt@host\r\nCSeq: 7 OPTIONS\r\nTimestamp: " + timestamp + "\r\n\r\n"
+		return &Packet{Payload: []byte(payload), Tsec: 100 + ms/1000, Tmsec: ms % 1000 * 1000}
+	}
+
+	d.trackRTT(sip("OPTIONS sip:bob@example.com SIP/2.0", "54", 0))
+	d.trackRTT(sip("SIP/2.0 200 OK", "54 0.1", 250))
+	// A second response doesn't count again
+	d.trackRTT(sip("SIP/2.0 200 OK", "54 0.1", 300))
+
+	if d.rtt.count != 1 || d.rtt.sum != 150*time.Millisecond {
+		t.Errorf("rtt count = %d, sum = %v, want 1, 150ms", d.rtt.count, d.rtt.sum)
+	}
+}
+
+func TestProtoType(t *testing.T) {
+	if _, err := parseProtoTypes("514=300"); err == nil {
+		t.Error("payload type 300 should fail")
+	}
+	protoTypes, err := parseProtoTypes("eth1=100, 514=100,5060=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Decoder{protoTypes: protoTypes}
+	for _, tt := range []struct {
+		pkt  Packet
+		want byte
+	}{
+		{Packet{IfaceName: "eth1", DstPort: 5060, ProtoType: 1}, 100},
+		{Packet{SrcPort: 40000, DstPort: 514}, 100},
+		{Packet{SrcPort: 5060, DstPort: 514, ProtoType: 1}, 100},
+		{Packet{IfaceName: "eth0", SrcPort: 40000, DstPort: 5062, ProtoType: 5}, 5},
+	} {
+		if got := d.protoType(&tt.pkt); got != tt.want {
+			t.Errorf("protoType(%+v) = %d, want %d", tt.pkt, got, tt.want)
+		}
+	}
+}
+
+func TestAddDSCP(t *testing.T) {
+	pkt := &Packet{TOS: 0xb8}
+	if got := string(addDSCP([]byte(`{"type":200}`), pkt)); got != `{"dscp":46,"ecn":0,"type":200}` {
+		t.Errorf("addDSCP() = %s", got)
+	}
+	if got := string(addDSCP([]byte(`{}`), pkt)); got != `{"dscp":46,"ecn":0}` {
+		t.Errorf("addDSCP() = %s", got)
+	}
+}
+
+func TestCheckQUIC(t *testing.T) {
+	d := &Decoder{}
+	initial := append([]byte{0xc3, 0x00, 0x00, 0x00, 0x01, 0x08}, make([]byte, 40)...)
+	short := append([]byte{0x43}, make([]byte, 40)...)
+	src, dst := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	now := time.Now()
+
+	if d.checkQUIC(&Packet{SrcIP: src, DstIP: dst, SrcPort: 40000, DstPort: 443, Payload: initial}, now) {
+		t.Error("QUIC on a non SIP port should not be reported")
+	}
+	if d.checkQUIC(&Packet{SrcIP: src, DstIP: dst, SrcPort: 40000, DstPort: 5060, Payload: short}, now) {
+		t.Error("short header of an unknown flow should not be reported")
+	}
+	if !d.checkQUIC(&Packet{SrcIP: src, DstIP: dst, SrcPort: 40000, DstPort: 5060, Payload: initial}, now) {
+		t.Error("QUIC initial on 5060 should be reported")
+	}
+	if !d.checkQUIC(&Packet{SrcIP: dst, DstIP: src, SrcPort: 5060, DstPort: 40000, Payload: short}, now) {
+		t.Error("short header of a known flow should be reported")
+	}
+	if d.quic.packets != 2 || d.quic.newFlow != 1 || len(d.quic.flows) != 1 {
+		t.Errorf("packets = %d, new flows = %d, flows = %d, want 2, 1, 1", d.quic.packets, d.quic.newFlow, len(d.quic.flows))
+	}
+}
+
+func TestReload(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(rawPacket), Length: len(rawPacket)}
+	if pkt, _ := d.Process(rawPacket, &ci); pkt == nil {
+		t.Fatal("packet should pass without filters")
+	}
+
+	path := filepath.Join(t.TempDir(), "reload.conf")
+	if err := os.WriteFile(path, []byte("# drop keepalives\ndim=options, notify\ntg=carrierA=192.168.0.0/16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reload(path); err != nil {
+		t.Fatal(err)
+	}
+	if pkt, _ := d.Process(rawPacket, &ci); pkt != nil {
+		t.Error("OPTIONS should be discarded after reload")
+	}
+
+	if err := os.WriteFile(path, []byte("dim=INVITE\ntg=carrierA=192.168.0.0/33\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reload(path); err == nil {
+		t.Error("invalid trunk should fail")
+	}
+	f := d.loadFilters()
+	if strings.Join(f.methods, ",") != "OPTIONS,NOTIFY" || f.rawTrunks != "carrierA=192.168.0.0/16" {
+		t.Errorf("filters changed by a failed reload: %+v", f)
+	}
+
+	if err := os.WriteFile(path, []byte("hs=10.0.0.1:9060*0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reload(path); err == nil {
+		t.Error("-hs weights were reloaded with a single HEP server")
+	}
+	var weights string
+	d.ReloadWeights(func(s string) error { weights = s; return nil })
+	if err := d.reload(path); err != nil || weights != "10.0.0.1:9060*0" {
+		t.Errorf("reload of -hs weights = %q, %v", weights, err)
+	}
+
+	if err := os.WriteFile(path, []byte("dim=\nhp=rotated key\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reload(path); err != nil {
+		t.Fatal(err)
+	}
+	if pkt, _ := d.Process(rawPacket, &ci); pkt == nil || string(pkt.NodePW) != "rotated key" {
+		t.Errorf("packet after reload of -hp = %+v, want NodePW %q", pkt, "rotated key")
+	}
+
+	if err := os.WriteFile(path, []byte("hi=2003\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reload(path); err == nil {
+		t.Error("-hi can't be reloaded")
+	}
+}
+
+func TestCaptureEvent(t *testing.T) {
+	config.Cfg.StartEvent = true
+	config.Cfg.Version = "heplify test"
+	defer func() { config.Cfg.StartEvent, config.Cfg.Version = false, "" }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	var events []*Packet
+	d.SendEvents(func(pkt *Packet) { events = append(events, pkt) })
+	path := filepath.Join(t.TempDir(), "reload.conf")
+	if err := os.WriteFile(path, []byte("dim=OPTIONS\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reload(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d capture events, want start and reload", len(events))
+	}
+	for i, want := range []string{"start", "reload"} {
+		var e captureEvent
+		if err := json.Unmarshal(events[i].Payload, &e); err != nil {
+			t.Fatal(err)
+		}
+		if events[i].ProtoType != 100 || e.Type != "capture" || e.Event != want || e.Version != "heplify test" || e.Host != d.Host {
+			t.Errorf("capture event %d = %s", i, events[i].Payload)
+		}
+	}
+	if !bytes.Contains(events[1].Payload, []byte(`"discard_methods":"OPTIONS"`)) {
+		t.Errorf("reload event without the reloaded filters: %s", events[1].Payload)
+	}
+}
+
+func TestDumpCaches(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.SDPCache.Set([]byte("10.0.0.140001"), []byte("dump@host"), 120)
+	d.RTCPCache.Set([]byte{0x12, 0x34, 0x56, 0x78}, []byte("dump@host"), 0)
+
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/caches", nil))
+	var dump map[string][]CacheEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+	if sdp := dump["SDPCache"]; len(sdp) != 1 || sdp[0].Key != "10.0.0.140001" || sdp[0].Value != "dump@host" || sdp[0].TTL < 119 {
+		t.Errorf("SDPCache = %+v", sdp)
+	}
+	if rtcp := dump["RTCPCache"]; len(rtcp) != 1 || rtcp[0].Key != "0x12345678" || rtcp[0].TTL != 0 {
+		t.Errorf("RTCPCache = %+v", rtcp)
+	}
+	if _, ok := dump["FlowCache"]; ok {
+		t.Error("disabled FlowCache should not be dumped")
+	}
+
+	if dump := d.DumpCaches("RTCPCache"); len(dump) != 1 {
+		t.Errorf("DumpCaches(RTCPCache) = %v", dump)
+	}
+}
+
+func TestDropCounts(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.filters.Store(&filters{filter: "REGISTER"})
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(rawPacket), Length: len(rawPacket)}
+	d.Process(rawPacket, &ci)
+	d.Process(rawPacket, &ci)
+	// The snaplen cuts the UDP header
+	ci.CaptureLength = 40
+	d.Process(rawPacket[:40], &ci)
+
+	counts := d.DropCounts()
+	if len(counts) != 2 || counts["no filter match"] != 2 || counts["truncated"] != 1 {
+		t.Errorf("DropCounts() = %v", counts)
+	}
+	if counts = d.DropCounts(); len(counts) != 0 {
+		t.Errorf("DropCounts() was not reset: %v", counts)
+	}
+}
+
+func TestProcessSnaplen(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	data := rawPacket[:300]
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(rawPacket)}
+	pkt, err := d.Process(data, &ci)
+	if err != nil || pkt == nil {
+		t.Fatalf("Process() = %v, %v", pkt, err)
+	}
+	if pkt.ProtoType != 1 || len(pkt.Payload) != len(data)-42 || pkt.WireLength != uint32(len(rawPacket)) {
+		t.Errorf("got ProtoType %d, %d payload bytes, wire length %d", pkt.ProtoType, len(pkt.Payload), pkt.WireLength)
+	}
+	if d.truncCount != 1 {
+		t.Errorf("truncCount = %d", d.truncCount)
+	}
+	if counts := d.DropCounts(); len(counts) != 0 {
+		t.Errorf("DropCounts() = %v", counts)
+	}
+
+	// A cut fragment can't be reassembled
+	frag := append([]byte(nil), data...)
+	frag[20] |= 0x20
+	if pkt, _ = d.Process(frag, &ci); pkt != nil {
+		t.Error("snaplen cut fragment was decoded")
+	}
+	if counts := d.DropCounts(); counts["truncated"] != 1 {
+		t.Errorf("DropCounts() = %v", counts)
+	}
+}
+
+func TestCaptureMode(t *testing.T) {
+	defer func() { config.Cfg.CaptureMode = "" }()
+	sip := []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: mode@host\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 40000 RTP/AVP 0\r\n")
+	newIP4 := func() *layers.IPv4 {
+		return &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	}
+	data := serializeEthernet(t, newIP4(), serializeIPv4UDP(t, newIP4(), sip)[20:])
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+
+	config.Cfg.CaptureMode = "signaling"
+	d := NewDecoder(layers.LinkTypeEthernet)
+	if pkt, err := d.Process(data, &ci); err != nil || pkt == nil {
+		t.Fatalf("signaling Process() = %v, %v", pkt, err)
+	}
+	if callID := d.MediaCallID(net.IP{10, 0, 0, 1}, 40000); callID != nil {
+		t.Errorf("signaling mode filled the SDPCache with %s", callID)
+	}
+
+	config.Cfg.CaptureMode = "media"
+	d = NewDecoder(layers.LinkTypeEthernet)
+	if pkt, err := d.Process(data, &ci); err != nil || pkt != nil {
+		t.Fatalf("media Process() = %v, %v", pkt, err)
+	}
+	if callID := d.MediaCallID(net.IP{10, 0, 0, 1}, 40000); string(callID) != "mode@host" {
+		t.Errorf("media mode MediaCallID() = %q", callID)
+	}
+	if counts := d.DropCounts(); counts["signaling in media mode"] != 1 {
+		t.Errorf("DropCounts() = %v", counts)
+	}
+}
+
+func TestSplitUDP(t *testing.T) {
+	config.Cfg.SplitUDP = true
+	defer func() { config.Cfg.SplitUDP = false }()
+	invite := "INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: first@host\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"Content-Length: 5\r\n\r\n" +
+		"v=0\r\n"
+	bye := "BYE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: second@host\r\n" +
+		"CSeq: 2 BYE\r\n" +
+		"l: 0\r\n\r\n"
+	newIP4 := func() *layers.IPv4 {
+		return &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	}
+	payload := []byte(invite + bye + "\x00\x01binary")
+	data := serializeEthernet(t, newIP4(), serializeIPv4UDP(t, newIP4(), payload)[20:])
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	pkt, err := d.Process(data, &ci)
+	if err != nil || pkt == nil || string(pkt.Payload) != invite || string(pkt.CID) != "first@host" {
+		t.Fatalf("Process() = %+v, %v", pkt, err)
+	}
+	stacked := d.StackedPackets()
+	if len(stacked) != 1 || string(stacked[0].Payload) != bye || string(stacked[0].CID) != "second@host" || stacked[0].ProtoType != 1 {
+		t.Fatalf("StackedPackets() = %+v", stacked)
+	}
+	if stacked = d.StackedPackets(); len(stacked) != 0 {
+		t.Errorf("StackedPackets() returned %d packets twice", len(stacked))
+	}
+
+	// Without Content-Length the datagram stays one message
+	if msgs, _ := splitSIPMessages([]byte("OPTIONS sip:bob@example.com SIP/2.0\r\nCSeq: 1 OPTIONS\r\n\r\n")); msgs != nil {
+		t.Errorf("splitSIPMessages() without Content-Length = %q", msgs)
+	}
+}
+
+func TestSplitUDPPerMessage(t *testing.T) {
+	config.Cfg.SplitUDP = true
+	defer func() { config.Cfg.SplitUDP = false }()
+	options := "OPTIONS sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: options@host\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"Content-Length: 0\r\n\r\n"
+	sdp := "v=0\r\nc=IN IP4 10.0.0.9\r\nm=audio 30000 RTP/AVP 0\r\n"
+	invite := "INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: sdp@host\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"Content-Length: " + strconv.Itoa(len(sdp)) + "\r\n\r\n" + sdp
+	newIP4 := func() *layers.IPv4 {
+		return &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	}
+	data := serializeEthernet(t, newIP4(), serializeIPv4UDP(t, newIP4(), []byte(options+invite))[20:])
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.filters.Store(&filters{methods: []string{"OPTIONS"}})
+	if pkt, err := d.Process(data, &ci); pkt != nil || err != nil {
+		t.Fatalf("Process() = %+v, %v", pkt, err)
+	}
+	stacked := d.StackedPackets()
+	if len(stacked) != 1 || string(stacked[0].CID) != "sdp@host" {
+		t.Fatalf("StackedPackets() = %+v", stacked)
+	}
+	if callID, err := d.SDPCache.Get([]byte("10.0.0.930001")); err != nil || string(callID) != "sdp@host" {
+		t.Errorf("SDPCache = %q, %v", callID, err)
+	}
+
+	// The INVITE is dropped, not the OPTIONS in front of it
+	d.filters.Store(&filters{methods: []string{"INVITE"}})
+	pkt, err := d.Process(data, &ci)
+	if err != nil || pkt == nil || string(pkt.Payload) != options {
+		t.Fatalf("Process() = %+v, %v", pkt, err)
+	}
+	if stacked = d.StackedPackets(); len(stacked) != 0 {
+		t.Errorf("StackedPackets() = %+v", stacked)
+	}
+}
+
+func TestMessageHash(t *testing.T) {
+	msg := func(via, maxForwards, cseq string) []byte {
+		return []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+			"Via: " + via + "\r\n" +
+			"Max-Forwards: " + maxForwards + "\r\n" +
+			"CSeq: " + cseq + "\r\n\r\n" +
+			"v=0\r\n")
+	}
+	orig := messageHash(msg("SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK776;rport", "70", "1 INVITE"))
+	proxied := messageHash(msg("SIP/2.0/UDP 10.0.0.1;BRANCH=z9hG4bK999;rport", "69", "1 INVITE"))
+	if len(orig) != 20 || !bytes.Equal(orig, proxied) {
+		t.Errorf("messageHash() = %x and %x for the same message", orig, proxied)
+	}
+	if next := messageHash(msg("SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK776;rport", "70", "2 INVITE")); bytes.Equal(orig, next) {
+		t.Error("messageHash() is the same for another CSeq")
+	}
+	if got := string(removeBranch([]byte("v: SIP/2.0/UDP a;branch=1,SIP/2.0/UDP b;branch=2;received=c"))); got != "v: SIP/2.0/UDP a,SIP/2.0/UDP b;received=c" {
+		t.Errorf("removeBranch() = %q", got)
+	}
+}
+
+func TestLoopbackLinkType(t *testing.T) {
+	ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{127, 0, 0, 1}, DstIP: net.IP{127, 0, 0, 1}}
+	sip := []byte("OPTIONS sip:bob@example.com SIP/2.0\r\nCall-ID: loop@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	// The NULL header is the address family in host byte order
+	data := append([]byte{2, 0, 0, 0}, serializeIPv4UDP(t, ip4, sip)...)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+
+	d := NewDecoder(layers.LinkTypeNull)
+	if pkt, err := d.Process(data, &ci); err != nil || pkt == nil || string(pkt.CID) != "loop@host" {
+		t.Errorf("Process() of a loopback packet = %+v, %v", pkt, err)
+	}
+}
+
+func TestMirrorHeaders(t *testing.T) {
+	config.Cfg.MirrorHeaders = "juniper,arista"
+	defer func() { config.Cfg.MirrorHeaders = "" }()
+	d := NewDecoder(layers.LinkTypeEthernet)
+
+	juniper := append([]byte{'M', 'G', 'C', 0x81, 0x00, 0x03, 0x01, 0x01, 0x00}, rawPacket...)
+	arista := append(append(append([]byte{}, rawPacket[:12]...), 0xd2, 0x8b, 0x00, 0x01, 0x00, 0x10, 1, 2, 3, 4, 5, 6, 7, 8), rawPacket[12:]...)
+	for name, data := range map[string][]byte{"juniper": juniper, "arista": arista} {
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+		pkt, err := d.Process(data, &ci)
+		if err != nil || pkt == nil || !pkt.SrcIP.Equal(net.IP{192, 168, 247, 250}) || pkt.SrcPort != 5060 {
+			t.Errorf("Process() of %s frame = %+v, %v", name, pkt, err)
+		}
+	}
+	if d.mirror.logged {
+		t.Error("known mirror headers should not be logged")
+	}
+
+	unknown := append([]byte{0xde, 0xad, 0xbe, 0xef}, rawPacket...)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(unknown), Length: len(unknown)}
+	d.Process(unknown, &ci)
+	if !d.mirror.logged {
+		t.Error("unknown prefix should be logged")
+	}
+}
+
+func TestCacheSDPMultipart(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.cacheSDPIPPort([]byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: multipart@host\r\n" +
+		"Content-Type: multipart/mixed;boundary=b1\r\n\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"Content-Disposition: early-session\r\n\r\n" +
+		"v=0\r\nc=IN IP4 10.0.0.1\r\nm=audio 30000 RTP/AVP 0\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"Content-Disposition: session\r\n\r\n" +
+		"v=0\r\nc=IN IP4 10.0.0.1\r\nm=audio 40000 RTP/AVP 0\r\n" +
+		"--b1--\r\n"))
+	if callID := d.MediaCallID(net.IP{10, 0, 0, 1}, 40000); string(callID) != "multipart@host" {
+		t.Errorf("MediaCallID() of the session SDP = %q", callID)
+	}
+	if callID := d.MediaCallID(net.IP{10, 0, 0, 1}, 30000); callID != nil {
+		t.Errorf("early-session SDP was cached for %q", callID)
+	}
+
+	// Some UAs end lines with a bare LF
+	d.cacheSDPIPPort([]byte("INVITE sip:bob@example.com SIP/2.0\n" +
+		"Call-ID: multipart-lf@host\n" +
+		"Content-Type: multipart/mixed;boundary=b1\n\n" +
+		"--b1\n" +
+		"Content-Type: application/sdp\n" +
+		"Content-Disposition: early-session\n\n" +
+		"v=0\nc=IN IP4 10.0.0.3\nm=audio 30000 RTP/AVP 0\n" +
+		"--b1\n" +
+		"Content-Type: application/sdp\n" +
+		"Content-Disposition: session\n\n" +
+		"v=0\nc=IN IP4 10.0.0.3\nm=audio 40000 RTP/AVP 0\n" +
+		"--b1--\n"))
+	if callID := d.MediaCallID(net.IP{10, 0, 0, 3}, 40000); string(callID) != "multipart-lf@host" {
+		t.Errorf("MediaCallID() of the LF session SDP = %q", callID)
+	}
+	if callID := d.MediaCallID(net.IP{10, 0, 0, 3}, 30000); callID != nil {
+		t.Errorf("LF early-session SDP was cached for %q", callID)
+	}
+}
+
+func TestTrunkTable(t *testing.T) {
+	trunks, err := ParseTrunks("carrierA=10.0.0.0/8,192.168.1.0/24; carrierB=10.1.0.0/16;v6=2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"10.2.3.4", "carrierA"},
+		{"10.1.3.4", "carrierB"},
+		{"192.168.1.200", "carrierA"},
+		{"192.168.2.1", ""},
+		{"2001:db8::1", "v6"},
+		{"2001:db9::1", ""},
+	}
+	for _, tt := range tests {
+		if got := trunks.Lookup(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Lookup(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+	if got := trunks.Match(net.ParseIP("172.16.0.1"), net.ParseIP("10.1.0.1")); got != "carrierB" {
+		t.Errorf("Match() = %q, want carrierB", got)
+	}
+
+	if _, err := ParseTrunks("carrierA=10.0.0.0/33"); err == nil {
+		t.Error("invalid CIDR was accepted")
+	}
+
+	// IPv4-mapped IPv6 ranges match the IPv4 addresses
+	mapped, err := ParseCIDRs("::ffff:10.0.0.1,::ffff:192.168.0.0/112")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{"10.0.0.1": "::ffff:10.0.0.1/128", "10.0.0.2": "", "192.168.3.4": "::ffff:192.168.0.0/112", "192.169.0.1": ""} {
+		if got := mapped.Lookup(net.ParseIP(ip)); got != want {
+			t.Errorf("Lookup(%s) = %q, want %q", ip, got, want)
+		}
+	}
+	if all, err := ParseCIDRs("::ffff:0.0.0.0/96"); err != nil || all.Lookup(net.IP{172, 16, 0, 1}) == "" {
+		t.Errorf("::ffff:0.0.0.0/96 doesn't match all IPv4 addresses: %v", err)
+	}
+}
+
+func TestIPFilters(t *testing.T) {
+	ips, err := ParseCIDRs("10.0.0.0/8, 192.168.1.5,2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{"10.2.3.4": "10.0.0.0/8", "192.168.1.5": "192.168.1.5/32", "192.168.1.6": "", "2001:db8::1": "2001:db8::/32"} {
+		if got := ips.Lookup(net.ParseIP(ip)); got != want {
+			t.Errorf("Lookup(%s) = %q, want %q", ip, got, want)
+		}
+	}
+	if _, err := ParseCIDRs("10.0.0.300"); err == nil {
+		t.Error("invalid IP was accepted")
+	}
+
+	// rawPacket is sent from 192.168.247.250 to 192.168.245.250
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(rawPacket), Length: len(rawPacket)}
+	for _, tt := range []struct {
+		include, exclude string
+		pass             bool
+	}{
+		{"192.168.245.0/24", "", true},
+		{"10.0.0.0/8,2001:db8::/32", "", false},
+		{"", "192.168.247.250", false},
+		{"192.168.0.0/16", "192.168.245.0/24", false},
+		{"", "10.0.0.0/8", true},
+	} {
+		config.Cfg.IncludeIPs, config.Cfg.ExcludeIPs = tt.include, tt.exclude
+		d := NewDecoder(layers.LinkTypeEthernet)
+		if pkt, _ := d.Process(rawPacket, &ci); (pkt != nil) != tt.pass {
+			t.Errorf("-fip %q -dip %q passed %v, want %v", tt.include, tt.exclude, pkt != nil, tt.pass)
+		}
+	}
+	config.Cfg.IncludeIPs, config.Cfg.ExcludeIPs = "", ""
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	path := filepath.Join(t.TempDir(), "reload.conf")
+	if err := os.WriteFile(path, []byte("dip=192.168.247.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.reload(path); err != nil {
+		t.Fatal(err)
+	}
+	if pkt, _ := d.Process(rawPacket, &ci); pkt != nil || d.DropCounts()["IP filter"] != 1 {
+		t.Error("packet should be discarded by IP after reload")
+	}
+}
+
+func TestCDR(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.CDRCache = freecache.NewCache(1024 * 1024)
+	d.CDRSeenCache = freecache.NewCache(1024 * 1024)
+	var events []*Packet
+	d.SendEvents(func(pkt *Packet) { events = append(events, pkt) })
+
+	push := func(method, body string) int {
+		w := httptest.NewRecorder()
+		d.handleCDR(w, httptest.NewRequest(method, "/cdr", strings.NewReader(body)))
+		return w.Code
+	}
+	if code := push("GET", ""); code != 405 {
+		t.Errorf("GET /cdr = %d", code)
+	}
+	if code := push("POST", `{"account":"1001"}`); code != 400 {
+		t.Errorf("CDR without call_id = %d", code)
+	}
+	// The CDR of rawPacket waits for its SIP
+	if code := push("POST", `{"call_id": "BC099884@6dfcffe8", "account": "1001", "trunk": "carrierA", "rate": 0.01}
+{"call_id":"other@host","account":12345678901234567890}`); code != 204 {
+		t.Fatalf("POST /cdr = %d", code)
+	}
+	if len(events) != 0 {
+		t.Fatalf("CDRs sent before their SIP: %+v", events)
+	}
+
+	ts := time.Unix(1600000000, 123456000)
+	ci := gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(rawPacket), Length: len(rawPacket)}
+	d.Process(rawPacket, &ci)
+	d.Process(rawPacket, &ci)
+	if len(events) != 1 || events[0].ProtoType != 100 || string(events[0].CID) != "BC099884@6dfcffe8" ||
+		events[0].Tsec != 1600000000 || events[0].Tmsec != 123456 ||
+		string(events[0].Payload) != `{"account":"1001","call_id":"BC099884@6dfcffe8","rate":0.01,"trunk":"carrierA","type":"cdr"}` {
+		t.Fatalf("CDR HEP logs = %+v", events)
+	}
+	// Only the CDR of other@host is still pending, the seen calls are kept apart
+	if n := d.CDRCache.EntryCount(); n != 1 {
+		t.Errorf("CDRCache holds %d entries", n)
+	}
+
+	// The SIP was seen, so a later CDR is sent right away
+	if code := push("POST", `{"call_id":"BC099884@6dfcffe8","account":12345678901234567890}`); code != 204 {
+		t.Fatalf("POST /cdr = %d", code)
+	}
+	if len(events) != 2 || string(events[1].CID) != "BC099884@6dfcffe8" ||
+		string(events[1].Payload) != `{"account":12345678901234567890,"call_id":"BC099884@6dfcffe8","type":"cdr"}` {
+		t.Errorf("CDR HEP logs = %+v", events)
+	}
+}
+
+func TestCacheSDPOrigin(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	sdp := func(version, port string) []byte {
+		return []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+			"Call-ID: reinvite-1@example.com\r\n\r\n" +
+			"v=0\r\n" +
+			"o=alice 2890844526 " + version + " IN IP4 10.0.0.1\r\n" +
+			"c=IN IP4 10.0.0.1\r\n" +
+			"m=audio " + port + " RTP/AVP 0\r\n")
+	}
+	callID := []byte("reinvite-1@example.com")
+	origin := protos.SDPOrigin{Username: "alice", SessionID: "2890844526", Address: "10.0.0.1"}
+
+	if !d.cacheSDPOrigin(callID, sdp("1", "40000")) {
+		t.Fatal("first SDP was taken as retransmission")
+	}
+	if d.cacheSDPOrigin(callID, sdp("1", "40000")) {
+		t.Error("retransmitted SDP was taken as new version")
+	}
+	if !d.cacheSDPOrigin(callID, sdp("2", "40010")) {
+		t.Error("re-INVITE with new version was taken as retransmission")
+	}
+	if version := d.SDPVersion(callID, origin); string(version) != "2" {
+		t.Errorf("SDPVersion() = %q, want 2", version)
+	}
+
+	d.cacheSDPIPPort(sdp("3", "40020"))
+	if corrID, err := d.SDPCache.Get([]byte("10.0.0.140021")); err != nil || string(corrID) != string(callID) {
+		t.Errorf("new media of re-INVITE not cached: %q, %v", corrID, err)
+	}
+}
+
+func TestCacheSDPGzip(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte("v=0\r\no=- 1 1 IN IP4 10.0.0.7\r\nc=IN IP4 10.0.0.7\r\nm=audio 41000 RTP/AVP 0\r\n"))
+	gz.Close()
+	invite := append([]byte("INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"Call-ID: gzip@host\r\n"+
+		"Content-Type: application/sdp\r\n"+
+		"Content-Encoding: gzip\r\n\r\n"), body.Bytes()...)
+	orig := string(invite)
+
+	d.cacheSDPIPPort(invite)
+	if callID, err := d.SDPCache.Get([]byte("10.0.0.741001")); err != nil || string(callID) != "gzip@host" {
+		t.Errorf("gzipped SDP not cached: %q, %v", callID, err)
+	}
+	if string(invite) != orig {
+		t.Error("payload was modified")
+	}
+
+	// The repeated lines make gzip compress instead of storing the SDP
+	body.Reset()
+	gz = gzip.NewWriter(&body)
+	gz.Write([]byte("v=0\r\nc=IN IP4 10.0.0.7\r\nm=audio 41000 RTP/AVP 0\r\n" + strings.Repeat("a=sendrecv\r\n", 20)))
+	gz.Close()
+	lf := append([]byte("INVITE sip:bob@example.com SIP/2.0\n"+
+		"Call-ID: gzip-lf@host\n"+
+		"Content-Type: application/sdp\n"+
+		"Content-Encoding: gzip\n\n"), body.Bytes()...)
+	d.SDPCache.Clear()
+	d.cacheSDPIPPort(lf)
+	if callID, err := d.SDPCache.Get([]byte("10.0.0.741001")); err != nil || string(callID) != "gzip-lf@host" {
+		t.Errorf("gzipped SDP after LF header not cached: %q, %v", callID, err)
+	}
+}
+
+func TestCacheSDPCodec(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	sdp := func(from, formats, rtpmap string) []byte {
+		return []byte("SIP/2.0 200 OK\r\n" +
+			"Call-ID: codec@host\r\n" +
+			"Content-Type: application/sdp\r\n\r\n" +
+			"v=0\r\n" +
+			"o=" + from + " 1 1 IN IP4 10.0.0.1\r\n" +
+			"c=IN IP4 10.0.0.1\r\n" +
+			"m=audio 40000 RTP/AVP " + formats + "\r\n" + rtpmap)
+	}
+
+	offer := sdp("alice", "101 96 8 0", "a=rtpmap:101 telephone-event/8000\r\na=rtpmap:96 opus/48000/2\r\n")
+	medias := protos.ParseSDPMedia(offer)
+	if len(medias) != 1 || len(medias[0].Formats) != 4 {
+		t.Fatalf("ParseSDPMedia() = %+v", medias)
+	}
+	if f := medias[0].Formats[3]; f.PayloadType != 0 || f.Codec != "PCMU/8000" {
+		t.Errorf("static payload type = %+v", f)
+	}
+
+	d.cacheSDPIPPort(offer)
+	if codec := d.SDPCodec([]byte("codec@host")); string(codec) != "opus/48000/2" {
+		t.Errorf("SDPCodec() after offer = %q", codec)
+	}
+	d.cacheSDPIPPort(sdp("bob", "8 101", "a=rtpmap:101 telephone-event/8000\r\n"))
+	if codec := d.SDPCodec([]byte("codec@host")); string(codec) != "PCMA/8000" {
+		t.Errorf("SDPCodec() after answer = %q", codec)
+	}
+}
+
+func TestParseSDPSession(t *testing.T) {
+	sdp := []byte("v=0\r\n" +
+		"o=alice 1 1 IN IP4 10.0.0.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"t=0 0\r\n" +
+		"t=3034423619 3042462419\r\n" +
+		"t=broken\r\n" +
+		"m=audio 40000 RTP/AVP 0\r\n" +
+		"s=ignored\r\n")
+	session, ok := protos.ParseSDPSession(sdp)
+	want := protos.SDPSession{Name: "-", Timings: []protos.SDPTiming{{Start: 0, Stop: 0}, {Start: 3034423619, Stop: 3042462419}}}
+	if !ok || !reflect.DeepEqual(session, want) {
+		t.Errorf("ParseSDPSession() = %+v, %v", session, ok)
+	}
+	if session, ok := protos.ParseSDPSession([]byte("v=0\nt=0 0\n")); ok || len(session.Timings) != 1 {
+		t.Errorf("ParseSDPSession() without s= = %+v, %v", session, ok)
+	}
+}
+
+func TestInvalidateRTCP(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	sdp := func(callID, version string) []byte {
+		return []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+			"Call-ID: " + callID + "\r\n" +
+			"Content-Type: application/sdp\r\n\r\n" +
+			"v=0\r\n" +
+			"o=alice 1 " + version + " IN IP4 10.0.0.1\r\n" +
+			"c=IN IP4 10.0.0.1\r\n" +
+			"m=audio 40000 RTP/AVP 0\r\n")
+	}
+	sr := append([]byte{0x80, 200, 0x00, 0x06, 0x12, 0x34, 0x56, 0x78}, make([]byte, 20)...)
+	src, dst := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+
+	d.cacheSDPIPPort(sdp("old@host", "1"))
+	if _, cid, _ := d.correlateRTCP(src, 40001, dst, 50001, sr); string(cid) != "old@host" {
+		t.Fatalf("RTCP correlated to %q", cid)
+	}
+	// Retransmissions keep the learned SSRC
+	d.cacheSDPIPPort(sdp("old@host", "1"))
+	if cid, _ := d.RTCPCache.Get(sr[4:8]); string(cid) != "old@host" {
+		t.Errorf("RTCPCache after retransmission = %q", cid)
+	}
+
+	// A new call reuses the media address
+	d.cacheSDPIPPort(sdp("new@host", "1"))
+	if _, err := d.RTCPCache.Get(sr[4:8]); err == nil {
+		t.Error("stale SSRC should be removed from the RTCPCache")
+	}
+	if _, cid, _ := d.correlateRTCP(src, 40001, dst, 50001, sr); string(cid) != "new@host" {
+		t.Errorf("RTCP correlated to %q after new SDP", cid)
+	}
+}
+
+func TestSDPConflict(t *testing.T) {
+	defer func() { config.Cfg.SDPConflict = "" }()
+	sdp := func(callID string) []byte {
+		return []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+			"Call-ID: " + callID + "\r\n" +
+			"Content-Type: application/sdp\r\n\r\n" +
+			"v=0\r\n" +
+			"o=alice 1 1 IN IP4 10.0.0.1\r\n" +
+			"c=IN IP4 10.0.0.1\r\n" +
+			"m=audio 40000 RTP/AVP 0\r\n")
+	}
+	// Receiver report of ssrc 0x0000abcd about the remote ssrc 0x11223344
+	rr := []byte{0x81, 201, 0, 7, 0, 0, 0xab, 0xcd,
+		0x11, 0x22, 0x33, 0x44, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0, 80, 0, 0, 0, 0, 0, 0, 0, 0}
+	src, dst := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+
+	for _, tc := range []struct {
+		policy string
+		callID string
+	}{
+		{"overwrite", "new@host"},
+		{"keep-first", "old@host"},
+		{"multi", "new@host"},
+	} {
+		config.Cfg.SDPConflict = tc.policy
+		d := NewDecoder(layers.LinkTypeEthernet)
+		d.cacheSDPIPPort(sdp("old@host"))
+		d.cacheSDPIPPort(sdp("new@host"))
+		if d.sdpConflictCount != 1 {
+			t.Errorf("%s: sdpConflictCount = %d", tc.policy, d.sdpConflictCount)
+		}
+		if cid := d.MediaCallID(src, 40000); string(cid) != tc.callID {
+			t.Errorf("%s: MediaCallID() = %q, want %q", tc.policy, cid, tc.callID)
+		}
+		if _, cid, _ := d.correlateRTCP(src, 40001, dst, 50001, rr); string(cid) != tc.callID {
+			t.Errorf("%s: RTCP correlated to %q, want %q", tc.policy, cid, tc.callID)
+		}
+	}
+
+	// multi picks the call whose remote ssrc is reported on
+	config.Cfg.SDPConflict = "multi"
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.cacheSDPIPPort(sdp("old@host"))
+	d.cacheSDPIPPort(sdp("new@host"))
+	d.cacheSDPIPPort(sdp("old@host"))
+	if d.sdpConflictCount != 1 {
+		t.Errorf("multi: sdpConflictCount = %d after repeated SDP", d.sdpConflictCount)
+	}
+	if err := d.RTCPCache.Set([]byte{0x11, 0x22, 0x33, 0x44}, []byte("new@host"), 60); err != nil {
+		t.Fatal(err)
+	}
+	if _, cid, _ := d.correlateRTCP(src, 40001, dst, 50001, rr); string(cid) != "new@host" {
+		t.Errorf("multi: RTCP correlated to %q by report block", cid)
+	}
+}
+
+func TestScanDetection(t *testing.T) {
+	config.Cfg.Scan, config.Cfg.ScanPatterns, config.Cfg.ScanRate = true, "friendly-scanner,SIPVicious", 3
+	defer func() { config.Cfg.Scan, config.Cfg.ScanPatterns, config.Cfg.ScanRate = false, "", 0 }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	var events []*Packet
+	d.SendEvents(func(pkt *Packet) { events = append(events, pkt) })
+	request := func(src net.IP, tsec uint32, headers string) *Packet {
+		return &Packet{Version: 0x02, Protocol: 0x11, SrcIP: src, DstIP: net.IP{10, 0, 0, 2}, SrcPort: 5070, DstPort: 5060, Tsec: tsec,
+			Payload: []byte("OPTIONS sip:100@10.0.0.2 SIP/2.0\r\nCall-ID: scan@host\r\n" + headers + "\r\n")}
+	}
+
+	// The User-Agent matches, the second hit within a minute is only tagged
+	for i := uint32(0); i < 2; i++ {
+		pkt := request(net.IP{192, 0, 2, 1}, 1000+i, "User-Agent: Friendly-Scanner\r\n")
+		d.checkScan(pkt)
+		if pkt.Scan != "pattern" {
+			t.Errorf("scanner request %d tagged %q", i, pkt.Scan)
+		}
+	}
+	if len(events) != 1 || !bytes.Contains(events[0].Payload, []byte(`"reason":"pattern","pattern":"friendly-scanner"`)) ||
+		string(events[0].CID) != "scan@host" || events[0].ProtoType != 100 {
+		t.Fatalf("scan events = %+v", events)
+	}
+
+	// Only requests above the rate in the same second are tagged
+	for i := 0; i < 5; i++ {
+		pkt := request(net.IP{192, 0, 2, 2}, 2000, "User-Agent: Linphone\r\n")
+		d.checkScan(pkt)
+		if tagged := pkt.Scan == "rate"; tagged != (i >= 3) {
+			t.Errorf("request %d tagged %q", i, pkt.Scan)
+		}
+	}
+	pkt := request(net.IP{192, 0, 2, 2}, 2001, "User-Agent: Linphone\r\n")
+	if d.checkScan(pkt); pkt.Scan != "" {
+		t.Errorf("request in the next second tagged %q", pkt.Scan)
+	}
+	if len(events) != 2 || !bytes.Contains(events[1].Payload, []byte(`"reason":"rate"`)) {
+		t.Errorf("scan events = %d", len(events))
+	}
+	if d.scanCount != 4 {
+		t.Errorf("scanCount = %d", d.scanCount)
+	}
+}
+
+func TestDialogKey(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	msg := func(start, from, to string) []byte {
+		return []byte(start + "\r\nCall-ID: reused@gw\r\nCSeq: 1 INVITE\r\nFrom: <sip:a@gw>" + from + "\r\nTo: <sip:b@pbx>" + to + "\r\n\r\n")
+	}
+	for _, tc := range []struct {
+		payload []byte
+		want    string
+	}{
+		{msg("INVITE sip:b@pbx SIP/2.0", ";tag=first", ""), "reused@gw;tag=first"},
+		{msg("SIP/2.0 200 OK", ";tag=first", ";tag=callee"), "reused@gw;tag=first"},
+		// The callee hangs up with the tags swapped
+		{msg("BYE sip:a@gw SIP/2.0", ";tag=callee", ";tag=first"), "reused@gw;tag=first"},
+		// The gateway reuses the Call-ID for the next call
+		{msg("INVITE sip:b@pbx SIP/2.0", ";tag=second", ""), "reused@gw;tag=second"},
+		{msg("BYE sip:a@gw SIP/2.0", ";tag=zcallee", ";tag=second"), "reused@gw;tag=second"},
+		// Without the INVITE both directions take the lower tag
+		{msg("BYE sip:b@pbx SIP/2.0", ";tag=y", ";tag=x"), "reused@gw;tag=x"},
+		{msg("SIP/2.0 200 OK", ";tag=y", ";tag=x"), "reused@gw;tag=x"},
+		{msg("OPTIONS sip:b@pbx SIP/2.0", "", ""), "reused@gw"},
+	} {
+		if key := d.dialogKey(tc.payload, []byte("reused@gw")); string(key) != tc.want {
+			t.Errorf("dialogKey(%q) = %q, want %q", tc.payload, key, tc.want)
+		}
+	}
+
+	if callID := DialogCallID([]byte("reused@gw;tag=first")); string(callID) != "reused@gw" {
+		t.Errorf("DialogCallID() = %q", callID)
+	}
+
+	config.Cfg.DialogCID = true
+	defer func() { config.Cfg.DialogCID = false }()
+	ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	data := serializeEthernet(t, ip4, serializeIPv4UDP(t, ip4, msg("INVITE sip:b@pbx SIP/2.0", ";tag=third", ""))[20:])
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	if pkt, err := d.Process(data, &ci); err != nil || pkt == nil || string(pkt.CID) != "reused@gw;tag=third" {
+		t.Errorf("Process() CID = %+v, %v", pkt, err)
+	}
+}
+
+func TestDialogCIDCorrelation(t *testing.T) {
+	config.Cfg.DialogCID, config.Cfg.CallMOS, config.Cfg.CorrelationID = true, true, "callid"
+	defer func() { config.Cfg.DialogCID, config.Cfg.CallMOS, config.Cfg.CorrelationID = false, false, "" }()
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.CorrCache = freecache.NewCache(1024 * 1024)
+
+	frame := func(src, dst net.IP, srcPort, dstPort layers.UDPPort, payload []byte) []byte {
+		ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+		udp := &layers.UDP{SrcPort: srcPort, DstPort: dstPort}
+		udp.SetNetworkLayerForChecksum(ip4)
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, udp, gopacket.Payload(payload)); err != nil {
+			t.Fatal(err)
+		}
+		return serializeEthernet(t, ip4, buf.Bytes())
+	}
+	process := func(data []byte) *Packet {
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+		pkt, err := d.Process(data, &ci)
+		if err != nil || pkt == nil {
+			t.Fatalf("Process() = %+v, %v", pkt, err)
+		}
+		return pkt
+	}
+	alice, bob := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+
+	invite := process(frame(alice, bob, 5060, 5060, []byte("INVITE sip:bob@pbx SIP/2.0\r\n"+
+		"Call-ID: reused@gw\r\nCSeq: 1 INVITE\r\nFrom: <sip:a@gw>;tag=first\r\nTo: <sip:b@pbx>\r\n"+
+		"Content-Type: application/sdp\r\n\r\n"+
+		"v=0\r\nc=IN IP4 10.0.0.1\r\nm=audio 40000 RTP/AVP 0\r\n")))
+	if string(invite.CID) != "reused@gw;tag=first" {
+		t.Fatalf("INVITE CID = %q", invite.CID)
+	}
+
+	rr := []byte{0x81, 201, 0, 7, 0, 0, 0, 1,
+		0x12, 0x34, 0x56, 0x78, 26, 0, 0, 3, 0, 0, 0, 100, 0, 0, 0, 80, 0, 0, 0, 0, 0, 0, 0, 0}
+	rtcp := process(frame(alice, bob, 40001, 50001, rr))
+	if string(rtcp.CID) != string(invite.CID) || len(rtcp.CorrID) == 0 || string(rtcp.CorrID) != string(invite.CorrID) {
+		t.Errorf("RTCP CID %q CorrID %q, SIP CorrID %q", rtcp.CID, rtcp.CorrID, invite.CorrID)
+	}
+
+	// The callee hangs up with the tags swapped
+	process(frame(bob, alice, 5060, 5060, []byte("BYE sip:a@gw SIP/2.0\r\n"+
+		"Call-ID: reused@gw\r\nCSeq: 1 BYE\r\nFrom: <sip:b@pbx>;tag=callee\r\nTo: <sip:a@gw>;tag=first\r\n\r\n")))
+	reports := d.MOSReports()
+	if len(reports) != 1 || string(reports[0].CID) != "reused@gw;tag=first" || !bytes.Contains(reports[0].Payload, []byte(`"end":"bye"`)) {
+		t.Errorf("MOSReports() = %+v", reports)
+	}
+}
+
+func TestRTPQoS(t *testing.T) {
+	config.Cfg.RTPQoS = time.Second
+	defer func() { config.Cfg.RTPQoS = 0 }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	var events []*Packet
+	d.SendEvents(func(pkt *Packet) { events = append(events, pkt) })
+	d.cacheSDPIPPort([]byte("SIP/2.0 200 OK\r\n" +
+		"Call-ID: qos@host\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\r\n" +
+		"o=bob 1 1 IN IP4 10.0.0.1\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 40000 RTP/AVP 8\r\n"))
+
+	start := time.Now()
+	pkt := &Packet{Version: 0x02, Protocol: 0x11, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, SrcPort: 40000, DstPort: 50000}
+	rtp := make([]byte, 172)
+	rtp[0], rtp[1] = 0x80, 8
+	binary.BigEndian.PutUint32(rtp[8:12], 0xcafe)
+	// 20 ms PCMA packets for a second without 5 and 6 and with 10 late
+	for seq := 0; seq <= 50; seq++ {
+		if seq == 5 || seq == 6 {
+			continue
+		}
+		binary.BigEndian.PutUint16(rtp[2:4], uint16(65530+seq))
+		binary.BigEndian.PutUint32(rtp[4:8], uint32(seq*160))
+		ts := start.Add(time.Duration(seq) * 20 * time.Millisecond)
+		if seq == 10 {
+			ts = ts.Add(16 * time.Millisecond)
+		}
+		d.trackQoS(pkt, rtp, ts)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("got %d QoS records, want 1", len(events))
+	}
+	var r qosReport
+	if err := json.Unmarshal(events[0].Payload, &r); err != nil {
+		t.Fatal(err)
+	}
+	if string(events[0].CID) != "qos@host" || r.Type != "rtp_qos" || r.SSRC != 0xcafe || r.Packets != 49 || r.Expected != 51 || r.Lost != 2 || r.Loss != 3.92 {
+		t.Errorf("QoS record %s of %q", events[0].Payload, events[0].CID)
+	}
+	if r.Jitter <= 0 || r.Jitter >= 1 {
+		t.Errorf("jitter = %v ms", r.Jitter)
+	}
+}
+
+func TestMediaTime(t *testing.T) {
+	config.Cfg.TimeSource = "rtp"
+	defer func() { config.Cfg.TimeSource = "capture" }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.cacheSDPIPPort([]byte("SIP/2.0 200 OK\r\n" +
+		"Call-ID: clock@host\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\r\n" +
+		"o=bob 1 1 IN IP4 10.0.0.1\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 40000 RTP/AVP 8\r\n" +
+		"a=rtpmap:8 PCMA/8000\r\n"))
+
+	start := time.Unix(1700000000, 0)
+	callID := []byte("clock@host")
+	base := uint32(0xfffffe00)
+	if got := d.mediaTime(0xcafe, base, callID, start); !got.Equal(start) {
+		t.Fatalf("first packet at %v, want capture time %v", got, start)
+	}
+	// One second of media captured with 300 ms of jitter, across the wrap
+	if got, want := d.mediaTime(0xcafe, base+8000, callID, start.Add(1300*time.Millisecond)), start.Add(time.Second); !got.Equal(want) {
+		t.Errorf("media time %v, want %v", got, want)
+	}
+	// A jump of the RTP timestamps re-anchors the clock
+	late := start.Add(2 * time.Second)
+	if got := d.mediaTime(0xcafe, 0x40000000, callID, late); !got.Equal(late) {
+		t.Errorf("media time after jump %v, want %v", got, late)
+	}
+	// Unknown clock rate
+	if got := d.mediaTime(0xbeef, 8000, []byte("other@host"), late); !got.Equal(late) {
+		t.Errorf("media time without SDP %v, want %v", got, late)
+	}
+
+	sr := make([]byte, 28)
+	sr[0], sr[1] = 0x80, 200
+	binary.BigEndian.PutUint32(sr[4:8], 0xcafe)
+	binary.BigEndian.PutUint32(sr[16:20], 0x40000000+4000)
+	pkt := &Packet{CID: callID}
+	d.setMediaTime(pkt, sr, true, late.Add(time.Second))
+	if got := time.Unix(int64(pkt.Tsec), int64(pkt.Tmsec)*1000); !got.Equal(late.Add(500 * time.Millisecond)) {
+		t.Errorf("sender report at %v, want %v", got, late.Add(500*time.Millisecond))
+	}
+}
+
+func TestSweepIdle(t *testing.T) {
+	config.Cfg.CallMOS = true
+	config.Cfg.SweepInterval, config.Cfg.IdleTimeout = time.Hour, time.Minute
+	defer func() { config.Cfg.CallMOS, config.Cfg.SweepInterval, config.Cfg.IdleTimeout = false, 0, 0 }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	now := time.Now()
+	d.quic.flows = map[string]time.Time{"old": now.Add(-2 * time.Minute), "new": now}
+	rr := []byte{0x81, 201, 0, 7, 0, 0, 0, 1,
+		0x12, 0x34, 0x56, 0x78, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0, 80, 0, 0, 0, 0, 0, 0, 0, 0}
+	d.trackMOS([]byte("gone@host"), &Packet{Version: 0x02, SrcIP: net.IP{10, 0, 0, 2}, DstIP: net.IP{10, 0, 0, 1}}, rr, now.Add(-2*time.Minute))
+
+	// The sweep runs with the next packet after the ticker
+	atomic.StoreInt32(&d.sweep.due, 1)
+	ci := gopacket.CaptureInfo{Timestamp: now, CaptureLength: len(rawPacket), Length: len(rawPacket)}
+	d.Process(rawPacket, &ci)
+
+	if len(d.quic.flows) != 1 || len(d.mos.calls) != 0 {
+		t.Errorf("%d QUIC flows and %d MOS calls left, want 1 and 0", len(d.quic.flows), len(d.mos.calls))
+	}
+	reports := d.MOSReports()
+	if len(reports) != 1 || !bytes.Contains(reports[0].Payload, []byte(`"end":"abandoned"`)) || !reports[0].SrcIP.Equal(net.IP{10, 0, 0, 2}) {
+		t.Errorf("MOSReports() = %+v", reports)
+	}
+	if evicted := d.Evictions(); evicted["quic"] != 1 || evicted["mos"] != 1 || evicted["fragments"] != 0 {
+		t.Errorf("Evictions() = %v", evicted)
+	}
+}
+
+func TestAsymmetricRTCP(t *testing.T) {
+	config.Cfg.FlowCache = true
+	defer func() { config.Cfg.FlowCache = false }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.cacheSDPIPPort([]byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: asym@host\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 40000 RTP/AVP 0\r\n"))
+	sr := func(ssrc byte) []byte {
+		return append([]byte{0x80, 200, 0x00, 0x06, 0x12, 0x34, 0x56, ssrc}, make([]byte, 20)...)
+	}
+	sdpSide, natSide := net.IP{10, 0, 0, 1}, net.IP{192, 0, 2, 7}
+
+	report, cid, _ := d.correlateRTCP(sdpSide, 40001, natSide, 61001, sr(1))
+	if string(cid) != "asym@host" || bytes.Contains(report, []byte("asymmetric")) {
+		t.Fatalf("RTCP from the SDP address = %s, %q", report, cid)
+	}
+	// The other side isn't in the SDP and only matches the learned flow
+	for i := 0; i < 2; i++ {
+		report, cid, _ = d.correlateRTCP(natSide, 61001, sdpSide, 40001, sr(2))
+		if string(cid) != "asym@host" || !bytes.HasPrefix(report, []byte(`{"asymmetric":true,`)) {
+			t.Errorf("RTCP from the NAT address = %s, %q", report, cid)
+		}
+	}
+	if d.asymCount != 2 {
+		t.Errorf("asymCount = %d, want 2", d.asymCount)
+	}
+}
+
+func TestCorrelateRTPEvent(t *testing.T) {
+	config.Cfg.RTPEvents = true
+	defer func() { config.Cfg.RTPEvents = false }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.cacheSDPIPPort([]byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: dtmf@host\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 40000 RTP/AVP 0 101\r\n" +
+		"a=rtpmap:101 telephone-event/8000\r\n"))
+
+	rtp := func(pt, flags byte) []byte {
+		return []byte{0x80, pt, 0x00, 0x01, 0x00, 0x00, 0x10, 0x00, 0x12, 0x34, 0x56, 0x78, 5, flags, 0x03, 0x20}
+	}
+	src, dst := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	if data, _, _ := d.correlateRTPEvent(src, 40000, dst, 50000, rtp(0x80|101, 0x0a)); data != nil {
+		t.Errorf("event without end bit = %s", data)
+	}
+	if data, _, _ := d.correlateRTPEvent(src, 40000, dst, 50000, rtp(0, 0x8a)); data != nil {
+		t.Errorf("PCMU packet = %s", data)
+	}
+	data, cid, proto := d.correlateRTPEvent(src, 40000, dst, 50000, rtp(101, 0x8a))
+	want := `{"type":"rtp-event","event":5,"digit":"5","end":true,"volume":10,"duration":800,"ssrc":305419896,"timestamp":4096}`
+	if string(data) != want || string(cid) != "dtmf@host" || proto != 100 {
+		t.Errorf("correlateRTPEvent() = %s, %s, %d", data, cid, proto)
+	}
+	if data, _, _ := d.correlateRTPEvent(src, 40000, dst, 50000, rtp(101, 0x8a)); data != nil {
+		t.Errorf("retransmitted end = %s", data)
+	}
+}
+
+func TestVAD(t *testing.T) {
+	config.Cfg.VADGap = 100 * time.Millisecond
+	defer func() { config.Cfg.VADGap = 0 }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.cacheSDPIPPort([]byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: vad@host\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 40000 RTP/AVP 0 118\r\n" +
+		"a=rtpmap:118 CN/8000\r\n"))
+
+	pkt := &Packet{SrcIP: net.IP{10, 0, 0, 1}, SrcPort: 40000, DstIP: net.IP{10, 0, 0, 2}, DstPort: 50000}
+	rtp := func(pt byte, seq uint16) []byte {
+		return []byte{0x80, pt, byte(seq >> 8), byte(seq), 0, 0, 0, 0, 0x12, 0x34, 0x56, 0x78}
+	}
+	now := time.Now()
+	d.trackVAD(pkt, rtp(0, 1), now)
+	d.trackVAD(pkt, rtp(0, 2), now.Add(20*time.Millisecond))
+	d.trackVAD(pkt, rtp(118, 3), now.Add(40*time.Millisecond))
+	// VAD silence keeps the sequence numbers
+	d.trackVAD(pkt, rtp(0, 4), now.Add(540*time.Millisecond))
+	// Loss leaves a hole
+	d.trackVAD(pkt, rtp(0, 10), now.Add(1040*time.Millisecond))
+
+	sr := append([]byte{0x80, 200, 0, 6, 0x12, 0x34, 0x56, 0x78}, make([]byte, 20)...)
+	report := d.addVAD([]byte(`{"ssrc":305419896}`), sr)
+	if want := `{"cn_packets":1,"vad_gaps":1,"vad_silence_ms":500,"ssrc":305419896}`; string(report) != want {
+		t.Errorf("addVAD() = %s, want %s", report, want)
+	}
+	report = d.addVAD([]byte(`{}`), sr)
+	if want := `{"cn_packets":0,"vad_gaps":0,"vad_silence_ms":0}`; string(report) != want {
+		t.Errorf("addVAD() after reset = %s", report)
+	}
+	sr[7] = 0x79
+	if report = d.addVAD([]byte(`{}`), sr); string(report) != `{}` {
+		t.Errorf("addVAD() of unknown SSRC = %s", report)
+	}
+}
+
+func TestSDPCandidates(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.cacheSDPIPPort([]byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: ice@host\r\n" +
+		"Content-Type: application/sdp\r\n\r\n" +
+		"v=0\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 40000 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=candidate:1 1 udp 2113937151 10.0.0.1 40000 typ host generation 0\r\n" +
+		"a=candidate:2 1 udp 1845501695 203.0.113.7 61000 typ srflx raddr 10.0.0.1 rport 40000\r\n" +
+		"a=candidate:3 1 udp 1845501695 2001:DB8::7 62000 typ relay raddr 0.0.0.0 rport 0\r\n" +
+		"a=candidate:4 1 tcp 1518280447 203.0.113.7 9 typ host tcptype active\r\n" +
+		"a=candidate:5 1 udp 2113937151 7f4c.local 50000 typ host\r\n"))
+
+	for _, c := range []struct {
+		ip   net.IP
+		port uint16
+		want string
+	}{
+		{net.IP{203, 0, 113, 7}, 61000, "ice@host"},
+		{net.ParseIP("2001:db8::7"), 62000, "ice@host"},
+		{net.IP{203, 0, 113, 7}, 9, ""},
+	} {
+		if callID := d.MediaCallID(c.ip, c.port); string(callID) != c.want {
+			t.Errorf("MediaCallID(%v, %d) = %q, want %q", c.ip, c.port, callID, c.want)
+		}
+	}
+
+	media := protos.ParseSDPMedia([]byte("m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:2 1 UDP 1845501695 203.0.113.7 61000 typ srflx raddr 10.0.0.1 rport 40000\r\n" +
+		"a=candidate:bad\r\n"))
+	want := []protos.SDPCandidate{{Foundation: "2", Component: 1, Transport: "udp", Priority: 1845501695, Address: "203.0.113.7", Port: 61000, Type: "srflx"}}
+	if len(media) != 1 || !reflect.DeepEqual(media[0].Candidates, want) {
+		t.Errorf("ParseSDPMedia() = %+v", media)
+	}
+}
+
+func TestMOS(t *testing.T) {
+	if _, err := ParseCodecImpairments("PCMA=0"); err == nil {
+		t.Error("codec parameters without Bpl should fail")
+	}
+	if r, mos := eModel(0, 0, 0, defaultImpairment); r != 93.2 || mos != 4.41 {
+		t.Errorf("eModel() without impairments = %v, %v", r, mos)
+	}
+
+	config.Cfg.CallMOS = true
+	config.Cfg.MOSCodecs = "G729=11/19"
+	defer func() { config.Cfg.CallMOS, config.Cfg.MOSCodecs = false, "" }()
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.SDPCache.Set(codecKey([]byte("mos@host")), []byte("G729/8000"), 120)
+
+	// RR about SSRC 0x12345678 with 10% loss and a jitter of 10ms
+	rr := []byte{0x81, 201, 0, 7, 0, 0, 0, 1,
+		0x12, 0x34, 0x56, 0x78, 26, 0, 0, 3, 0, 0, 0, 100, 0, 0, 0, 80, 0, 0, 0, 0, 0, 0, 0, 0}
+	pkt := &Packet{SrcIP: net.IP{10, 0, 0, 2}, SrcPort: 50001}
+	now := time.Now()
+	d.trackMOS([]byte("mos@host"), pkt, rr, now)
+	rr[12] = 0
+	d.trackMOS([]byte("mos@host"), pkt, rr, now.Add(5*time.Second))
+
+	d.finishMOS(&Packet{Payload: []byte("BYE sip:bob@example.com SIP/2.0\r\nCall-ID: mos@host\r\n\r\n")})
+	reports := d.MOSReports()
+	if len(reports) != 1 || reports[0].ProtoType != 100 || string(reports[0].CID) != "mos@host" {
+		t.Fatalf("MOSReports() = %+v", reports)
+	}
+	want := `{"type":"mos","call_id":"mos@host","end":"bye","codec":"G729/8000","streams":[{"reporter":"10.0.0.2:50001","ssrc":305419896,"reports":2,"packets_lost":3,"loss_percent":5.08,"jitter_ms":10,"rtt_ms":0,"r_factor":64,"mos":3.3}]}`
+	if string(reports[0].Payload) != want {
+		t.Errorf("MOS record = %s, want %s", reports[0].Payload, want)
+	}
+	if reports = d.MOSReports(); len(reports) != 0 {
+		t.Errorf("MOSReports() returned %d records twice", len(reports))
+	}
+}
+
+func TestMOSInterim(t *testing.T) {
+	config.Cfg.CallMOS, config.Cfg.MediaStatsInterval = true, 10*time.Second
+	defer func() { config.Cfg.CallMOS, config.Cfg.MediaStatsInterval = false, 0 }()
+	d := NewDecoder(layers.LinkTypeEthernet)
+
+	rr := []byte{0x81, 201, 0, 7, 0, 0, 0, 1,
+		0x12, 0x34, 0x56, 0x78, 0, 0, 0, 3, 0, 0, 0, 100, 0, 0, 0, 80, 0, 0, 0, 0, 0, 0, 0, 0}
+	pkt := &Packet{SrcIP: net.IP{10, 0, 0, 2}, SrcPort: 50001}
+	now := time.Now()
+	for i := 0; i <= 4; i++ {
+		rr[15] = byte(3 + i)
+		d.trackMOS([]byte("long@host"), pkt, rr, now.Add(time.Duration(i)*5*time.Second))
+	}
+	reports := d.MOSReports()
+	if len(reports) != 2 {
+		t.Fatalf("got %d interim records, want 2", len(reports))
+	}
+	for i, want := range []string{`"reports":3,"packets_lost":5`, `"reports":5,"packets_lost":7`} {
+		if !bytes.Contains(reports[i].Payload, []byte(`"end":"interim"`)) || !bytes.Contains(reports[i].Payload, []byte(want)) {
+			t.Errorf("interim record %d = %s, want %s", i, reports[i].Payload, want)
+		}
+	}
+
+	d.finishMOS(&Packet{Payload: []byte("BYE sip:bob@example.com SIP/2.0\r\nCall-ID: long@host\r\n\r\n")})
+	if reports = d.MOSReports(); len(reports) != 1 || !bytes.Contains(reports[0].Payload, []byte(`"end":"bye","codec":"unknown","streams":[{"reporter":"10.0.0.2:50001","ssrc":305419896,"reports":5,"packets_lost":7,"loss_percent":0,"jitter_ms":10`)) {
+		t.Errorf("final record = %+v", reports)
+	}
+}
+
+func TestParseRTCPCompound(t *testing.T) {
+	rr := []byte{0x81, 201, 0, 7, 0, 0, 0, 1,
+		0x12, 0x34, 0x56, 0x78, 26, 0, 0, 3, 0, 0, 0, 100, 0, 0, 0, 80, 0, 0, 0, 0, 0, 0, 0, 0}
+	sdes := append([]byte{0x81, 202, 0, 4, 0, 0, 0, 1, 1, 9}, "user@host\x00"...)
+	bye := append([]byte{0x81, 203, 0, 4, 0, 0, 0, 1, 8}, "teardown\x00\x00\x00"...)
+	payload := append(append(append([]byte{}, rr...), sdes...), bye...)
+
+	ssrc, report, info := protos.ParseRTCP(payload)
+	if info != "" || !bytes.Equal(ssrc, []byte{0, 0, 0, 1}) {
+		t.Fatalf("ParseRTCP() = %x, %q", ssrc, info)
+	}
+	var got protos.RTCP_Packet
+	if err := json.Unmarshal(report, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != 201 || len(got.ReportBlocks) != 1 || got.ReportBlocks[0].SourceSsrc != 0x12345678 {
+		t.Errorf("RR of the compound packet = %+v", got)
+	}
+	if len(got.Sdes) != 1 || !reflect.DeepEqual(got.Sdes[0].Items, []protos.RTCP_sdes_item{{Type: "cname", Text: "user@host"}}) {
+		t.Errorf("SDES of the compound packet = %+v", got.Sdes)
+	}
+	if got.Bye == nil || !reflect.DeepEqual(got.Bye.Ssrcs, []uint32{1}) || got.Bye.Reason != "teardown" {
+		t.Errorf("BYE of the compound packet = %+v", got.Bye)
+	}
+
+	// A report count beyond the packet length must not read the next packet
+	rr[0] = 0x84
+	if _, report, _ = protos.ParseRTCP(append(rr, sdes...)); !bytes.Contains(report, []byte(`"cname"`)) {
+		t.Errorf("ParseRTCP() with a wrong report count = %s", report)
+	}
+}
+
+func TestCorrelateRadius(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	attr := func(typ byte, value []byte) []byte {
+		return append([]byte{typ, byte(len(value) + 2)}, value...)
+	}
+	radius := func(code byte, attrs ...[]byte) []byte {
+		b := append([]byte{code, 7, 0, 0}, make([]byte, 16)...)
+		for _, a := range attrs {
+			b = append(b, a...)
+		}
+		b[3] = byte(len(b))
+		return b
+	}
+	pair := []byte("call-id=radius@host")
+	vsa := append([]byte{0, 0, 0, 9, 1, byte(len(pair) + 2)}, pair...)
+	client, server := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+
+	data, cid, proto := d.correlateRadius(client, 40000, server, 1813, radius(4,
+		attr(40, []byte{0, 0, 0, 1}), attr(44, []byte("session-1")), attr(26, vsa)))
+	want := `{"type":"radius","code":"Accounting-Request","identifier":7,"acct_status_type":"Start","acct_session_id":"session-1","call_id":"radius@host"}`
+	if string(data) != want || string(cid) != "radius@host" || proto != 100 {
+		t.Errorf("correlateRadius(request) = %s, %s, %d", data, cid, proto)
+	}
+	data, cid, _ = d.correlateRadius(server, 1813, client, 40000, radius(5))
+	if string(cid) != "radius@host" {
+		t.Errorf("correlateRadius(response) = %s, %s", data, cid)
+	}
+	if data, _, _ := d.correlateRadius(server, 1813, client, 40000, radius(5)); data != nil {
+		t.Errorf("response without request = %s", data)
+	}
+	if _, cid, _ := d.correlateRadius(client, 40000, server, 1813, radius(4, attr(44, []byte("kamailio@host")))); string(cid) != "kamailio@host" {
+		t.Errorf("Acct-Session-Id wasn't used as Call-ID: %s", cid)
+	}
+	if data, _, _ := d.correlateRadius(client, 40000, server, 1813, radius(4, []byte{44, 40})); data != nil {
+		t.Errorf("malformed attribute = %s", data)
+	}
+}
+
+func TestCountResponse(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	d.countResponse([]byte("INVITE sip:bob@example.com SIP/2.0\r\nCSeq: 1 INVITE\r\n\r\n"))
+	d.countResponse([]byte("SIP/2.0 486 Busy Here\r\nCSeq: 1 INVITE\r\n\r\n"))
+	d.countResponse([]byte("SIP/2.0 404 Not Found\r\nCSeq: 1 INVITE\r\n\r\n"))
+	d.countResponse([]byte("SIP/2.0 200 OK\r\nCSeq: 2 BYE\r\n\r\n"))
+
+	counts := d.ResponseCounts()
+	if len(counts) != 2 || counts["INVITE 4xx"] != 2 || counts["BYE 2xx"] != 1 {
+		t.Errorf("ResponseCounts() = %v", counts)
+	}
+	if counts = d.ResponseCounts(); len(counts) != 0 {
+		t.Errorf("ResponseCounts() was not reset: %v", counts)
+	}
+}
+
+func TestProcessExportParseErrors(t *testing.T) {
+	config.Cfg.ExportParseErrors = true
+	defer func() { config.Cfg.ExportParseErrors = false }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	// Cut the frame, so the IPv4 length exceeds the captured bytes
+	data := rawPacket[:200]
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	pkt, err := d.Process(data, &ci)
+	if err != nil || pkt == nil {
+		t.Fatalf("Process() = %v, %v", pkt, err)
+	}
+	if pkt.ProtoType != 100 || !strings.Contains(string(pkt.Payload), "IPv4 length exceeds captured bytes") {
+		t.Errorf("wrong parse error packet %d %q", pkt.ProtoType, pkt.Payload)
+	}
+
+	// gopacket's SIP decoder fails on unknown methods, the SIP is still sent
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	sip := []byte("KDMQ sip:a@b SIP/2.0\r\nCall-ID: kdmq@host\r\nCSeq: 1 KDMQ\r\n\r\n")
+	frame := serializeEthernet(t, ip4, serializeIPv4UDP(t, ip4, sip)[20:])
+	ci = gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(frame), Length: len(frame)}
+	if pkt, err = d.Process(frame, &ci); pkt == nil || pkt.ProtoType != 1 || string(pkt.Payload) != string(sip) {
+		t.Errorf("Process() of valid SIP = %+v, %v", pkt, err)
+	}
+
+	// A broken UDP header is reported
+	frame = serializeEthernet(t, ip4, []byte{0x13, 0xc4, 0x13, 0xc4})
+	ci = gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(frame), Length: len(frame)}
+	if pkt, _ = d.Process(frame, &ci); pkt == nil || !strings.Contains(string(pkt.Payload), "UDP header") {
+		t.Errorf("Process() of broken UDP = %+v", pkt)
+	}
+
+	d.parseErrCount = maxParseErrors
+	if pkt, _ = d.Process(data, &ci); pkt != nil {
+		t.Errorf("parse errors above the limit are exported")
+	}
+}
+
+func TestCacheSDPBundle(t *testing.T) {
+	d := NewDecoder(layers.LinkTypeEthernet)
+	invite := []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: bundle-1@example.com\r\n\r\n" +
+		"v=0\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"a=group:BUNDLE 0 1\r\n" +
+		"m=audio 40000 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:0\r\n" +
+		"a=rtcp-mux\r\n" +
+		"m=video 0 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=bundle-only\r\n" +
+		"a=mid:1\r\n" +
+		"a=rtcp-mux\r\n")
+
+	d.cacheSDPIPPort(invite)
+	if corrID, err := d.SDPCache.Get([]byte("10.0.0.140000")); err != nil || string(corrID) != "bundle-1@example.com" {
+		t.Errorf("bundle transport not cached: %q, %v", corrID, err)
+	}
+	if media := d.SDPBundle([]byte("bundle-1@example.com")); string(media) != "audio video" {
+		t.Errorf("SDPBundle() = %q", media)
+	}
+}
+
+func TestProcessClockSkew(t *testing.T) {
+	config.Cfg.MaxClockSkew = time.Hour
+	defer func() { config.Cfg.MaxClockSkew = 0 }()
+
+	d := NewDecoder(layers.LinkTypeEthernet)
+	for _, ts := range []time.Time{time.Now().Add(-30 * time.Minute), time.Now().Add(-48 * time.Hour), time.Now().Add(2 * time.Hour)} {
+		ci := gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(rawPacket), Length: len(rawPacket)}
+		pkt, err := d.Process(rawPacket, &ci)
+		if err != nil || pkt == nil {
+			t.Fatalf("Process() = %v, %v", pkt, err)
+		}
+		if skew := time.Since(time.Unix(int64(pkt.Tsec), 0)); skew > time.Hour || skew < -time.Hour {
+			t.Errorf("timestamp %v was not replaced", ts)
+		}
+	}
+	if d.skewCount != 2 {
+		t.Errorf("skewCount = %d, want 2", d.skewCount)
+	}
+}
+
+func TestHeaderChunks(t *testing.T) {
+	for _, s := range []string{"Subject", "Subject=0x0f", "Subject=0x10000"} {
+		if _, err := parseHeaderChunks(s); err == nil {
+			t.Errorf("parseHeaderChunks(%q) should fail", s)
+		}
+	}
+	chunks, err := parseHeaderChunks("Organization=0x40, Subject=65")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Decoder{hdrChunks: chunks}
+	got := d.headerChunks([]byte("INVITE sip:bob@example.com SIP/2.0\r\ns: tag-7\r\nOrganization: PBX 1\r\n\r\n"))
+	want := []HeaderChunk{{Type: 0x40, Value: []byte("PBX 1")}, {Type: 0x41, Value: []byte("tag-7")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("headerChunks() = %q, want %q", got, want)
+	}
+}
+
+func TestNodeID(t *testing.T) {
+	nodeIDs, err := parseNodeIDs("eth0=2003, carrierA=2004, 10.0.1.5=2005")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localIPs, err := parseLocalIPs("10.0.0.5, 10.0.1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Decoder{NodeID: 2002, nodeIDs: nodeIDs, localIPs: localIPs}
+
+	tests := []struct {
+		pkt  Packet
+		want uint32
+	}{
+		{Packet{IfaceName: "eth0", Trunk: "carrierA"}, 2004},
+		{Packet{IfaceName: "eth0", Trunk: "carrierB"}, 2003},
+		{Packet{IfaceName: "eth1"}, 2002},
+		{Packet{IfaceName: "eth0", SrcIP: net.IP{192, 0, 2, 1}, DstIP: net.IP{10, 0, 1, 5}}, 2005},
+		{Packet{IfaceName: "eth0", SrcIP: net.IP{10, 0, 0, 5}, DstIP: net.IP{10, 0, 1, 5}}, 2003},
+	}
+	for _, tt := range tests {
+		tt.pkt.LocalIP = d.localIP(&tt.pkt)
+		if got := d.nodeID(&tt.pkt); got != tt.want {
+			t.Errorf("nodeID(%s, %s) = %d, want %d", tt.pkt.IfaceName, tt.pkt.Trunk, got, tt.want)
+		}
+	}
+
+	if _, err := parseNodeIDs("eth0=abc"); err == nil {
+		t.Error("invalid node ID was accepted")
+	}
+	if _, err := parseLocalIPs("10.0.0.5,eth0"); err == nil {
+		t.Error("invalid local IP was accepted")
+	}
+}
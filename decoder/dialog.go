@@ -0,0 +1,79 @@
+package decoder
+
+import (
+	"bytes"
+
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/heplify/ownlayers"
+	"github.com/negbie/logp"
+)
+
+// sipCID returns the Call-ID a SIP packet is correlated by, which is the
+// dialog key with -dcid. It is kept in pkt.CID, so SIP, RTCP, MOS, CDR
+// and correlation IDs all use the same key.
+func (d *Decoder) sipCID(pkt *Packet) []byte {
+	if pkt.CID == nil {
+		pkt.CID = ExtractCallID(pkt.Payload)
+		if config.Cfg.DialogCID && pkt.CID != nil {
+			pkt.CID = d.dialogKey(pkt.Payload, pkt.CID)
+		}
+	}
+	return pkt.CID
+}
+
+// DialogCallID returns the Call-ID of a correlation ID which may
+// be a dialog key of -dcid. A Call-ID never contains a semicolon.
+func DialogCallID(cid []byte) []byte {
+	if i := bytes.Index(cid, []byte(";tag=")); i >= 0 {
+		return cid[:i]
+	}
+	return cid
+}
+
+// dialogKey returns callID with the From tag of the request which created the
+// dialog appended like "a84b4c76e66710@pc33;tag=1928301774". It keeps dialogs
+// of gateways which reuse a Call-ID apart. The To tag can't be part of the key,
+// because the dialog creating request and its provisional responses lack it.
+// The creator tag is remembered, so requests of the callee which carry it in
+// the To header get the same key. Without the creating request the lower tag
+// is taken, which is the same in both directions.
+func (d *Decoder) dialogKey(payload, callID []byte) []byte {
+	from := ownlayers.ParseTag(string(extractHeader(payload, "From", "f")))
+	if from == "" {
+		return callID
+	}
+	to := ownlayers.ParseTag(string(extractHeader(payload, "To", "t")))
+
+	tag := from
+	switch {
+	case to == "":
+		if !bytes.HasPrefix(payload, []byte("SIP/2.0 ")) {
+			if err := d.SIPCache.Set(dialogTagKey(callID, from), []byte{1}, 43200); err != nil {
+				logp.Warn("%v", err)
+			}
+		}
+	case d.isDialogTag(callID, from):
+	case d.isDialogTag(callID, to) || to < from:
+		tag = to
+	}
+
+	key := make([]byte, 0, len(callID)+5+len(tag))
+	key = append(key, callID...)
+	key = append(key, ";tag="...)
+	return append(key, tag...)
+}
+
+// isDialogTag tells if tag is the From tag of a dialog creating request of callID.
+func (d *Decoder) isDialogTag(callID []byte, tag string) bool {
+	_, err := d.SIPCache.Get(dialogTagKey(callID, tag))
+	return err == nil
+}
+
+// dialogTagKey returns the SIPCache key for the From tag of a dialog creating request.
+func dialogTagKey(callID []byte, tag string) []byte {
+	key := make([]byte, 0, 4+len(callID)+1+len(tag))
+	key = append(key, "dlg:"...)
+	key = append(key, callID...)
+	key = append(key, 0)
+	return append(key, tag...)
+}
@@ -0,0 +1,107 @@
+package decoder
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/negbie/logp"
+)
+
+// dropReason tells why a packet wasn't sent as HEP.
+type dropReason int
+
+const (
+	dropTruncated dropReason = iota
+	dropDuplicate
+	dropFilter
+	dropDiscard
+	dropMethod
+	dropLayer
+	dropParseError
+	dropFragment
+	dropBadFragment
+	dropLog
+	dropQUIC
+	dropRTCP
+	dropRTP
+	dropNoCallID
+	dropRadius
+	dropSignaling
+	dropIPFilter
+	dropUnknown
+	numDropReasons
+)
+
+var dropReasonNames = [numDropReasons]string{
+	dropTruncated:   "truncated",
+	dropDuplicate:   "duplicate",
+	dropFilter:      "no filter match",
+	dropDiscard:     "discard match",
+	dropMethod:      "discarded method",
+	dropLayer:       "unexpected layer",
+	dropParseError:  "parse error",
+	dropFragment:    "fragment held",
+	dropBadFragment: "bad fragment",
+	dropLog:         "uncorrelated log",
+	dropQUIC:        "QUIC",
+	dropRTCP:        "uncorrelated RTCP",
+	dropRTP:         "RTP",
+	dropNoCallID:    "no Call-ID",
+	dropRadius:      "uncorrelated RADIUS",
+	dropSignaling:   "signaling in media mode",
+	dropIPFilter:    "IP filter",
+	dropUnknown:     "unknown",
+}
+
+func (r dropReason) String() string {
+	if r < 0 || r >= numDropReasons {
+		return fmt.Sprintf("dropReason(%d)", int(r))
+	}
+	return dropReasonNames[r]
+}
+
+// dropStats counts the dropped packets per reason.
+type dropStats struct {
+	sync.Mutex
+	counts [numDropReasons]int
+}
+
+// dropPacket counts a packet which isn't sent because of reason.
+// It returns what process returns for dropped packets.
+func (d *Decoder) dropPacket(reason dropReason) (*Packet, error) {
+	d.drops.Lock()
+	d.drops.counts[reason]++
+	d.drops.Unlock()
+	logp.Debug("drop", "Drop packet: %s", reason)
+	return nil, nil
+}
+
+// DropCounts returns the dropped packets by reason since
+// the last call and resets them.
+func (d *Decoder) DropCounts() map[string]int {
+	d.drops.Lock()
+	defer d.drops.Unlock()
+	counts := make(map[string]int)
+	for reason, count := range d.drops.counts {
+		if count > 0 {
+			counts[dropReason(reason).String()] = count
+		}
+	}
+	d.drops.counts = [numDropReasons]int{}
+	return counts
+}
+
+func (d *Decoder) printDropStats() {
+	counts := d.DropCounts()
+	if len(counts) == 0 {
+		return
+	}
+	stats := make([]string, 0, len(counts))
+	for reason := dropReason(0); reason < numDropReasons; reason++ {
+		if count, ok := counts[reason.String()]; ok {
+			stats = append(stats, fmt.Sprintf("%s: %d", reason, count))
+		}
+	}
+	logp.Info("Dropped packets since last minute %s", strings.Join(stats, ", "))
+}
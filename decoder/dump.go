@@ -0,0 +1,97 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/coocood/freecache"
+	"github.com/negbie/logp"
+)
+
+// maxDumpEntries limits the entries dumped per cache
+const maxDumpEntries = 100000
+
+// CacheEntry is one key/value pair of a correlation cache.
+// TTL is the number of seconds left, 0 means it never expires.
+type CacheEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	TTL   int64  `json:"ttl"`
+}
+
+// dumpString returns b as string if it's printable and hex encoded otherwise,
+// e.g. for the binary SSRC keys of the RTCPCache.
+func dumpString(b []byte) string {
+	if !utf8.Valid(b) {
+		return "0x" + hex.EncodeToString(b)
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return "0x" + hex.EncodeToString(b)
+		}
+	}
+	return string(b)
+}
+
+func dumpCache(cache *freecache.Cache) []CacheEntry {
+	entries := []CacheEntry{}
+	now := time.Now().Unix()
+	it := cache.NewIterator()
+	for entry := it.Next(); entry != nil && len(entries) < maxDumpEntries; entry = it.Next() {
+		var ttl int64
+		if entry.ExpireAt > 0 {
+			ttl = int64(entry.ExpireAt) - now
+		}
+		entries = append(entries, CacheEntry{Key: dumpString(entry.Key), Value: dumpString(entry.Value), TTL: ttl})
+	}
+	return entries
+}
+
+// DumpCaches returns the entries of the enabled correlation caches by their name.
+// If name is set only that cache is returned.
+func (d *Decoder) DumpCaches(name string) map[string][]CacheEntry {
+	caches := map[string]*freecache.Cache{
+		"SIPCache":     d.SIPCache,
+		"SDPCache":     d.SDPCache,
+		"RTCPCache":    d.RTCPCache,
+		"FlowCache":    d.FlowCache,
+		"InviteCache":  d.InviteCache,
+		"CorrCache":    d.CorrCache,
+		"RTTCache":     d.RTTCache,
+		"CDRCache":     d.CDRCache,
+		"CDRSeenCache": d.CDRSeenCache,
+	}
+	dump := make(map[string][]CacheEntry)
+	for n, cache := range caches {
+		if cache == nil || (name != "" && name != n) {
+			continue
+		}
+		dump[n] = dumpCache(cache)
+	}
+	return dump
+}
+
+// ServeHTTP writes the correlation caches as JSON. A single cache
+// can be selected like /debug/caches?cache=RTCPCache.
+func (d *Decoder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(d.DumpCaches(r.URL.Query().Get("cache"))); err != nil {
+		logp.Warn("cache dump: %v", err)
+	}
+}
+
+func (d *Decoder) serveCacheDump(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/caches", d)
+	mux.HandleFunc("/debug/evictions", d.serveEvictions)
+	logp.Info("Serve the correlation caches on http://%s/debug/caches", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logp.Err("cache dump: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package decoder
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/logp"
+)
+
+// captureEvent records in Homer when and how a capture agent came online.
+type captureEvent struct {
+	Type           string `json:"type"`
+	Event          string `json:"event"`
+	Host           string `json:"host"`
+	Version        string `json:"version"`
+	Interface      string `json:"interface"`
+	ReadFile       string `json:"read_file,omitempty"`
+	Mode           string `json:"mode"`
+	CaptureMode    string `json:"capture_mode,omitempty"`
+	PortRange      string `json:"port_range"`
+	Filter         string `json:"filter,omitempty"`
+	Discard        string `json:"discard,omitempty"`
+	DiscardMethods string `json:"discard_methods,omitempty"`
+	Trunks         string `json:"trunks,omitempty"`
+	NodeID         uint32 `json:"node_id"`
+	Time           string `json:"time"`
+}
+
+// SendEvents passes the HEP logs of the decoder itself to send. With -se
+// the capture started event is sent right away and again on each reload.
+func (d *Decoder) SendEvents(send func(*Packet)) {
+	d.events.Store(send)
+	d.sendCaptureEvent("start", time.Now())
+}
+
+// ReloadWeights passes the -hs weights of the reload file to set.
+func (d *Decoder) ReloadWeights(set func(weights string) error) {
+	d.weights.Store(set)
+}
+
+// sendCaptureEvent sends the capture event with the running filters
+// if -se is set. event is "start" or "reload".
+func (d *Decoder) sendCaptureEvent(event string, now time.Time) {
+	if !config.Cfg.StartEvent {
+		return
+	}
+	if pkt := d.captureEvent(event, now); pkt != nil {
+		d.sendEvent(pkt)
+	}
+}
+
+// sendEvent passes pkt to the sender set with SendEvents if there is one.
+func (d *Decoder) sendEvent(pkt *Packet) {
+	if send, ok := d.events.Load().(func(*Packet)); ok && send != nil {
+		send(pkt)
+	}
+}
+
+// captureEvent returns the capture event as HEP log from and to localhost.
+func (d *Decoder) captureEvent(event string, now time.Time) *Packet {
+	f := d.loadFilters()
+	e := captureEvent{
+		Type:           "capture",
+		Event:          event,
+		Host:           d.Host,
+		Version:        config.Cfg.Version,
+		Mode:           config.Cfg.Mode,
+		CaptureMode:    config.Cfg.CaptureMode,
+		Filter:         f.filter,
+		Discard:        f.discard,
+		DiscardMethods: strings.Join(f.methods, ","),
+		Trunks:         f.rawTrunks,
+		NodeID:         d.NodeID,
+		Time:           now.UTC().Format(time.RFC3339),
+	}
+	if iface := config.Cfg.Iface; iface != nil {
+		e.Interface, e.ReadFile, e.PortRange = iface.Device, iface.ReadFile, iface.PortRange
+	}
+	data, err := json.Marshal(&e)
+	if err != nil {
+		logp.Warn("%v", err)
+		return nil
+	}
+	logp.Info("Send capture %s event: %s", event, string(data))
+	return &Packet{
+		Version:   0x02,
+		Protocol:  0x11,
+		SrcIP:     net.IPv4(127, 0, 0, 1).To4(),
+		DstIP:     net.IPv4(127, 0, 0, 1).To4(),
+		Tsec:      uint32(now.Unix()),
+		Tmsec:     uint32(now.Nanosecond() / 1000),
+		ProtoType: 100,
+		NodeID:    d.NodeID,
+		NodePW:    f.nodePW,
+		Payload:   data,
+	}
+}
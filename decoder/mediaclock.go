@@ -0,0 +1,83 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/negbie/heplify/config"
+)
+
+const (
+	// maxMediaClocks limits how many SSRCs keep a media clock anchor
+	maxMediaClocks = 4096
+	// maxClockDrift re-anchors a media clock which runs this far from the
+	// capture time, e.g. after the SSRC was reused or the timestamps jumped
+	maxClockDrift = 10 * time.Second
+)
+
+// mediaClock maps the RTP timestamps of one SSRC to the capture time
+// of the first packet seen with the negotiated clock rate.
+type mediaClock struct {
+	rtpTs uint32
+	wall  time.Time
+}
+
+// clockRate returns the clock rate of the negotiated audio codec
+// of a call from the SDP rtpmap like 8000 for PCMA/8000 or 0.
+func (d *Decoder) clockRate(callID []byte) float64 {
+	codec := strings.Split(string(d.SDPCodec(callID)), "/")
+	if len(codec) < 2 {
+		return 0
+	}
+	rate, err := strconv.ParseFloat(codec[1], 64)
+	if err != nil || rate <= 0 {
+		return 0
+	}
+	return rate
+}
+
+// mediaTime returns the time of the RTP timestamp rtpTs of ssrc on the
+// media clock of the call with -ts rtp. It falls back to the capture
+// time ts if the option is off or the clock rate is unknown.
+func (d *Decoder) mediaTime(ssrc, rtpTs uint32, callID []byte, ts time.Time) time.Time {
+	if config.Cfg.TimeSource != "rtp" || callID == nil {
+		return ts
+	}
+	rate := d.clockRate(callID)
+	if rate == 0 {
+		return ts
+	}
+	if d.mediaClocks == nil || len(d.mediaClocks) >= maxMediaClocks {
+		d.mediaClocks = make(map[uint32]mediaClock)
+	}
+	c, ok := d.mediaClocks[ssrc]
+	if ok {
+		// The difference is signed, so timestamps wrap around
+		t := c.wall.Add(time.Duration(float64(int32(rtpTs-c.rtpTs)) / rate * float64(time.Second)))
+		if drift := t.Sub(ts); drift < maxClockDrift && drift > -maxClockDrift {
+			return t
+		}
+	}
+	d.mediaClocks[ssrc] = mediaClock{rtpTs: rtpTs, wall: ts}
+	return ts
+}
+
+// setMediaTime sets the HEP timestamp of pkt to the media time of the
+// RTP timestamp of a RTP packet or a RTCP sender report in payload.
+// Receiver reports carry no RTP timestamp and keep the capture time.
+func (d *Decoder) setMediaTime(pkt *Packet, payload []byte, rtcp bool, ts time.Time) {
+	var ssrc, rtpTs uint32
+	switch {
+	case rtcp && len(payload) >= 20 && payload[1] == 200:
+		ssrc, rtpTs = binary.BigEndian.Uint32(payload[4:8]), binary.BigEndian.Uint32(payload[16:20])
+	case !rtcp && len(payload) >= 12:
+		ssrc, rtpTs = binary.BigEndian.Uint32(payload[8:12]), binary.BigEndian.Uint32(payload[4:8])
+	default:
+		return
+	}
+	t := d.mediaTime(ssrc, rtpTs, pkt.CID, ts)
+	pkt.Tsec = uint32(t.Unix())
+	pkt.Tmsec = uint32(t.Nanosecond() / 1000)
+}
@@ -0,0 +1,123 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/negbie/logp"
+)
+
+// mirrorHeaders strip the header a switch vendor adds to mirrored Ethernet
+// frames and report if they found one.
+var mirrorHeaders = map[string]func(data []byte) ([]byte, bool){
+	"juniper": stripJuniper,
+	"arista":  stripArista,
+}
+
+// mirrorStats holds the enabled mirror header strippers and whether
+// an unknown prefix was already logged.
+type mirrorStats struct {
+	strip  []func(data []byte) ([]byte, bool)
+	logged bool
+}
+
+// knownEtherTypes are the EtherTypes of frames without a mirror header
+var knownEtherTypes = map[uint16]bool{
+	0x0800: true, // IPv4
+	0x0806: true, // ARP
+	0x86DD: true, // IPv6
+	0x8100: true, // 802.1Q
+	0x88A8: true, // 802.1ad
+	0x9100: true, // QinQ
+	0x8847: true, // MPLS
+	0x8848: true, // MPLS multicast
+	0x88CC: true, // LLDP
+	0x8809: true, // LACP
+}
+
+// parseMirrorHeaders parses a comma separated list of vendors like "juniper,arista".
+func parseMirrorHeaders(s string) ([]func(data []byte) ([]byte, bool), error) {
+	var strip []func(data []byte) ([]byte, bool)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		f, ok := mirrorHeaders[v]
+		if !ok {
+			return nil, fmt.Errorf("unknown mirror header vendor %q", v)
+		}
+		strip = append(strip, f)
+	}
+	return strip, nil
+}
+
+// stripMirror returns the Ethernet frame of data without a known vendor
+// mirror header. A frame whose EtherType is still unknown is logged once
+// with a hex dump, so support for its header can be added.
+func (d *Decoder) stripMirror(data []byte) []byte {
+	for _, strip := range d.mirror.strip {
+		if frame, ok := strip(data); ok {
+			return frame
+		}
+	}
+	if !d.mirror.logged && len(data) >= 14 && !knownEtherTypes[binary.BigEndian.Uint16(data[12:14])] {
+		d.mirror.logged = true
+		n := len(data)
+		if n > 64 {
+			n = 64
+		}
+		logp.Warn("Unknown mirror header or EtherType 0x%04x, first %d bytes:\n%s", binary.BigEndian.Uint16(data[12:14]), n, hex.Dump(data[:n]))
+	}
+	return data
+}
+
+// stripJuniper removes the Juniper header with the magic "MGC", a flags
+// byte and optional TLV extensions in front of the Ethernet frame.
+// Frames captured without their layer 2 header are left alone.
+func stripJuniper(data []byte) ([]byte, bool) {
+	const (
+		flagNoL2 = 0x02
+		flagExt  = 0x80
+	)
+	if len(data) < 4 || data[0] != 'M' || data[1] != 'G' || data[2] != 'C' || data[3]&flagNoL2 != 0 {
+		return data, false
+	}
+	offset := 4
+	if data[3]&flagExt != 0 {
+		if len(data) < 6 {
+			return data, false
+		}
+		offset += 2 + int(binary.BigEndian.Uint16(data[4:6]))
+	}
+	if len(data) < offset+14 {
+		return data, false
+	}
+	return data[offset:], true
+}
+
+// stripArista removes the Arista timestamp header with EtherType 0xD28B
+// between the MAC addresses and the real EtherType.
+func stripArista(data []byte) ([]byte, bool) {
+	if len(data) < 18 || binary.BigEndian.Uint16(data[12:14]) != 0xD28B {
+		return data, false
+	}
+	var size int
+	switch binary.BigEndian.Uint16(data[16:18]) {
+	case 0x0010:
+		size = 8 // 64 bit timestamp
+	case 0x0020:
+		size = 6 // 48 bit timestamp
+	default:
+		return data, false
+	}
+	hdr := 6 + size
+	if len(data) < 14+hdr {
+		return data, false
+	}
+	frame := make([]byte, 0, len(data)-hdr)
+	frame = append(frame, data[:12]...)
+	return append(frame, data[12+hdr:]...), true
+}
@@ -0,0 +1,281 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/negbie/heplify/protos"
+	"github.com/negbie/logp"
+)
+
+const (
+	// maxMOSCalls limits how many calls collect RTCP for their MOS
+	maxMOSCalls = 4096
+	// mosCallTimeout forgets calls without BYE like the RTCPCache
+	mosCallTimeout = 12 * time.Hour
+	// ntpEpochOffset is the number of seconds from 1900 to 1970
+	ntpEpochOffset = 2208988800
+)
+
+// CodecImpairment holds the ITU-T G.113 equipment impairment factor Ie and
+// the packet loss robustness factor Bpl of a codec for the E-model.
+type CodecImpairment struct {
+	Ie  float64
+	Bpl float64
+}
+
+// defaultImpairment is used for codecs missing in -mosc. It is G.711 with PLC.
+var defaultImpairment = CodecImpairment{Ie: 0, Bpl: 25.1}
+
+// ParseCodecImpairments parses codec parameters like "PCMA=0/25.1,G729=11/19".
+// The codec names are matched case insensitive to the SDP encoding names.
+func ParseCodecImpairments(s string) (map[string]CodecImpairment, error) {
+	codecs := make(map[string]CodecImpairment)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		i := strings.Index(v, "=")
+		j := strings.Index(v, "/")
+		if i < 1 || j < i {
+			return nil, fmt.Errorf("codec parameters must look like PCMA=0/25.1: %q", v)
+		}
+		ie, err := strconv.ParseFloat(v[i+1:j], 64)
+		if err != nil {
+			return nil, err
+		}
+		bpl, err := strconv.ParseFloat(v[j+1:], 64)
+		if err != nil {
+			return nil, err
+		}
+		if ie < 0 || bpl <= 0 {
+			return nil, fmt.Errorf("Ie must not be negative and Bpl must be positive: %q", v)
+		}
+		codecs[strings.ToLower(strings.TrimSpace(v[:i]))] = CodecImpairment{Ie: ie, Bpl: bpl}
+	}
+	return codecs, nil
+}
+
+// mosStream sums the RTCP report blocks about one RTP stream of a call.
+type mosStream struct {
+	Reporter string  `json:"reporter"`
+	SSRC     uint32  `json:"ssrc"`
+	Reports  int     `json:"reports"`
+	Lost     uint32  `json:"packets_lost"`
+	Loss     float64 `json:"loss_percent"`
+	Jitter   float64 `json:"jitter_ms"`
+	RTT      float64 `json:"rtt_ms"`
+	RFactor  float64 `json:"r_factor"`
+	MOS      float64 `json:"mos"`
+	rtts     int
+}
+
+type mosCall struct {
+	streams  map[uint32]*mosStream
+	lastSeen time.Time
+	// interim is when the last interim record was queued
+	interim time.Time
+	// last is the addressing of the last RTCP packet
+	// for the record of calls without BYE
+	last Packet
+}
+
+// mosStats collects the RTCP of correlated calls. The MOS records of ended
+// calls wait in pending until the worker fetches them with MOSReports.
+// With an interval the calls also queue interim records while they last.
+type mosStats struct {
+	codecs   map[string]CodecImpairment
+	calls    map[string]*mosCall
+	pending  []*Packet
+	interval time.Duration
+}
+
+// trackMOS will add the report blocks of a correlated RTCP packet to the
+// streams of its call. Each source SSRC is one direction of the call.
+func (d *Decoder) trackMOS(callID []byte, pkt *Packet, payload []byte, ts time.Time) {
+	blocks := protos.RTCPReportBlocks(payload)
+	if len(blocks) == 0 {
+		return
+	}
+	c, ok := d.mos.calls[string(callID)]
+	if !ok {
+		if len(d.mos.calls) >= maxMOSCalls {
+			d.expireMOS(ts, mosCallTimeout)
+			if len(d.mos.calls) >= maxMOSCalls {
+				return
+			}
+		}
+		c = &mosCall{streams: make(map[uint32]*mosStream), interim: ts}
+		d.mos.calls[string(callID)] = c
+	}
+	c.lastSeen = ts
+	c.last = Packet{Version: pkt.Version, Protocol: pkt.Protocol, SrcIP: pkt.SrcIP, DstIP: pkt.DstIP,
+		SrcPort: pkt.SrcPort, DstPort: pkt.DstPort, Tsec: pkt.Tsec, Tmsec: pkt.Tmsec, NodeID: pkt.NodeID, NodePW: pkt.NodePW}
+
+	clockRate := 8000.0
+	if codec := d.SDPCodec(callID); codec != nil {
+		if i := bytes.IndexByte(codec, '/'); i >= 0 {
+			if rate, err := strconv.Atoi(string(codec[i+1:])); err == nil && rate > 0 {
+				clockRate = float64(rate)
+			}
+		}
+	}
+	for _, b := range blocks {
+		s, ok := c.streams[b.SourceSsrc]
+		if !ok {
+			s = &mosStream{Reporter: fmt.Sprintf("%v:%d", pkt.SrcIP, pkt.SrcPort), SSRC: b.SourceSsrc}
+			c.streams[b.SourceSsrc] = s
+		}
+		s.Reports++
+		s.Lost = b.Cumulative_lost
+		s.Loss += float64(b.Fraction_lost) * 100 / 256
+		s.Jitter += float64(b.Jitter) * 1000 / clockRate
+		if rtt, ok := roundTripTime(b.LastSR, b.Delay_last_SR, ts); ok {
+			s.RTT += rtt
+			s.rtts++
+		}
+	}
+	if d.mos.interval > 0 && ts.Sub(c.interim) >= d.mos.interval {
+		c.interim = ts
+		d.queueMOS(callID, c, pkt, "interim")
+	}
+}
+
+// roundTripTime returns the RTT in ms of a report block as RFC 3550 6.4.1
+// computes it. The arrival time is taken where the packet was captured.
+func roundTripTime(lsr, dlsr uint32, ts time.Time) (float64, bool) {
+	if lsr == 0 {
+		return 0, false
+	}
+	frac := uint64(ts.Nanosecond()) << 32 / 1e9
+	arrival := uint32((uint64(ts.Unix())+ntpEpochOffset)<<16 | frac>>16)
+	rtt := arrival - lsr - dlsr
+	// Negative or implausible values come from unsynced clocks
+	if rtt == 0 || rtt > 10<<16 {
+		return 0, false
+	}
+	return float64(rtt) * 1000 / 65536, true
+}
+
+// expireMOS will queue the MOS records of the calls without RTCP
+// since maxIdle as abandoned and forget them.
+func (d *Decoder) expireMOS(now time.Time, maxIdle time.Duration) int {
+	var n int
+	for callID, c := range d.mos.calls {
+		if now.Sub(c.lastSeen) > maxIdle {
+			delete(d.mos.calls, callID)
+			d.queueMOS([]byte(callID), c, &c.last, "abandoned")
+			n++
+		}
+	}
+	return n
+}
+
+// finishMOS will queue the MOS record of the call ended by a BYE as HEP log.
+func (d *Decoder) finishMOS(pkt *Packet) {
+	if !bytes.HasPrefix(pkt.Payload, []byte("BYE ")) {
+		return
+	}
+	callID := d.sipCID(pkt)
+	c, ok := d.mos.calls[string(callID)]
+	if !ok {
+		return
+	}
+	delete(d.mos.calls, string(callID))
+	d.queueMOS(callID, c, pkt, "bye")
+}
+
+// queueMOS will queue the MOS record of a call which ended by reason
+// as HEP log with the addressing of pkt. The sums of the streams are
+// kept, so interim records are cumulative.
+func (d *Decoder) queueMOS(callID []byte, c *mosCall, pkt *Packet, reason string) {
+	codec := "unknown"
+	impairment := defaultImpairment
+	if sdpCodec := d.SDPCodec(callID); sdpCodec != nil {
+		codec = string(sdpCodec)
+		name := strings.ToLower(strings.SplitN(codec, "/", 2)[0])
+		if ci, ok := d.mos.codecs[name]; ok {
+			impairment = ci
+		}
+	}
+	streams := make([]*mosStream, 0, len(c.streams))
+	for _, sum := range c.streams {
+		s := *sum
+		s.Loss /= float64(s.Reports)
+		s.Jitter /= float64(s.Reports)
+		if s.rtts > 0 {
+			s.RTT /= float64(s.rtts)
+		}
+		s.RFactor, s.MOS = eModel(s.Loss, s.Jitter, s.RTT, impairment)
+		s.Loss, s.Jitter, s.RTT = round2(s.Loss), round2(s.Jitter), round2(s.RTT)
+		streams = append(streams, &s)
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].SSRC < streams[j].SSRC })
+	data, err := json.Marshal(&struct {
+		Type    string       `json:"type"`
+		CallID  string       `json:"call_id"`
+		End     string       `json:"end"`
+		Codec   string       `json:"codec"`
+		Streams []*mosStream `json:"streams"`
+	}{"mos", string(callID), reason, codec, streams})
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	logp.Debug("mos", "Found CallID: %s in MOS: %s", string(callID), string(data))
+	d.mos.pending = append(d.mos.pending, &Packet{
+		Version:   pkt.Version,
+		Protocol:  pkt.Protocol,
+		SrcIP:     pkt.SrcIP,
+		DstIP:     pkt.DstIP,
+		SrcPort:   pkt.SrcPort,
+		DstPort:   pkt.DstPort,
+		Tsec:      pkt.Tsec,
+		Tmsec:     pkt.Tmsec,
+		ProtoType: 100,
+		NodeID:    pkt.NodeID,
+		NodePW:    pkt.NodePW,
+		Payload:   data,
+		CID:       callID,
+	})
+}
+
+// eModel estimates the R-factor and MOS of ITU-T G.107 from the average loss
+// in percent, jitter and RTT in ms. The one-way delay is half the RTT plus
+// a jitter buffer of twice the jitter.
+func eModel(loss, jitter, rtt float64, ci CodecImpairment) (float64, float64) {
+	delay := rtt/2 + 2*jitter
+	id := 0.024 * delay
+	if delay > 177.3 {
+		id += 0.11 * (delay - 177.3)
+	}
+	ieEff := ci.Ie + (95-ci.Ie)*loss/(loss+ci.Bpl)
+	r := 93.2 - id - ieEff
+	mos := 1.0
+	switch {
+	case r >= 100:
+		mos = 4.5
+	case r > 0:
+		mos = 1 + 0.035*r + r*(r-60)*(100-r)*7e-6
+	}
+	return math.Round(r*10) / 10, round2(mos)
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// MOSReports returns the MOS records of the calls ended
+// since the last call as HEP log packets.
+func (d *Decoder) MOSReports() []*Packet {
+	reports := d.mos.pending
+	d.mos.pending = nil
+	return reports
+}
@@ -0,0 +1,119 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+// maxQUICFlows limits how many QUIC flows are remembered
+const maxQUICFlows = 4096
+
+// quicStats counts SIP over QUIC. Without the TLS keys the
+// QUIC packets can't be decrypted so they are only counted.
+type quicStats struct {
+	packets int
+	newFlow int
+	// flows holds the time of the last packet by flow
+	flows map[string]time.Time
+}
+
+// quicVersion returns the version of a QUIC long header packet.
+func quicVersion(payload []byte) (uint32, bool) {
+	// Long header with the fixed bit, version and DCID length
+	if len(payload) < 7 || payload[0]&0xc0 != 0xc0 {
+		return 0, false
+	}
+	version := binary.BigEndian.Uint32(payload[1:5])
+	switch {
+	case version == 0x00000001, version == 0x6b3343cf:
+		// QUIC v1 and v2
+	case version&0xffffff00 == 0xff000000:
+		// IETF drafts
+	default:
+		return 0, false
+	}
+	if payload[5] > 20 {
+		return 0, false
+	}
+	return version, true
+}
+
+func quicFlowKey(pkt *Packet) string {
+	src := fmt.Sprintf("%v:%d", pkt.SrcIP, pkt.SrcPort)
+	dst := fmt.Sprintf("%v:%d", pkt.DstIP, pkt.DstPort)
+	if src > dst {
+		src, dst = dst, src
+	}
+	return src + "-" + dst
+}
+
+func (d *Decoder) isSIPPort(port uint16) bool {
+	if d.sipPorts != nil && len(d.sipPorts.ranges) > 0 {
+		return d.sipPorts.contains(port)
+	}
+	return port == 5060 || port == 5061
+}
+
+// checkQUIC reports whether the UDP packet belongs to a SIP over QUIC flow.
+// New flows are detected by a long header on a SIP port, later short
+// header packets by the remembered flow.
+func (d *Decoder) checkQUIC(pkt *Packet, ts time.Time) bool {
+	// Both header forms have the fixed bit which RTP and RTCP don't have
+	if len(pkt.Payload) == 0 || pkt.Payload[0]&0x40 == 0 {
+		return false
+	}
+	var key string
+	if len(d.quic.flows) > 0 {
+		key = quicFlowKey(pkt)
+		if _, ok := d.quic.flows[key]; ok {
+			d.quic.flows[key] = ts
+			d.quic.packets++
+			return true
+		}
+	}
+	if !d.isSIPPort(pkt.SrcPort) && !d.isSIPPort(pkt.DstPort) {
+		return false
+	}
+	version, ok := quicVersion(pkt.Payload)
+	if !ok {
+		return false
+	}
+	d.quic.packets++
+	if key == "" {
+		key = quicFlowKey(pkt)
+	}
+	if d.quic.flows == nil {
+		d.quic.flows = make(map[string]time.Time)
+	}
+	if len(d.quic.flows) < maxQUICFlows {
+		d.quic.flows[key] = ts
+		d.quic.newFlow++
+		logp.Info("Undecryptable SIP over QUIC version 0x%08x from %v:%d to %v:%d",
+			version, pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort)
+	}
+	return true
+}
+
+// expireQUIC forgets the flows without packets since maxIdle.
+func (d *Decoder) expireQUIC(now time.Time, maxIdle time.Duration) int {
+	var n int
+	for key, seen := range d.quic.flows {
+		if now.Sub(seen) > maxIdle {
+			delete(d.quic.flows, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (d *Decoder) printQUICStats() {
+	if d.quic.packets == 0 {
+		return
+	}
+	logp.Info("QUIC since last minute packets: %d, new flows: %d, known flows: %d",
+		d.quic.packets, d.quic.newFlow, len(d.quic.flows))
+	d.quic.packets, d.quic.newFlow = 0, 0
+}
@@ -0,0 +1,186 @@
+package decoder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/logp"
+)
+
+// filters holds the settings which can be changed with SIGHUP
+// without losing the caches and fragments of the running decoder.
+type filters struct {
+	filter  string
+	discard string
+	methods []string
+	trunks  *TrunkTable
+	// rawTrunks keeps the -tg value to log changes
+	rawTrunks string
+	// includeIPs and excludeIPs hold the -fip and -dip ranges
+	includeIPs    *TrunkTable
+	excludeIPs    *TrunkTable
+	rawIncludeIPs string
+	rawExcludeIPs string
+	// nodePW is the HEP authentication key of new packets
+	nodePW []byte
+	// rawWeights keeps the last reloaded -hs weights
+	rawWeights string
+}
+
+func newFilters(filter, discard, methods, trunks, includeIPs, excludeIPs string) (*filters, error) {
+	f := &filters{filter: filter, discard: discard, rawTrunks: trunks, rawIncludeIPs: includeIPs, rawExcludeIPs: excludeIPs}
+	if methods != "" {
+		for _, m := range strings.Split(strings.ToUpper(methods), ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				f.methods = append(f.methods, m)
+			}
+		}
+	}
+	if trunks != "" {
+		var err error
+		if f.trunks, err = ParseTrunks(trunks); err != nil {
+			return nil, err
+		}
+	}
+	if includeIPs != "" {
+		var err error
+		if f.includeIPs, err = ParseCIDRs(includeIPs); err != nil {
+			return nil, fmt.Errorf("-fip: %v", err)
+		}
+	}
+	if excludeIPs != "" {
+		var err error
+		if f.excludeIPs, err = ParseCIDRs(excludeIPs); err != nil {
+			return nil, fmt.Errorf("-dip: %v", err)
+		}
+	}
+	return f, nil
+}
+
+func (d *Decoder) loadFilters() *filters {
+	if f, ok := d.filters.Load().(*filters); ok {
+		return f
+	}
+	return &filters{}
+}
+
+// readReloadFile reads lines like "fi=INVITE" or "dim=OPTIONS,NOTIFY" for
+// the flags -fi, -di, -dim, -tg, -fip, -dip and -hp and the -hs weights
+// like "hs=10.0.0.1:9060*2,10.0.0.2:9060*0". Flags missing in the file keep their value.
+func readReloadFile(path string, cur *filters) (*filters, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	filter, discard, methods, trunks := cur.filter, cur.discard, strings.Join(cur.methods, ","), cur.rawTrunks
+	includeIPs, excludeIPs := cur.rawIncludeIPs, cur.rawExcludeIPs
+	nodePW, weights := cur.nodePW, cur.rawWeights
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing '=' in %q", n, line)
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch strings.TrimLeft(strings.TrimSpace(line[:i]), "-") {
+		case "fi":
+			if filter, err = strconv.Unquote(`"` + value + `"`); err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+		case "di":
+			if discard, err = strconv.Unquote(`"` + value + `"`); err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+		case "dim":
+			methods = value
+		case "tg":
+			trunks = value
+		case "fip":
+			includeIPs = value
+		case "dip":
+			excludeIPs = value
+		case "hp":
+			nodePW = []byte(value)
+		case "hs":
+			weights = value
+		default:
+			return nil, fmt.Errorf("line %d: %q can't be reloaded", n, line[:i])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	f, err := newFilters(filter, discard, methods, trunks, includeIPs, excludeIPs)
+	if err != nil {
+		return nil, err
+	}
+	f.nodePW, f.rawWeights = nodePW, weights
+	return f, nil
+}
+
+// reload applies the reload file. On errors the running filters are kept.
+func (d *Decoder) reload(path string) error {
+	cur := d.loadFilters()
+	f, err := readReloadFile(path, cur)
+	if err != nil {
+		return err
+	}
+	if f.rawWeights != cur.rawWeights {
+		set, ok := d.weights.Load().(func(string) error)
+		if !ok || set == nil {
+			return fmt.Errorf("-hs weights can only be reloaded with several HEP servers")
+		}
+		if err := set(f.rawWeights); err != nil {
+			return fmt.Errorf("-hs weights: %v", err)
+		}
+	}
+	changed := false
+	logChange := func(name, old, new string) {
+		if old != new {
+			changed = true
+			logp.Info("Reload %s from %q to %q", name, old, new)
+		}
+	}
+	logChange("-fi", cur.filter, f.filter)
+	logChange("-di", cur.discard, f.discard)
+	logChange("-dim", strings.Join(cur.methods, ","), strings.Join(f.methods, ","))
+	logChange("-tg", cur.rawTrunks, f.rawTrunks)
+	logChange("-fip", cur.rawIncludeIPs, f.rawIncludeIPs)
+	logChange("-dip", cur.rawExcludeIPs, f.rawExcludeIPs)
+	logChange("-hs weights", cur.rawWeights, f.rawWeights)
+	// The key itself stays out of the log
+	if !bytes.Equal(cur.nodePW, f.nodePW) {
+		changed = true
+		logp.Info("Reload -hp, new packets use the new HEP authentication key")
+	}
+	if !changed {
+		logp.Info("Reload %s without changes", path)
+	}
+	d.filters.Store(f)
+	d.sendCaptureEvent("reload", time.Now())
+	return nil
+}
+
+func (d *Decoder) reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := d.reload(config.Cfg.ReloadFile); err != nil {
+			logp.Err("keep the running filters, reload %s: %v", config.Cfg.ReloadFile, err)
+		}
+	}
+}
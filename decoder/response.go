@@ -0,0 +1,71 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/negbie/heplify/ownlayers"
+	"github.com/negbie/logp"
+)
+
+// responseStats counts SIP responses by the method of the
+// answered request and the response class like "INVITE 4xx".
+type responseStats struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+// countResponse buckets a SIP response by its CSeq method and status class.
+// Requests and payloads which can't be decoded are ignored.
+func (d *Decoder) countResponse(payload []byte) {
+	if !bytes.HasPrefix(payload, []byte("SIP/2.0 ")) {
+		return
+	}
+	sip := ownlayers.NewSIP()
+	if err := sip.DecodeFromBytes(payload, nil); err != nil {
+		logp.Debug("sipwarn", "%v", err)
+		return
+	}
+	_, method := sip.GetCSeq()
+	if method == "" || sip.ResponseClass() == 0 {
+		return
+	}
+	key := fmt.Sprintf("%s %dxx", method, sip.ResponseClass())
+
+	d.responses.Lock()
+	if d.responses.counts == nil {
+		d.responses.counts = make(map[string]int)
+	}
+	d.responses.counts[key]++
+	d.responses.Unlock()
+}
+
+// ResponseCounts returns the SIP responses by method and status class
+// since the last call and resets them.
+func (d *Decoder) ResponseCounts() map[string]int {
+	d.responses.Lock()
+	defer d.responses.Unlock()
+	counts := d.responses.counts
+	d.responses.counts = nil
+	return counts
+}
+
+func (d *Decoder) printResponseStats() {
+	counts := d.ResponseCounts()
+	if len(counts) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	stats := make([]string, 0, len(keys))
+	for _, key := range keys {
+		stats = append(stats, fmt.Sprintf("%s: %d", key, counts[key]))
+	}
+	logp.Info("SIP responses since last minute %s", strings.Join(stats, ", "))
+}
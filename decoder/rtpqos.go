@@ -0,0 +1,172 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+// maxQoSStreams limits how many RTP streams are tracked for -rq
+const maxQoSStreams = 4096
+
+// qosStream holds the RFC 3550 receiver statistics of one RTP stream.
+// maxSeq is the extended highest sequence number and baseSeq the first
+// one expected in the current interval. jitter is in timestamp units.
+type qosStream struct {
+	callID      []byte
+	clockRate   float64
+	payloadType uint8
+	start       time.Time
+	lastSeen    time.Time
+	baseSeq     uint32
+	maxSeq      uint32
+	received    int
+	lastTransit float64
+	jitter      float64
+}
+
+// qosStats tracks the RTP streams by SSRC for endpoints which send no RTCP.
+type qosStats struct {
+	interval time.Duration
+	streams  map[uint32]*qosStream
+}
+
+// qosReport is the synthetic QoS record of one RTP stream and interval.
+type qosReport struct {
+	Type        string  `json:"type"`
+	SSRC        uint32  `json:"ssrc"`
+	PayloadType uint8   `json:"payload_type"`
+	IntervalMs  int64   `json:"interval_ms"`
+	Packets     int     `json:"packets"`
+	Expected    int     `json:"expected"`
+	Lost        int     `json:"lost"`
+	Loss        float64 `json:"loss_percent"`
+	Jitter      float64 `json:"jitter_ms"`
+}
+
+// trackQoS will update the jitter and loss of the RTP stream of payload and
+// send them as HEP log every -rq interval if the stream belongs to a call.
+func (d *Decoder) trackQoS(pkt *Packet, payload []byte, ts time.Time) {
+	if len(payload) < 12 {
+		return
+	}
+	ssrc := binary.BigEndian.Uint32(payload[8:12])
+	seq := binary.BigEndian.Uint16(payload[2:4])
+	s, ok := d.qos.streams[ssrc]
+	if !ok {
+		if len(d.qos.streams) >= maxQoSStreams {
+			d.expireQoS(ts, time.Minute)
+			if len(d.qos.streams) >= maxQoSStreams {
+				return
+			}
+		}
+		s = &qosStream{payloadType: payload[1] & 0x7f, start: ts, baseSeq: uint32(seq), maxSeq: uint32(seq) - 1}
+		d.qos.streams[ssrc] = s
+	}
+	if s.callID == nil {
+		d.resolveQoSCall(s, pkt)
+	}
+
+	// Sequence numbers may wrap, late packets only count as received
+	if delta := seq - uint16(s.maxSeq); delta < 0x8000 {
+		s.maxSeq += uint32(delta)
+	}
+	s.received++
+
+	// RFC 3550 A.8 interarrival jitter
+	if s.clockRate > 0 {
+		arrival := float64(ts.UnixNano()) / 1e9 * s.clockRate
+		transit := arrival - float64(binary.BigEndian.Uint32(payload[4:8]))
+		if s.received > 1 {
+			// The RTP timestamp may wrap between two packets
+			diff := math.Mod(math.Abs(transit-s.lastTransit), 1<<32)
+			if diff > 1<<31 {
+				diff = 1<<32 - diff
+			}
+			s.jitter += (diff - s.jitter) / 16
+		}
+		s.lastTransit = transit
+	}
+	s.lastSeen = ts
+
+	if ts.Sub(s.start) >= d.qos.interval {
+		d.reportQoS(ssrc, s, pkt, ts, d.mediaTime(ssrc, binary.BigEndian.Uint32(payload[4:8]), s.callID, ts))
+	}
+}
+
+// resolveQoSCall looks up the Call-ID and the clock rate of the
+// negotiated codec of the call the RTP stream belongs to.
+func (d *Decoder) resolveQoSCall(s *qosStream, pkt *Packet) {
+	callID := d.MediaCallID(pkt.SrcIP, pkt.SrcPort)
+	if callID == nil {
+		if callID = d.MediaCallID(pkt.DstIP, pkt.DstPort); callID == nil {
+			return
+		}
+	}
+	s.callID = append([]byte{}, callID...)
+	if s.clockRate = d.clockRate(callID); s.clockRate == 0 {
+		s.clockRate = 8000
+	}
+}
+
+// reportQoS sends the statistics of the interval of s with the timestamp at
+// and starts the next one.
+func (d *Decoder) reportQoS(ssrc uint32, s *qosStream, pkt *Packet, ts, at time.Time) {
+	expected := int(s.maxSeq - s.baseSeq + 1)
+	r := qosReport{
+		Type:        "rtp_qos",
+		SSRC:        ssrc,
+		PayloadType: s.payloadType,
+		IntervalMs:  ts.Sub(s.start).Milliseconds(),
+		Packets:     s.received,
+		Expected:    expected,
+	}
+	if lost := expected - s.received; lost > 0 && expected > 0 {
+		r.Lost = lost
+		r.Loss = round2(float64(lost) * 100 / float64(expected))
+	}
+	if s.clockRate > 0 {
+		r.Jitter = round2(s.jitter * 1000 / s.clockRate)
+	}
+	s.start, s.baseSeq, s.received = ts, s.maxSeq+1, 0
+	if s.callID == nil {
+		return
+	}
+
+	data, err := json.Marshal(&r)
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	logp.Debug("rtp", "Found CallID: %s in RTP QoS: %s", string(s.callID), string(data))
+	d.sendEvent(&Packet{
+		Version:   pkt.Version,
+		Protocol:  pkt.Protocol,
+		SrcIP:     pkt.SrcIP,
+		DstIP:     pkt.DstIP,
+		SrcPort:   pkt.SrcPort,
+		DstPort:   pkt.DstPort,
+		Tsec:      uint32(at.Unix()),
+		Tmsec:     uint32(at.Nanosecond() / 1000),
+		ProtoType: 100,
+		NodeID:    pkt.NodeID,
+		NodePW:    pkt.NodePW,
+		Payload:   data,
+		CID:       s.callID,
+	})
+}
+
+// expireQoS forgets the streams which were quiet for maxIdle.
+func (d *Decoder) expireQoS(now time.Time, maxIdle time.Duration) int {
+	var n int
+	for ssrc, s := range d.qos.streams {
+		if now.Sub(s.lastSeen) > maxIdle {
+			delete(d.qos.streams, ssrc)
+			n++
+		}
+	}
+	return n
+}
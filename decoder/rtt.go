@@ -0,0 +1,86 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/negbie/heplify/ownlayers"
+	"github.com/negbie/logp"
+)
+
+// rttStats collects the signaling round-trip times of
+// requests and responses which carry a Timestamp header.
+type rttStats struct {
+	sync.Mutex
+	count int
+	sum   time.Duration
+	max   time.Duration
+}
+
+// rttKey returns the RTTCache key of a request and its responses.
+func rttKey(callID []byte, sip *ownlayers.SIP) []byte {
+	seq, method := sip.GetCSeq()
+	return []byte("rtt " + string(callID) + " " + strconv.Itoa(seq) + " " + method)
+}
+
+// trackRTT remembers the capture time of requests with a Timestamp header.
+// When a response echoes it, the round-trip time is the time between both
+// minus the delay the UAS added to the echoed header.
+func (d *Decoder) trackRTT(pkt *Packet) {
+	sip := ownlayers.NewSIP()
+	if err := sip.DecodeFromBytes(pkt.Payload, nil); err != nil {
+		logp.Debug("sipwarn", "%v", err)
+		return
+	}
+	if _, _, ok := sip.GetTimestamp(); !ok {
+		return
+	}
+	callID := ExtractCallID(pkt.Payload)
+	if callID == nil {
+		return
+	}
+	key := rttKey(callID, sip)
+	ts := time.Unix(int64(pkt.Tsec), int64(pkt.Tmsec)*1000)
+
+	if !sip.IsResponse {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(ts.UnixNano()))
+		if err := d.RTTCache.Set(key, buf[:], inviteTimeout); err != nil {
+			logp.Warn("%v", err)
+		}
+		return
+	}
+
+	buf, err := d.RTTCache.Get(key)
+	if err != nil || len(buf) != 8 {
+		return
+	}
+	d.RTTCache.Del(key)
+	_, delay, _ := sip.GetTimestamp()
+	rtt := ts.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(buf)))) - time.Duration(delay*float64(time.Second))
+	if rtt < 0 {
+		return
+	}
+	logp.Debug("rtt", "SIP RTT %v for %s", rtt, string(key))
+
+	d.rtt.Lock()
+	d.rtt.count++
+	d.rtt.sum += rtt
+	if rtt > d.rtt.max {
+		d.rtt.max = rtt
+	}
+	d.rtt.Unlock()
+}
+
+func (d *Decoder) printRTTStats() {
+	d.rtt.Lock()
+	count, sum, max := d.rtt.count, d.rtt.sum, d.rtt.max
+	d.rtt.count, d.rtt.sum, d.rtt.max = 0, 0, 0
+	d.rtt.Unlock()
+	if count == 0 {
+		return
+	}
+	logp.Info("SIP RTT since last minute from %d Timestamp headers avg: %v, max: %v", count, sum/time.Duration(count), max)
+}
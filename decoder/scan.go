@@ -0,0 +1,140 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/logp"
+)
+
+// maxScanSources limits the source IPs counted per second and reported per minute
+const maxScanSources = 4096
+
+// scanEvent is the HEP log sent for a source IP flagged as SIP scanner.
+type scanEvent struct {
+	Type      string `json:"type"`
+	Reason    string `json:"reason"`
+	Pattern   string `json:"pattern,omitempty"`
+	SrcIP     string `json:"src_ip"`
+	SrcPort   uint16 `json:"src_port"`
+	Method    string `json:"method"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Requests  int    `json:"requests,omitempty"`
+}
+
+// scanStats holds the lower case scanner patterns, the out-of-dialog
+// requests per source IP in the current second and when a source IP
+// was reported last.
+type scanStats struct {
+	patterns [][]byte
+	second   uint32
+	requests map[string]int
+	reported map[string]uint32
+}
+
+// parseScanPatterns parses a comma separated list of User-Agent or
+// From patterns like "friendly-scanner,sipvicious".
+func parseScanPatterns(s string) [][]byte {
+	var patterns [][]byte
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			patterns = append(patterns, []byte(v))
+		}
+	}
+	return patterns
+}
+
+// checkScan tags SIP requests whose User-Agent or From matches a scanner
+// pattern or whose source IP sent more than -scanr out-of-dialog requests
+// in the current second. A HEP log is sent at most once a minute per IP.
+func (d *Decoder) checkScan(pkt *Packet) {
+	if bytes.HasPrefix(pkt.Payload, []byte("SIP/2.0 ")) {
+		return
+	}
+	if pkt.Tsec != d.scan.second {
+		d.scan.second = pkt.Tsec
+		if len(d.scan.requests) > 0 {
+			d.scan.requests = make(map[string]int)
+		}
+	}
+
+	src := string(pkt.SrcIP)
+	e := scanEvent{Type: "scan"}
+	ua := extractHeader(pkt.Payload, "User-Agent", "")
+	if pattern := d.scanPattern(ua, extractHeader(pkt.Payload, "From", "f")); pattern != nil {
+		e.Reason, e.Pattern = "pattern", string(pattern)
+	} else if !pkt.InDialog && config.Cfg.ScanRate > 0 {
+		n, ok := d.scan.requests[src]
+		if !ok && len(d.scan.requests) >= maxScanSources {
+			return
+		}
+		d.scan.requests[src] = n + 1
+		if n+1 <= config.Cfg.ScanRate {
+			return
+		}
+		e.Reason, e.Requests = "rate", n+1
+	} else {
+		return
+	}
+
+	pkt.Scan = e.Reason
+	d.scanCount++
+	if last, ok := d.scan.reported[src]; ok && pkt.Tsec-last < 60 {
+		return
+	}
+	if len(d.scan.reported) >= maxScanSources {
+		d.scan.reported = make(map[string]uint32)
+	}
+	d.scan.reported[src] = pkt.Tsec
+
+	e.SrcIP, e.SrcPort, e.UserAgent = pkt.SrcIP.String(), pkt.SrcPort, string(ua)
+	if i := bytes.IndexByte(pkt.Payload, ' '); i > 0 {
+		e.Method = string(pkt.Payload[:i])
+	}
+	d.sendScanEvent(pkt, &e)
+}
+
+// scanPattern returns the first scanner pattern found in
+// one of the header values or nil.
+func (d *Decoder) scanPattern(values ...[]byte) []byte {
+	for _, v := range values {
+		if len(v) == 0 {
+			continue
+		}
+		v = bytes.ToLower(v)
+		for _, p := range d.scan.patterns {
+			if bytes.Contains(v, p) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// sendScanEvent sends e as HEP log with the addresses of pkt
+// correlated to its Call-ID.
+func (d *Decoder) sendScanEvent(pkt *Packet, e *scanEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	logp.Info("SIP scanner from %v:%d: %s", pkt.SrcIP, pkt.SrcPort, string(data))
+	d.sendEvent(&Packet{
+		Version:   pkt.Version,
+		Protocol:  pkt.Protocol,
+		SrcIP:     pkt.SrcIP,
+		DstIP:     pkt.DstIP,
+		SrcPort:   pkt.SrcPort,
+		DstPort:   pkt.DstPort,
+		Tsec:      pkt.Tsec,
+		Tmsec:     pkt.Tmsec,
+		ProtoType: 100,
+		NodeID:    pkt.NodeID,
+		NodePW:    pkt.NodePW,
+		Payload:   data,
+		CID:       ExtractCallID(pkt.Payload),
+	})
+}
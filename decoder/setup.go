@@ -0,0 +1,173 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/negbie/heplify/ownlayers"
+	"github.com/negbie/logp"
+)
+
+// inviteTimeout is how long an INVITE waits for its first 18x or 2xx response.
+// It matches SIP Timer B.
+const inviteTimeout = 32
+
+// SetupHistogram holds call setup delays, the time between an initial INVITE
+// and its first 18x or 2xx response. Counts[i] is the number of delays up to
+// Buckets[i], the last count holds the delays above all buckets.
+type SetupHistogram struct {
+	Buckets []time.Duration
+	Counts  []int
+	Sum     time.Duration
+}
+
+func (h *SetupHistogram) observe(delay time.Duration) {
+	i := sort.Search(len(h.Buckets), func(i int) bool { return delay <= h.Buckets[i] })
+	h.Counts[i]++
+	h.Sum += delay
+}
+
+func (h *SetupHistogram) String() string {
+	var total int
+	stats := make([]string, 0, len(h.Counts))
+	for i, count := range h.Counts {
+		total += count
+		if i < len(h.Buckets) {
+			stats = append(stats, fmt.Sprintf("<=%v: %d", h.Buckets[i], count))
+		} else {
+			stats = append(stats, fmt.Sprintf(">%v: %d", h.Buckets[i-1], count))
+		}
+	}
+	var avg time.Duration
+	if total > 0 {
+		avg = h.Sum / time.Duration(total)
+	}
+	return fmt.Sprintf("%s, avg: %v", strings.Join(stats, ", "), avg)
+}
+
+// setupStats collects call setup histograms by response class
+// and optionally trunk like "2xx carrierA".
+type setupStats struct {
+	sync.Mutex
+	buckets []time.Duration
+	hists   map[string]*SetupHistogram
+}
+
+// ParseSetupBuckets parses comma separated histogram bucket bounds like "100ms,1s,3s".
+func ParseSetupBuckets(s string) ([]time.Duration, error) {
+	var buckets []time.Duration
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		b, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		if b <= 0 || (len(buckets) > 0 && b <= buckets[len(buckets)-1]) {
+			return nil, fmt.Errorf("buckets must be positive and ascending: %q", s)
+		}
+		buckets = append(buckets, b)
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no buckets in %q", s)
+	}
+	return buckets, nil
+}
+
+// trackSetup remembers the time of initial INVITEs by Call-ID and records the
+// delay when the first 18x or 2xx response for it arrives. Retransmissions of the
+// INVITE keep the first timestamp and later responses are ignored.
+func (d *Decoder) trackSetup(pkt *Packet) {
+	isResponse := bytes.HasPrefix(pkt.Payload, []byte("SIP/2.0 "))
+	if !isResponse && !bytes.HasPrefix(pkt.Payload, []byte("INVITE ")) {
+		return
+	}
+	sip := ownlayers.NewSIP()
+	if err := sip.DecodeFromBytes(pkt.Payload, nil); err != nil {
+		logp.Debug("sipwarn", "%v", err)
+		return
+	}
+	callID := ExtractCallID(pkt.Payload)
+	if len(callID) == 0 {
+		return
+	}
+	ts := time.Unix(int64(pkt.Tsec), int64(pkt.Tmsec)*1000)
+
+	if !isResponse {
+		// Re-INVITEs are sent inside a dialog and carry a To tag
+		if sip.IsInDialog() {
+			return
+		}
+		if _, err := d.InviteCache.Get(callID); err == nil {
+			return
+		}
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(ts.UnixNano()))
+		if err := d.InviteCache.Set(callID, buf[:], inviteTimeout); err != nil {
+			logp.Warn("%v", err)
+		}
+		return
+	}
+
+	if _, method := sip.GetCSeq(); method != "INVITE" {
+		return
+	}
+	code := sip.ResponseCode
+	if code < 180 || code >= 300 {
+		return
+	}
+	buf, err := d.InviteCache.Get(callID)
+	if err != nil || len(buf) != 8 {
+		return
+	}
+	d.InviteCache.Del(callID)
+	delay := ts.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(buf))))
+	if delay < 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%dxx", sip.ResponseClass())
+	if pkt.Trunk != "" {
+		key += " " + pkt.Trunk
+	}
+	d.setup.Lock()
+	if d.setup.hists == nil {
+		d.setup.hists = make(map[string]*SetupHistogram)
+	}
+	h, ok := d.setup.hists[key]
+	if !ok {
+		h = &SetupHistogram{Buckets: d.setup.buckets, Counts: make([]int, len(d.setup.buckets)+1)}
+		d.setup.hists[key] = h
+	}
+	h.observe(delay)
+	d.setup.Unlock()
+}
+
+// SetupDelays returns the call setup histograms by response class and trunk
+// since the last call and resets them.
+func (d *Decoder) SetupDelays() map[string]*SetupHistogram {
+	d.setup.Lock()
+	defer d.setup.Unlock()
+	hists := d.setup.hists
+	d.setup.hists = nil
+	return hists
+}
+
+func (d *Decoder) printSetupStats() {
+	hists := d.SetupDelays()
+	keys := make([]string, 0, len(hists))
+	for key := range hists {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		logp.Info("Call setup delay %s since last minute %v", key, hists[key])
+	}
+}
@@ -0,0 +1,76 @@
+package decoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/negbie/logp"
+)
+
+// maxUnexpectedPorts limits how many unexpected SIP ports are remembered
+const maxUnexpectedPorts = 1024
+
+type portRange struct {
+	from, to uint16
+}
+
+// sipPorts holds the ports SIP is expected on and the other
+// ports SIP was already reported for.
+type sipPorts struct {
+	ranges     []portRange
+	unexpected map[uint16]bool
+}
+
+// parsePorts parses a comma separated list of ports
+// and port ranges like "5060-5090,5070,8080".
+func parsePorts(s string) ([]portRange, error) {
+	var ranges []portRange
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		from, to := v, v
+		if i := strings.Index(v, "-"); i >= 0 {
+			from, to = v[:i], v[i+1:]
+		}
+		f, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", v)
+		}
+		t, err := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+		if err != nil || t < f {
+			return nil, fmt.Errorf("invalid port range %q", v)
+		}
+		ranges = append(ranges, portRange{uint16(f), uint16(t)})
+	}
+	return ranges, nil
+}
+
+func (sp *sipPorts) contains(port uint16) bool {
+	for _, r := range sp.ranges {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSIPPort logs once per port when SIP was decoded on a port which
+// is not configured, so operators can add it. The packet is still used.
+func (d *Decoder) checkSIPPort(pkt *Packet) {
+	if d.sipPorts == nil || d.sipPorts.contains(pkt.SrcPort) || d.sipPorts.contains(pkt.DstPort) {
+		return
+	}
+	// The lower port is most likely the listening one
+	port := pkt.DstPort
+	if pkt.SrcPort < port {
+		port = pkt.SrcPort
+	}
+	if d.sipPorts.unexpected[port] || len(d.sipPorts.unexpected) >= maxUnexpectedPorts {
+		return
+	}
+	d.sipPorts.unexpected[port] = true
+	logp.Info("SIP on unexpected port %d from %v:%d to %v:%d, add it with -sp", port, pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort)
+}
@@ -0,0 +1,81 @@
+package decoder
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/negbie/logp"
+)
+
+// splitStacked cuts pkt after its first SIP message if some SBCs batched
+// more messages into the UDP datagram. The others wait until the worker
+// fetches them with StackedPackets.
+func (d *Decoder) splitStacked(pkt *Packet) {
+	msgs, rest := splitSIPMessages(pkt.Payload)
+	if len(msgs) == 0 {
+		return
+	}
+	if len(rest) > 0 {
+		logp.Debug("sipwarn", "Cut %d bytes after the SIP messages from %v:%d", len(rest), pkt.SrcIP, pkt.SrcPort)
+	}
+	pkt.Payload = msgs[0]
+	for _, msg := range msgs[1:] {
+		next := *pkt
+		next.Payload = msg
+		d.stacked = append(d.stacked, &next)
+	}
+}
+
+// splitSIPMessages splits payload into the SIP messages framed by their
+// Content-Length. rest holds the bytes after the last message which don't
+// start another one. It returns no messages if the first can't be framed.
+func splitSIPMessages(payload []byte) (msgs [][]byte, rest []byte) {
+	for len(payload) > 0 {
+		_, end := headerEnd(payload)
+		if end < 0 {
+			break
+		}
+		cl, err := strconv.Atoi(string(extractHeader(payload[:end], "Content-Length", "l")))
+		if err != nil || cl < 0 || end+cl > len(payload) {
+			break
+		}
+		msgs = append(msgs, payload[:end+cl])
+		payload = bytes.TrimLeft(payload[end+cl:], "\r\n")
+		if !isSIPStart(payload) {
+			return msgs, payload
+		}
+	}
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	// The last message can't be framed and keeps the rest
+	return append(msgs, payload), nil
+}
+
+// isSIPStart tells if b starts with a SIP request or status line.
+func isSIPStart(b []byte) bool {
+	end := indexLineEnd(b)
+	if end < 0 {
+		return false
+	}
+	line := b[:end]
+	return bytes.HasPrefix(line, []byte("SIP/2.0 ")) || bytes.HasSuffix(line, []byte(" SIP/2.0"))
+}
+
+// StackedPackets returns the further SIP messages of the last UDP
+// datagram split with -us. Their SDP is cached like the one of the first
+// message and messages dropped by -dim or the SIP checks are left out.
+func (d *Decoder) StackedPackets() []*Packet {
+	stacked := d.stacked
+	d.stacked = nil
+	pkts := stacked[:0]
+	for _, pkt := range stacked {
+		if captureMedia() {
+			d.cacheSDPIPPort(pkt.Payload)
+		}
+		if pkt, _ := d.processSIP(pkt); pkt != nil {
+			pkts = append(pkts, d.finishPacket(pkt))
+		}
+	}
+	return pkts
+}
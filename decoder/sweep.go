@@ -0,0 +1,100 @@
+package decoder
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+// fragmentTimeout is how long fragments wait for the rest of their datagram
+const fragmentTimeout = time.Minute
+
+// sweepStats evicts idle flow state. A ticker marks the sweep as due and
+// the next packet runs it, so the flow maps need no locks. Idle times are
+// measured in packet time, which also works for pcap files.
+type sweepStats struct {
+	sync.Mutex
+	due     int32
+	maxIdle time.Duration
+	now     time.Time
+	// evicted counts the evicted entries by category since start
+	evicted map[string]int
+}
+
+func (d *Decoder) sweepTicker(interval time.Duration) {
+	for range time.Tick(interval) {
+		atomic.StoreInt32(&d.sweep.due, 1)
+	}
+}
+
+// checkSweep will sweep the idle state if the ticker marked it as due.
+func (d *Decoder) checkSweep(ts time.Time) {
+	if ts.After(d.sweep.now) {
+		d.sweep.now = ts
+	}
+	if atomic.CompareAndSwapInt32(&d.sweep.due, 1, 0) {
+		d.sweepIdle(d.sweep.now)
+	}
+}
+
+// sweepIdle evicts fragments older than a minute and QUIC flows, VAD and QoS
+// streams and MOS calls idle since -sit. MOS calls are sent as abandoned.
+func (d *Decoder) sweepIdle(now time.Time) {
+	evicted := map[string]int{
+		"fragments": d.defragger.DiscardOlderThan(now.Add(-fragmentTimeout)),
+		"quic":      d.expireQUIC(now, d.sweep.maxIdle),
+	}
+	if d.vad.streams != nil {
+		evicted["vad"] = d.expireVAD(now, d.sweep.maxIdle)
+	}
+	if d.qos.streams != nil {
+		evicted["rtpqos"] = d.expireQoS(now, d.sweep.maxIdle)
+	}
+	if d.mos.calls != nil {
+		evicted["mos"] = d.expireMOS(now, d.sweep.maxIdle)
+	}
+	d.sweep.Lock()
+	for category, n := range evicted {
+		d.sweep.evicted[category] += n
+	}
+	d.sweep.Unlock()
+	logp.Debug("sweep", "Evicted idle state %v", evicted)
+}
+
+// Evictions returns the evicted entries by category since start.
+func (d *Decoder) Evictions() map[string]int {
+	d.sweep.Lock()
+	defer d.sweep.Unlock()
+	evicted := make(map[string]int, len(d.sweep.evicted))
+	for category, n := range d.sweep.evicted {
+		evicted[category] = n
+	}
+	return evicted
+}
+
+func (d *Decoder) printSweepStats() {
+	evicted := d.Evictions()
+	if len(evicted) == 0 {
+		return
+	}
+	stats := make([]string, 0, len(evicted))
+	for category, n := range evicted {
+		stats = append(stats, category+": "+strconv.Itoa(n))
+	}
+	sort.Strings(stats)
+	logp.Info("Evicted idle state since start %s", strings.Join(stats, ", "))
+}
+
+func (d *Decoder) serveEvictions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.Evictions()); err != nil {
+		logp.Warn("evictions: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package decoder
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+type trunkNode struct {
+	child [2]*trunkNode
+	name  string
+}
+
+// TrunkTable maps IP ranges to trunk names. It's a binary radix tree
+// per address family, so a lookup costs at most 32 or 128 steps no
+// matter how many ranges are configured. The longest prefix wins.
+type TrunkTable struct {
+	root4 trunkNode
+	root6 trunkNode
+}
+
+// ParseTrunks parses trunk definitions like
+//
+//	carrierA=10.1.0.0/16,10.2.0.0/16;carrierB=2001:db8::/32
+func ParseTrunks(s string) (*TrunkTable, error) {
+	t := &TrunkTable{}
+	for _, trunk := range strings.Split(s, ";") {
+		if strings.TrimSpace(trunk) == "" {
+			continue
+		}
+		index := strings.Index(trunk, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid trunk definition %q, want name=cidr[,cidr]", trunk)
+		}
+		name := strings.TrimSpace(trunk[:index])
+		for _, cidr := range strings.Split(trunk[index+1:], ",") {
+			if err := t.Insert(strings.TrimSpace(cidr), name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// ParseCIDRs parses a list of IPs and ranges like
+//
+//	10.1.0.0/16,192.168.1.5,2001:db8::/32
+//
+// into a table which names each range by itself.
+func ParseCIDRs(s string) (*TrunkTable, error) {
+	t := &TrunkTable{}
+	for _, cidr := range strings.Split(s, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		if err := t.Insert(cidr, cidr); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Insert adds an IPv4 or IPv6 range to the trunk with the given name.
+func (t *TrunkTable) Insert(cidr, name string) error {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	ones, _ := ipNet.Mask.Size()
+	node, ip := &t.root6, ipNet.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		// IPv4-mapped IPv6 ranges like ::ffff:10.0.0.0/104 count the 96 bit prefix
+		if len(ipNet.IP) == net.IPv6len {
+			if ones -= 96; ones < 0 {
+				return fmt.Errorf("invalid IPv4-mapped range %q", cidr)
+			}
+		}
+		node, ip = &t.root4, ip4
+	}
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> (7 - uint(i%8)) & 1
+		if node.child[bit] == nil {
+			node.child[bit] = &trunkNode{}
+		}
+		node = node.child[bit]
+	}
+	node.name = name
+	return nil
+}
+
+// Lookup returns the trunk name of the longest range containing ip or "".
+func (t *TrunkTable) Lookup(ip net.IP) string {
+	node := &t.root6
+	if ip4 := ip.To4(); ip4 != nil {
+		node, ip = &t.root4, ip4
+	} else if len(ip) != net.IPv6len {
+		return ""
+	}
+	name := node.name
+	for i := 0; i < len(ip)*8; i++ {
+		if node = node.child[ip[i/8]>>(7-uint(i%8))&1]; node == nil {
+			break
+		}
+		if node.name != "" {
+			name = node.name
+		}
+	}
+	return name
+}
+
+// Match returns the trunk of the source address and falls back to the destination.
+func (t *TrunkTable) Match(srcIP, dstIP net.IP) string {
+	if name := t.Lookup(srcIP); name != "" {
+		return name
+	}
+	return t.Lookup(dstIP)
+}
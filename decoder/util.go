@@ -2,14 +2,26 @@ package decoder
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/heplify/ownlayers"
+	"github.com/negbie/heplify/protos"
 	"github.com/negbie/logp"
 )
 
@@ -42,6 +54,487 @@ func intToIP(nn uint32) net.IP {
 	return ip
 }
 
+// ExtractCallID returns the value of the Call-ID header of a SIP message
+// or nil if there is none. The long and the compact form are both supported.
+func ExtractCallID(payload []byte) []byte {
+	return extractHeader(payload, "Call-ID", "i")
+}
+
+// extractHeader returns the trimmed value of the first SIP header with the
+// given name or compact form without decoding the whole message.
+func extractHeader(payload []byte, name, compact string) []byte {
+	for len(payload) > 0 {
+		end := bytes.IndexByte(payload, '\n')
+		if end < 0 {
+			end = len(payload)
+		}
+		line := bytes.TrimRight(payload[:end], "\r")
+		if len(line) == 0 {
+			// End of SIP headers
+			return nil
+		}
+		if colon := bytes.IndexByte(line, ':'); colon > 0 {
+			n := bytes.TrimSpace(line[:colon])
+			if bytes.EqualFold(n, []byte(name)) || bytes.EqualFold(n, []byte(compact)) {
+				if value := bytes.TrimSpace(line[colon+1:]); len(value) > 0 {
+					return value
+				}
+			}
+		}
+		if end == len(payload) {
+			break
+		}
+		payload = payload[end+1:]
+	}
+	return nil
+}
+
+// indexLineEnd returns the index of the first "\r\n" or bare "\n" in b or -1.
+func indexLineEnd(b []byte) int {
+	i := bytes.IndexByte(b, '\n')
+	if i > 0 && b[i-1] == '\r' {
+		return i - 1
+	}
+	return i
+}
+
+// maxGzipBody limits the decompressed size of a SIP body
+const maxGzipBody = 64 * 1024
+
+// gunzipBody returns a copy of a SIP message with its gzip compressed body
+// decompressed. Other messages and broken bodies are returned unchanged.
+func gunzipBody(payload []byte) []byte {
+	if !bytes.Contains(payload, []byte("gzip")) {
+		return payload
+	}
+	encoding := extractHeader(payload, "Content-Encoding", "e")
+	if !bytes.Contains(bytes.ToLower(encoding), []byte("gzip")) {
+		return payload
+	}
+	end, start := headerEnd(payload)
+	if end < 0 {
+		return payload
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(payload[start:]))
+	if err != nil {
+		logp.Debug("sdpwarn", "Content-Encoding gzip: %v", err)
+		return payload
+	}
+	defer gz.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(gz, maxGzipBody))
+	if err != nil {
+		logp.Debug("sdpwarn", "Content-Encoding gzip: %v", err)
+		return payload
+	}
+	plain := make([]byte, 0, start+len(body))
+	plain = append(plain, payload[:start]...)
+	return append(plain, body...)
+}
+
+// sessionSDP returns a multipart SIP message with only its SDP part of
+// disposition session as body, so the SDP of early media or SDP-like
+// lines of other parts aren't cached. Without session part the first SDP
+// part is kept. Other messages are returned unchanged.
+func sessionSDP(payload []byte) []byte {
+	contentType := extractHeader(payload, "Content-Type", "c")
+	if !bytes.HasPrefix(bytes.ToLower(contentType), []byte("multipart/")) {
+		return payload
+	}
+	end, start := headerEnd(payload)
+	if end < 0 {
+		return payload
+	}
+	var sdp []byte
+	for _, part := range ownlayers.SplitBody(string(contentType), "", payload[start:]) {
+		if part.ContentType != "application/sdp" {
+			continue
+		}
+		if part.Disposition == "session" {
+			sdp = part.Body
+			break
+		}
+		if sdp == nil {
+			sdp = part.Body
+		}
+	}
+	if sdp == nil {
+		return payload
+	}
+	session := make([]byte, 0, start+len(sdp))
+	session = append(session, payload[:start]...)
+	return append(session, sdp...)
+}
+
+// ipv6Transport follows the extension header chain of ip6 to the transport
+// protocol like UDP or TCP. It stops at a Fragment header and returns it,
+// because only the reassembled datagram carries a whole transport layer.
+func ipv6Transport(packet gopacket.Packet, ip6 *layers.IPv6) (layers.IPProtocol, *layers.IPv6Fragment) {
+	next := ip6.NextHeader
+	for _, l := range packet.Layers() {
+		switch ext := l.(type) {
+		case *layers.IPv6HopByHop:
+			next = ext.NextHeader
+		case *layers.IPv6Routing:
+			next = ext.NextHeader
+		case *layers.IPv6Destination:
+			next = ext.NextHeader
+		case *layers.IPv6Fragment:
+			return ext.NextHeader, ext
+		}
+	}
+	return next, nil
+}
+
+// isInDialog tells if a SIP request carries a To tag and
+// therefore belongs to an existing dialog.
+func isInDialog(payload []byte) bool {
+	if bytes.HasPrefix(payload, []byte("SIP/2.0 ")) {
+		return false
+	}
+	to := extractHeader(payload, "To", "t")
+	return to != nil && ownlayers.ParseTag(string(to)) != ""
+}
+
+// hasMagicCookie tells if the topmost Via branch of a SIP message
+// starts with the RFC 3261 magic cookie.
+func hasMagicCookie(payload []byte) bool {
+	via := extractHeader(payload, "Via", "v")
+	return strings.HasPrefix(ownlayers.ParseViaBranch(string(via)), ownlayers.MagicCookie)
+}
+
+// headerEnd returns the index of the empty line after the header of a SIP
+// message and the index of its body. Bare LF line ends are accepted like
+// by the SIP parser. Both are -1 if the message has no empty line.
+func headerEnd(payload []byte) (end, body int) {
+	crlf := bytes.Index(payload, []byte("\r\n\r\n"))
+	if lf := bytes.Index(payload, []byte("\n\n")); lf >= 0 && (crlf < 0 || lf < crlf) {
+		return lf, lf + 2
+	}
+	if crlf < 0 {
+		return -1, -1
+	}
+	return crlf, crlf + 4
+}
+
+// bodyLengthMismatch tells if the Content-Length of a SIP message differs
+// from the length of its body. Messages without it are not checked.
+func bodyLengthMismatch(payload []byte) bool {
+	end, body := headerEnd(payload)
+	if end < 0 {
+		return false
+	}
+	cl := extractHeader(payload[:body], "Content-Length", "l")
+	if cl == nil {
+		return false
+	}
+	n, err := strconv.Atoi(string(cl))
+	return err != nil || n != len(payload)-body
+}
+
+// messageHash returns the SHA1 of a SIP message without its Max-Forwards
+// headers and Via branches, so a copy that differs only in them hashes like
+// the original. A message relayed by a proxy which adds its own Via or
+// Record-Route line hashes differently.
+func messageHash(payload []byte) []byte {
+	h := sha1.New()
+	for len(payload) > 0 {
+		end := bytes.IndexByte(payload, '\n')
+		if end < 0 {
+			end = len(payload)
+		}
+		line := bytes.TrimRight(payload[:end], "\r")
+		if len(line) == 0 {
+			// The body is hashed as it is
+			h.Write([]byte("\r\n"))
+			if end < len(payload) {
+				h.Write(payload[end+1:])
+			}
+			break
+		}
+		if colon := bytes.IndexByte(line, ':'); colon > 0 {
+			name := bytes.TrimSpace(line[:colon])
+			if bytes.EqualFold(name, []byte("Max-Forwards")) {
+				line = nil
+			} else if bytes.EqualFold(name, []byte("Via")) || bytes.EqualFold(name, []byte("v")) {
+				line = removeBranch(line)
+			}
+		}
+		if line != nil {
+			h.Write(line)
+			h.Write([]byte("\r\n"))
+		}
+		if end == len(payload) {
+			break
+		}
+		payload = payload[end+1:]
+	}
+	return h.Sum(nil)
+}
+
+// removeBranch returns a copy of a Via header line without branch parameters.
+func removeBranch(line []byte) []byte {
+	lower := bytes.ToLower(line)
+	var out []byte
+	for {
+		i := bytes.Index(lower, []byte(";branch="))
+		if i < 0 {
+			return append(out, line...)
+		}
+		out = append(out, line[:i]...)
+		j := bytes.IndexAny(lower[i+1:], ";,")
+		if j < 0 {
+			return out
+		}
+		line, lower = line[i+1+j:], lower[i+1+j:]
+	}
+}
+
+// flowKey returns a direction independent key for an UDP 5-tuple.
+// Both endpoints are ordered so A->B and B->A map to the same key.
+func flowKey(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) []byte {
+	src := net.JoinHostPort(srcIP.String(), strconv.Itoa(int(srcPort)))
+	dst := net.JoinHostPort(dstIP.String(), strconv.Itoa(int(dstPort)))
+	if dst < src {
+		src, dst = dst, src
+	}
+	return []byte("udp " + src + " " + dst)
+}
+
+// maxParseErrors limits the exported parse errors per minute,
+// so a flood of malformed packets doesn't flood Homer as well.
+const maxParseErrors = 100
+
+// maxParseErrorPayload is how much of the failing payload is exported
+const maxParseErrorPayload = 256
+
+// parseError counts a parse failure. If ExportParseErrors is set it returns
+// a HEP log packet with the reason and the start of the failing payload.
+func (d *Decoder) parseError(pkt *Packet, reason string, payload []byte) (*Packet, error) {
+	d.parseErrCount++
+	if !config.Cfg.ExportParseErrors || d.parseErrCount > maxParseErrors {
+		return d.dropPacket(dropParseError)
+	}
+	if len(payload) > maxParseErrorPayload {
+		payload = payload[:maxParseErrorPayload]
+	}
+	if pkt.Version == 0 {
+		pkt.Version = 0x02
+		pkt.SrcIP = net.IPv4zero.To4()
+		pkt.DstIP = net.IPv4zero.To4()
+	}
+	pkt.ProtoType = 100
+	pkt.Payload = []byte(fmt.Sprintf("heplify parse error on %s: %s: %q", d.Host, reason, payload))
+	return pkt, nil
+}
+
+// dtlsKey returns the SDPCache key for the DTLS parameters of a call.
+// The prefix keeps it apart from the IP+port keys.
+func dtlsKey(callID []byte) []byte {
+	return append([]byte("dtls "), callID...)
+}
+
+// cryptoKey returns the SDPCache key for the SDES-SRTP crypto suites of a call.
+func cryptoKey(callID []byte) []byte {
+	return append([]byte("crypto "), callID...)
+}
+
+// bundleKey returns the SDPCache key for the bundled media of a call.
+func bundleKey(callID []byte) []byte {
+	return append([]byte("bundle "), callID...)
+}
+
+// codecKey returns the SDPCache key for the codec of a call.
+func codecKey(callID []byte) []byte {
+	return append([]byte("codec "), callID...)
+}
+
+// eventKey returns the SDPCache key for the telephone-event payload type of a call.
+func eventKey(callID []byte) []byte {
+	return append([]byte("event "), callID...)
+}
+
+// radiusKey returns the SIPCache key for the Call-ID of a RADIUS
+// accounting exchange, clientIP and clientPort are the sender of the request.
+func radiusKey(clientIP net.IP, clientPort uint16, identifier uint8) []byte {
+	return []byte(fmt.Sprintf("radius %s %d", net.JoinHostPort(clientIP.String(), strconv.Itoa(int(clientPort))), identifier))
+}
+
+// cnKey returns the SDPCache key for the comfort noise payload type of a call.
+func cnKey(callID []byte) []byte {
+	return append([]byte("cn "), callID...)
+}
+
+// ssrcIndexKey returns the RTCPCache key for the SSRCs correlated by an SDP media address.
+func ssrcIndexKey(keySDP []byte) []byte {
+	return append([]byte("ssrcs "), keySDP...)
+}
+
+// asymKey returns the RTCPCache key which marks an SSRC
+// learned from a port which wasn't announced in the SDP.
+func asymKey(ssrc []byte) []byte {
+	return append([]byte("asym "), ssrc...)
+}
+
+// originKey returns the SDPCache key for the SDP version of one session in a call.
+func originKey(callID []byte, origin protos.SDPOrigin) []byte {
+	return []byte("origin " + string(callID) + " " + origin.Username + " " + origin.SessionID + " " + origin.Address)
+}
+
+// parseNodeIDs parses HEP node IDs per interface or trunk like eth0=2003,carrierA=2004
+func parseNodeIDs(s string) (map[string]uint32, error) {
+	nodeIDs := make(map[string]uint32)
+	for _, entry := range strings.Split(s, ",") {
+		index := strings.Index(entry, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid HEP node ID %q, want name=id", entry)
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(entry[index+1:]), 10, 32)
+		if err != nil || id > 0xFFFFFFFE {
+			return nil, fmt.Errorf("invalid HEP node ID %q", entry)
+		}
+		nodeIDs[strings.TrimSpace(entry[:index])] = uint32(id)
+	}
+	return nodeIDs, nil
+}
+
+// nodeID returns the HEP node ID of the packets trunk, then of its local
+// IP, then of its capture interface and falls back to the global node ID.
+func (d *Decoder) nodeID(pkt *Packet) uint32 {
+	if id, ok := d.nodeIDs[pkt.Trunk]; ok && pkt.Trunk != "" {
+		return id
+	}
+	if id, ok := d.nodeIDs[pkt.LocalIP.String()]; ok && pkt.LocalIP != nil {
+		return id
+	}
+	if id, ok := d.nodeIDs[pkt.IfaceName]; ok && pkt.IfaceName != "" {
+		return id
+	}
+	return d.NodeID
+}
+
+// parseLocalIPs parses the local IPs of the capture host like 10.0.0.5,2001:db8::5
+func parseLocalIPs(s string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local IP %q", entry)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// localIP returns the configured local IP the packet was sent from or to.
+// The source wins for packets between two local IPs.
+func (d *Decoder) localIP(pkt *Packet) net.IP {
+	for _, ip := range []net.IP{pkt.SrcIP, pkt.DstIP} {
+		for _, local := range d.localIPs {
+			if local.Equal(ip) {
+				return local
+			}
+		}
+	}
+	return nil
+}
+
+// reservedChunks are the HEP chunk types heplify sends itself
+var reservedChunks = map[uint64]bool{1: true, 2: true, 3: true, 4: true, 5: true, 6: true, 7: true, 8: true, 9: true,
+	10: true, 11: true, 12: true, 13: true, 14: true, 15: true, 16: true, 17: true, 18: true, 0x20: true, 0x30: true, 0x31: true, 0x32: true, 0x33: true, 0x34: true}
+
+// headerChunk maps a SIP header to a HEP chunk type.
+type headerChunk struct {
+	name    string
+	compact string
+	typ     uint16
+}
+
+// parseHeaderChunks parses SIP headers with their HEP chunk
+// type like Organization=0x40,Subject=0x41.
+func parseHeaderChunks(s string) ([]headerChunk, error) {
+	var chunks []headerChunk
+	for _, entry := range strings.Split(s, ",") {
+		index := strings.Index(entry, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid header chunk %q, want header=type", entry)
+		}
+		typ, err := strconv.ParseUint(strings.TrimSpace(entry[index+1:]), 0, 16)
+		if err != nil || typ == 0 || reservedChunks[typ] {
+			return nil, fmt.Errorf("invalid or reserved HEP chunk type in %q", entry)
+		}
+		name := strings.TrimSpace(entry[:index])
+		compact := ownlayers.CompactHeaderName(name)
+		if compact == "" {
+			compact = name
+		}
+		chunks = append(chunks, headerChunk{name: name, compact: compact, typ: uint16(typ)})
+	}
+	return chunks, nil
+}
+
+// headerChunks returns the values of the SIP headers selected with -hhc.
+func (d *Decoder) headerChunks(payload []byte) []HeaderChunk {
+	var chunks []HeaderChunk
+	for _, hc := range d.hdrChunks {
+		if value := extractHeader(payload, hc.name, hc.compact); value != nil {
+			chunks = append(chunks, HeaderChunk{Type: hc.typ, Value: value})
+		}
+	}
+	return chunks
+}
+
+// parseProtoTypes parses forced HEP payload types per interface
+// or port like eth1=100,514=100.
+func parseProtoTypes(s string) (map[string]byte, error) {
+	protoTypes := make(map[string]byte)
+	for _, entry := range strings.Split(s, ",") {
+		index := strings.Index(entry, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid HEP payload type %q, want name=type", entry)
+		}
+		t, err := strconv.ParseUint(strings.TrimSpace(entry[index+1:]), 10, 8)
+		if err != nil || t == 0 {
+			return nil, fmt.Errorf("invalid HEP payload type %q", entry)
+		}
+		protoTypes[strings.TrimSpace(entry[:index])] = byte(t)
+	}
+	return protoTypes, nil
+}
+
+// protoType returns the forced HEP payload type of the packets capture
+// interface, then of its destination and source port or its own one.
+func (d *Decoder) protoType(pkt *Packet) byte {
+	if t, ok := d.protoTypes[pkt.IfaceName]; ok && pkt.IfaceName != "" {
+		return t
+	}
+	for _, port := range []uint16{pkt.DstPort, pkt.SrcPort} {
+		if t, ok := d.protoTypes[strconv.Itoa(int(port))]; ok && port != 0 {
+			return t
+		}
+	}
+	return pkt.ProtoType
+}
+
+// ifaceName resolves and caches the name of the interface with the given index.
+func (d *Decoder) ifaceName(index int) string {
+	name, ok := d.ifaces[index]
+	if !ok {
+		if iface, err := net.InterfaceByIndex(index); err == nil {
+			name = iface.Name
+		} else {
+			logp.Debug("iface", "%v", err)
+		}
+		if d.ifaces == nil {
+			d.ifaces = make(map[int]string)
+		}
+		d.ifaces[index] = name
+	}
+	return name
+}
+
 func isPrivIP(IP net.IP) (p bool) {
 	_, classA, _ := net.ParseCIDR("10.0.0.0/8")
 	_, classB, _ := net.ParseCIDR("172.16.0.0/12")
@@ -59,45 +552,145 @@ func (d *Decoder) flushFragments() {
 	}
 }
 
+// DSCP returns the differentiated services code point like 46 for EF.
+func (p *Packet) DSCP() uint8 {
+	return p.TOS >> 2
+}
+
+// ECN returns the explicit congestion notification bits.
+func (p *Packet) ECN() uint8 {
+	return p.TOS & 0x03
+}
+
+// addDSCP adds the DSCP and ECN of the packet to a JSON object like the RTCP report.
+func addDSCP(report []byte, pkt *Packet) []byte {
+	if len(report) < 2 || report[0] != '{' {
+		return report
+	}
+	fields := fmt.Sprintf(`{"dscp":%d,"ecn":%d`, pkt.DSCP(), pkt.ECN())
+	if report[1] != '}' {
+		fields += ","
+	}
+	return append([]byte(fields), report[1:]...)
+}
+
 // MarshalJSON implements json marshal functions for Packet
 func (p *Packet) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		Version   byte
-		Protocol  byte
-		SrcIP     net.IP
-		DstIP     net.IP
-		SrcPort   uint16
-		DstPort   uint16
-		Tsec      uint32
-		Tmsec     uint32
-		ProtoType byte
-		NodeID    uint32
-		NodePW    string
-		Payload   string
-		CID       string
-		Vlan      uint16
+		Version            byte
+		Protocol           byte
+		SrcIP              net.IP
+		DstIP              net.IP
+		SrcPort            uint16
+		DstPort            uint16
+		Tsec               uint32
+		Tmsec              uint32
+		ProtoType          byte
+		NodeID             uint32
+		NodePW             string
+		Payload            string
+		CID                string
+		Vlan               uint16
+		IfaceName          string `json:",omitempty"`
+		IfaceIndex         int    `json:",omitempty"`
+		Trunk              string `json:",omitempty"`
+		L2TPTunnelID       uint16 `json:",omitempty"`
+		L2TPSessionID      uint32 `json:",omitempty"`
+		InDialog           bool   `json:",omitempty"`
+		NoMagicCookie      bool   `json:",omitempty"`
+		CorrID             string `json:",omitempty"`
+		TTL                uint8  `json:",omitempty"`
+		DSCP               uint8  `json:",omitempty"`
+		ECN                uint8  `json:",omitempty"`
+		Reassembled        bool   `json:",omitempty"`
+		Fragments          int    `json:",omitempty"`
+		LocalIP            net.IP `json:",omitempty"`
+		Hash               string `json:",omitempty"`
+		Scan               string `json:",omitempty"`
+		BodyLengthMismatch bool   `json:",omitempty"`
+		WireLength         uint32 `json:",omitempty"`
+		PPPoESessionID     uint16 `json:",omitempty"`
 	}{
-		Version:   p.Version,
-		Protocol:  p.Protocol,
-		SrcIP:     p.SrcIP,
-		DstIP:     p.DstIP,
-		SrcPort:   p.SrcPort,
-		DstPort:   p.DstPort,
-		Tsec:      p.Tsec,
-		Tmsec:     p.Tmsec,
-		ProtoType: p.ProtoType,
-		NodeID:    p.NodeID,
-		NodePW:    string(p.NodePW),
-		Payload:   string(p.Payload),
-		CID:       string(p.CID),
-		Vlan:      p.Vlan,
+		Version:            p.Version,
+		Protocol:           p.Protocol,
+		SrcIP:              p.SrcIP,
+		DstIP:              p.DstIP,
+		SrcPort:            p.SrcPort,
+		DstPort:            p.DstPort,
+		Tsec:               p.Tsec,
+		Tmsec:              p.Tmsec,
+		ProtoType:          p.ProtoType,
+		NodeID:             p.NodeID,
+		NodePW:             string(p.NodePW),
+		Payload:            string(p.Payload),
+		CID:                string(p.CID),
+		Vlan:               p.Vlan,
+		IfaceName:          p.IfaceName,
+		IfaceIndex:         p.IfaceIndex,
+		Trunk:              p.Trunk,
+		L2TPTunnelID:       p.L2TPTunnelID,
+		L2TPSessionID:      p.L2TPSessionID,
+		InDialog:           p.InDialog,
+		NoMagicCookie:      p.NoMagicCookie,
+		CorrID:             string(p.CorrID),
+		TTL:                p.TTL,
+		DSCP:               p.DSCP(),
+		ECN:                p.ECN(),
+		Reassembled:        p.Reassembled,
+		Fragments:          p.Fragments,
+		LocalIP:            p.LocalIP,
+		Hash:               hex.EncodeToString(p.Hash),
+		Scan:               p.Scan,
+		BodyLengthMismatch: p.BodyLengthMismatch,
+		WireLength:         p.WireLength,
+		PPPoESessionID:     p.PPPoESessionID,
+	})
+}
+
+// packetMetadata holds the Packet fields which have no HEP chunk
+// of their own. They are sent as JSON in chunk 0x34 with -hmd.
+type packetMetadata struct {
+	IfaceName          string `json:"iface_name,omitempty"`
+	IfaceIndex         int    `json:"iface_index,omitempty"`
+	Trunk              string `json:"trunk,omitempty"`
+	L2TPTunnelID       uint16 `json:"l2tp_tunnel_id,omitempty"`
+	L2TPSessionID      uint32 `json:"l2tp_session_id,omitempty"`
+	PPPoESessionID     uint16 `json:"pppoe_session_id,omitempty"`
+	TTL                uint8  `json:"ttl,omitempty"`
+	LocalIP            net.IP `json:"local_ip,omitempty"`
+	InDialog           bool   `json:"in_dialog,omitempty"`
+	NoMagicCookie      bool   `json:"no_magic_cookie,omitempty"`
+	BodyLengthMismatch bool   `json:"body_length_mismatch,omitempty"`
+	Scan               string `json:"scan,omitempty"`
+}
+
+// Metadata returns the JSON of the fields without a HEP chunk
+// or nil if none of them is set.
+func (p *Packet) Metadata() []byte {
+	b, err := json.Marshal(packetMetadata{
+		IfaceName:          p.IfaceName,
+		IfaceIndex:         p.IfaceIndex,
+		Trunk:              p.Trunk,
+		L2TPTunnelID:       p.L2TPTunnelID,
+		L2TPSessionID:      p.L2TPSessionID,
+		PPPoESessionID:     p.PPPoESessionID,
+		TTL:                p.TTL,
+		LocalIP:            p.LocalIP,
+		InDialog:           p.InDialog,
+		NoMagicCookie:      p.NoMagicCookie,
+		BodyLengthMismatch: p.BodyLengthMismatch,
+		Scan:               p.Scan,
 	})
+	if err != nil || len(b) <= 2 {
+		return nil
+	}
+	return b
 }
 
 func (d *Decoder) printPacketStats() {
-	logp.Info("Packets since last minute IPv4: %d, IPv6: %d, UDP: %d, TCP: %d, RTCP: %d, RTCPFail: %d, DNS: %d, duplicate: %d, fragments: %d, unknown: %d",
-		d.ip4Count, d.ip6Count, d.udpCount, d.tcpCount, d.rtcpCount, d.rtcpFailCount, d.dnsCount, d.dupCount, d.fragCount, d.unknownCount)
-	d.ip4Count, d.ip6Count, d.udpCount, d.tcpCount, d.rtcpCount, d.rtcpFailCount, d.dnsCount, d.dupCount, d.fragCount, d.unknownCount = 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
+	logp.Info("Packets since last minute IPv4: %d, IPv6: %d, UDP: %d, TCP: %d, RTCP: %d, RTCPFail: %d, DNS: %d, duplicate: %d, fragments: %d, dropped fragments: %d, truncated: %d, no Call-ID: %d, no Via magic cookie: %d, Content-Length mismatch: %d, parse errors: %d, clock skew: %d, asymmetric RTCP: %d, SDP conflicts: %d, SIP scanners: %d, unknown: %d",
+		d.ip4Count, d.ip6Count, d.udpCount, d.tcpCount, d.rtcpCount, d.rtcpFailCount, d.dnsCount, d.dupCount, d.fragCount, d.fragDropCount, d.truncCount, d.noCallIDCount, d.noCookieCount, d.bodyLenCount, d.parseErrCount, d.skewCount, d.asymCount, d.sdpConflictCount, d.scanCount, d.unknownCount)
+	d.ip4Count, d.ip6Count, d.udpCount, d.tcpCount, d.rtcpCount, d.rtcpFailCount, d.dnsCount, d.dupCount, d.fragCount, d.fragDropCount, d.truncCount, d.noCallIDCount, d.noCookieCount, d.bodyLenCount, d.parseErrCount, d.skewCount, d.asymCount, d.sdpConflictCount, d.scanCount, d.unknownCount = 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
 }
 
 func (d *Decoder) printSIPCacheStats() {
@@ -118,15 +711,30 @@ func (d *Decoder) printRTCPCacheStats() {
 	d.RTCPCache.ResetStatistics()
 }
 
+func (d *Decoder) printFlowCacheStats() {
+	logp.Info("FlowCache EntryCount: %v, LookupCount: %v, HitCount: %v, ExpiredCount: %v, OverwriteCount: %v",
+		d.FlowCache.EntryCount(), d.FlowCache.LookupCount(), d.FlowCache.HitCount(), d.FlowCache.ExpiredCount(), d.FlowCache.OverwriteCount())
+	d.FlowCache.ResetStatistics()
+}
+
 func (d *Decoder) printStats() {
 	for {
 		<-time.After(60 * time.Second)
 		go func() {
 			d.printPacketStats()
+			d.printResponseStats()
+			d.printDropStats()
+			d.printSetupStats()
+			d.printRTTStats()
+			d.printQUICStats()
+			d.printSweepStats()
 			if runtime.GOARCH == "amd64" {
 				d.printSIPCacheStats()
 				d.printSDPCacheStats()
 				d.printRTCPCacheStats()
+				if d.FlowCache != nil {
+					d.printFlowCacheStats()
+				}
 			}
 		}()
 	}
@@ -243,3 +851,34 @@ func parseNG(data []byte) (interface{}, int, error) {
 		return data[length+1 : endPosition], endPosition, nil
 	}
 }
+
+// decodeL2TP returns the L2TP data message carried by packet, either L2TPv2
+// over UDP port 1701 or L2TPv3 directly over IP. It returns nil for anything else.
+func decodeL2TP(packet gopacket.Packet) *ownlayers.L2TP {
+	l2tp := &ownlayers.L2TP{}
+	if udpLayer := packet.Layer(layers.LayerTypeUDP); udpLayer != nil {
+		udp, ok := udpLayer.(*layers.UDP)
+		if !ok || (udp.SrcPort != ownlayers.L2TPv2Port && udp.DstPort != ownlayers.L2TPv2Port) {
+			return nil
+		}
+		if err := l2tp.DecodeFromBytes(udp.Payload, gopacket.NilDecodeFeedback); err != nil {
+			logp.Debug("layer", "%v", err)
+			return nil
+		}
+	} else if ipv4Layer := packet.Layer(layers.LayerTypeIPv4); ipv4Layer != nil {
+		ip4, ok := ipv4Layer.(*layers.IPv4)
+		if !ok || ip4.Protocol != ownlayers.L2TPv3Protocol || ip4.Flags&layers.IPv4MoreFragments != 0 || ip4.FragOffset != 0 {
+			return nil
+		}
+		if err := l2tp.DecodeL2TPv3(ip4.Payload); err != nil {
+			logp.Debug("layer", "%v", err)
+			return nil
+		}
+	} else {
+		return nil
+	}
+	if l2tp.Control || l2tp.NextLayerType() == gopacket.LayerTypePayload {
+		return nil
+	}
+	return l2tp
+}
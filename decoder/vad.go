@@ -0,0 +1,135 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/negbie/heplify/protos"
+	"github.com/negbie/logp"
+)
+
+const (
+	// maxVADStreams limits how many RTP streams are tracked
+	maxVADStreams = 4096
+	// comfortNoise is the static RTP payload type of CN, RFC 3389
+	comfortNoise = 13
+)
+
+// vadStream counts comfort noise and silence gaps of one RTP stream
+// until the next RTCP report of its SSRC is annotated.
+type vadStream struct {
+	cnPayloadType int
+	lastSeq       uint16
+	lastSeen      time.Time
+	cnPackets     int
+	gaps          int
+	silence       time.Duration
+}
+
+// vadStats tracks the RTP streams by SSRC. A gap is a pause longer than
+// the threshold between packets with consecutive sequence numbers. Lost
+// packets leave holes in the sequence numbers, VAD silence does not.
+type vadStats struct {
+	threshold time.Duration
+	streams   map[uint32]*vadStream
+}
+
+// cacheSDPComfortNoise will add the payload type of CN in the first
+// audio section to the SDPCache with the CallID as key if it isn't 13.
+func (d *Decoder) cacheSDPComfortNoise(callID, payload []byte) {
+	for _, media := range protos.ParseSDPMedia(payload) {
+		if media.Media != "audio" || media.Port == "0" {
+			continue
+		}
+		for _, f := range media.Formats {
+			if f.PayloadType != comfortNoise && strings.HasPrefix(strings.ToUpper(f.Codec), "CN/") {
+				if err := d.SDPCache.Set(cnKey(callID), []byte(strconv.Itoa(f.PayloadType)), 43200); err != nil {
+					logp.Warn("%v", err)
+				}
+				return
+			}
+		}
+		return
+	}
+}
+
+// cnPayloadType returns the dynamic CN payload type negotiated for the
+// call of the RTP stream or 13.
+func (d *Decoder) cnPayloadType(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) int {
+	callID := d.MediaCallID(srcIP, srcPort)
+	if callID == nil {
+		if callID = d.MediaCallID(dstIP, dstPort); callID == nil {
+			return comfortNoise
+		}
+	}
+	if pt, err := d.SDPCache.Get(cnKey(callID)); err == nil {
+		if n, err := strconv.Atoi(string(pt)); err == nil {
+			return n
+		}
+	}
+	return comfortNoise
+}
+
+// trackVAD will count the comfort noise packets and silence gaps of a RTP packet.
+func (d *Decoder) trackVAD(pkt *Packet, payload []byte, ts time.Time) {
+	if len(payload) < 12 {
+		return
+	}
+	ssrc := binary.BigEndian.Uint32(payload[8:12])
+	seq := binary.BigEndian.Uint16(payload[2:4])
+	s, ok := d.vad.streams[ssrc]
+	if !ok {
+		if len(d.vad.streams) >= maxVADStreams {
+			d.expireVAD(ts, time.Minute)
+			if len(d.vad.streams) >= maxVADStreams {
+				return
+			}
+		}
+		s = &vadStream{cnPayloadType: d.cnPayloadType(pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort), lastSeq: seq - 1, lastSeen: ts}
+		d.vad.streams[ssrc] = s
+	}
+	if int(payload[1]&0x7f) == s.cnPayloadType {
+		s.cnPackets++
+	}
+	if gap := ts.Sub(s.lastSeen); seq == s.lastSeq+1 && gap > d.vad.threshold {
+		s.gaps++
+		s.silence += gap
+		logp.Debug("rtp", "Silence of %v in RTP stream 0x%08x from %v:%d", gap, ssrc, pkt.SrcIP, pkt.SrcPort)
+	}
+	s.lastSeq, s.lastSeen = seq, ts
+}
+
+// expireVAD forgets the streams which were quiet for maxIdle.
+func (d *Decoder) expireVAD(now time.Time, maxIdle time.Duration) int {
+	var n int
+	for ssrc, s := range d.vad.streams {
+		if now.Sub(s.lastSeen) > maxIdle {
+			delete(d.vad.streams, ssrc)
+			n++
+		}
+	}
+	return n
+}
+
+// addVAD will add the comfort noise packets, silence gaps and their
+// duration since the last report of the sender to a RTCP report,
+// so silence suppressed by VAD isn't taken for packet loss.
+func (d *Decoder) addVAD(report, rtcp []byte) []byte {
+	if len(rtcp) < 8 || len(report) < 2 || report[0] != '{' {
+		return report
+	}
+	s, ok := d.vad.streams[binary.BigEndian.Uint32(rtcp[4:8])]
+	if !ok {
+		return report
+	}
+	fields := fmt.Sprintf(`{"cn_packets":%d,"vad_gaps":%d,"vad_silence_ms":%d`, s.cnPackets, s.gaps, s.silence.Milliseconds())
+	if report[1] != '}' {
+		fields += ","
+	}
+	s.cnPackets, s.gaps, s.silence = 0, 0, 0
+	return append([]byte(fields), report[1:]...)
+}
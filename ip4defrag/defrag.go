@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
@@ -36,7 +37,9 @@ const (
 	IPv4MinimumFragmentSize    = 8     // Minimum size of a single fragment
 	IPv4MaximumSize            = 65535 // Maximum size of a fragment (2^16)
 	IPv4MaximumFragmentOffset  = 8183  // Maximum offset of a fragment
-	IPv4MaximumFragmentListLen = 8192  // Back out if we get more than this many fragments
+	IPv4MaximumFragmentListLen = 128   // Back out if we get more than this many fragments for one datagram, 65535/(576-20) fit
+	IPv4MaximumReassemblies    = 4096  // Maximum number of datagrams reassembled at the same time
+	IPv4MinimumTCPHeaderSize   = 20    // First fragment of a TCP datagram must hold the whole header, RFC 1858
 )
 
 // DefragIPv4 takes in an IPv4 packet with a fragment payload.
@@ -84,15 +87,24 @@ func (d *IPv4Defragmenter) DefragIPv4(in *layers.IPv4) (*layers.IPv4, error) {
 // This is useful when operating on pcap files instead of live captured data
 //
 func (d *IPv4Defragmenter) DefragIPv4WithTimestamp(in *layers.IPv4, t time.Time) (*layers.IPv4, error) {
+	out, _, err := d.DefragIPv4Fragments(in, t)
+	return out, err
+}
+
+// DefragIPv4Fragments works like DefragIPv4WithTimestamp and also
+// returns the number of fragments of a reassembled datagram.
+// It is 1 for packets which were not fragmented.
+func (d *IPv4Defragmenter) DefragIPv4Fragments(in *layers.IPv4, t time.Time) (*layers.IPv4, int, error) {
 	// check if we need to defrag
 	if st := d.dontDefrag(in); st == true {
 		debug.Printf("defrag: do nothing, do not need anything")
-		return in, nil
+		return in, 1, nil
 	}
 	// perfom security checks
 	if err := d.securityChecks(in); err != nil {
 		debug.Printf("defrag: alert security check")
-		return nil, err
+		atomic.AddUint64(&d.dropped, 1)
+		return nil, 0, err
 	}
 
 	// ok, got a fragment
@@ -106,6 +118,12 @@ func (d *IPv4Defragmenter) DefragIPv4WithTimestamp(in *layers.IPv4, t time.Time)
 	d.Lock()
 	fl, exist = d.ipFlows[ipf]
 	if !exist {
+		if len(d.ipFlows) >= IPv4MaximumReassemblies {
+			d.Unlock()
+			atomic.AddUint64(&d.dropped, 1)
+			return nil, 0, fmt.Errorf("defrag: too many concurrent reassemblies (%d)",
+				IPv4MaximumReassemblies)
+		}
 		debug.Printf("defrag: unknown flow, creating a new one\n")
 		fl = new(fragmentList)
 		d.ipFlows[ipf] = fl
@@ -113,13 +131,22 @@ func (d *IPv4Defragmenter) DefragIPv4WithTimestamp(in *layers.IPv4, t time.Time)
 	d.Unlock()
 	// insert, and if final build it
 	out, err2 := fl.insert(in, t)
+	if err2 != nil {
+		atomic.AddUint64(&d.dropped, 1)
+		// an overlap poisons the whole datagram, forget it
+		if err2 == errOverlap {
+			d.flush(ipf)
+		}
+		return nil, 0, err2
+	}
 
 	// at last, if we hit the maximum frag list len
 	// without any defrag success, we just drop everything and
 	// raise an error
 	if out == nil && fl.List.Len()+1 > IPv4MaximumFragmentListLen {
 		d.flush(ipf)
-		return nil, fmt.Errorf("defrag: Fragment List hits its maximum"+
+		atomic.AddUint64(&d.dropped, uint64(fl.List.Len()))
+		return nil, 0, fmt.Errorf("defrag: Fragment List hits its maximum"+
 			"size(%d), without success. Flushing the list",
 			IPv4MaximumFragmentListLen)
 	}
@@ -128,10 +155,11 @@ func (d *IPv4Defragmenter) DefragIPv4WithTimestamp(in *layers.IPv4, t time.Time)
 	if out != nil {
 		// when defrag is done for a flow between two ip
 		// clean the list
+		fragments := fl.List.Len()
 		d.flush(ipf)
-		return out, nil
+		return out, fragments, nil
 	}
-	return nil, err2
+	return nil, 0, err2
 }
 
 // DiscardOlderThan forgets all packets without any activity since
@@ -150,11 +178,17 @@ func (d *IPv4Defragmenter) DiscardOlderThan(t time.Time) int {
 	return nb
 }
 
+// Dropped returns the number of fragments which were dropped
+// because they failed the security checks, overlapped or were
+// duplicates since the defragmenter was created.
+func (d *IPv4Defragmenter) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
 // flush the fragment list for a particular flow
 func (d *IPv4Defragmenter) flush(ipf ipv4) {
 	d.Lock()
-	fl := new(fragmentList)
-	d.ipFlows[ipf] = fl
+	delete(d.ipFlows, ipf)
 	d.Unlock()
 }
 
@@ -182,6 +216,17 @@ func (d *IPv4Defragmenter) securityChecks(ip *layers.IPv4) error {
 			"(handcrafted? %d < %d)", fragSize, IPv4MinimumFragmentSize)
 	}
 
+	// don't allow the tiny fragment attack, RFC 1858 and RFC 3128
+	if ip.Protocol == layers.IPProtocolTCP {
+		if ip.FragOffset == 1 {
+			return errors.New("defrag: TCP fragment with offset 8 (tiny fragment attack?)")
+		}
+		if ip.FragOffset == 0 && fragSize < IPv4MinimumTCPHeaderSize {
+			return fmt.Errorf("defrag: first TCP fragment too small "+
+				"(tiny fragment attack? %d < %d)", fragSize, IPv4MinimumTCPHeaderSize)
+		}
+	}
+
 	// don't allow too big fragment offset
 	if ip.FragOffset > IPv4MaximumFragmentOffset {
 		return fmt.Errorf("defrag: fragment offset too big "+
@@ -198,6 +243,11 @@ func (d *IPv4Defragmenter) securityChecks(ip *layers.IPv4) error {
 	return nil
 }
 
+var (
+	errDuplicate = errors.New("defrag: duplicate fragment")
+	errOverlap   = errors.New("defrag: overlapping fragment")
+)
+
 // fragmentList holds a container/list used to contains IP
 // packets/fragments.  It stores internal counters to track the
 // maximum total of byte, and the current length it has received.
@@ -218,40 +268,42 @@ func (f *fragmentList) insert(in *layers.IPv4, t time.Time) (*layers.IPv4, error
 	// TODO: should keep a copy of *in in the list
 	// or not (ie the packet source is reliable) ? -> depends on Lazy / last packet
 	fragOffset := in.FragOffset * 8
+	fragLength := in.Length - uint16(in.IHL)*4
 	if fragOffset >= f.Highest {
 		f.List.PushBack(in)
 	} else {
+		var next *list.Element
 		for e := f.List.Front(); e != nil; e = e.Next() {
 			frag, _ := e.Value.(*layers.IPv4)
-			if in.FragOffset == frag.FragOffset {
-				// TODO: what if we receive a fragment
-				// that begins with duplicate data but
-				// *also* has new data? For example:
-				//
-				// AAAA
-				//     BB
-				//     BBCC
-				//         DDDD
-				//
-				// In this situation we completely
-				// ignore CC and the complete packet can
-				// never be reassembled.
+			offset := frag.FragOffset * 8
+			length := frag.Length - uint16(frag.IHL)*4
+			if fragOffset == offset && fragLength == length {
 				debug.Printf("defrag: ignoring frag %d as we already have it (duplicate?)\n",
 					fragOffset)
-				return nil, nil
+				return nil, errDuplicate
+			}
+			// Legit senders never produce overlapping fragments. They are
+			// used to sneak data past filters, so reject them, RFC 1858
+			if fragOffset < offset+length && offset < fragOffset+fragLength {
+				debug.Printf("defrag: frag %d overlaps existing frag %d\n",
+					fragOffset, offset)
+				return nil, errOverlap
 			}
-			if in.FragOffset < frag.FragOffset {
-				debug.Printf("defrag: inserting frag %d before existing frag %d\n",
-					fragOffset, frag.FragOffset*8)
-				f.List.InsertBefore(in, e)
-				break
+			if next == nil && fragOffset < offset {
+				next = e
 			}
 		}
+		if next == nil {
+			f.List.PushBack(in)
+		} else {
+			debug.Printf("defrag: inserting frag %d before existing frag %d\n",
+				fragOffset, next.Value.(*layers.IPv4).FragOffset*8)
+			f.List.InsertBefore(in, next)
+		}
 	}
 
 	f.LastSeen = t
 
-	fragLength := in.Length - 20
 	// After inserting the Fragment, we update the counters
 	if f.Highest < fragOffset+fragLength {
 		f.Highest = fragOffset + fragLength
@@ -283,20 +335,21 @@ func (f *fragmentList) build(in *layers.IPv4) (*layers.IPv4, error) {
 	debug.Printf("defrag: building the datagram \n")
 	for e := f.List.Front(); e != nil; e = e.Next() {
 		frag, _ := e.Value.(*layers.IPv4)
+		fragLength := frag.Length - uint16(frag.IHL)*4
 		if frag.FragOffset*8 == currentOffset {
 			debug.Printf("defrag: building - adding %d\n", frag.FragOffset*8)
 			final = append(final, frag.Payload...)
-			currentOffset = currentOffset + frag.Length - 20
+			currentOffset = currentOffset + fragLength
 		} else if frag.FragOffset*8 < currentOffset {
 			// overlapping fragment - let's take only what we need
 			startAt := currentOffset - frag.FragOffset*8
 			debug.Printf("defrag: building - overlapping, starting at %d\n",
 				startAt)
-			if startAt > frag.Length-20 {
+			if startAt > fragLength {
 				return nil, errors.New("defrag: building - invalid fragment")
 			}
 			final = append(final, frag.Payload[startAt:]...)
-			currentOffset = currentOffset + frag.FragOffset*8
+			currentOffset = frag.FragOffset*8 + fragLength
 		} else {
 			// Houston - we have an hole !
 			debug.Printf("defrag: hole found while building, " +
@@ -311,7 +364,7 @@ func (f *fragmentList) build(in *layers.IPv4) (*layers.IPv4, error) {
 		Version:    in.Version,
 		IHL:        in.IHL,
 		TOS:        in.TOS,
-		Length:     f.Highest,
+		Length:     f.Highest + uint16(in.IHL)*4,
 		Id:         0,
 		Flags:      0,
 		FragOffset: 0,
@@ -347,6 +400,7 @@ func newIPv4(ip *layers.IPv4) ipv4 {
 type IPv4Defragmenter struct {
 	sync.RWMutex
 	ipFlows map[ipv4]*fragmentList
+	dropped uint64
 }
 
 // NewIPv4Defragmenter returns a new IPv4Defragmenter
@@ -0,0 +1,128 @@
+package ip4defrag
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func fragment(id uint16, proto layers.IPProtocol, offset uint16, more bool, payload []byte) *layers.IPv4 {
+	ip := &layers.IPv4{
+		Version:    4,
+		IHL:        5,
+		Length:     20 + uint16(len(payload)),
+		Id:         id,
+		FragOffset: offset / 8,
+		TTL:        64,
+		Protocol:   proto,
+		SrcIP:      net.IP{10, 0, 0, 1},
+		DstIP:      net.IP{10, 0, 0, 2},
+	}
+	if more {
+		ip.Flags = layers.IPv4MoreFragments
+	}
+	ip.Payload = payload
+	return ip
+}
+
+func TestDefragIPv4OutOfOrder(t *testing.T) {
+	d := NewIPv4Defragmenter()
+	a, b, c := bytes.Repeat([]byte{'a'}, 16), bytes.Repeat([]byte{'b'}, 16), []byte("cccccccc")
+
+	for _, in := range []*layers.IPv4{
+		fragment(1, layers.IPProtocolUDP, 32, false, c),
+		fragment(1, layers.IPProtocolUDP, 0, true, a),
+	} {
+		if out, err := d.DefragIPv4(in); out != nil || err != nil {
+			t.Fatalf("DefragIPv4() = %v, %v", out, err)
+		}
+	}
+	out, err := d.DefragIPv4(fragment(1, layers.IPProtocolUDP, 16, true, b))
+	if err != nil || out == nil {
+		t.Fatalf("DefragIPv4() = %v, %v", out, err)
+	}
+	if want := string(a) + string(b) + string(c); string(out.Payload) != want || out.Length != 20+40 {
+		t.Errorf("reassembled %q with length %d", out.Payload, out.Length)
+	}
+	if len(d.ipFlows) != 0 {
+		t.Errorf("flow was not flushed after reassembly")
+	}
+}
+
+func TestDefragIPv4Fragments(t *testing.T) {
+	d := NewIPv4Defragmenter()
+	now := time.Now()
+
+	if out, n, err := d.DefragIPv4Fragments(fragment(5, layers.IPProtocolUDP, 0, false, []byte("whole")), now); out == nil || n != 1 || err != nil {
+		t.Errorf("unfragmented DefragIPv4Fragments() = %v, %d, %v", out, n, err)
+	}
+	if out, n, err := d.DefragIPv4Fragments(fragment(6, layers.IPProtocolUDP, 0, true, bytes.Repeat([]byte{'a'}, 16)), now); out != nil || n != 0 || err != nil {
+		t.Errorf("first fragment DefragIPv4Fragments() = %v, %d, %v", out, n, err)
+	}
+	d.DefragIPv4Fragments(fragment(6, layers.IPProtocolUDP, 16, true, bytes.Repeat([]byte{'b'}, 16)), now)
+	if out, n, err := d.DefragIPv4Fragments(fragment(6, layers.IPProtocolUDP, 32, false, []byte("cccccccc")), now); out == nil || n != 3 || err != nil {
+		t.Errorf("last fragment DefragIPv4Fragments() = %v, %d, %v", out, n, err)
+	}
+}
+
+func TestDefragIPv4Overlap(t *testing.T) {
+	d := NewIPv4Defragmenter()
+
+	if _, err := d.DefragIPv4(fragment(2, layers.IPProtocolUDP, 0, true, bytes.Repeat([]byte{'a'}, 16))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DefragIPv4(fragment(2, layers.IPProtocolUDP, 0, true, bytes.Repeat([]byte{'a'}, 16))); err != errDuplicate {
+		t.Errorf("duplicate fragment error = %v", err)
+	}
+	if _, err := d.DefragIPv4(fragment(2, layers.IPProtocolUDP, 8, false, bytes.Repeat([]byte{'x'}, 16))); err != errOverlap {
+		t.Errorf("overlapping fragment error = %v", err)
+	}
+	if len(d.ipFlows) != 0 {
+		t.Errorf("datagram with overlapping fragments was not flushed")
+	}
+	if d.Dropped() != 2 {
+		t.Errorf("Dropped() = %d, want 2", d.Dropped())
+	}
+}
+
+func TestDefragIPv4TinyFragment(t *testing.T) {
+	d := NewIPv4Defragmenter()
+
+	if _, err := d.DefragIPv4(fragment(3, layers.IPProtocolTCP, 0, true, make([]byte, 8))); err == nil {
+		t.Error("first TCP fragment without the whole header was accepted")
+	}
+	if _, err := d.DefragIPv4(fragment(3, layers.IPProtocolTCP, 8, false, make([]byte, 16))); err == nil {
+		t.Error("TCP fragment with offset 8 was accepted")
+	}
+	if _, err := d.DefragIPv4(fragment(4, layers.IPProtocolUDP, 0, true, make([]byte, 8))); err != nil {
+		t.Errorf("small UDP fragment was rejected: %v", err)
+	}
+	if d.Dropped() != 2 {
+		t.Errorf("Dropped() = %d, want 2", d.Dropped())
+	}
+}
+
+func TestDefragIPv4Limits(t *testing.T) {
+	d := NewIPv4Defragmenter()
+
+	for i := 0; i < IPv4MaximumReassemblies; i++ {
+		if _, err := d.DefragIPv4(fragment(uint16(i), layers.IPProtocolUDP, 0, true, make([]byte, 8))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.DefragIPv4(fragment(IPv4MaximumReassemblies, layers.IPProtocolUDP, 0, true, make([]byte, 8))); err == nil {
+		t.Error("more concurrent reassemblies than allowed")
+	}
+
+	d = NewIPv4Defragmenter()
+	var err error
+	for i := 0; i < IPv4MaximumFragmentListLen && err == nil; i++ {
+		_, err = d.DefragIPv4(fragment(1, layers.IPProtocolUDP, uint16(i*8), true, make([]byte, 8)))
+	}
+	if err == nil {
+		t.Error("more fragments per datagram than allowed")
+	}
+}
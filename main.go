@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/negbie/heplify/config"
 	"github.com/negbie/heplify/sniffer"
@@ -14,6 +15,8 @@ import (
 
 const version = "heplify 1.3"
 
+var listDevices bool
+
 func init() {
 
 	flag.Usage = func() {
@@ -32,8 +35,9 @@ func init() {
 	)
 
 	flag.StringVar(&ifaceConfig.Device, "i", "any", "Listen on interface")
+	flag.BoolVar(&listDevices, "li", false, "List the capture interfaces with their addresses and link type and exit")
 	flag.StringVar(&ifaceConfig.Type, "t", "pcap", "Capture types are [pcap, af_packet]")
-	flag.StringVar(&ifaceConfig.ReadFile, "rf", "", "Read pcap file")
+	flag.StringVar(&ifaceConfig.ReadFile, "rf", "", "Read pcap file, also gzipped")
 	flag.StringVar(&ifaceConfig.WriteFile, "wf", "", "Path to write pcap file")
 	flag.IntVar(&ifaceConfig.RotationTime, "rt", 60, "Pcap rotation time in minutes")
 	flag.BoolVar(&config.Cfg.Zip, "zf", false, "Enable pcap compression")
@@ -41,8 +45,16 @@ func init() {
 	flag.BoolVar(&ifaceConfig.ReadSpeed, "rs", false, "Maximum pcap read speed. Doesn't use packet timestamps")
 	flag.IntVar(&ifaceConfig.Snaplen, "s", 8192, "Snaplength")
 	flag.StringVar(&ifaceConfig.PortRange, "pr", "5060-5090", "Portrange to capture SIP")
+	flag.StringVar(&config.Cfg.SIPPorts, "sp", "", "Additional SIP ports like 5070,8080-8090. SIP seen on other ports is still sent and logged once")
+	flag.BoolVar(&config.Cfg.StrictSIP, "sc", false, "Treat SIP responses with status codes outside of 100-699 as invalid")
+	flag.BoolVar(&config.Cfg.TolerantSIP, "tl", false, "Tolerant SIP parsing: keep parsing headers after a stray blank line which is not followed by a body and keep the first -mh headers of longer messages")
+	flag.IntVar(&config.Cfg.MaxSIPHeaders, "mh", 512, "Maximum number of headers per SIP message")
+	flag.BoolVar(&config.Cfg.SplitUDP, "us", false, "Split UDP datagrams with several SIP messages by their Content-Length and send each one")
 	flag.BoolVar(&ifaceConfig.WithVlan, "vlan", false, "vlan")
 	flag.BoolVar(&ifaceConfig.WithErspan, "erspan", false, "erspan")
+	flag.StringVar(&config.Cfg.MirrorHeaders, "vm", "", "Strip vendor mirror headers of SPAN frames [juniper, arista], comma separated. Unknown prefixes are logged once")
+	flag.BoolVar(&ifaceConfig.WithL2TP, "l2tp", false, "Decode SIP inside L2TPv2 and L2TPv3 tunnels")
+	flag.StringVar(&config.Cfg.TimeSource, "ts", "capture", "Timestamp source [capture, erspan, rtp]. rtp times RTCP sender reports, DTMF and -rq records by their RTP timestamp and the SDP clock rate")
 	flag.IntVar(&ifaceConfig.BufferSizeMb, "b", 32, "Interface buffersize (MB)")
 	flag.StringVar(&dbg, "d", "", "Enable certain debug selectors [fragment,layer,payload,rtp,rtcp,sdp]")
 	flag.BoolVar(&std, "e", false, "Log to stderr and disable syslog/file output")
@@ -52,16 +64,61 @@ func init() {
 	flag.StringVar(&fileRotator.Path, "p", "./", "Log filepath")
 	flag.StringVar(&fileRotator.Name, "n", "heplify.log", "Log filename")
 	flag.BoolVar(&config.Cfg.Bench, "bm", false, "Benchmark for the next 2 minutes and exit")
+	flag.IntVar(&config.Cfg.DryRun, "dr", 0, "Dry run: decode this many packets, print a summary and exit without sending HEP")
+	flag.StringVar(&config.Cfg.ExtractCall, "xc", "", "Extract the call with this Call-ID from the pcap file with its SIP, RTCP and RTP")
+	flag.StringVar(&config.Cfg.ExtractFile, "xf", "call.pcap", "File for -xc. Files ending with .hep get HEP encoded SIP and RTCP")
 	flag.StringVar(&config.Cfg.Mode, "m", "SIPRTCP", "Capture modes [SIP, SIPDNS, SIPLOG, SIPRTP, SIPRTCP]")
+	flag.StringVar(&config.Cfg.CaptureMode, "cm", "both", "Capture [signaling, media, both]. signaling skips SDP, RTCP and RTP, media fills the SDPCache from SIP but only sends RTCP and RTP events")
 	flag.BoolVar(&config.Cfg.Dedup, "dd", false, "Deduplicate packets")
+	flag.IntVar(&config.Cfg.WarmupSeconds, "wu", 0, "Fill the correlation caches for this many seconds before sending HEP")
+	flag.DurationVar(&config.Cfg.DedupWindow, "dw", 0, "Drop identical frames seen again within this window like 10ms, e.g. from two SPAN sessions")
+	flag.DurationVar(&config.Cfg.MaxClockSkew, "cs", 0, "Replace capture timestamps which differ more than this from the wall clock like 1h. Not used for PCAP files")
+	flag.BoolVar(&config.Cfg.FlowCache, "fc", false, "Correlate RTCP also by the learned media 5-tuple and mark it asymmetric if it is sent from a port which isn't in the SDP")
+	flag.StringVar(&config.Cfg.SDPConflict, "sdpc", "overwrite", "SDP media address reused by another call [overwrite, keep-first, multi]. multi keeps up to 4 calls and picks one for RTCP by its SSRCs")
+	flag.BoolVar(&config.Cfg.WithDSCP, "dscp", false, "Add the DSCP and ECN of the IP header to RTCP reports")
+	flag.BoolVar(&config.Cfg.RTPEvents, "dtmf", false, "Send RFC 4733 DTMF events of RTP as HEP logs correlated to the Call-ID")
+	flag.DurationVar(&config.Cfg.VADGap, "vad", 0, "Add comfort noise packets and RTP pauses longer than this like 200ms to RTCP reports, so VAD silence isn't taken for loss. Needs -m SIPRTP")
+	flag.DurationVar(&config.Cfg.RTPQoS, "rq", 0, "Send the jitter and loss of RTP streams as HEP log correlated to the Call-ID at this interval like 10s, for endpoints without RTCP. Needs -m SIPRTP")
+	flag.BoolVar(&config.Cfg.CallMOS, "mos", false, "Send a MOS per call direction from the correlated RTCP as HEP log when the call ends with BYE")
+	flag.StringVar(&config.Cfg.MOSCodecs, "mosc", "PCMU=0/25.1,PCMA=0/25.1,G722=0/25.1,G723=15/16.1,G729=11/19", "E-model Ie/Bpl per codec for -mos. Other codecs use 0/25.1")
+	flag.DurationVar(&config.Cfg.MediaStatsInterval, "msi", 0, "Send an interim -mos record with the loss, jitter and lost packets of each call so far at this interval like 30s")
+	flag.DurationVar(&config.Cfg.SweepInterval, "sw", 0, "Evict old fragments and idle QUIC, VAD, QoS and MOS state at this interval like 30s. The counts are served under /debug/evictions with -cd")
+	flag.DurationVar(&config.Cfg.IdleTimeout, "sit", 5*time.Minute, "Idle time after which -sw evicts a flow. MOS records of evicted calls are sent as abandoned")
+	flag.BoolVar(&config.Cfg.Radius, "radius", false, "Send RADIUS accounting of UDP port 1813 as HEP logs correlated to the Call-ID of a call-id AVP or the Acct-Session-Id")
+	flag.BoolVar(&config.Cfg.RequireCallID, "rc", false, "Drop SIP packets without a Call-ID")
+	flag.BoolVar(&config.Cfg.ExportParseErrors, "pe", false, "Send parse errors as HEP log messages")
+	flag.StringVar(&config.Cfg.Trunks, "tg", "", "Tag packets with a trunk by IP range like carrierA=10.1.0.0/16,10.2.0.0/16;carrierB=2001:db8::/32")
+	flag.StringVar(&config.Cfg.SetupBuckets, "sd", "", "Log call setup delay from INVITE to first 18x or 2xx in buckets like 100ms,500ms,1s,3s")
+	flag.BoolVar(&config.Cfg.SIPRTT, "rtt", false, "Log the SIP round-trip time of requests and responses with a Timestamp header")
+	flag.StringVar(&config.Cfg.CorrelationID, "cid", "", "Attach a correlation ID to SIP, RTCP and logs. Use callid for a Call-ID fingerprint or a SIP header name like X-Correlation-ID")
+	flag.BoolVar(&config.Cfg.DialogCID, "dcid", false, "Correlate by Call-ID and the From tag of the dialog creating request, so calls of gateways reusing a Call-ID don't merge")
+	flag.StringVar(&config.Cfg.CacheDumpAddr, "cd", "", "Serve the correlation caches as JSON for debugging on this address like localhost:6060 under /debug/caches")
+	flag.StringVar(&config.Cfg.CDRAddr, "cdr", "", "Accept CDRs as JSON objects with a call_id by HTTP POST on this address like localhost:6061 under /cdr. Each one is sent as HEP log with the Call-ID once the SIP of the call is seen")
 	flag.StringVar(&config.Cfg.Discard, "di", "", "Discard uninteresting packets by any string")
 	flag.StringVar(&config.Cfg.DiscardMethod, "dim", "", "Discard uninteresting SIP packets by CSeq [OPTIONS,NOTIFY]")
 	flag.StringVar(&config.Cfg.Filter, "fi", "", "Filter interesting packets by any string")
-	flag.StringVar(&config.Cfg.HepServer, "hs", "127.0.0.1:9060", "HEP server address")
+	flag.StringVar(&config.Cfg.IncludeIPs, "fip", "", "Filter packets from or to these IPs or ranges like 10.1.0.0/16,192.168.1.5,2001:db8::/32")
+	flag.StringVar(&config.Cfg.ExcludeIPs, "dip", "", "Discard packets from or to these IPs or ranges like 10.9.0.0/16,2001:db8:9::/48")
+	flag.StringVar(&config.Cfg.ReloadFile, "hup", "", "Reload -fi, -di, -dim, -tg, -fip, -dip, -hp and the -hs weights from this file on SIGHUP, one per line like dim=OPTIONS,NOTIFY or hs=10.0.0.1:9060*0")
+	flag.StringVar(&config.Cfg.HepServer, "hs", "127.0.0.1:9060", "HEP server address. Comma separated addresses with optional weight like 10.0.0.1:9060*2,10.0.0.2:9060 are sharded by Call-ID")
 	flag.StringVar(&config.Cfg.HepNodePW, "hp", "myhep", "HEP node PW")
 	flag.UintVar(&config.Cfg.HepNodeID, "hi", 2002, "HEP node ID")
-	flag.StringVar(&config.Cfg.Network, "nt", "udp", "Network types are [udp, tcp, tls]")
+	flag.StringVar(&config.Cfg.HepNodeIDs, "him", "", "HEP node ID per interface, trunk or local IP like eth0=2003,carrierA=2004,10.0.1.5=2005")
+	flag.StringVar(&config.Cfg.LocalIPs, "lip", "", "Local IPs of a multi-homed host like 10.0.0.5,10.0.1.5. Packets record the one they were sent from or to")
+	flag.StringVar(&config.Cfg.ProtoTypes, "hpt", "", "Force the HEP payload type per interface or port like eth1=100,514=100")
+	flag.StringVar(&config.Cfg.Network, "nt", "udp", "Network types are [udp, tcp, tls]. tcp and tls keep a persistent connection and reconnect on errors")
+	flag.BoolVar(&config.Cfg.ExportFragments, "hfc", false, "Send the fragment count of reassembled IPv4 packets in HEP chunk 0x31")
 	flag.BoolVar(&config.Cfg.Protobuf, "protobuf", false, "Use Protobuf on wire")
+	flag.IntVar(&config.Cfg.MaxPayloadExport, "mpe", 0, "Cut SIP payloads longer than this many bytes before sending them as HEP and fix their Content-Length. Correlation uses the full payload")
+	flag.BoolVar(&config.Cfg.MessageHash, "hmh", false, "Send a SHA1 of each SIP message without Via branches and Max-Forwards in HEP chunk 0x32 for dedup")
+	flag.BoolVar(&config.Cfg.ExportWireLength, "hwl", false, "Send the length of the captured frame on the wire in HEP chunk 0x33 for bandwidth accounting")
+	flag.BoolVar(&config.Cfg.ExportMetadata, "hmd", false, "Send the capture interface, trunk, L2TP and PPPoE IDs, TTL, local IP and SIP warnings of a packet as JSON in HEP chunk 0x34")
+	flag.StringVar(&config.Cfg.HeaderChunks, "hhc", "", "Send SIP headers in extra HEP chunks like Organization=0x40,Subject=0x41")
+	flag.IntVar(&config.Cfg.HepVersion, "hv", 3, "HEP version [2, 3]. HEPv2 carries only SIP and 16 bit node IDs")
+	flag.BoolVar(&config.Cfg.Scan, "scan", false, "Tag SIP scanner requests by User-Agent or From pattern and request rate and send a HEP log per source IP")
+	flag.StringVar(&config.Cfg.ScanPatterns, "scanp", "friendly-scanner,sipvicious,sipcli,sip-scan,sundayddr,iwar,vaxsipuseragent,pplsip,smap", "Comma separated User-Agent or From patterns of -scan")
+	flag.IntVar(&config.Cfg.ScanRate, "scanr", 20, "Out-of-dialog SIP requests per second from one IP above which -scan tags them. 0 disables it")
+	flag.BoolVar(&config.Cfg.StartEvent, "se", false, "Send a HEP log with the host, version, interface and filters when the capture starts and on reload")
 	flag.Parse()
 
 	config.Cfg.Iface = &ifaceConfig
@@ -70,7 +127,21 @@ func init() {
 	logp.DebugSelectorsStr = &dbg
 	logging.Files = &fileRotator
 	config.Cfg.Logging = &logging
+	config.Cfg.Version = version
 
+	if config.Cfg.HepVersion != 2 && config.Cfg.HepVersion != 3 {
+		checkCritErr(fmt.Errorf("unknown HEP version %d", config.Cfg.HepVersion))
+	}
+	switch config.Cfg.SDPConflict {
+	case "overwrite", "keep-first", "multi":
+	default:
+		checkCritErr(fmt.Errorf("unknown SDP conflict policy %s", config.Cfg.SDPConflict))
+	}
+	switch config.Cfg.TimeSource {
+	case "capture", "erspan", "rtp":
+	default:
+		checkCritErr(fmt.Errorf("unknown timestamp source %s", config.Cfg.TimeSource))
+	}
 	if config.Cfg.HepNodeID > 0xFFFFFFFE {
 		config.Cfg.HepNodeID = 0xFFFFFFFE
 	}
@@ -94,6 +165,10 @@ func checkCritErr(err error) {
 }
 
 func main() {
+	if listDevices {
+		checkCritErr(sniffer.PrintDevices())
+		return
+	}
 
 	/* 	go func() {
 		log.Println(http.ListenAndServe("localhost:6060", nil))
@@ -0,0 +1,147 @@
+package ownlayers
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// LayerTypeERSPAN registers the ERSPAN layer type 2012.
+var LayerTypeERSPAN = gopacket.RegisterLayerType(2012, gopacket.LayerTypeMetadata{Name: "ERSPAN", Decoder: gopacket.DecodeFunc(decodeERSPAN)})
+
+// ERSPAN versions as carried in the Ver field
+const (
+	ERSPANTypeII  = 1
+	ERSPANTypeIII = 2
+)
+
+// ERSPAN Type III timestamp granularities
+const (
+	ERSPANGra100us    = 0 // 100 microseconds
+	ERSPANGra100ns    = 1 // 100 nanoseconds
+	ERSPANGraIEEE1588 = 2 // IEEE 1588, seconds in the platform specific subheader
+	ERSPANGraUser     = 3 // user configurable
+)
+
+/* ERSPAN Type III header
+0               1               2               3              4
+0 1 2 3 4 5 6 7 0 1 2 3 4 5 6 7 0 1 2 3 4 5 6 7 0 1 2 3 4 5 6 7
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|  Ver  |          VLAN         | COS |BSO|T|     Session ID    |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                          Timestamp                            |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|             SGT               |P|    FT   |   Hw ID   |D|Gra|O|
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+
+Platform specific subheader (present if O=1), Platf ID 0x03:
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|  Platf ID |      Reserved     |         Port ID/Index         |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                     Timestamp (seconds)                       |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+*/
+
+// ERSPAN represents an ERSPAN Type II or Type III header.
+type ERSPAN struct {
+	Version     uint8
+	VLAN        uint16
+	COS         uint8
+	SessionID   uint16
+	Timestamp   uint32
+	Granularity uint8
+	HasPlatform bool
+	PlatformID  uint8
+	PlatformSec uint32
+	Payload     []byte
+	Contents    []byte
+}
+
+// LayerType returns the layer type of the ERSPAN object, which is LayerTypeERSPAN.
+func (e *ERSPAN) LayerType() gopacket.LayerType {
+	return LayerTypeERSPAN
+}
+
+// CanDecode returns a set of layers that ERSPAN objects can decode, which is just LayerTypeERSPAN.
+func (e *ERSPAN) CanDecode() gopacket.LayerClass {
+	return LayerTypeERSPAN
+}
+
+// NextLayerType specifies the next layer that should be decoded. ERSPAN always carries Ethernet.
+func (e *ERSPAN) NextLayerType() gopacket.LayerType {
+	return layers.LayerTypeEthernet
+}
+
+func (e *ERSPAN) LayerContents() []byte {
+	return e.Contents
+}
+
+func (e *ERSPAN) LayerPayload() []byte {
+	return e.Payload
+}
+
+// DecodeFromBytes decodes the given bytes into this layer.
+// Type II has a fixed 8 byte header. Type III has a 12 byte header
+// which is followed by an 8 byte platform specific subheader if the O bit is set.
+func (e *ERSPAN) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 8 {
+		return errors.New("ERSPAN header should have at least 8 octets")
+	}
+
+	e.Version = data[0] >> 4
+	e.VLAN = binary.BigEndian.Uint16(data[0:2]) & 0x0fff
+	e.COS = data[2] >> 5
+	e.SessionID = binary.BigEndian.Uint16(data[2:4]) & 0x03ff
+	offset := 8
+
+	switch e.Version {
+	case ERSPANTypeII:
+	case ERSPANTypeIII:
+		if len(data) < 12 {
+			return errors.New("ERSPAN Type III header should have at least 12 octets")
+		}
+		e.Timestamp = binary.BigEndian.Uint32(data[4:8])
+		e.Granularity = (data[11] >> 1) & 0x03
+		e.HasPlatform = data[11]&0x01 == 1
+		offset = 12
+
+		if e.HasPlatform {
+			if len(data) < 20 {
+				return errors.New("Not enough octets left in ERSPAN Type III header to get the platform specific subheader")
+			}
+			e.PlatformID = data[12] >> 2
+			e.PlatformSec = binary.BigEndian.Uint32(data[16:20])
+			offset = 20
+		}
+	default:
+		return errors.New("Unsupported ERSPAN version")
+	}
+
+	e.Contents = data[:offset]
+	e.Payload = data[offset:]
+	return nil
+}
+
+// Time returns the hardware timestamp of a Type III header. It's only
+// an absolute time if the granularity is IEEE 1588 and the platform specific
+// subheader carries the seconds, otherwise ok is false.
+func (e *ERSPAN) Time() (t time.Time, ok bool) {
+	if e.Version != ERSPANTypeIII || e.Granularity != ERSPANGraIEEE1588 || !e.HasPlatform || e.PlatformID != 0x03 {
+		return t, false
+	}
+	return time.Unix(int64(e.PlatformSec), int64(e.Timestamp)), true
+}
+
+// decodeERSPAN decodes the ERSPAN header and hands the payload to the Ethernet decoder.
+func decodeERSPAN(data []byte, p gopacket.PacketBuilder) error {
+	e := &ERSPAN{}
+	err := e.DecodeFromBytes(data, p)
+	if err != nil {
+		return err
+	}
+	p.AddLayer(e)
+	return p.NextDecoder(e.NextLayerType())
+}
@@ -0,0 +1,45 @@
+package ownlayers
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+func TestERSPANTypeIII(t *testing.T) {
+	data := []byte{
+		0x20, 0x0a, 0x00, 0x05, // Ver=2, VLAN=10, Session ID=5
+		0x00, 0x00, 0x03, 0xe8, // Timestamp 1000ns
+		0x00, 0x00, 0x00, 0x05, // Gra=IEEE 1588, O=1
+		0x0c, 0x00, 0x00, 0x01, // Platf ID=0x03
+		0x5a, 0xa2, 0x9b, 0x98, // Timestamp seconds
+		0xde, 0xad, // payload
+	}
+
+	e := &ERSPAN{}
+	if err := e.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if e.Version != ERSPANTypeIII || e.VLAN != 10 || e.SessionID != 5 {
+		t.Errorf("unexpected header %+v", e)
+	}
+	if len(e.Payload) != 2 || e.Payload[0] != 0xde {
+		t.Errorf("payload starts at wrong offset: %x", e.Payload)
+	}
+	ts, ok := e.Time()
+	if !ok || ts.Unix() != 0x5aa29b98 || ts.Nanosecond() != 1000 {
+		t.Errorf("Time() = %v, %v", ts, ok)
+	}
+
+	// Without platform specific subheader the header is 12 bytes
+	data[11] = 0x04
+	if err := e.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Payload) != 10 {
+		t.Errorf("payload length = %d, want 10", len(e.Payload))
+	}
+	if _, ok := e.Time(); ok {
+		t.Errorf("Time() should not be absolute without platform subheader")
+	}
+}
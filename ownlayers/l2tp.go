@@ -0,0 +1,170 @@
+package ownlayers
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// LayerTypeL2TP registers the L2TP layer type 2013.
+var LayerTypeL2TP = gopacket.RegisterLayerType(2013, gopacket.LayerTypeMetadata{Name: "L2TP", Decoder: gopacket.DecodeFunc(decodeL2TP)})
+
+// L2TP well known transport
+const (
+	L2TPv2Port     = 1701 // UDP port of L2TPv2
+	L2TPv3Protocol = 115  // IP protocol of L2TPv3
+)
+
+// PPP protocols carried in L2TPv2 data messages
+const (
+	pppIPv4 = 0x0021
+	pppIPv6 = 0x0057
+)
+
+/* L2TPv2 header, RFC 2661
+0                   1                   2                   3
+0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|T|L|x|x|S|x|O|P|x|x|x|x|  Ver  |          Length (opt)         |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|           Tunnel ID           |           Session ID          |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|             Ns (opt)          |             Nr (opt)          |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|      Offset Size (opt)        |    Offset pad... (opt)
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+
+L2TPv3 data message over IP, RFC 3931. Cookies are not supported.
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                      Session ID (32 bits)                     |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+*/
+
+// L2TP represents a L2TPv2 header with its PPP header or a L2TPv3 session header.
+// The payload of a L2TPv2 data message is an IPv4 or IPv6 packet, the payload of
+// a L2TPv3 data message is an Ethernet frame.
+type L2TP struct {
+	Version   uint8
+	Control   bool
+	TunnelID  uint16
+	SessionID uint32
+	next      gopacket.LayerType
+	Payload   []byte
+	Contents  []byte
+}
+
+// LayerType returns the layer type of the L2TP object, which is LayerTypeL2TP.
+func (l *L2TP) LayerType() gopacket.LayerType {
+	return LayerTypeL2TP
+}
+
+// CanDecode returns a set of layers that L2TP objects can decode, which is just LayerTypeL2TP.
+func (l *L2TP) CanDecode() gopacket.LayerClass {
+	return LayerTypeL2TP
+}
+
+// NextLayerType returns IPv4 or IPv6 for L2TPv2 and Ethernet for L2TPv3 data messages.
+func (l *L2TP) NextLayerType() gopacket.LayerType {
+	return l.next
+}
+
+func (l *L2TP) LayerContents() []byte {
+	return l.Contents
+}
+
+func (l *L2TP) LayerPayload() []byte {
+	return l.Payload
+}
+
+// DecodeFromBytes decodes a L2TPv2 header as carried in UDP.
+// Use DecodeL2TPv3 for L2TPv3 over IP.
+func (l *L2TP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 6 {
+		return errors.New("L2TP header should have at least 6 octets")
+	}
+
+	flags := binary.BigEndian.Uint16(data[0:2])
+	l.Version = uint8(flags & 0x000f)
+	l.Control = flags&0x8000 != 0
+	if l.Version != 2 {
+		return errors.New("Unsupported L2TP version")
+	}
+
+	offset := 2
+	if flags&0x4000 != 0 {
+		offset += 2
+	}
+	if len(data) < offset+4 {
+		return errors.New("Not enough octets left in L2TP header to get the tunnel and session ID")
+	}
+	l.TunnelID = binary.BigEndian.Uint16(data[offset : offset+2])
+	l.SessionID = uint32(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+	offset += 4
+	if flags&0x0800 != 0 {
+		offset += 4
+	}
+	if flags&0x0200 != 0 {
+		if len(data) < offset+2 {
+			return errors.New("Not enough octets left in L2TP header to get the offset size")
+		}
+		offset += 2 + int(binary.BigEndian.Uint16(data[offset:offset+2]))
+	}
+	if len(data) < offset {
+		return errors.New("L2TP header exceeds the packet")
+	}
+
+	l.next = gopacket.LayerTypePayload
+	if !l.Control {
+		// PPP header, address and control field may be compressed
+		ppp := data[offset:]
+		if len(ppp) >= 2 && ppp[0] == 0xff && ppp[1] == 0x03 {
+			ppp = ppp[2:]
+			offset += 2
+		}
+		if len(ppp) >= 2 {
+			switch binary.BigEndian.Uint16(ppp[0:2]) {
+			case pppIPv4:
+				l.next = layers.LayerTypeIPv4
+				offset += 2
+			case pppIPv6:
+				l.next = layers.LayerTypeIPv6
+				offset += 2
+			}
+		}
+	}
+
+	l.Contents = data[:offset]
+	l.Payload = data[offset:]
+	return nil
+}
+
+// DecodeL2TPv3 decodes a L2TPv3 session header as carried directly in IP.
+func (l *L2TP) DecodeL2TPv3(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("L2TPv3 header should have at least 4 octets")
+	}
+	l.Version = 3
+	l.SessionID = binary.BigEndian.Uint32(data[0:4])
+	// Session ID 0 marks a control message
+	l.Control = l.SessionID == 0
+	l.next = layers.LayerTypeEthernet
+	if l.Control {
+		l.next = gopacket.LayerTypePayload
+	}
+	l.Contents = data[:4]
+	l.Payload = data[4:]
+	return nil
+}
+
+// decodeL2TP decodes the L2TPv2 header and hands the payload to the next decoder.
+func decodeL2TP(data []byte, p gopacket.PacketBuilder) error {
+	l := &L2TP{}
+	err := l.DecodeFromBytes(data, p)
+	if err != nil {
+		return err
+	}
+	p.AddLayer(l)
+	return p.NextDecoder(l.NextLayerType())
+}
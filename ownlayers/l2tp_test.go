@@ -0,0 +1,63 @@
+package ownlayers
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestL2TPv2(t *testing.T) {
+	data := []byte{
+		0x40, 0x02, 0x00, 0x0e, // L=1, Ver=2, Length=14
+		0x00, 0x07, 0x00, 0x09, // Tunnel ID=7, Session ID=9
+		0xff, 0x03, 0x00, 0x21, // PPP IPv4
+		0x45, 0x00, // payload
+	}
+
+	l := &L2TP{}
+	if err := l.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if l.Version != 2 || l.Control || l.TunnelID != 7 || l.SessionID != 9 {
+		t.Errorf("unexpected header %+v", l)
+	}
+	if l.NextLayerType() != layers.LayerTypeIPv4 {
+		t.Errorf("NextLayerType() = %v, want IPv4", l.NextLayerType())
+	}
+	if len(l.Payload) != 2 || l.Payload[0] != 0x45 {
+		t.Errorf("payload starts at wrong offset: %x", l.Payload)
+	}
+
+	// Compressed PPP address and control field with sequence numbers and IPv6
+	data = []byte{
+		0x08, 0x02, 0x00, 0x07, 0x00, 0x09, // S=1, Ver=2
+		0x00, 0x01, 0x00, 0x02, // Ns, Nr
+		0x00, 0x57, // PPP IPv6
+		0x60,
+	}
+	if err := l.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if l.NextLayerType() != layers.LayerTypeIPv6 || len(l.Payload) != 1 {
+		t.Errorf("unexpected IPv6 decode %+v", l)
+	}
+
+	data[1] = 0x03
+	if err := l.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+		t.Errorf("L2TPv3 over UDP should not decode")
+	}
+}
+
+func TestL2TPv3(t *testing.T) {
+	l := &L2TP{}
+	if err := l.DecodeL2TPv3([]byte{0x00, 0x01, 0x00, 0x02, 0xaa}); err != nil {
+		t.Fatal(err)
+	}
+	if l.Version != 3 || l.Control || l.SessionID != 0x10002 || l.NextLayerType() != layers.LayerTypeEthernet {
+		t.Errorf("unexpected header %+v", l)
+	}
+	if err := l.DecodeL2TPv3([]byte{0, 0, 0, 0}); err != nil || !l.Control {
+		t.Errorf("session ID 0 should be a control message")
+	}
+}
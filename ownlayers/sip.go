@@ -2,10 +2,14 @@ package ownlayers
 
 import (
 	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
-	"io"
+	"net/textproto"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -13,6 +17,53 @@ import (
 
 var LayerTypeSIP = gopacket.RegisterLayerType(2011, gopacket.LayerTypeMetadata{Name: "SIP", Decoder: gopacket.DecodeFunc(decodeSIP)})
 
+// MaxSIPLineLength is the longest request, status or header line
+// DecodeFromBytes accepts. Longer lines make the whole message invalid.
+var MaxSIPLineLength = 8 * 1024
+
+// MaxSIPHeaders is the most header lines DecodeFromBytes parses.
+// Messages with more are invalid unless TruncateHeaders is set. 0 means no limit.
+var MaxSIPHeaders = 512
+
+// TruncateHeaders makes DecodeFromBytes keep the first MaxSIPHeaders
+// headers of a message with more instead of rejecting it.
+var TruncateHeaders = false
+
+// StrictResponseCodes makes ParseFirstLine reject status
+// codes outside of the 100-699 range of RFC 3261.
+var StrictResponseCodes = false
+
+// TolerantBlankLines makes DecodeFromBytes skip a blank line inside the
+// headers when the next line looks like another header and not like a body.
+var TolerantBlankLines = false
+
+// sipLongLines counts the messages rejected because of MaxSIPLineLength
+var sipLongLines uint64
+
+// sipBlankLines counts the blank lines skipped because of TolerantBlankLines
+var sipBlankLines uint64
+
+// sipManyHeaders counts the messages with more than MaxSIPHeaders headers
+var sipManyHeaders uint64
+
+// SIPLongLines returns how many SIP messages were rejected because
+// one of their lines was longer than MaxSIPLineLength.
+func SIPLongLines() uint64 {
+	return atomic.LoadUint64(&sipLongLines)
+}
+
+// SIPBlankLines returns how many blank lines inside of SIP headers
+// were skipped because of TolerantBlankLines.
+func SIPBlankLines() uint64 {
+	return atomic.LoadUint64(&sipBlankLines)
+}
+
+// SIPManyHeaders returns how many SIP messages had more
+// than MaxSIPHeaders headers.
+func SIPManyHeaders() uint64 {
+	return atomic.LoadUint64(&sipManyHeaders)
+}
+
 // SIPVersion defines the different versions of the SIP Protocol
 type SIPVersion uint8
 
@@ -170,6 +221,12 @@ var compactSipHeadersCorrespondance map[string]string = map[string]string{
 	"via":                 "v",
 }
 
+// CompactHeaderName returns the compact form of a SIP header
+// name like s for Subject or an empty string.
+func CompactHeaderName(name string) string {
+	return compactSipHeadersCorrespondance[strings.ToLower(name)]
+}
+
 // SIP object will contains information about decoded SIP packet.
 // -> The SIP Version
 // -> The SIP Headers (in a map[string][]string because of multiple headers with the same name
@@ -185,6 +242,13 @@ type SIP struct {
 	Version SIPVersion
 	Headers map[string][]string
 
+	// headerNames keeps the header names in the order
+	// they first appeared on the wire
+	headerNames []string
+
+	// firstLine is the request or status line as seen on the wire
+	firstLine string
+
 	// Request
 	Method SIPMethod
 
@@ -192,6 +256,9 @@ type SIP struct {
 	IsResponse     bool
 	ResponseCode   int
 	ResponseStatus string
+
+	// HeadersTruncated is set when headers after MaxSIPHeaders were dropped
+	HeadersTruncated bool
 }
 
 // decodeSIP decodes the byte slice into a SIP type. It also
@@ -230,48 +297,64 @@ func (s *SIP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	// Init some vars for parsing follow-up
 	var countLines int
 	var line []byte
-	var err error
 
-	// Clean leading new line
-	data = bytes.Trim(data, "\n")
+	// Clean leading new lines, spaces and null padding of keepalives
+	// and trailing new lines
+	data = bytes.TrimLeft(data, "\r\n \x00")
+	data = bytes.TrimRight(data, "\n")
 
 	// Iterate on all lines of the SIP Headers
 	// and stop when we reach the SDP (aka when the new line
 	// is at index 0 of the remaining packet)
-	buffer := bytes.NewBuffer(data)
+	rest := data
 
 	for {
 
-		// Read next line
-		line, err = buffer.ReadBytes(byte('\n'))
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return err
-			}
+		// Find the end of the next line. A line without
+		// new line at the end of the packet is ignored
+		end := bytes.IndexByte(rest, '\n')
+		if end > MaxSIPLineLength || end < 0 && len(rest) > MaxSIPLineLength {
+			atomic.AddUint64(&sipLongLines, 1)
+			return fmt.Errorf("SIP line exceeds %d bytes", MaxSIPLineLength)
+		}
+		if end < 0 {
+			break
 		}
 
 		// Trim the new line delimiters
-		line = bytes.Trim(line, "\r\n")
+		line = bytes.Trim(rest[:end], "\r\n")
+		rest = rest[end+1:]
 
 		// Empty line, we hit Body
 		// Putting packet remain in Paypload
 		if len(line) == 0 {
-			s.BaseLayer.Payload = buffer.Bytes()
+			if TolerantBlankLines && countLines > 0 && !s.isBody(rest) {
+				atomic.AddUint64(&sipBlankLines, 1)
+				continue
+			}
+			s.BaseLayer.Payload = rest
 			break
 		}
 
 		// First line is the SIP request/response line
 		// Other lines are headers
 		if countLines == 0 {
-			err = s.ParseFirstLine(line)
-			if err != nil {
+			if err := s.ParseFirstLine(line); err != nil {
 				return err
 			}
+			s.firstLine = string(line)
 
 		} else {
 
+			if MaxSIPHeaders > 0 && countLines > MaxSIPHeaders {
+				atomic.AddUint64(&sipManyHeaders, 1)
+				if !TruncateHeaders {
+					return fmt.Errorf("SIP message exceeds %d headers", MaxSIPHeaders)
+				}
+				s.HeadersTruncated = true
+				break
+			}
+
 			// Find the ':' to separate header name and value
 			index := bytes.Index(line, []byte(":"))
 			if index >= 0 {
@@ -279,6 +362,9 @@ func (s *SIP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 				headerName := strings.ToLower(string(bytes.Trim(line[:index], " ")))
 				headerValue := string(bytes.Trim(line[index+1:], " "))
 
+				if _, ok := s.Headers[headerName]; !ok {
+					s.headerNames = append(s.headerNames, headerName)
+				}
 				s.Headers[headerName] = append(s.Headers[headerName], headerValue)
 			}
 		}
@@ -289,6 +375,48 @@ func (s *SIP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	return nil
 }
 
+// isBody tells if rest after a blank line is the body. It's not
+// when the next line looks like a header or a folded header line
+// and rest is longer than a Content-Length parsed so far.
+func (s *SIP) isBody(rest []byte) bool {
+	if cl := s.GetFirstHeader("content-length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && len(rest) <= n {
+			return true
+		}
+	}
+	next := rest
+	if end := bytes.IndexByte(rest, '\n'); end >= 0 {
+		next = rest[:end]
+	}
+	// A line starting with white space continues a folded header
+	if len(next) > 0 && (next[0] == ' ' || next[0] == '\t') {
+		return false
+	}
+	return !isHeaderLine(bytes.TrimRight(next, "\r"))
+}
+
+// isHeaderLine tells if line starts with a header name followed by a
+// colon. SDP lines like "a=rtpmap:0 PCMU/8000" have a '=' before it.
+func isHeaderLine(line []byte) bool {
+	index := bytes.IndexByte(line, ':')
+	if index <= 0 {
+		return false
+	}
+	name := bytes.TrimRight(line[:index], " \t")
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case bytes.IndexByte([]byte("-.!%*_+`'~"), c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // ParseFirstLine will compute the first line of a SIP packet.
 // The first line will tell us if it's a request or a response.
 //
@@ -327,6 +455,9 @@ func (s *SIP) ParseFirstLine(firstLine []byte) error {
 		if err != nil {
 			return err
 		}
+		if StrictResponseCodes && s.ResponseClass() == 0 {
+			return fmt.Errorf("invalid SIP status code %d", s.ResponseCode)
+		}
 
 		// Compute status line
 		s.ResponseStatus = splits[2]
@@ -351,23 +482,126 @@ func (s *SIP) ParseFirstLine(firstLine []byte) error {
 	return nil
 }
 
+// ResponseClass will return the class of the status code of the
+// current SIP response from 1 for provisional to 6 for global failure.
+// Codes which aren't listed in RFC 3261 like 199 or 494 keep the class
+// of their first digit. It returns 0 for requests and for codes outside
+// of 100-699.
+func (s *SIP) ResponseClass() int {
+	if !s.IsResponse || s.ResponseCode < 100 || s.ResponseCode > 699 {
+		return 0
+	}
+	return s.ResponseCode / 100
+}
+
 // GetAllHeaders will return the full headers of the
 // current SIP packets in a map[string][]string
 func (s *SIP) GetAllHeaders() map[string][]string {
 	return s.Headers
 }
 
+// GetHeaderNames will return the lowercased header names
+// of the current SIP packet in wire order. Repeated headers
+// are only listed once at the position of their first appearance.
+func (s *SIP) GetHeaderNames() []string {
+	return s.headerNames
+}
+
+// HeadersJSON will return the headers of the current SIP packet
+// as a JSON object. Unlike marshaling the Headers map directly,
+// the keys are written in wire order so the output is stable.
+func (s *SIP) HeadersJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, name := range s.headerNames {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(s.Headers[name])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// sipHeaderNames holds the header names which don't
+// follow the usual Word-Word capitalization
+var sipHeaderNames = map[string]string{
+	"call-id":          "Call-ID",
+	"cseq":             "CSeq",
+	"rack":             "RAck",
+	"rseq":             "RSeq",
+	"sip-etag":         "SIP-ETag",
+	"sip-if-match":     "SIP-If-Match",
+	"www-authenticate": "WWW-Authenticate",
+}
+
+// canonicalHeaderName returns the usual spelling of a lower case header name.
+// Compact forms stay lower case.
+func canonicalHeaderName(name string) string {
+	if len(name) == 1 {
+		return name
+	}
+	if canonical, ok := sipHeaderNames[name]; ok {
+		return canonical
+	}
+	return textproto.CanonicalMIMEHeaderKey(name)
+}
+
+// Minimize rebuilds the SIP message with only the headers in keep
+// and the unchanged body. All headers are kept if keep is empty.
+// Headers keep their wire order. Lines are always terminated with CRLF,
+// no matter which line endings the original message used.
+//
+// 	Minimize([]string{"Call-ID", "CSeq"}) -> INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: a84b4c76e66710\r\nCSeq: 314159 INVITE\r\n\r\n
+//
+func (s *SIP) Minimize(keep []string) []byte {
+	wanted := make(map[string]bool, 2*len(keep))
+	for _, name := range keep {
+		name = strings.ToLower(name)
+		wanted[name] = true
+		if compact, ok := compactSipHeadersCorrespondance[name]; ok {
+			wanted[compact] = true
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(s.firstLine)
+	b.WriteString("\r\n")
+	for _, name := range s.headerNames {
+		if len(keep) > 0 && !wanted[name] {
+			continue
+		}
+		for _, value := range s.Headers[name] {
+			b.WriteString(canonicalHeaderName(name))
+			b.WriteString(": ")
+			b.WriteString(value)
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+	b.Write(s.BaseLayer.Payload)
+	return b.Bytes()
+}
+
 // GetHeader will return all the headers with
 // the specified name.
 func (s *SIP) GetHeader(headerName string) []string {
 	headerName = strings.ToLower(headerName)
 	h := make([]string, 0)
-	if _, ok := s.Headers[headerName]; ok {
-		if len(s.Headers[headerName]) > 0 {
-			return s.Headers[headerName]
-		} else if len(s.Headers[compactSipHeadersCorrespondance[headerName]]) > 0 {
-			return s.Headers[compactSipHeadersCorrespondance[headerName]]
-		}
+	if len(s.Headers[headerName]) > 0 {
+		return s.Headers[headerName]
+	} else if len(s.Headers[compactSipHeadersCorrespondance[headerName]]) > 0 {
+		return s.Headers[compactSipHeadersCorrespondance[headerName]]
 	}
 	return h
 }
@@ -377,12 +611,1040 @@ func (s *SIP) GetHeader(headerName string) []string {
 // headers with the same name, it returns the first.
 func (s *SIP) GetFirstHeader(headerName string) string {
 	headerName = strings.ToLower(headerName)
-	if _, ok := s.Headers[headerName]; ok {
-		if len(s.Headers[headerName]) > 0 {
-			return s.Headers[headerName][0]
-		} else if len(s.Headers[compactSipHeadersCorrespondance[headerName]]) > 0 {
-			return s.Headers[compactSipHeadersCorrespondance[headerName]][0]
+	if len(s.Headers[headerName]) > 0 {
+		return s.Headers[headerName][0]
+	} else if len(s.Headers[compactSipHeadersCorrespondance[headerName]]) > 0 {
+		return s.Headers[compactSipHeadersCorrespondance[headerName]][0]
+	}
+	return ""
+}
+
+// GetSubject will return the Subject header or its
+// compact form s of the current SIP packet.
+func (s *SIP) GetSubject() string {
+	return s.GetFirstHeader("subject")
+}
+
+// GetOrganization will return the Organization header of the
+// current SIP packet which some PBXs use for routing tags.
+func (s *SIP) GetOrganization() string {
+	return s.GetFirstHeader("organization")
+}
+
+// GetPriority will return the Priority header of the current
+// SIP packet in lower case like emergency, urgent, normal or non-urgent.
+func (s *SIP) GetPriority() string {
+	return strings.ToLower(s.GetFirstHeader("priority"))
+}
+
+// GetSupported will return the option tags of the Supported header
+// or its compact form k like timer, 100rel, path or gruu.
+func (s *SIP) GetSupported() []string {
+	return splitHeaderValues(s.GetHeader("supported"))
+}
+
+// GetAllowEvents will return the event packages of the Allow-Events
+// header or its compact form u like presence, dialog or refer.
+func (s *SIP) GetAllowEvents() []string {
+	return splitHeaderValues(s.GetHeader("allow-events"))
+}
+
+// GetAccept will return the media types of the Accept header like
+// application/sdp from the most to the least preferred.
+func (s *SIP) GetAccept() []string {
+	return splitQValues(s.GetHeader("accept"))
+}
+
+// GetAcceptEncoding will return the content codings of the Accept-Encoding
+// header like gzip or identity from the most to the least preferred.
+func (s *SIP) GetAcceptEncoding() []string {
+	return splitQValues(s.GetHeader("accept-encoding"))
+}
+
+// GetAcceptLanguage will return the language tags of the Accept-Language
+// header like en-gb from the most to the least preferred.
+func (s *SIP) GetAcceptLanguage() []string {
+	return splitQValues(s.GetHeader("accept-language"))
+}
+
+// splitQValues will split header values with q-values and return them
+// without the q parameter ordered by their q-value. Values of the same
+// q-value keep their order. Values with q=0 are not acceptable.
+//
+// 	da, en-gb;q=0.8, en;q=0.9, fr;q=0 -> da en en-gb
+//
+func splitQValues(values []string) []string {
+	type qValue struct {
+		value string
+		q     float64
+	}
+	var qs []qValue
+	for _, value := range splitHeaderValues(values) {
+		params := splitUnquoted(value, ';')
+		kept := []string{strings.TrimSpace(params[0])}
+		q := 1.0
+		for _, param := range params[1:] {
+			if i := strings.Index(param, "="); i > 0 && strings.EqualFold(strings.TrimSpace(param[:i]), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(param[i+1:]), 64); err == nil {
+					q = f
+				}
+				continue
+			}
+			kept = append(kept, strings.TrimSpace(param))
+		}
+		if kept[0] == "" || q <= 0 {
+			continue
+		}
+		qs = append(qs, qValue{strings.Join(kept, ";"), q})
+	}
+	sort.SliceStable(qs, func(i, j int) bool { return qs[i].q > qs[j].q })
+
+	h := make([]string, len(qs))
+	for i := range qs {
+		h[i] = qs[i].value
+	}
+	return h
+}
+
+// GetTimestamp will return the time and the optional delay of the
+// Timestamp header of the current SIP packet in seconds. A UAS echoes
+// the time of the request and adds how long it held the request.
+//
+// 	Timestamp: 54.21 0.3 -> 54.21, 0.3, true
+//
+func (s *SIP) GetTimestamp() (float64, float64, bool) {
+	fields := strings.Fields(s.GetFirstHeader("timestamp"))
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, 0, false
+	}
+	t, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || t < 0 {
+		return 0, 0, false
+	}
+	var delay float64
+	if len(fields) == 2 {
+		if delay, err = strconv.ParseFloat(fields[1], 64); err != nil || delay < 0 {
+			return 0, 0, false
+		}
+	}
+	return t, delay, true
+}
+
+// GetCallInfo will return all Call-Info values of the current
+// SIP packet. Comma separated values are returned one by one.
+func (s *SIP) GetCallInfo() []string {
+	return splitHeaderValues(s.GetHeader("call-info"))
+}
+
+// GetAlertInfo will return all Alert-Info values of the current
+// SIP packet. Comma separated values are returned one by one.
+func (s *SIP) GetAlertInfo() []string {
+	return splitHeaderValues(s.GetHeader("alert-info"))
+}
+
+// ParseCallInfo will split a single Call-Info value into its
+// URI without angle brackets and the value of the purpose parameter.
+//
+// 	<http://www.example.com/alice/photo.jpg> ;purpose=icon -> http://www.example.com/alice/photo.jpg, icon
+//
+func ParseCallInfo(value string) (uri string, purpose string) {
+	value = strings.TrimSpace(value)
+	start := strings.Index(value, "<")
+	end := strings.Index(value, ">")
+	if start < 0 || end < start {
+		return "", ""
+	}
+	uri = value[start+1 : end]
+
+	for _, param := range strings.Split(value[end+1:], ";") {
+		index := strings.Index(param, "=")
+		if index < 0 {
+			continue
+		}
+		if strings.ToLower(strings.TrimSpace(param[:index])) == "purpose" {
+			purpose = strings.Trim(strings.TrimSpace(param[index+1:]), `"`)
+		}
+	}
+	return uri, purpose
+}
+
+// SIPDTMF holds a DTMF event carried in the body of a SIP INFO
+// request. Duration is in milliseconds and 0 if it wasn't sent.
+type SIPDTMF struct {
+	CallID   string
+	Signal   string
+	Duration int
+}
+
+// GetDTMF will parse the body of a SIP INFO request with the
+// Content-Type application/dtmf-relay or application/dtmf.
+//
+// 	application/dtmf-relay -> Signal=5\r\nDuration=160\r\n
+// 	application/dtmf       -> 5
+//
+func (s *SIP) GetDTMF() (SIPDTMF, bool) {
+	dtmf := SIPDTMF{CallID: s.GetFirstHeader("call-id")}
+	if s.IsResponse || s.Method != SIPMethodInfo {
+		return dtmf, false
+	}
+
+	body := strings.TrimSpace(string(s.BaseLayer.Payload))
+
+	switch mediaType(s.GetFirstHeader("content-type")) {
+	case "application/dtmf-relay":
+		for _, line := range strings.Split(body, "\n") {
+			index := strings.Index(line, "=")
+			if index < 0 {
+				continue
+			}
+			value := strings.TrimSpace(line[index+1:])
+			switch strings.ToLower(strings.TrimSpace(line[:index])) {
+			case "signal":
+				dtmf.Signal = value
+			case "duration":
+				dtmf.Duration, _ = strconv.Atoi(value)
+			}
+		}
+	case "application/dtmf":
+		dtmf.Signal = body
+	default:
+		return dtmf, false
+	}
+	return dtmf, dtmf.Signal != ""
+}
+
+// SIPInstantMessage holds the content of a SIP MESSAGE request.
+// From and To are only set for message/cpim bodies and hold the
+// addresses of the CPIM envelope.
+type SIPInstantMessage struct {
+	CallID      string
+	ContentType string
+	From        string
+	To          string
+	Text        string
+}
+
+// GetInstantMessage will return the text of a SIP MESSAGE request
+// with the Content-Type text/plain or message/cpim.
+func (s *SIP) GetInstantMessage() (SIPInstantMessage, bool) {
+	msg := SIPInstantMessage{CallID: s.GetFirstHeader("call-id")}
+	if s.IsResponse || s.Method != SIPMethodMessage {
+		return msg, false
+	}
+
+	msg.ContentType = mediaType(s.GetFirstHeader("content-type"))
+	body := strings.Replace(string(s.BaseLayer.Payload), "\r\n", "\n", -1)
+
+	switch msg.ContentType {
+	case "text/plain":
+		msg.Text = body
+	case "message/cpim":
+		// CPIM headers, blank line, MIME headers, blank line, content
+		parts := strings.SplitN(body, "\n\n", 3)
+		if len(parts) < 3 {
+			return msg, false
+		}
+		for _, line := range strings.Split(parts[0], "\n") {
+			index := strings.Index(line, ":")
+			if index < 0 {
+				continue
+			}
+			value := strings.TrimSpace(line[index+1:])
+			switch strings.ToLower(strings.TrimSpace(line[:index])) {
+			case "from":
+				msg.From = value
+			case "to":
+				msg.To = value
+			}
+		}
+		for _, line := range strings.Split(parts[1], "\n") {
+			if index := strings.Index(line, ":"); index >= 0 && strings.EqualFold(strings.TrimSpace(line[:index]), "content-type") {
+				msg.ContentType = mediaType(line[index+1:])
+			}
+		}
+		msg.Text = parts[2]
+	default:
+		return msg, false
+	}
+	return msg, true
+}
+
+// mediaType returns the lower case media type of a Content-Type value without parameters.
+func mediaType(contentType string) string {
+	if index := strings.Index(contentType, ";"); index >= 0 {
+		contentType = contentType[:index]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// ParseContentDisposition will return the lower case disposition type
+// and handling parameter of a Content-Disposition value.
+//
+// 	session;handling=required -> session, required
+//
+func ParseContentDisposition(value string) (disposition, handling string) {
+	params := splitUnquoted(value, ';')
+	disposition = strings.ToLower(strings.TrimSpace(params[0]))
+	for _, param := range params[1:] {
+		if i := strings.Index(param, "="); i > 0 && strings.EqualFold(strings.TrimSpace(param[:i]), "handling") {
+			handling = strings.ToLower(strings.Trim(strings.TrimSpace(param[i+1:]), `"`))
+		}
+	}
+	return disposition, handling
+}
+
+// GetContentDisposition will return the disposition type and handling
+// parameter of the Content-Disposition header of the current SIP packet.
+func (s *SIP) GetContentDisposition() (disposition, handling string) {
+	return ParseContentDisposition(s.GetFirstHeader("content-disposition"))
+}
+
+// SIPBodyPart is one part of a SIP body. ContentType is the lower case
+// media type without parameters. Disposition tells how to treat the
+// part like session, early-session, render, signal or icon.
+type SIPBodyPart struct {
+	ContentType string
+	Disposition string
+	Handling    string
+	ContentID   string
+	Body        []byte
+}
+
+// GetBodyParts will return the parts of a multipart body or
+// the body as single part.
+func (s *SIP) GetBodyParts() []SIPBodyPart {
+	return SplitBody(s.GetFirstHeader("content-type"), s.GetFirstHeader("content-disposition"), s.BaseLayer.Payload)
+}
+
+// SplitBody will split a multipart body by the boundary of its Content-Type.
+// Nested multiparts are split too. Other bodies are returned as single part.
+// Parts without Content-Disposition get the default of RFC 3261, which is
+// session for SDP and render else.
+func SplitBody(contentType, disposition string, body []byte) []SIPBodyPart {
+	if len(body) == 0 {
+		return nil
+	}
+	typ := mediaType(contentType)
+	if !strings.HasPrefix(typ, "multipart/") {
+		part := SIPBodyPart{ContentType: typ, Body: body}
+		part.Disposition, part.Handling = ParseContentDisposition(disposition)
+		if part.Disposition == "" {
+			part.Disposition = defaultDisposition(typ)
+		}
+		return []SIPBodyPart{part}
+	}
+
+	boundary := multipartBoundary(contentType)
+	if boundary == "" {
+		return nil
+	}
+	delimiter := []byte("--" + boundary)
+	var parts []SIPBodyPart
+	start := bytes.Index(body, delimiter)
+	for start >= 0 {
+		rest := body[start+len(delimiter):]
+		if bytes.HasPrefix(rest, []byte("--")) {
+			// Close delimiter
+			break
+		}
+		if end := bytes.IndexByte(rest, '\n'); end >= 0 {
+			rest = rest[end+1:]
+		} else {
+			break
+		}
+		next := bytes.Index(rest, append([]byte("\n"), delimiter...))
+		content := rest
+		if next >= 0 {
+			content = rest[:next]
+			start = start + len(body[start:]) - len(rest) + next + 1
+		} else {
+			start = -1
+		}
+		// The CRLF in front of the delimiter belongs to it
+		content = bytes.TrimSuffix(content, []byte("\r"))
+		parts = append(parts, splitPart(content)...)
+	}
+	return parts
+}
+
+// splitPart parses the headers of one part of a multipart body.
+func splitPart(content []byte) []SIPBodyPart {
+	var contentType, disposition, contentID string
+	for len(content) > 0 {
+		end := bytes.IndexByte(content, '\n')
+		if end < 0 {
+			end = len(content)
+		}
+		line := string(bytes.TrimRight(content[:end], "\r"))
+		if end == len(content) {
+			content = nil
+		} else {
+			content = content[end+1:]
+		}
+		if line == "" {
+			break
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			value := strings.TrimSpace(line[i+1:])
+			switch strings.ToLower(strings.TrimSpace(line[:i])) {
+			case "content-type", "c":
+				contentType = value
+			case "content-disposition":
+				disposition = value
+			case "content-id":
+				contentID = strings.Trim(value, "<>")
+			}
+		}
+	}
+	parts := SplitBody(contentType, disposition, content)
+	for i := range parts {
+		if parts[i].ContentID == "" {
+			parts[i].ContentID = contentID
+		}
+	}
+	return parts
+}
+
+// multipartBoundary returns the boundary parameter of a Content-Type value.
+func multipartBoundary(contentType string) string {
+	for _, param := range splitUnquoted(contentType, ';')[1:] {
+		if i := strings.Index(param, "="); i > 0 && strings.EqualFold(strings.TrimSpace(param[:i]), "boundary") {
+			return strings.Trim(strings.TrimSpace(param[i+1:]), `"`)
+		}
+	}
+	return ""
+}
+
+func defaultDisposition(contentType string) string {
+	if contentType == "application/sdp" {
+		return "session"
+	}
+	return "render"
+}
+
+// GetCSeq will return the sequence number and method of the CSeq header.
+// Responses carry the method of the request they answer only here.
+//
+// 	314159 INVITE -> 314159, INVITE
+//
+func (s *SIP) GetCSeq() (seq int, method string) {
+	fields := strings.Fields(s.GetFirstHeader("cseq"))
+	if len(fields) != 2 {
+		return 0, ""
+	}
+	seq, _ = strconv.Atoi(fields[0])
+	return seq, strings.ToUpper(fields[1])
+}
+
+// GetRSeq will return the RSeq header of a reliable provisional
+// response of RFC 3262. RSeq numbers range from 1 to 2**31-1.
+//
+// 	RSeq: 988789 -> 988789, true
+//
+func (s *SIP) GetRSeq() (uint32, bool) {
+	return parseRSeq(strings.TrimSpace(s.GetFirstHeader("rseq")))
+}
+
+// GetRAck will return the RSeq, CSeq number and method of the RAck header
+// of a PRACK. They name the reliable provisional response it acknowledges.
+//
+// 	RAck: 776656 1 INVITE -> 776656, 1, INVITE, true
+//
+func (s *SIP) GetRAck() (rseq uint32, cseq uint32, method string, ok bool) {
+	fields := strings.Fields(s.GetFirstHeader("rack"))
+	if len(fields) != 3 {
+		return 0, 0, "", false
+	}
+	if rseq, ok = parseRSeq(fields[0]); !ok {
+		return 0, 0, "", false
+	}
+	n, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	return rseq, uint32(n), strings.ToUpper(fields[2]), true
+}
+
+func parseRSeq(s string) (uint32, bool) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 || n > 1<<31-1 {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+// GetICID will return the icid-value parameter of the
+// P-Charging-Vector header or an empty string.
+//
+// 	icid-value=1234bc9876e;icid-generated-at=192.0.6.8;orig-ioi=home1.net -> 1234bc9876e
+//
+func (s *SIP) GetICID() string {
+	for _, param := range splitUnquoted(s.GetFirstHeader("p-charging-vector"), ';') {
+		index := strings.Index(param, "=")
+		if index >= 0 && strings.EqualFold(strings.TrimSpace(param[:index]), "icid-value") {
+			return strings.Trim(strings.TrimSpace(param[index+1:]), `"`)
+		}
+	}
+	return ""
+}
+
+// GetPCFA will return the charging collection function (ccf) and event
+// charging function (ecf) addresses of the P-Charging-Function-Addresses header.
+//
+// 	ccf=192.1.1.1; ccf=192.1.1.2; ecf=192.1.1.3 -> [192.1.1.1 192.1.1.2], [192.1.1.3]
+//
+func (s *SIP) GetPCFA() (ccf []string, ecf []string) {
+	for _, value := range s.GetHeader("p-charging-function-addresses") {
+		for _, param := range splitUnquoted(value, ';') {
+			index := strings.Index(param, "=")
+			if index < 0 {
+				continue
+			}
+			addr := strings.Trim(strings.TrimSpace(param[index+1:]), `"`)
+			switch strings.ToLower(strings.TrimSpace(param[:index])) {
+			case "ccf":
+				ccf = append(ccf, addr)
+			case "ecf":
+				ecf = append(ecf, addr)
+			}
+		}
+	}
+	return ccf, ecf
+}
+
+// GetPVNI will return the visited network of the first
+// P-Visited-Network-ID value without quotes and parameters.
+//
+// 	"Visited network number 1";param=1 -> Visited network number 1
+//
+func (s *SIP) GetPVNI() string {
+	values := splitHeaderValues(s.GetHeader("p-visited-network-id"))
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(splitUnquoted(values[0], ';')[0]), `"`)
+}
+
+// GetPANI will return the access type and the lower cased parameters of the
+// P-Access-Network-Info header. The value added by the network is preferred
+// over the one of the UE. Parameters without a value like network-provided
+// map to an empty string.
+//
+// 	3GPP-E-UTRAN-FDD; utran-cell-id-3gpp=234151D0FCE11; network-provided
+// 	-> 3GPP-E-UTRAN-FDD, map[network-provided: utran-cell-id-3gpp:234151D0FCE11]
+//
+func (s *SIP) GetPANI() (accessType string, params map[string]string) {
+	for _, value := range splitHeaderValues(s.GetHeader("p-access-network-info")) {
+		parts := splitUnquoted(value, ';')
+		p := make(map[string]string, len(parts)-1)
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if param == "" {
+				continue
+			}
+			if index := strings.Index(param, "="); index >= 0 {
+				p[strings.ToLower(strings.TrimSpace(param[:index]))] = strings.Trim(strings.TrimSpace(param[index+1:]), `"`)
+			} else {
+				p[strings.ToLower(param)] = ""
+			}
+		}
+		_, networkProvided := p["network-provided"]
+		if params == nil || networkProvided {
+			accessType, params = strings.TrimSpace(parts[0]), p
+		}
+		if networkProvided {
+			break
+		}
+	}
+	return accessType, params
+}
+
+// SIPWarning holds one warning value of a Warning header. Codes 300
+// to 399 tell why the session description wasn't acceptable.
+type SIPWarning struct {
+	Code  int
+	Agent string
+	Text  string
+}
+
+// GetWarnings will return the values of all Warning headers with
+// the quotes and escapes of the text removed.
+//
+// 	370 device "Insufficient bandwidth" -> 370, device, Insufficient bandwidth
+//
+func (s *SIP) GetWarnings() []SIPWarning {
+	var warnings []SIPWarning
+	for _, value := range splitHeaderValues(s.GetHeader("warning")) {
+		fields := strings.SplitN(value, " ", 3)
+		if len(fields) < 3 || len(fields[0]) != 3 {
+			continue
+		}
+		code, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		warnings = append(warnings, SIPWarning{
+			Code:  code,
+			Agent: fields[1],
+			Text:  unquoteWarnText(strings.TrimSpace(fields[2])),
+		})
+	}
+	return warnings
+}
+
+// unquoteWarnText will remove the quotes and backslash escapes of a quoted-string.
+func unquoteWarnText(text string) string {
+	if len(text) < 2 || text[0] != '"' || text[len(text)-1] != '"' {
+		return text
+	}
+	text = text[1 : len(text)-1]
+	if strings.IndexByte(text, '\\') < 0 {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			i++
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
+
+// SIPEvent holds the key fields of a NOTIFY or PUBLISH body of a
+// recognized event package. For message-summary the voice message
+// counts are set, for dialog the dialog state and for presence the
+// basic status. Entity is the resource the dialog or presence
+// document is about.
+type SIPEvent struct {
+	CallID          string
+	Package         string
+	MessagesWaiting bool
+	NewMessages     int
+	OldMessages     int
+	Entity          string
+	State           string
+}
+
+// GetEvent will return the event package of the Event header
+// or its compact form o without parameters in lower case.
+//
+// 	dialog;id=453 -> dialog
+//
+func (s *SIP) GetEvent() string {
+	return mediaType(s.GetFirstHeader("event"))
+}
+
+// GetEventBody will parse the body of a NOTIFY or PUBLISH request for the
+// message-summary, dialog and presence event packages. The bodies of other
+// event packages are left alone and false is returned.
+func (s *SIP) GetEventBody() (SIPEvent, bool) {
+	event := SIPEvent{CallID: s.GetFirstHeader("call-id"), Package: s.GetEvent()}
+	if s.IsResponse || (s.Method != SIPMethodNotify && s.Method != SIPMethodPublish) || len(s.BaseLayer.Payload) == 0 {
+		return event, false
+	}
+
+	switch event.Package {
+	case "message-summary":
+		// Messages-Waiting: yes
+		// Voice-Message: 2/8 (0/2)
+		for _, line := range strings.Split(string(s.BaseLayer.Payload), "\n") {
+			index := strings.Index(line, ":")
+			if index < 0 {
+				continue
+			}
+			value := strings.TrimSpace(line[index+1:])
+			switch strings.ToLower(strings.TrimSpace(line[:index])) {
+			case "messages-waiting":
+				event.MessagesWaiting = strings.EqualFold(value, "yes")
+			case "voice-message":
+				fmt.Sscanf(value, "%d/%d", &event.NewMessages, &event.OldMessages)
+			}
+		}
+	case "dialog":
+		var info struct {
+			Entity string `xml:"entity,attr"`
+			Dialog []struct {
+				State string `xml:"state"`
+			} `xml:"dialog"`
+		}
+		if err := xml.Unmarshal(s.BaseLayer.Payload, &info); err != nil {
+			return event, false
+		}
+		event.Entity = info.Entity
+		if len(info.Dialog) > 0 {
+			event.State = strings.TrimSpace(info.Dialog[0].State)
+		}
+	case "presence":
+		var presence struct {
+			Entity string `xml:"entity,attr"`
+			Tuple  []struct {
+				Basic string `xml:"status>basic"`
+			} `xml:"tuple"`
+		}
+		if err := xml.Unmarshal(s.BaseLayer.Payload, &presence); err != nil {
+			return event, false
+		}
+		event.Entity = presence.Entity
+		if len(presence.Tuple) > 0 {
+			event.State = strings.TrimSpace(presence.Tuple[0].Basic)
+		}
+	default:
+		return event, false
+	}
+	return event, true
+}
+
+// GetGeolocation will return all Geolocation values of the current SIP
+// packet. Comma separated values are returned one by one.
+//
+// 	<cid:target123@example.com>, <https://lis.example.com/8a3f> -> <cid:target123@example.com> <https://lis.example.com/8a3f>
+//
+func (s *SIP) GetGeolocation() []string {
+	return splitHeaderValues(s.GetHeader("geolocation"))
+}
+
+// SIPLocation holds the first civic and geodetic location of a PIDF-LO body
+// (RFC 4119, RFC 5491).
+// Civic holds the civicAddress elements like country, A1, A3, RD, HNO or PC.
+// Shape is the GML shape like Point or Circle with the latitude and longitude
+// of its first position and the radius in meters for circles.
+type SIPLocation struct {
+	CallID    string
+	Entity    string
+	Method    string
+	Civic     map[string]string
+	Shape     string
+	Latitude  float64
+	Longitude float64
+	Radius    float64
+}
+
+// gmlShapes are the GML shapes of the PIDF-LO geodetic location profile
+var gmlShapes = map[string]bool{
+	"Point":     true,
+	"Polygon":   true,
+	"Circle":    true,
+	"Ellipse":   true,
+	"ArcBand":   true,
+	"Sphere":    true,
+	"Ellipsoid": true,
+	"Prism":     true,
+}
+
+// GetLocation will parse the PIDF-LO body part the Geolocation header refers
+// to by its cid URI or else the first application/pidf+xml part. It returns
+// false if there is none or it holds neither a civic nor a geodetic location.
+func (s *SIP) GetLocation() (SIPLocation, bool) {
+	loc := SIPLocation{CallID: s.GetFirstHeader("call-id")}
+	var pidf []byte
+	cids := make(map[string]bool)
+	for _, value := range s.GetGeolocation() {
+		if uri, _ := ParseCallInfo(value); strings.HasPrefix(strings.ToLower(uri), "cid:") {
+			cids[uri[4:]] = true
+		}
+	}
+	for _, part := range s.GetBodyParts() {
+		if part.ContentType != "application/pidf+xml" {
+			continue
+		}
+		if cids[part.ContentID] {
+			pidf = part.Body
+			break
+		}
+		if pidf == nil {
+			pidf = part.Body
+		}
+	}
+	if pidf == nil {
+		return loc, false
+	}
+	return loc, ParsePIDFLO(pidf, &loc)
+}
+
+// ParsePIDFLO will fill loc with the entity, method and first civic and
+// geodetic location of a PIDF-LO document. Namespace prefixes are ignored.
+func ParsePIDFLO(data []byte, loc *SIPLocation) bool {
+	var (
+		text  []byte
+		civic bool
+		pos   bool
+	)
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			switch {
+			case name == "presence":
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "entity" {
+						loc.Entity = attr.Value
+					}
+				}
+			case name == "civicAddress" && loc.Civic == nil:
+				loc.Civic = make(map[string]string)
+				civic = true
+			case gmlShapes[name] && loc.Shape == "":
+				loc.Shape = name
+			}
+			text = text[:0]
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.EndElement:
+			name := t.Name.Local
+			value := strings.TrimSpace(string(text))
+			text = text[:0]
+			switch {
+			case name == "civicAddress":
+				civic = false
+			case civic && value != "":
+				loc.Civic[name] = value
+			case name == "method" && loc.Method == "":
+				loc.Method = value
+			case name == "pos" && loc.Shape != "" && !pos:
+				fields := strings.Fields(value)
+				if len(fields) >= 2 {
+					loc.Latitude, _ = strconv.ParseFloat(fields[0], 64)
+					loc.Longitude, _ = strconv.ParseFloat(fields[1], 64)
+					pos = true
+				}
+			case name == "radius" && loc.Shape == "Circle":
+				loc.Radius, _ = strconv.ParseFloat(value, 64)
+			}
+		}
+	}
+	return len(loc.Civic) > 0 || pos
+}
+
+// SIPContact holds the parsed parts of a single Contact value.
+// Instance and RegID are the RFC 5626 +sip.instance and reg-id
+// parameters, Transport is the transport URI parameter in upper case.
+type SIPContact struct {
+	DisplayName string
+	URI         string
+	Transport   string
+	Instance    string
+	RegID       string
+	Params      map[string]string
+}
+
+// GetContacts will return all Contact values of the current SIP packet
+// and of its compact form m parsed into SIPContact objects.
+func (s *SIP) GetContacts() []SIPContact {
+	headers := append(append([]string{}, s.Headers["contact"]...), s.Headers[CompactHeaderName("contact")]...)
+	values := splitHeaderValues(headers)
+	contacts := make([]SIPContact, 0, len(values))
+	for _, value := range values {
+		contacts = append(contacts, ParseContact(value))
+	}
+	return contacts
+}
+
+// ParseContact will parse a single Contact value like
+//
+// 	"Alice" <sip:alice@192.0.2.1;transport=tcp>;+sip.instance="<urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6>";reg-id=1
+//
+func ParseContact(value string) SIPContact {
+	c := SIPContact{Params: make(map[string]string)}
+	value = strings.TrimSpace(value)
+
+	var rest string
+	if start := indexUnquoted(value, '<'); start >= 0 {
+		end := strings.Index(value[start:], ">")
+		if end < 0 {
+			return c
+		}
+		c.DisplayName = strings.Trim(strings.TrimSpace(value[:start]), `"`)
+		c.URI = value[start+1 : start+end]
+		rest = value[start+end+1:]
+	} else if index := strings.Index(value, ";"); index >= 0 {
+		// Without angle brackets all parameters are header parameters
+		c.URI = value[:index]
+		rest = value[index:]
+	} else {
+		c.URI = value
+	}
+
+	for _, param := range strings.Split(c.URI, ";")[1:] {
+		if index := strings.Index(param, "="); index >= 0 && strings.EqualFold(strings.TrimSpace(param[:index]), "transport") {
+			c.Transport = strings.ToUpper(strings.TrimSpace(param[index+1:]))
+		}
+	}
+
+	for _, param := range splitUnquoted(rest, ';') {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		name, val := param, ""
+		if index := strings.Index(param, "="); index >= 0 {
+			name, val = strings.TrimSpace(param[:index]), strings.TrimSpace(param[index+1:])
+		}
+		name = strings.ToLower(name)
+		val = strings.Trim(val, `"`)
+		c.Params[name] = val
+
+		switch name {
+		case "+sip.instance":
+			c.Instance = strings.TrimSuffix(strings.TrimPrefix(val, "<"), ">")
+		case "reg-id":
+			c.RegID = val
+		}
+	}
+	return c
+}
+
+// ParseTag will return the tag parameter of a From or To value.
+//
+// 	"Bob" <sip:bob@biloxi.com>;tag=a6c85cf -> a6c85cf
+//
+func ParseTag(value string) string {
+	return ParseContact(value).Params["tag"]
+}
+
+// GetFromTag will return the tag of the From header of the current SIP packet.
+func (s *SIP) GetFromTag() string {
+	return ParseTag(s.GetFirstHeader("from"))
+}
+
+// GetToTag will return the tag of the To header of the current SIP packet.
+func (s *SIP) GetToTag() string {
+	return ParseTag(s.GetFirstHeader("to"))
+}
+
+// IsInDialog will tell if the current SIP request is sent inside of
+// an existing dialog. Dialog creating requests like an initial INVITE
+// have no To tag yet.
+func (s *SIP) IsInDialog() bool {
+	return s.GetToTag() != ""
+}
+
+// indexUnquoted returns the index of the first c which is not inside quotes or -1.
+func indexUnquoted(s string, c byte) int {
+	var quoted bool
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' {
+			quoted = !quoted
+		} else if s[i] == c && !quoted {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitUnquoted splits s on every sep which is not inside quotes.
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	for {
+		index := indexUnquoted(s, sep)
+		if index < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:index])
+		s = s[index+1:]
+	}
+}
+
+// splitHeaderValues will split header values on commas which
+// are not inside of angle brackets or quotes.
+func splitHeaderValues(values []string) []string {
+	h := make([]string, 0, len(values))
+	for _, value := range values {
+		var quoted, bracketed bool
+		start := 0
+		for i := 0; i < len(value); i++ {
+			switch value[i] {
+			case '"':
+				quoted = !quoted
+			case '\\':
+				// Skip escaped quotes in quoted-strings
+				if quoted {
+					i++
+				}
+			case '<':
+				if !quoted {
+					bracketed = true
+				}
+			case '>':
+				if !quoted {
+					bracketed = false
+				}
+			case ',':
+				if !quoted && !bracketed {
+					if v := strings.TrimSpace(value[start:i]); v != "" {
+						h = append(h, v)
+					}
+					start = i + 1
+				}
+			}
+		}
+		if v := strings.TrimSpace(value[start:]); v != "" {
+			h = append(h, v)
+		}
+	}
+	return h
+}
+
+// MagicCookie starts every Via branch of RFC 3261 compliant elements
+const MagicCookie = "z9hG4bK"
+
+// ParseViaBranch will return the branch parameter of the first
+// value of a Via header.
+//
+// 	SIP/2.0/UDP 192.0.2.4;branch=z9hG4bKnashds8;rport -> z9hG4bKnashds8
+//
+func ParseViaBranch(value string) string {
+	value = splitUnquoted(value, ',')[0]
+	for _, param := range splitUnquoted(value, ';')[1:] {
+		param = strings.TrimSpace(param)
+		if i := strings.Index(param, "="); i > 0 && strings.EqualFold(strings.TrimSpace(param[:i]), "branch") {
+			return strings.TrimSpace(param[i+1:])
 		}
 	}
 	return ""
 }
+
+// GetViaBranch will return the branch of the topmost Via header.
+func (s *SIP) GetViaBranch() string {
+	return ParseViaBranch(s.GetFirstHeader("via"))
+}
+
+// HasMagicCookie will tell if the topmost Via branch starts with the
+// RFC 3261 magic cookie. Branches of RFC 2543 devices and spoofed
+// messages often lack it.
+func (s *SIP) HasMagicCookie() bool {
+	return strings.HasPrefix(s.GetViaBranch(), MagicCookie)
+}
+
+// GetViaTransport will return the transport of the topmost
+// Via header in upper case, like UDP, TCP, TLS or WS.
+// This is the transport as seen by the UA which may differ
+// from the captured one when there is a proxy in between.
+//
+// 	Via: SIP/2.0/TLS 192.0.2.4:5061;branch=z9hG4bKnashds8 -> TLS
+//
+func (s *SIP) GetViaTransport() string {
+	via := s.GetFirstHeader("via")
+
+	// A single Via header can carry several comma separated values
+	if index := strings.Index(via, ","); index >= 0 {
+		via = via[:index]
+	}
+
+	// sent-protocol is protocol-name SLASH protocol-version SLASH transport
+	splits := strings.SplitN(via, "/", 3)
+	if len(splits) < 3 {
+		return ""
+	}
+
+	transport := strings.TrimLeft(splits[2], " \t")
+	if index := strings.IndexAny(transport, " \t"); index >= 0 {
+		transport = transport[:index]
+	}
+	return strings.ToUpper(transport)
+}
@@ -0,0 +1,593 @@
+package ownlayers
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var sipInvite = []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776asdhds\r\n" +
+	"Via: SIP/2.0/TCP 10.0.0.2:5060;branch=z9hG4bK776asdhdt\r\n" +
+	"To: Bob <sip:bob@example.com>\r\n" +
+	"From: Alice <sip:alice@example.com>;tag=1928301774\r\n" +
+	"Call-ID: a84b4c76e66710@pc33.example.com\r\n" +
+	"CSeq: 314159 INVITE\r\n" +
+	"Contact: <sip:alice@10.0.0.1:5060>\r\n" +
+	"Content-Type: application/sdp\r\n" +
+	"Content-Length: 4\r\n" +
+	"\r\n" +
+	"v=0\r\n")
+
+func decodeTestSIP(t *testing.T, data []byte) *SIP {
+	s := NewSIP()
+	if err := s.DecodeFromBytes(data, nil); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSIPMaxHeaders(t *testing.T) {
+	defer func() { TruncateHeaders = false }()
+	var b bytes.Buffer
+	b.WriteString("INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: many@a\r\n")
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&b, "X-Header-%d: %d\r\n", i, i)
+	}
+	b.WriteString("\r\nv=0\r\n")
+
+	before := SIPManyHeaders()
+	if err := NewSIP().DecodeFromBytes(b.Bytes(), nil); err == nil {
+		t.Error("50000 headers should fail")
+	}
+
+	TruncateHeaders = true
+	s := decodeTestSIP(t, b.Bytes())
+	if !s.HeadersTruncated || len(s.Headers) != MaxSIPHeaders || s.GetFirstHeader("call-id") != "many@a" {
+		t.Errorf("truncated = %v with %d headers", s.HeadersTruncated, len(s.Headers))
+	}
+	if got := SIPManyHeaders() - before; got != 2 {
+		t.Errorf("SIPManyHeaders() counted %d, want 2", got)
+	}
+
+	s = decodeTestSIP(t, sipInvite)
+	if s.HeadersTruncated {
+		t.Error("short message should not be truncated")
+	}
+}
+
+func TestSIPTolerantBlankLines(t *testing.T) {
+	defer func() { TolerantBlankLines = false }()
+	tests := []struct {
+		name     string
+		data     string
+		tolerant bool
+		callID   string
+		body     string
+	}{
+		{"blank line inside headers", "INVITE sip:bob@b SIP/2.0\r\nVia: SIP/2.0/UDP a;branch=z9hG4bK1\r\n\r\nCall-ID: 1@a\r\nContent-Length: 5\r\n\r\nv=0\r\n", true, "1@a", "v=0\r"},
+		{"blank line inside headers not tolerated", "INVITE sip:bob@b SIP/2.0\r\nVia: SIP/2.0/UDP a;branch=z9hG4bK1\r\n\r\nCall-ID: 1@a\r\n\r\n", false, "", "Call-ID: 1@a\r\n\r"},
+		{"folded header with blank line", "BYE sip:bob@b SIP/2.0\r\nSubject: lunch\r\n\r\n \tand more\r\nCall-ID: 2@a\r\n\r\n", true, "2@a", ""},
+		{"SDP body", "SIP/2.0 200 OK\r\nCall-ID: 3@a\r\n\r\nv=0\r\no=- 1 1 IN IP4 a\r\n", true, "3@a", "v=0\r\no=- 1 1 IN IP4 a\r"},
+		{"header like body of Content-Length", "NOTIFY sip:bob@b SIP/2.0\r\nCall-ID: 4@a\r\nContent-Length: 20\r\n\r\nSIP-ETag: 1234567\r\n", true, "4@a", "SIP-ETag: 1234567\r"},
+		{"several blank lines before body", "MESSAGE sip:bob@b SIP/2.0\r\nCall-ID: 5@a\r\n\r\n\r\nhello", true, "5@a", "\r\nhello"},
+	}
+
+	for _, tt := range tests {
+		TolerantBlankLines = tt.tolerant
+		s := decodeTestSIP(t, []byte(tt.data))
+		if got := s.GetFirstHeader("call-id"); got != tt.callID {
+			t.Errorf("%s: Call-ID = %q, want %q", tt.name, got, tt.callID)
+		}
+		if got := string(s.Payload()); got != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.name, got, tt.body)
+		}
+	}
+}
+
+func TestSIPHeadersJSON(t *testing.T) {
+	s := decodeTestSIP(t, sipInvite)
+
+	want := `{"via":["SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776asdhds","SIP/2.0/TCP 10.0.0.2:5060;branch=z9hG4bK776asdhdt"],` +
+		`"to":["Bob \u003csip:bob@example.com\u003e"],` +
+		`"from":["Alice \u003csip:alice@example.com\u003e;tag=1928301774"],` +
+		`"call-id":["a84b4c76e66710@pc33.example.com"],` +
+		`"cseq":["314159 INVITE"],` +
+		`"contact":["\u003csip:alice@10.0.0.1:5060\u003e"],` +
+		`"content-type":["application/sdp"],` +
+		`"content-length":["4"]}`
+
+	for i := 0; i < 10; i++ {
+		got, err := s.HeadersJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("HeadersJSON() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestSIPGetViaTransport(t *testing.T) {
+	tests := []struct {
+		via  string
+		want string
+	}{
+		{"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776asdhds", "UDP"},
+		{"Via: SIP/2.0/tls 10.0.0.1:5061;branch=z9hG4bK776asdhds", "TLS"},
+		{"Via: SIP / 2.0 / WS df7jal23ls0d.invalid;branch=z9hG4bKasudf", "WS"},
+		{"v: SIP/2.0/TCP 10.0.0.1;branch=z9hG4bK1, SIP/2.0/UDP 10.0.0.2;branch=z9hG4bK2", "TCP"},
+		{"Via: garbage", ""},
+	}
+
+	for _, tt := range tests {
+		s := decodeTestSIP(t, []byte("OPTIONS sip:bob@example.com SIP/2.0\r\n"+tt.via+"\r\n\r\n"))
+		if got := s.GetViaTransport(); got != tt.want {
+			t.Errorf("GetViaTransport() for %q = %q, want %q", tt.via, got, tt.want)
+		}
+	}
+}
+
+func TestSIPGetViaBranch(t *testing.T) {
+	tests := []struct {
+		via    string
+		branch string
+		cookie bool
+	}{
+		{"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776asdhds;rport", "z9hG4bK776asdhds", true},
+		{"v: SIP/2.0/TCP 10.0.0.1 ; BRANCH = z9hG4bK1, SIP/2.0/UDP 10.0.0.2;branch=z9hG4bK2", "z9hG4bK1", true},
+		{"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=776asdhds", "776asdhds", false},
+		{"Via: SIP/2.0/UDP 10.0.0.1:5060;received=10.0.0.9", "", false},
+	}
+
+	for _, tt := range tests {
+		s := decodeTestSIP(t, []byte("OPTIONS sip:bob@example.com SIP/2.0\r\n"+tt.via+"\r\n\r\n"))
+		if got := s.GetViaBranch(); got != tt.branch {
+			t.Errorf("GetViaBranch() for %q = %q, want %q", tt.via, got, tt.branch)
+		}
+		if got := s.HasMagicCookie(); got != tt.cookie {
+			t.Errorf("HasMagicCookie() for %q = %v, want %v", tt.via, got, tt.cookie)
+		}
+	}
+}
+
+func TestSIPGetCallInfo(t *testing.T) {
+	s := decodeTestSIP(t, []byte("INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"Call-Info: <http://www.example.com/alice/photo.jpg> ;purpose=icon, <http://www.example.com/alice/> ;purpose=info\r\n"+
+		"Alert-Info: <http://www.example.com/sounds/moo.wav>\r\n"+
+		"Alert-Info: <urn:alert:service:call-waiting>\r\n\r\n"))
+
+	callInfo := s.GetCallInfo()
+	if len(callInfo) != 2 {
+		t.Fatalf("GetCallInfo() = %q", callInfo)
+	}
+	uri, purpose := ParseCallInfo(callInfo[1])
+	if uri != "http://www.example.com/alice/" || purpose != "info" {
+		t.Errorf("ParseCallInfo(%q) = %q, %q", callInfo[1], uri, purpose)
+	}
+
+	alertInfo := s.GetAlertInfo()
+	if len(alertInfo) != 2 || alertInfo[1] != "<urn:alert:service:call-waiting>" {
+		t.Errorf("GetAlertInfo() = %q", alertInfo)
+	}
+}
+
+func TestSIPGetContacts(t *testing.T) {
+	s := decodeTestSIP(t, []byte("REGISTER sip:example.com SIP/2.0\r\n"+
+		`Contact: "Alice, Home" <sip:alice@192.0.2.1;transport=tcp;ob>;+sip.instance="<urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6>";reg-id=1;expires=3600`+"\r\n"+
+		"m: sip:alice@192.0.2.2;expires=0\r\n\r\n"))
+
+	contacts := s.GetContacts()
+	if len(contacts) != 2 || contacts[1].URI != "sip:alice@192.0.2.2" || contacts[1].Params["expires"] != "0" {
+		t.Fatalf("GetContacts() = %+v", contacts)
+	}
+	c := contacts[0]
+	if c.DisplayName != "Alice, Home" || c.URI != "sip:alice@192.0.2.1;transport=tcp;ob" || c.Transport != "TCP" {
+		t.Errorf("wrong contact %+v", c)
+	}
+	if c.Instance != "urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6" || c.RegID != "1" || c.Params["expires"] != "3600" {
+		t.Errorf("wrong contact parameters %+v", c)
+	}
+
+	c = ParseContact("sip:alice@192.0.2.2;expires=0")
+	if c.URI != "sip:alice@192.0.2.2" || c.Transport != "" || c.Params["expires"] != "0" {
+		t.Errorf("wrong contact without brackets %+v", c)
+	}
+}
+
+func TestSIPMinimize(t *testing.T) {
+	s := decodeTestSIP(t, []byte("INVITE sip:bob@example.com SIP/2.0\n"+
+		"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776asdhds\n"+
+		"From: Alice <sip:alice@example.com>;tag=1928301774\n"+
+		"i: a84b4c76e66710@pc33.example.com\n"+
+		"CSeq: 314159 INVITE\n"+
+		"User-Agent: secret softphone\n"+
+		"www-authenticate: Digest realm=\"example.com\"\n"+
+		"Content-Length: 3\n"+
+		"\n"+
+		"v=0"))
+
+	want := "INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776asdhds\r\n" +
+		"i: a84b4c76e66710@pc33.example.com\r\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"WWW-Authenticate: Digest realm=\"example.com\"\r\n" +
+		"Content-Length: 3\r\n" +
+		"\r\n" +
+		"v=0"
+	got := s.Minimize([]string{"via", "Call-ID", "CSeq", "WWW-Authenticate", "Content-Length"})
+	if string(got) != want {
+		t.Errorf("Minimize() = %q, want %q", got, want)
+	}
+}
+
+func TestSIPGetDTMF(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        SIPDTMF
+		ok          bool
+	}{
+		{"application/dtmf-relay", "Signal=5\r\nDuration=160\r\n", SIPDTMF{"dtmf@host", "5", 160}, true},
+		{"Application/DTMF-Relay; charset=utf-8", "signal = #\r\n", SIPDTMF{"dtmf@host", "#", 0}, true},
+		{"application/dtmf", "9", SIPDTMF{"dtmf@host", "9", 0}, true},
+		{"application/media_control+xml", "<media_control/>", SIPDTMF{CallID: "dtmf@host"}, false},
+	}
+
+	for _, tt := range tests {
+		s := decodeTestSIP(t, []byte("INFO sip:bob@example.com SIP/2.0\r\n"+
+			"i: dtmf@host\r\n"+
+			"c: "+tt.contentType+"\r\n\r\n"+tt.body))
+		got, ok := s.GetDTMF()
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("GetDTMF() for %q = %+v, %v, want %+v, %v", tt.contentType, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSIPGetInstantMessage(t *testing.T) {
+	s := decodeTestSIP(t, []byte("MESSAGE sip:bob@example.com SIP/2.0\r\n"+
+		"Call-ID: im-1@host\r\n"+
+		"Content-Type: Message/CPIM\r\n\r\n"+
+		"From: Alice <im:alice@example.com>\r\n"+
+		"To: Bob <im:bob@example.com>\r\n"+
+		"DateTime: 2000-12-13T13:40:00-08:00\r\n\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n"+
+		"Hello Bob"))
+
+	want := SIPInstantMessage{"im-1@host", "text/plain", "Alice <im:alice@example.com>", "Bob <im:bob@example.com>", "Hello Bob"}
+	if got, ok := s.GetInstantMessage(); !ok || got != want {
+		t.Errorf("GetInstantMessage() = %+v, %v, want %+v", got, ok, want)
+	}
+
+	s = decodeTestSIP(t, []byte("MESSAGE sip:bob@example.com SIP/2.0\r\ni: im-2@host\r\nc: text/plain\r\n\r\nHi"))
+	if got, ok := s.GetInstantMessage(); !ok || got.Text != "Hi" || got.From != "" {
+		t.Errorf("GetInstantMessage() = %+v, %v", got, ok)
+	}
+}
+
+func TestSIPMaxLineLength(t *testing.T) {
+	long := strings.Repeat("a", MaxSIPLineLength+1)
+	for _, data := range []string{
+		"OPTIONS sip:bob@example.com SIP/2.0\r\nX-Long: " + long + "\r\n\r\n",
+		"OPTIONS sip:bob@example.com SIP/2.0\r\nX-Long: " + long,
+	} {
+		before := SIPLongLines()
+		if err := NewSIP().DecodeFromBytes([]byte(data), nil); err == nil {
+			t.Errorf("line with %d bytes was accepted", len(long))
+		}
+		if SIPLongLines() != before+1 {
+			t.Errorf("SIPLongLines() was not incremented")
+		}
+	}
+
+	s := decodeTestSIP(t, []byte("OPTIONS sip:bob@example.com SIP/2.0\r\nX-Long: "+long[:1000]+"\r\n\r\nbody"))
+	if len(s.GetFirstHeader("x-long")) != 1000 || string(s.Payload()) != "body" {
+		t.Errorf("long header within the limit was not decoded")
+	}
+}
+
+func TestSIPCharging(t *testing.T) {
+	s := decodeTestSIP(t, []byte("INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"P-Charging-Vector: icid-value=\"AyretyU0dm+6O2IrT5tAFrbHLso=023551024\";icid-generated-at=192.0.6.8;orig-ioi=home1.net\r\n"+
+		"P-Charging-Function-Addresses: ccf=192.1.1.1; ccf=\"[5555::a55:b44]\"; ecf=192.1.1.3\r\n\r\n"))
+
+	if icid := s.GetICID(); icid != "AyretyU0dm+6O2IrT5tAFrbHLso=023551024" {
+		t.Errorf("GetICID() = %q", icid)
+	}
+	ccf, ecf := s.GetPCFA()
+	if len(ccf) != 2 || ccf[1] != "[5555::a55:b44]" || len(ecf) != 1 || ecf[0] != "192.1.1.3" {
+		t.Errorf("GetPCFA() = %q, %q", ccf, ecf)
+	}
+}
+
+func TestSIPAccessNetwork(t *testing.T) {
+	s := decodeTestSIP(t, []byte("REGISTER sip:ims.example.com SIP/2.0\r\n"+
+		"P-Visited-Network-ID: \"Visited network number 1\";param=1, other.net\r\n"+
+		"P-Access-Network-Info: 3GPP-E-UTRAN-FDD; utran-cell-id-3gpp=234150999999999\r\n"+
+		"P-Access-Network-Info: 3GPP-E-UTRAN-FDD; Utran-Cell-Id-3gpp=\"234151D0FCE11\"; network-provided\r\n\r\n"))
+
+	if pvni := s.GetPVNI(); pvni != "Visited network number 1" {
+		t.Errorf("GetPVNI() = %q", pvni)
+	}
+	accessType, params := s.GetPANI()
+	if accessType != "3GPP-E-UTRAN-FDD" || params["utran-cell-id-3gpp"] != "234151D0FCE11" {
+		t.Errorf("GetPANI() = %q, %q", accessType, params)
+	}
+	if _, ok := params["network-provided"]; !ok {
+		t.Errorf("GetPANI() didn't prefer the network provided value: %q", params)
+	}
+
+	s = decodeTestSIP(t, []byte("OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n"))
+	if accessType, params := s.GetPANI(); s.GetPVNI() != "" || accessType != "" || params != nil {
+		t.Errorf("GetPANI() without header = %q, %q", accessType, params)
+	}
+}
+
+func TestSIPGetWarnings(t *testing.T) {
+	s := decodeTestSIP(t, []byte("SIP/2.0 488 Not Acceptable Here\r\n"+
+		"Warning: 370 device \"Insufficient bandwidth\", 305 pc33.example.com:5060 \"Incompatible \\\"G729\\\", format\"\r\n"+
+		"Warning: 399 sbc.example.com \"Miscellaneous warning\"\r\n"+
+		"Warning: bad\r\n\r\n"))
+
+	want := []SIPWarning{
+		{Code: 370, Agent: "device", Text: "Insufficient bandwidth"},
+		{Code: 305, Agent: "pc33.example.com:5060", Text: `Incompatible "G729", format`},
+		{Code: 399, Agent: "sbc.example.com", Text: "Miscellaneous warning"},
+	}
+	if warnings := s.GetWarnings(); !reflect.DeepEqual(warnings, want) {
+		t.Errorf("GetWarnings() = %+v, want %+v", warnings, want)
+	}
+}
+
+func TestSIPLeadingPadding(t *testing.T) {
+	for _, prefix := range []string{"\r\n", "\r\n\r\n", "  ", "\x00\x00\x00\x00", "\x00\r\n "} {
+		s := decodeTestSIP(t, []byte(prefix+"OPTIONS sip:bob@example.com SIP/2.0\r\nCSeq: 1 OPTIONS\r\n\r\n"))
+		if s.Method != SIPMethodOptions || s.GetFirstHeader("cseq") != "1 OPTIONS" {
+			t.Errorf("prefix %q: Method = %v, CSeq = %q", prefix, s.Method, s.GetFirstHeader("cseq"))
+		}
+	}
+}
+
+func TestSIPGetCSeq(t *testing.T) {
+	s := decodeTestSIP(t, []byte("SIP/2.0 486 Busy Here\r\nCSeq:  102 invite\r\n\r\n"))
+	if seq, method := s.GetCSeq(); seq != 102 || method != "INVITE" {
+		t.Errorf("GetCSeq() = %d, %q", seq, method)
+	}
+}
+
+func TestSIPGetRSeqRAck(t *testing.T) {
+	s := decodeTestSIP(t, []byte("SIP/2.0 183 Session Progress\r\nCSeq: 1 INVITE\r\nRSeq: 988789\r\n\r\n"))
+	if rseq, ok := s.GetRSeq(); !ok || rseq != 988789 {
+		t.Errorf("GetRSeq() = %d, %v", rseq, ok)
+	}
+	s = decodeTestSIP(t, []byte("PRACK sip:bob@example.com SIP/2.0\r\nCSeq: 2 PRACK\r\nRAck: 988789 1 invite\r\n\r\n"))
+	if rseq, cseq, method, ok := s.GetRAck(); !ok || rseq != 988789 || cseq != 1 || method != "INVITE" {
+		t.Errorf("GetRAck() = %d, %d, %q, %v", rseq, cseq, method, ok)
+	}
+	if _, ok := s.GetRSeq(); ok {
+		t.Error("GetRSeq() without RSeq header should fail")
+	}
+	s = decodeTestSIP(t, []byte("PRACK sip:bob@example.com SIP/2.0\r\nRSeq: 0\r\nRAck: 988789 INVITE\r\n\r\n"))
+	if _, ok := s.GetRSeq(); ok {
+		t.Error("RSeq 0 was accepted")
+	}
+	if _, _, _, ok := s.GetRAck(); ok {
+		t.Error("RAck without CSeq number was accepted")
+	}
+}
+
+func TestSIPGetBodyParts(t *testing.T) {
+	s := decodeTestSIP(t, []byte("INVITE sip:911@example.com SIP/2.0\r\n"+
+		"Content-Type: multipart/mixed; boundary=\"unique-boundary-1\"\r\n"+
+		"Content-Disposition: session;handling=required\r\n\r\n"+
+		"--unique-boundary-1\r\n"+
+		"Content-Type: application/sdp\r\n"+
+		"Content-Disposition: early-session\r\n\r\n"+
+		"v=0\r\n"+
+		"--unique-boundary-1\r\n"+
+		"Content-Type: application/sdp\r\n\r\n"+
+		"v=1\r\n"+
+		"--unique-boundary-1\r\n"+
+		"Content-Type: application/pidf+xml\r\n"+
+		"Content-ID: <target123@example.com>\r\n\r\n"+
+		"<presence/>\r\n"+
+		"--unique-boundary-1--\r\n"))
+
+	if disposition, handling := s.GetContentDisposition(); disposition != "session" || handling != "required" {
+		t.Errorf("GetContentDisposition() = %q, %q", disposition, handling)
+	}
+	parts := s.GetBodyParts()
+	want := []SIPBodyPart{
+		{ContentType: "application/sdp", Disposition: "early-session", Body: []byte("v=0")},
+		{ContentType: "application/sdp", Disposition: "session", Body: []byte("v=1")},
+		{ContentType: "application/pidf+xml", Disposition: "render", ContentID: "target123@example.com", Body: []byte("<presence/>")},
+	}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("GetBodyParts() = %+v", parts)
+	}
+}
+
+func TestSIPGetLocation(t *testing.T) {
+	s := decodeTestSIP(t, []byte("INVITE sip:911@example.com SIP/2.0\r\n"+
+		"Call-ID: ng911@example.com\r\n"+
+		"Geolocation: <cid:target123@example.com>, <https://lis.example.com/8a3f>\r\n"+
+		"Content-Type: multipart/mixed; boundary=boundary1\r\n\r\n"+
+		"--boundary1\r\n"+
+		"Content-Type: application/sdp\r\n\r\n"+
+		"v=0\r\n"+
+		"--boundary1\r\n"+
+		"Content-Type: application/pidf+xml\r\n"+
+		"Content-ID: <target123@example.com>\r\n\r\n"+
+		`<presence xmlns="urn:ietf:params:xml:ns:pidf" xmlns:gp="urn:ietf:params:xml:ns:pidf:geopriv10"`+
+		` xmlns:ca="urn:ietf:params:xml:ns:pidf:geopriv10:civicAddr" xmlns:gml="http://www.opengis.net/gml"`+
+		` xmlns:gs="http://www.opengis.net/pidflo/1.0" entity="pres:alice@example.com">`+"\r\n"+
+		"<tuple id=\"t1\"><status><gp:geopriv><gp:location-info>\r\n"+
+		"<ca:civicAddress><ca:country>US</ca:country><ca:A1>CA</ca:A1><ca:RD>Main</ca:RD><ca:HNO>12</ca:HNO></ca:civicAddress>\r\n"+
+		"<gs:Circle srsName=\"urn:ogc:def:crs:EPSG::4326\"><gml:pos>37.775 -122.4194</gml:pos>"+
+		"<gs:radius uom=\"urn:ogc:def:uom:EPSG::9001\">850.24</gs:radius></gs:Circle>\r\n"+
+		"</gp:location-info><gp:method>GPS</gp:method></gp:geopriv></status></tuple></presence>\r\n"+
+		"--boundary1--\r\n"))
+
+	if geo := s.GetGeolocation(); strings.Join(geo, " ") != "<cid:target123@example.com> <https://lis.example.com/8a3f>" {
+		t.Errorf("GetGeolocation() = %q", geo)
+	}
+	loc, ok := s.GetLocation()
+	want := SIPLocation{
+		CallID:    "ng911@example.com",
+		Entity:    "pres:alice@example.com",
+		Method:    "GPS",
+		Civic:     map[string]string{"country": "US", "A1": "CA", "RD": "Main", "HNO": "12"},
+		Shape:     "Circle",
+		Latitude:  37.775,
+		Longitude: -122.4194,
+		Radius:    850.24,
+	}
+	if !ok || !reflect.DeepEqual(loc, want) {
+		t.Errorf("GetLocation() = %+v, %v", loc, ok)
+	}
+
+	s = decodeTestSIP(t, []byte("INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"Content-Type: application/sdp\r\n\r\n"+
+		"v=0\r\n"))
+	if _, ok := s.GetLocation(); ok {
+		t.Error("GetLocation() without PIDF-LO should fail")
+	}
+}
+
+func TestSIPGetAccept(t *testing.T) {
+	s := decodeTestSIP(t, []byte("OPTIONS sip:bob@example.com SIP/2.0\r\n"+
+		"Accept: application/sdp;level=1, application/pidf+xml;q=0.5\r\n"+
+		"Accept: multipart/mixed;boundary=\"a,b\";q=0.8\r\n"+
+		"Accept-Encoding: gzip;q=0.2, identity\r\n"+
+		"Accept-Language: da, en-gb;q=0.8, en;q=0.9, fr;q=0\r\n\r\n"))
+
+	if got := s.GetAccept(); !reflect.DeepEqual(got, []string{"application/sdp;level=1", `multipart/mixed;boundary="a,b"`, "application/pidf+xml"}) {
+		t.Errorf("GetAccept() = %q", got)
+	}
+	if got := s.GetAcceptEncoding(); !reflect.DeepEqual(got, []string{"identity", "gzip"}) {
+		t.Errorf("GetAcceptEncoding() = %q", got)
+	}
+	if got := s.GetAcceptLanguage(); !reflect.DeepEqual(got, []string{"da", "en", "en-gb"}) {
+		t.Errorf("GetAcceptLanguage() = %q", got)
+	}
+}
+
+func TestSIPGetSupportedAllowEvents(t *testing.T) {
+	s := decodeTestSIP(t, []byte("INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"Supported: timer, 100rel\r\n"+
+		"Supported: path,gruu\r\n"+
+		"u: presence, dialog\r\n\r\n"))
+
+	supported := s.GetSupported()
+	if strings.Join(supported, " ") != "timer 100rel path gruu" {
+		t.Errorf("GetSupported() = %q", supported)
+	}
+	allowEvents := s.GetAllowEvents()
+	if strings.Join(allowEvents, " ") != "presence dialog" {
+		t.Errorf("GetAllowEvents() = %q", allowEvents)
+	}
+}
+
+func TestSIPIsInDialog(t *testing.T) {
+	s := decodeTestSIP(t, []byte("INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"From: \"Alice;tag=x\" <sip:alice@example.com>;tag=1928301774\r\n"+
+		"To: <sip:bob@example.com>\r\n\r\n"))
+	if tag := s.GetFromTag(); tag != "1928301774" {
+		t.Errorf("GetFromTag() = %q", tag)
+	}
+	if s.IsInDialog() {
+		t.Errorf("initial INVITE should not be in dialog")
+	}
+
+	s = decodeTestSIP(t, []byte("BYE sip:alice@example.com SIP/2.0\r\nt: <sip:alice@example.com>;TAG=a6c85cf\r\n\r\n"))
+	if tag := s.GetToTag(); tag != "a6c85cf" || !s.IsInDialog() {
+		t.Errorf("GetToTag() = %q, IsInDialog() = %v", tag, s.IsInDialog())
+	}
+}
+
+func TestSIPResponseClass(t *testing.T) {
+	for _, tt := range []struct {
+		line  string
+		class int
+	}{
+		{"SIP/2.0 199 Early Dialog Terminated", 1},
+		{"SIP/2.0 422 Session Interval Too Small", 4},
+		{"SIP/2.0 494 Security Agreement Required", 4},
+		{"SIP/2.0 699 Custom", 6},
+		{"SIP/2.0 99 Too Low", 0},
+		{"SIP/2.0 700 Too High", 0},
+		{"INVITE sip:bob@example.com SIP/2.0", 0},
+	} {
+		s := decodeTestSIP(t, []byte(tt.line+"\r\nCSeq: 1 INVITE\r\n\r\n"))
+		if class := s.ResponseClass(); class != tt.class {
+			t.Errorf("ResponseClass(%q) = %d, want %d", tt.line, class, tt.class)
+		}
+	}
+
+	StrictResponseCodes = true
+	defer func() { StrictResponseCodes = false }()
+	if err := NewSIP().DecodeFromBytes([]byte("SIP/2.0 700 Too High\r\n\r\n"), nil); err == nil {
+		t.Error("status code 700 should be rejected in strict mode")
+	}
+	if err := NewSIP().DecodeFromBytes([]byte("SIP/2.0 199 Early Dialog Terminated\r\n\r\n"), nil); err != nil {
+		t.Errorf("status code 199 rejected: %v", err)
+	}
+}
+
+func TestSIPGetTimestamp(t *testing.T) {
+	s := decodeTestSIP(t, []byte("SIP/2.0 200 OK\r\nTimestamp: 54.21 0.3\r\n\r\n"))
+	if ts, delay, ok := s.GetTimestamp(); !ok || ts != 54.21 || delay != 0.3 {
+		t.Errorf("GetTimestamp() = %v, %v, %v", ts, delay, ok)
+	}
+	s = decodeTestSIP(t, []byte("OPTIONS sip:bob@example.com SIP/2.0\r\nTimestamp: 1700000000\r\n\r\n"))
+	if ts, delay, ok := s.GetTimestamp(); !ok || ts != 1700000000 || delay != 0 {
+		t.Errorf("GetTimestamp() without delay = %v, %v, %v", ts, delay, ok)
+	}
+	s = decodeTestSIP(t, []byte("OPTIONS sip:bob@example.com SIP/2.0\r\nTimestamp: now\r\n\r\n"))
+	if _, _, ok := s.GetTimestamp(); ok {
+		t.Error("invalid Timestamp was accepted")
+	}
+}
+
+func TestSIPGetSubjectPriority(t *testing.T) {
+	s := decodeTestSIP(t, []byte("INVITE sip:support@example.com SIP/2.0\r\ns: Need more boxes\r\nPriority: Urgent\r\nOrganization: Boxes by Bob\r\n\r\n"))
+	if subject := s.GetSubject(); subject != "Need more boxes" {
+		t.Errorf("GetSubject() = %q", subject)
+	}
+	if organization := s.GetOrganization(); organization != "Boxes by Bob" {
+		t.Errorf("GetOrganization() = %q", organization)
+	}
+	if priority := s.GetPriority(); priority != "urgent" {
+		t.Errorf("GetPriority() = %q", priority)
+	}
+}
+
+func TestSIPGetEventBody(t *testing.T) {
+	tests := []struct {
+		event string
+		body  string
+		want  SIPEvent
+		ok    bool
+	}{
+		{"message-summary", "Messages-Waiting: yes\r\nMessage-Account: sip:alice@example.com\r\nVoice-Message: 2/8 (0/2)\r\n",
+			SIPEvent{CallID: "ev@host", Package: "message-summary", MessagesWaiting: true, NewMessages: 2, OldMessages: 8}, true},
+		{"dialog;id=453", `<?xml version="1.0"?><dialog-info xmlns="urn:ietf:params:xml:ns:dialog-info" version="1" state="full" entity="sip:alice@example.com">` +
+			`<dialog id="as7d900as8" direction="initiator"><state>confirmed</state></dialog></dialog-info>`,
+			SIPEvent{CallID: "ev@host", Package: "dialog", Entity: "sip:alice@example.com", State: "confirmed"}, true},
+		{"presence", `<?xml version="1.0"?><presence xmlns="urn:ietf:params:xml:ns:pidf" entity="pres:bob@example.com">` +
+			`<tuple id="t1"><status><basic>open</basic></status></tuple></presence>`,
+			SIPEvent{CallID: "ev@host", Package: "presence", Entity: "pres:bob@example.com", State: "open"}, true},
+		{"refer", "SIP/2.0 200 OK", SIPEvent{CallID: "ev@host", Package: "refer"}, false},
+	}
+
+	for _, tt := range tests {
+		s := decodeTestSIP(t, []byte("NOTIFY sip:alice@example.com SIP/2.0\r\ni: ev@host\r\no: "+tt.event+"\r\n\r\n"+tt.body))
+		got, ok := s.GetEventBody()
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("GetEventBody() for %s = %+v, %v, want %+v, %v", tt.event, got, ok, tt.want, tt.ok)
+		}
+	}
+}
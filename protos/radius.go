@@ -0,0 +1,115 @@
+package protos
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+/* RFC 2866 RADIUS accounting
+0                   1                   2                   3
+0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|     Code      |  Identifier   |            Length             |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                         Authenticator                         |
+|                          (16 octets)                          |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|  Attributes ...
++-+-+-+-+-+-+-+-+-+-+-+-+-
+*/
+
+const (
+	RadiusAccountingRequest  = 4
+	RadiusAccountingResponse = 5
+
+	radiusUserName       = 1
+	radiusVendorSpecific = 26
+	radiusAcctStatusType = 40
+	radiusAcctSessionID  = 44
+
+	// Cisco-AVPair of vendor 9 carries "call-id=<SIP Call-ID>"
+	ciscoVendorID = 9
+	ciscoAVPair   = 1
+)
+
+var radiusStatusTypes = map[uint32]string{
+	1:  "Start",
+	2:  "Stop",
+	3:  "Interim-Update",
+	7:  "Accounting-On",
+	8:  "Accounting-Off",
+	15: "Failed",
+}
+
+// Radius holds the accounting attributes used to correlate RADIUS to SIP.
+type Radius struct {
+	Code          string `json:"code"`
+	Identifier    uint8  `json:"identifier"`
+	StatusType    string `json:"acct_status_type,omitempty"`
+	AcctSessionID string `json:"acct_session_id,omitempty"`
+	CallID        string `json:"call_id,omitempty"`
+	UserName      string `json:"user_name,omitempty"`
+}
+
+// ParseRadius decodes a RADIUS Accounting-Request or Accounting-Response.
+func ParseRadius(payload []byte) (*Radius, error) {
+	if len(payload) < 20 {
+		return nil, fmt.Errorf("RADIUS packet too short: %d bytes", len(payload))
+	}
+	r := &Radius{Identifier: payload[1]}
+	switch payload[0] {
+	case RadiusAccountingRequest:
+		r.Code = "Accounting-Request"
+	case RadiusAccountingResponse:
+		r.Code = "Accounting-Response"
+	default:
+		return nil, fmt.Errorf("no RADIUS accounting code: %d", payload[0])
+	}
+	length := int(binary.BigEndian.Uint16(payload[2:4]))
+	if length < 20 || length > len(payload) {
+		return nil, fmt.Errorf("wrong RADIUS length %d of %d bytes", length, len(payload))
+	}
+
+	attrs := payload[20:length]
+	for len(attrs) > 0 {
+		if len(attrs) < 2 || attrs[1] < 2 || int(attrs[1]) > len(attrs) {
+			return nil, fmt.Errorf("malformed RADIUS attribute")
+		}
+		value := attrs[2:attrs[1]]
+		switch attrs[0] {
+		case radiusUserName:
+			r.UserName = string(value)
+		case radiusAcctSessionID:
+			r.AcctSessionID = string(value)
+		case radiusAcctStatusType:
+			if len(value) == 4 {
+				status := binary.BigEndian.Uint32(value)
+				if r.StatusType = radiusStatusTypes[status]; r.StatusType == "" {
+					r.StatusType = fmt.Sprintf("%d", status)
+				}
+			}
+		case radiusVendorSpecific:
+			if callID := ciscoCallID(value); callID != "" {
+				r.CallID = callID
+			}
+		}
+		attrs = attrs[attrs[1]:]
+	}
+	return r, nil
+}
+
+// ciscoCallID returns the SIP Call-ID of a Cisco-AVPair like "call-id=abc@host".
+func ciscoCallID(vsa []byte) string {
+	if len(vsa) < 6 || binary.BigEndian.Uint32(vsa[:4]) != ciscoVendorID || vsa[4] != ciscoAVPair {
+		return ""
+	}
+	if int(vsa[5]) < 2 || int(vsa[5]) > len(vsa)-4 {
+		return ""
+	}
+	pair := string(vsa[6 : 4+int(vsa[5])])
+	if i := strings.Index(pair, "="); i > 0 && strings.EqualFold(pair[:i], "call-id") {
+		return pair[i+1:]
+	}
+	return ""
+}
@@ -153,6 +153,8 @@ type RTCP_Packet struct {
 	ReportBlocks   []RTCP_report_block  `json:"report_blocks"`
 	ReportBlocksXr RTCP_report_block_xr `json:"report_blocks_xr"`
 	Sdes_ssrc      uint32               `json:"sdes_ssrc"`
+	Sdes           []RTCP_sdes_chunk    `json:"sdes,omitempty"`
+	Bye            *RTCP_bye            `json:"bye,omitempty"`
 }
 
 type RTCP_report_block struct {
@@ -178,6 +180,21 @@ type RTCP_report_block_xr struct {
 	End_system_delay uint16 `json:"end_system_delay"`
 }
 
+type RTCP_sdes_chunk struct {
+	Ssrc  uint32           `json:"ssrc"`
+	Items []RTCP_sdes_item `json:"items"`
+}
+
+type RTCP_sdes_item struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type RTCP_bye struct {
+	Ssrcs  []uint32 `json:"ssrcs"`
+	Reason string   `json:"reason,omitempty"`
+}
+
 func (rp *RTCP_Packet) AddReportBlock(rb RTCP_report_block) []RTCP_report_block {
 	rp.ReportBlocks = append(rp.ReportBlocks, rb)
 	return rp.ReportBlocks
@@ -215,9 +232,17 @@ func ParseRTCP(data []byte) (ssrcBytes []byte, rtcpPkt []byte, infoMsg string) {
 				RTCPVersion, RTCPPadding, RTCPReportCount, RTCPType, RTCPLength, len(data), dataLen, offset, hex.Dump(data))
 			break
 		}
+		// end is where the next packet of the compound packet starts
+		end := offset + RTCPLength
+		if end > len(data) {
+			end = len(data)
+		}
 
-		pkt.Type = uint8(RTCPType)
-		pkt.ReportCount = uint8(RTCPReportCount)
+		// The first packet of a compound packet is a SR or RR
+		if pkt.Type == 0 {
+			pkt.Type = uint8(RTCPType)
+			pkt.ReportCount = uint8(RTCPReportCount)
+		}
 
 		switch RTCPType {
 		case TYPE_RTCP_SR:
@@ -235,24 +260,9 @@ func ParseRTCP(data []byte) (ssrcBytes []byte, rtcpPkt []byte, infoMsg string) {
 			pkt.SenderInformation.Rtp_timestamp = binary.BigEndian.Uint32(data[offset+12:])
 			pkt.SenderInformation.Pkt_count = binary.BigEndian.Uint32(data[offset+16:])
 			pkt.SenderInformation.Octet_count = binary.BigEndian.Uint32(data[offset+20:])
-			offset += 24
-
-			if RTCPReportCount > 0 && RTCPLength >= 24 && offset+24 <= len(data) {
-				tmpReportBlocks := make([]RTCP_report_block, RTCPReportCount)
-				for i := 0; i < RTCPReportCount; i++ {
-					tmpReportBlocks[i].SourceSsrc = binary.BigEndian.Uint32(data[offset:])
-					tmpReportBlocks[i].Fraction_lost = data[offset+4]
-					var cumBuf [4]byte
-					copy(cumBuf[1:], data[offset+5:offset+8])
-					tmpReportBlocks[i].Cumulative_lost = binary.BigEndian.Uint32(cumBuf[:])
-					tmpReportBlocks[i].Highest_seq_no = binary.BigEndian.Uint32(data[offset+8:])
-					tmpReportBlocks[i].Jitter = binary.BigEndian.Uint32(data[offset+12:])
-					tmpReportBlocks[i].LastSR = binary.BigEndian.Uint32(data[offset+16:])
-					tmpReportBlocks[i].Delay_last_SR = binary.BigEndian.Uint32(data[offset+20:])
-
-					offset += 24
-					pkt.ReportBlocks = pkt.AddReportBlock(tmpReportBlocks[i])
-				}
+
+			for i, o := 0, offset+24; i < RTCPReportCount && o+24 <= end; i, o = i+1, o+24 {
+				pkt.ReportBlocks = pkt.AddReportBlock(parseReportBlock(data[o:]))
 			}
 
 		case TYPE_RTCP_RR:
@@ -264,24 +274,9 @@ func ParseRTCP(data []byte) (ssrcBytes []byte, rtcpPkt []byte, infoMsg string) {
 
 			ssrcBytes = data[offset : offset+4]
 			pkt.Ssrc = binary.BigEndian.Uint32(data[offset:])
-			offset += 4
-
-			if RTCPReportCount > 0 && RTCPLength >= 24 && offset+24 <= len(data) {
-				tmpReportBlocks := make([]RTCP_report_block, RTCPReportCount)
-				for i := 0; i < RTCPReportCount; i++ {
-					tmpReportBlocks[i].SourceSsrc = binary.BigEndian.Uint32(data[offset:])
-					tmpReportBlocks[i].Fraction_lost = data[offset+4]
-					var cumBuf [4]byte
-					copy(cumBuf[1:], data[offset+5:offset+8])
-					tmpReportBlocks[i].Cumulative_lost = binary.BigEndian.Uint32(cumBuf[:])
-					tmpReportBlocks[i].Highest_seq_no = binary.BigEndian.Uint32(data[offset+8:])
-					tmpReportBlocks[i].Jitter = binary.BigEndian.Uint32(data[offset+12:])
-					tmpReportBlocks[i].LastSR = binary.BigEndian.Uint32(data[offset+16:])
-					tmpReportBlocks[i].Delay_last_SR = binary.BigEndian.Uint32(data[offset+20:])
-
-					offset += 24
-					pkt.ReportBlocks = pkt.AddReportBlock(tmpReportBlocks[i])
-				}
+
+			for i, o := 0, offset+4; i < RTCPReportCount && o+24 <= end; i, o = i+1, o+24 {
+				pkt.ReportBlocks = pkt.AddReportBlock(parseReportBlock(data[o:]))
 			}
 
 		case TYPE_RTCP_SDES:
@@ -291,16 +286,26 @@ func ParseRTCP(data []byte) (ssrcBytes []byte, rtcpPkt []byte, infoMsg string) {
 				break
 			}
 
-			ssrcBytes = data[offset : offset+4]
+			if ssrcBytes == nil {
+				ssrcBytes = data[offset : offset+4]
+			}
 			pkt.Sdes_ssrc = binary.BigEndian.Uint32(data[offset:])
-			offset += RTCPLength
+			pkt.Sdes = append(pkt.Sdes, parseSDESChunks(data[offset:end], RTCPReportCount)...)
 
 		case TYPE_RTCP_APP:
 			infoMsg = fmt.Sprintf("Discard RTCP_APP packet type=%d", RTCPType)
-			offset += RTCPLength
 		case TYPE_RTCP_BYE:
-			infoMsg = fmt.Sprintf("Discard RTCP_BYE packet type=%d", RTCPType)
-			offset += RTCPLength
+			if RTCPReportCount*4 > RTCPLength {
+				infoMsg = fmt.Sprintf("Fishy RTCP_BYE RTCPVersion=%d, RTCPReportCount=%d, RTCPType=%d, RTCPLength=%d, dataLen=%d, offset=%d in packet:\n%v",
+					RTCPVersion, RTCPReportCount, RTCPType, RTCPLength, dataLen, offset, hex.Dump(data))
+				break
+			}
+
+			if ssrcBytes == nil && RTCPReportCount > 0 {
+				ssrcBytes = data[offset : offset+4]
+			}
+			pkt.Bye = parseBye(data[offset:end], RTCPReportCount)
+
 		case TYPE_RTCP_XR:
 			if RTCPLength < 8 || offset+8 > len(data) {
 				infoMsg = fmt.Sprintf("Fishy RTCP_XR RTCPVersion=%d, RTCPReportCount=%d, RTCPType=%d, RTCPLength=%d, dataLen=%d, offset=%d in packet:\n%v",
@@ -323,8 +328,8 @@ func ParseRTCP(data []byte) (ssrcBytes []byte, rtcpPkt []byte, infoMsg string) {
 				pkt.ReportBlocksXr.Round_trip_delay = binary.BigEndian.Uint16(data[offset+20:])
 				pkt.ReportBlocksXr.End_system_delay = binary.BigEndian.Uint16(data[offset+22:])
 			}
-			offset += RTCPLength
 		}
+		offset = end
 		dataLen -= RTCPLength + 4
 	}
 
@@ -335,3 +340,95 @@ func ParseRTCP(data []byte) (ssrcBytes []byte, rtcpPkt []byte, infoMsg string) {
 
 	return
 }
+
+// parseReportBlock parses the 24 byte report block at the start of data.
+func parseReportBlock(data []byte) RTCP_report_block {
+	var cumBuf [4]byte
+	copy(cumBuf[1:], data[5:8])
+	return RTCP_report_block{
+		SourceSsrc:      binary.BigEndian.Uint32(data),
+		Fraction_lost:   data[4],
+		Cumulative_lost: binary.BigEndian.Uint32(cumBuf[:]),
+		Highest_seq_no:  binary.BigEndian.Uint32(data[8:]),
+		Jitter:          binary.BigEndian.Uint32(data[12:]),
+		LastSR:          binary.BigEndian.Uint32(data[16:]),
+		Delay_last_SR:   binary.BigEndian.Uint32(data[20:]),
+	}
+}
+
+// parseSDESChunks parses count chunks of SDES items. Each chunk is a SSRC
+// followed by items which end with a null item and padding to 32 bits.
+func parseSDESChunks(data []byte, count int) []RTCP_sdes_chunk {
+	var chunks []RTCP_sdes_chunk
+	offset := 0
+	for i := 0; i < count && offset+4 <= len(data); i++ {
+		chunk := RTCP_sdes_chunk{Ssrc: binary.BigEndian.Uint32(data[offset:])}
+		offset += 4
+		for offset < len(data) && data[offset] != 0 {
+			if offset+2 > len(data) || offset+2+int(data[offset+1]) > len(data) {
+				return append(chunks, chunk)
+			}
+			itemLen := int(data[offset+1])
+			chunk.Items = append(chunk.Items, RTCP_sdes_item{
+				Type: sdesItemName(data[offset]),
+				Text: string(data[offset+2 : offset+2+itemLen]),
+			})
+			offset += 2 + itemLen
+		}
+		// Skip the null item and the padding to the next 32 bit boundary
+		offset = (offset + 4) &^ 3
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+var sdesItemNames = [...]string{1: "cname", 2: "name", 3: "email", 4: "phone", 5: "loc", 6: "tool", 7: "note", 8: "priv"}
+
+func sdesItemName(t uint8) string {
+	if int(t) < len(sdesItemNames) && sdesItemNames[t] != "" {
+		return sdesItemNames[t]
+	}
+	return fmt.Sprintf("item%d", t)
+}
+
+// parseBye parses the count SSRCs which leave and the optional reason.
+func parseBye(data []byte, count int) *RTCP_bye {
+	bye := &RTCP_bye{Ssrcs: make([]uint32, 0, count)}
+	offset := 0
+	for i := 0; i < count && offset+4 <= len(data); i++ {
+		bye.Ssrcs = append(bye.Ssrcs, binary.BigEndian.Uint32(data[offset:]))
+		offset += 4
+	}
+	if offset < len(data) {
+		n := int(data[offset])
+		if offset+1+n <= len(data) {
+			bye.Reason = string(data[offset+1 : offset+1+n])
+		}
+	}
+	return bye
+}
+
+// RTCPReportBlocks returns the report blocks of the SR and RR
+// packets in a compound RTCP packet.
+func RTCPReportBlocks(data []byte) []RTCP_report_block {
+	var blocks []RTCP_report_block
+	for len(data) >= 8 {
+		count := int(data[0] & 0x1f)
+		length := int(binary.BigEndian.Uint16(data[2:4])+1) * 4
+		if data[0]>>6 != 2 || length > len(data) {
+			break
+		}
+		offset := 8
+		if data[1] == TYPE_RTCP_SR {
+			offset += 20
+		}
+		if data[1] == TYPE_RTCP_SR || data[1] == TYPE_RTCP_RR {
+			for i := 0; i < count && offset+24 <= length; i++ {
+				blocks = append(blocks, parseReportBlock(data[offset:]))
+				offset += 24
+			}
+		}
+		data = data[length:]
+	}
+	return blocks
+}
@@ -0,0 +1,69 @@
+package protos
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+/* RFC 4733 telephone-event payload
+0                   1                   2                   3
+0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|     event     |E|R| volume    |          duration             |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+*/
+
+// dtmfDigits maps the DTMF events 0-16 to their digits
+var dtmfDigits = []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "*", "#", "A", "B", "C", "D", "flash"}
+
+// RTPEvent is a RFC 4733 telephone-event like a DTMF digit.
+type RTPEvent struct {
+	Event     uint8  `json:"event"`
+	Digit     string `json:"digit,omitempty"`
+	End       bool   `json:"end"`
+	Volume    uint8  `json:"volume"`
+	Duration  uint16 `json:"duration"`
+	SSRC      uint32 `json:"ssrc"`
+	Timestamp uint32 `json:"timestamp"`
+}
+
+// ParseRTPEvent decodes a telephone-event from a RTP packet
+// if the RTP payload type matches payloadType.
+func ParseRTPEvent(rtp []byte, payloadType int) (RTPEvent, bool) {
+	var e RTPEvent
+	if len(rtp) < 16 || rtp[0]>>6 != 2 || int(rtp[1]&0x7f) != payloadType {
+		return e, false
+	}
+	offset := 12 + 4*int(rtp[0]&0x0f)
+	if rtp[0]&0x10 != 0 {
+		if len(rtp) < offset+4 {
+			return e, false
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(rtp[offset+2:]))
+	}
+	if len(rtp) < offset+4 {
+		return e, false
+	}
+	payload := rtp[offset:]
+	e.Event = payload[0]
+	if int(e.Event) < len(dtmfDigits) {
+		e.Digit = dtmfDigits[e.Event]
+	}
+	e.End = payload[1]&0x80 != 0
+	e.Volume = payload[1] & 0x3f
+	e.Duration = binary.BigEndian.Uint16(payload[2:])
+	e.Timestamp = binary.BigEndian.Uint32(rtp[4:])
+	e.SSRC = binary.BigEndian.Uint32(rtp[8:])
+	return e, true
+}
+
+// EventPayloadType returns the payload type of telephone-event
+// in the media section or -1.
+func (m SDPMedia) EventPayloadType() int {
+	for _, f := range m.Formats {
+		if strings.HasPrefix(strings.ToLower(f.Codec), "telephone-event/") {
+			return f.PayloadType
+		}
+	}
+	return -1
+}
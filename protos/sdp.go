@@ -0,0 +1,405 @@
+package protos
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// SDPDTLS holds the DTLS-SRTP parameters of one SDP media section.
+// Session level a=fingerprint and a=setup values are inherited
+// by media sections which don't override them.
+type SDPDTLS struct {
+	Media           string `json:"media"`
+	FingerprintHash string `json:"fingerprint_hash,omitempty"` // Hash function like sha-256
+	Fingerprint     string `json:"fingerprint,omitempty"`
+	Setup           string `json:"setup,omitempty"` // actpass, active, passive or holdconn
+}
+
+// ParseSDPDTLS extracts a=fingerprint and a=setup per media section.
+// It returns nil if the SDP doesn't carry any of them.
+func ParseSDPDTLS(payload []byte) []SDPDTLS {
+	var (
+		session SDPDTLS
+		medias  []SDPDTLS
+		found   bool
+	)
+
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		current := &session
+		if len(medias) > 0 {
+			current = &medias[len(medias)-1]
+		}
+
+		switch {
+		case bytes.HasPrefix(line, []byte("m=")):
+			media := SDPDTLS{
+				FingerprintHash: session.FingerprintHash,
+				Fingerprint:     session.Fingerprint,
+				Setup:           session.Setup,
+			}
+			if fields := strings.Fields(string(line[2:])); len(fields) > 0 {
+				media.Media = fields[0]
+			}
+			medias = append(medias, media)
+		case bytes.HasPrefix(line, []byte("a=fingerprint:")):
+			// a=fingerprint:sha-256 4A:AD:B9:...
+			fields := strings.Fields(string(line[len("a=fingerprint:"):]))
+			if len(fields) == 2 {
+				current.FingerprintHash = strings.ToLower(fields[0])
+				current.Fingerprint = strings.ToUpper(fields[1])
+				found = true
+			}
+		case bytes.HasPrefix(line, []byte("a=setup:")):
+			current.Setup = strings.ToLower(strings.TrimSpace(string(line[len("a=setup:"):])))
+			found = true
+		}
+	}
+
+	if !found || len(medias) == 0 {
+		return nil
+	}
+	return medias
+}
+
+// SDPCrypto holds the SDES-SRTP crypto suite of one SDP media section.
+// The inline key material is never kept.
+type SDPCrypto struct {
+	Media string `json:"media"`
+	Tag   int    `json:"tag"`
+	Suite string `json:"suite"` // Crypto suite like AES_CM_128_HMAC_SHA1_80
+}
+
+// ParseSDPCrypto extracts the a=crypto lines of RFC 4568 per media section
+//
+//	a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|2^20|1:32
+//
+// It returns nil if the SDP doesn't carry any.
+func ParseSDPCrypto(payload []byte) []SDPCrypto {
+	var (
+		media   string
+		cryptos []SDPCrypto
+	)
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		switch {
+		case bytes.HasPrefix(line, []byte("m=")):
+			media = ""
+			if fields := strings.Fields(string(line[2:])); len(fields) > 0 {
+				media = fields[0]
+			}
+		case bytes.HasPrefix(line, []byte("a=crypto:")) && media != "":
+			// a=crypto:<tag> <crypto-suite> <key-params> [<session-params>]
+			fields := strings.Fields(string(line[len("a=crypto:"):]))
+			if len(fields) < 3 {
+				continue
+			}
+			tag, err := strconv.Atoi(fields[0])
+			if err != nil {
+				continue
+			}
+			cryptos = append(cryptos, SDPCrypto{Media: media, Tag: tag, Suite: strings.ToUpper(fields[1])})
+		}
+	}
+	return cryptos
+}
+
+// SDPOrigin holds the fields of the SDP o= line. Username, SessionID
+// and the address identify a session, Version is incremented with
+// every change of the session description.
+type SDPOrigin struct {
+	Username  string
+	SessionID string
+	Version   string
+	NetType   string
+	AddrType  string
+	Address   string
+}
+
+// ParseSDPOrigin extracts the o= line like
+//
+//	o=alice 2890844526 2890844527 IN IP4 192.0.2.1
+func ParseSDPOrigin(payload []byte) (SDPOrigin, bool) {
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte("o=")) {
+			continue
+		}
+		fields := strings.Fields(string(line[2:]))
+		if len(fields) != 6 {
+			return SDPOrigin{}, false
+		}
+		return SDPOrigin{fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]}, true
+	}
+	return SDPOrigin{}, false
+}
+
+// SDPTiming is a t= line with the start and stop time in NTP seconds.
+// Both are 0 for the usual unbounded session.
+type SDPTiming struct {
+	Start uint64 `json:"start"`
+	Stop  uint64 `json:"stop"`
+}
+
+// SDPSession holds the session name and timing of an SDP.
+// The name is often the placeholder "-".
+type SDPSession struct {
+	Name    string      `json:"name"`
+	Timings []SDPTiming `json:"timings,omitempty"`
+}
+
+// ParseSDPSession extracts the s= line and the t= lines like
+//
+//	s=-
+//	t=0 0
+//
+// It returns false if the SDP has no s= line.
+func ParseSDPSession(payload []byte) (SDPSession, bool) {
+	var (
+		session SDPSession
+		found   bool
+	)
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		switch {
+		case bytes.HasPrefix(line, []byte("m=")):
+			// s= and t= are session level lines
+			return session, found
+		case bytes.HasPrefix(line, []byte("s=")) && !found:
+			session.Name = strings.TrimSpace(string(line[2:]))
+			found = true
+		case bytes.HasPrefix(line, []byte("t=")):
+			fields := strings.Fields(string(line[2:]))
+			if len(fields) != 2 {
+				continue
+			}
+			start, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			stop, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			session.Timings = append(session.Timings, SDPTiming{Start: start, Stop: stop})
+		}
+	}
+	return session, found
+}
+
+// SDPBundle describes the media multiplexed onto one transport by
+// a=group:BUNDLE. Port is the port of the first bundled m= line which
+// isn't bundle-only, RTCPMux is set if RTCP shares that port.
+type SDPBundle struct {
+	Mids    []string
+	Media   []string
+	Port    string
+	RTCPMux bool
+}
+
+// ParseSDPBundle extracts the BUNDLE group like
+//
+//	a=group:BUNDLE 0 1
+func ParseSDPBundle(payload []byte) (SDPBundle, bool) {
+	var (
+		bundle  SDPBundle
+		found   bool
+		media   string
+		port    string
+		bundled = make(map[string]bool)
+	)
+
+	lines := bytes.Split(payload, []byte("\n"))
+	for _, line := range lines {
+		if bytes.HasPrefix(line, []byte("a=group:BUNDLE")) {
+			bundle.Mids = strings.Fields(string(line[len("a=group:BUNDLE"):]))
+			for _, mid := range bundle.Mids {
+				bundled[mid] = true
+			}
+			found = len(bundle.Mids) > 0
+			break
+		}
+	}
+	if !found {
+		return bundle, false
+	}
+
+	for _, line := range lines {
+		line = bytes.TrimRight(line, "\r")
+		switch {
+		case bytes.HasPrefix(line, []byte("m=")):
+			media, port = "", ""
+			if fields := strings.Fields(string(line[2:])); len(fields) > 1 {
+				media, port = fields[0], fields[1]
+			}
+		case bytes.HasPrefix(line, []byte("a=mid:")):
+			if !bundled[strings.TrimSpace(string(line[len("a=mid:"):]))] {
+				continue
+			}
+			bundle.Media = append(bundle.Media, media)
+			if bundle.Port == "" && port != "0" {
+				bundle.Port = port
+			}
+		case bytes.Equal(line, []byte("a=rtcp-mux")):
+			bundle.RTCPMux = true
+		}
+	}
+	return bundle, true
+}
+
+// staticPayloadTypes holds the static RTP payload type assignments of RFC 3551.
+var staticPayloadTypes = map[int]string{
+	0:  "PCMU/8000",
+	3:  "GSM/8000",
+	4:  "G723/8000",
+	5:  "DVI4/8000",
+	6:  "DVI4/16000",
+	7:  "LPC/8000",
+	8:  "PCMA/8000",
+	9:  "G722/8000",
+	10: "L16/44100/2",
+	11: "L16/44100",
+	12: "QCELP/8000",
+	13: "CN/8000",
+	14: "MPA/90000",
+	15: "G728/8000",
+	16: "DVI4/11025",
+	17: "DVI4/22050",
+	18: "G729/8000",
+	25: "CelB/90000",
+	26: "JPEG/90000",
+	28: "nv/90000",
+	31: "H261/90000",
+	32: "MPV/90000",
+	33: "MP2T/90000",
+	34: "H263/90000",
+}
+
+// SDPFormat is one RTP payload type of a media section with
+// its codec from a=rtpmap or the static assignment like PCMA/8000.
+type SDPFormat struct {
+	PayloadType int    `json:"pt"`
+	Codec       string `json:"codec,omitempty"`
+}
+
+// SDPCandidate is an ICE candidate of RFC 8839 like
+//
+//	a=candidate:1 1 udp 2113937151 192.0.2.1 54400 typ srflx raddr 10.0.0.1 rport 54400
+//
+// Component 1 is RTP and 2 RTCP.
+type SDPCandidate struct {
+	Foundation string `json:"foundation"`
+	Component  int    `json:"component"`
+	Transport  string `json:"transport"`
+	Priority   uint32 `json:"priority"`
+	Address    string `json:"address"`
+	Port       int    `json:"port"`
+	Type       string `json:"type"`
+}
+
+// SDPMedia holds a m= line with its formats in the offered order.
+type SDPMedia struct {
+	Media      string         `json:"media"`
+	Port       string         `json:"port"`
+	Proto      string         `json:"proto"`
+	Formats    []SDPFormat    `json:"formats,omitempty"`
+	Candidates []SDPCandidate `json:"candidates,omitempty"`
+}
+
+// ParseSDPMedia extracts the media sections with their payload types and ICE candidates like
+//
+//	m=audio 49170 RTP/AVP 0 8 101
+//	a=rtpmap:101 telephone-event/8000
+//	a=candidate:1 1 udp 2113937151 192.0.2.1 54400 typ host
+//
+// Formats of non RTP media like T.38 are skipped.
+func ParseSDPMedia(payload []byte) []SDPMedia {
+	var medias []SDPMedia
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		switch {
+		case bytes.HasPrefix(line, []byte("m=")):
+			fields := strings.Fields(string(line[2:]))
+			if len(fields) < 3 {
+				continue
+			}
+			media := SDPMedia{Media: fields[0], Port: fields[1], Proto: fields[2]}
+			if strings.Contains(media.Proto, "RTP") {
+				for _, f := range fields[3:] {
+					pt, err := strconv.Atoi(f)
+					if err != nil || pt < 0 || pt > 127 {
+						continue
+					}
+					media.Formats = append(media.Formats, SDPFormat{PayloadType: pt, Codec: staticPayloadTypes[pt]})
+				}
+			}
+			medias = append(medias, media)
+		case bytes.HasPrefix(line, []byte("a=rtpmap:")) && len(medias) > 0:
+			// a=rtpmap:<payload type> <encoding name>/<clock rate>[/<encoding parameters>]
+			fields := strings.Fields(string(line[len("a=rtpmap:"):]))
+			if len(fields) != 2 {
+				continue
+			}
+			pt, err := strconv.Atoi(fields[0])
+			if err != nil {
+				continue
+			}
+			media := &medias[len(medias)-1]
+			for i := range media.Formats {
+				if media.Formats[i].PayloadType == pt {
+					media.Formats[i].Codec = fields[1]
+				}
+			}
+		case bytes.HasPrefix(line, []byte("a=candidate:")) && len(medias) > 0:
+			if c, ok := parseSDPCandidate(string(line[len("a=candidate:"):])); ok {
+				media := &medias[len(medias)-1]
+				media.Candidates = append(media.Candidates, c)
+			}
+		}
+	}
+	return medias
+}
+
+// parseSDPCandidate parses the value of a=candidate:
+// <foundation> <component> <transport> <priority> <address> <port> typ <type> ...
+func parseSDPCandidate(value string) (SDPCandidate, bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return SDPCandidate{}, false
+	}
+	component, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return SDPCandidate{}, false
+	}
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return SDPCandidate{}, false
+	}
+	port, err := strconv.Atoi(fields[5])
+	if err != nil || port < 0 || port > 65535 {
+		return SDPCandidate{}, false
+	}
+	return SDPCandidate{
+		Foundation: fields[0],
+		Component:  component,
+		Transport:  strings.ToLower(fields[2]),
+		Priority:   uint32(priority),
+		Address:    fields[4],
+		Port:       port,
+		Type:       fields[7],
+	}, true
+}
+
+// Codec returns the first codec of the media section which isn't
+// a comfort noise or DTMF format. In an SDP answer this is the
+// negotiated codec.
+func (m SDPMedia) Codec() string {
+	for _, f := range m.Formats {
+		name := strings.ToLower(f.Codec)
+		if f.Codec == "" || strings.HasPrefix(name, "telephone-event") || strings.HasPrefix(name, "cn/") {
+			continue
+		}
+		return f.Codec
+	}
+	return ""
+}
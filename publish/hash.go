@@ -0,0 +1,193 @@
+package publish
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/negbie/logp"
+)
+
+// hashReplicas is the number of points per weight unit a target gets on the ring
+const hashReplicas = 100
+
+type hashTarget struct {
+	addr   string
+	weight int
+	out    *HEPOutputer
+}
+
+type ringPoint struct {
+	hash   uint32
+	target *hashTarget
+}
+
+// HashOutputer shards HEP messages over several HEP servers.
+// The server is chosen by consistent hashing of the correlation key, so
+// all messages of one call end up on the same server. If the chosen server
+// is down the next one on the ring is used.
+type HashOutputer struct {
+	sync.RWMutex
+	targets []*hashTarget
+	ring    []ringPoint
+}
+
+// NewHashOutputer creates a HEPOutputer for every address. An address can carry
+// an optional weight like 10.0.0.1:9060*2. The default weight is 1. Servers
+// which are unreachable at start stay on the ring as down until they connect.
+func NewHashOutputer(addrs []string) (*HashOutputer, error) {
+	ho := &HashOutputer{}
+	var total int
+	for _, addr := range addrs {
+		addr, weight, err := ParseWeightedAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		total += weight
+		ho.targets = append(ho.targets, &hashTarget{addr: addr, weight: weight})
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("all HEP servers in %v have weight 0", addrs)
+	}
+	for _, t := range ho.targets {
+		var err error
+		if t.out, err = NewHEPOutputer(t.addr); err != nil {
+			logp.Err("HEP server %s is down: %v", t.addr, err)
+			t.out = newDownHEPOutputer(t.addr)
+		}
+	}
+	ho.buildRing()
+	return ho, nil
+}
+
+// ParseWeightedAddr splits a HEP server address like 10.0.0.1:9060*2
+// into the address and its weight. The default weight is 1.
+func ParseWeightedAddr(s string) (string, int, error) {
+	s = strings.TrimSpace(s)
+	index := strings.LastIndex(s, "*")
+	if index < 0 {
+		return s, 1, nil
+	}
+	weight, err := strconv.Atoi(s[index+1:])
+	if err != nil || weight < 0 {
+		return "", 0, fmt.Errorf("invalid weight in HEP server address %s", s)
+	}
+	return s[:index], weight, nil
+}
+
+func hashKey(key []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32()
+}
+
+// buildRing must be called with the lock held or before the outputer is shared
+func (ho *HashOutputer) buildRing() {
+	ring := make([]ringPoint, 0, len(ho.targets)*hashReplicas)
+	for _, t := range ho.targets {
+		for i := 0; i < t.weight*hashReplicas; i++ {
+			ring = append(ring, ringPoint{hashKey([]byte(t.addr + "#" + strconv.Itoa(i))), t})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	ho.ring = ring
+}
+
+// SetWeight changes the weight of a HEP server and rebuilds the ring.
+// A weight of 0 takes the server out of rotation.
+func (ho *HashOutputer) SetWeight(addr string, weight int) error {
+	if weight < 0 {
+		return fmt.Errorf("invalid weight %d", weight)
+	}
+	return ho.setWeights(map[string]int{addr: weight})
+}
+
+// SetWeights changes the weights of the HEP servers in a list like
+// 10.0.0.1:9060*2,10.0.0.2:9060*0 as -hs takes it. Servers missing
+// in the list keep their weight.
+func (ho *HashOutputer) SetWeights(s string) error {
+	weights := make(map[string]int)
+	for _, addr := range strings.Split(s, ",") {
+		if strings.TrimSpace(addr) == "" {
+			continue
+		}
+		addr, weight, err := ParseWeightedAddr(addr)
+		if err != nil {
+			return err
+		}
+		weights[addr] = weight
+	}
+	return ho.setWeights(weights)
+}
+
+// setWeights applies the weights by address if all servers are known
+// and not all of them end up with weight 0.
+func (ho *HashOutputer) setWeights(weights map[string]int) error {
+	ho.Lock()
+	defer ho.Unlock()
+	var total int
+	for _, t := range ho.targets {
+		weight, ok := weights[t.addr]
+		if !ok {
+			weight = t.weight
+		}
+		total += weight
+	}
+	for addr := range weights {
+		if !ho.known(addr) {
+			return fmt.Errorf("unknown HEP server %s", addr)
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("all HEP servers would have weight 0")
+	}
+	for _, t := range ho.targets {
+		if weight, ok := weights[t.addr]; ok {
+			t.weight = weight
+		}
+	}
+	ho.buildRing()
+	return nil
+}
+
+func (ho *HashOutputer) known(addr string) bool {
+	for _, t := range ho.targets {
+		if t.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (ho *HashOutputer) pick(key []byte) *HEPOutputer {
+	ho.RLock()
+	defer ho.RUnlock()
+	if len(ho.ring) == 0 {
+		return nil
+	}
+	h := hashKey(key)
+	first := sort.Search(len(ho.ring), func(i int) bool { return ho.ring[i].hash >= h })
+	for i := 0; i < len(ho.ring); i++ {
+		t := ho.ring[(first+i)%len(ho.ring)].target
+		if !t.out.IsDown() {
+			return t.out
+		}
+	}
+	// Everything is down, stick to the owner of the key
+	return ho.ring[first%len(ho.ring)].target.out
+}
+
+// OutputKey sends the message to the HEP server which owns the key.
+func (ho *HashOutputer) OutputKey(key []byte, msg []byte) {
+	if out := ho.pick(key); out != nil {
+		out.Output(msg)
+	}
+}
+
+// Output sends messages without a correlation key to a fixed HEP server.
+func (ho *HashOutputer) Output(msg []byte) {
+	ho.OutputKey(nil, msg)
+}
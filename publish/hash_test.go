@@ -0,0 +1,94 @@
+package publish
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/negbie/heplify/config"
+)
+
+func TestHashOutputer(t *testing.T) {
+	config.Cfg.Network = "udp"
+	ho, err := NewHashOutputer([]string{"127.0.0.1:19060*2", "127.0.0.1:19061"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[*HEPOutputer]int)
+	for i := 0; i < 3000; i++ {
+		key := []byte("call-" + strconv.Itoa(i))
+		out := ho.pick(key)
+		if out != ho.pick(key) {
+			t.Fatalf("key %s is not mapped consistently", key)
+		}
+		counts[out]++
+	}
+	if len(counts) != 2 || counts[ho.targets[0].out] < counts[ho.targets[1].out] {
+		t.Errorf("weights are not respected: %v", counts)
+	}
+
+	if err := ho.SetWeight("127.0.0.1:19060", 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if ho.pick([]byte("call-"+strconv.Itoa(i))) != ho.targets[1].out {
+			t.Fatalf("target with weight 0 is still used")
+		}
+	}
+}
+
+func TestHashOutputerWeights(t *testing.T) {
+	config.Cfg.Network = "udp"
+	if _, err := NewHashOutputer([]string{"127.0.0.1:19060*0", "127.0.0.1:19061*0"}); err == nil {
+		t.Error("all weights 0 were accepted")
+	}
+
+	ho, err := NewHashOutputer([]string{"127.0.0.1:19060", "127.0.0.1:19061"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ho.SetWeights("127.0.0.1:19060*0, 127.0.0.1:19061*0"); err == nil {
+		t.Error("SetWeights() took all servers out of rotation")
+	}
+	if err := ho.SetWeights("127.0.0.1:19062*1"); err == nil {
+		t.Error("SetWeights() of an unknown server succeeded")
+	}
+	if err := ho.SetWeights("127.0.0.1:19061*0"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if ho.pick([]byte("call-"+strconv.Itoa(i))) != ho.targets[0].out {
+			t.Fatalf("target with weight 0 is still used")
+		}
+	}
+}
+
+func TestHashOutputerUnreachable(t *testing.T) {
+	config.Cfg.Network = "tcp"
+	defer func() { config.Cfg.Network = "udp" }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	ho, err := NewHashOutputer([]string{ln.Addr().String(), closed.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ho.targets) != 2 || !ho.targets[1].out.IsDown() || ho.targets[0].out.IsDown() {
+		t.Fatalf("unreachable server isn't kept as down: %+v", ho.targets)
+	}
+	for i := 0; i < 100; i++ {
+		if ho.pick([]byte("call-"+strconv.Itoa(i))) != ho.targets[0].out {
+			t.Fatalf("down server is used")
+		}
+	}
+}
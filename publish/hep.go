@@ -3,8 +3,11 @@ package publish
 import (
 	"bufio"
 	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/negbie/heplify/config"
 	"github.com/negbie/logp"
@@ -16,8 +19,19 @@ type HEPOutputer struct {
 	writer   *bufio.Writer
 	hepQueue chan []byte
 	errCnt   int
+	lastErr  int64
+	// down is set while a stream connection is being reestablished
+	down int32
+	// dropped counts the messages dropped because the hepQueue was full
+	dropped uint64
 }
 
+// downTime is how long a HEP server counts as down after a send error
+const downTime = 30 * time.Second
+
+// maxRetryWait caps the backoff between reconnects of a stream connection
+const maxRetryWait = 30 * time.Second
+
 func NewHEPOutputer(serverAddr string) (*HEPOutputer, error) {
 	ho := &HEPOutputer{
 		addr:     serverAddr,
@@ -32,6 +46,21 @@ func NewHEPOutputer(serverAddr string) (*HEPOutputer, error) {
 	return ho, nil
 }
 
+// newDownHEPOutputer returns a HEPOutputer for a server which is unreachable
+// at start. It counts as down until connecting to it succeeds.
+func newDownHEPOutputer(serverAddr string) *HEPOutputer {
+	ho := &HEPOutputer{
+		addr:     serverAddr,
+		hepQueue: make(chan []byte, 20000),
+		down:     1,
+	}
+	go func() {
+		ho.reconnect()
+		ho.Start()
+	}()
+	return ho
+}
+
 func (ho *HEPOutputer) Init() error {
 	var err error
 	if ho.conn, err = ho.ConnectServer(ho.addr); err != nil {
@@ -59,10 +88,34 @@ func (ho *HEPOutputer) ReConnect() error {
 	if ho.conn, err = ho.ConnectServer(ho.addr); err != nil {
 		return err
 	}
-	ho.writer.Reset(ho.conn)
+	if ho.writer == nil {
+		ho.writer = bufio.NewWriter(ho.conn)
+	} else {
+		ho.writer.Reset(ho.conn)
+	}
 	return nil
 }
 
+// reconnect retries to connect with backoff until it succeeds.
+// The server counts as down meanwhile.
+func (ho *HEPOutputer) reconnect() {
+	atomic.StoreInt32(&ho.down, 1)
+	wait := time.Second
+	for {
+		err := ho.ReConnect()
+		if err == nil {
+			atomic.StoreInt32(&ho.down, 0)
+			logp.Info("connected to %s", ho.addr)
+			return
+		}
+		logp.Err("reconnect error: %v", err)
+		time.Sleep(wait)
+		if wait *= 2; wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
+
 func (ho *HEPOutputer) ConnectServer(addr string) (conn net.Conn, err error) {
 	if config.Cfg.Network == "udp" {
 		if ho.conn, err = net.Dial("udp", addr); err != nil {
@@ -82,14 +135,32 @@ func (ho *HEPOutputer) ConnectServer(addr string) (conn net.Conn, err error) {
 	return ho.conn, nil
 }
 
+// Output queues msg for sending. A full queue drops msg, so a server
+// which is down doesn't block the publisher and the other servers.
 func (ho *HEPOutputer) Output(msg []byte) {
-	ho.hepQueue <- msg
+	select {
+	case ho.hepQueue <- msg:
+	default:
+		if n := atomic.AddUint64(&ho.dropped, 1); n%1000 == 1 {
+			logp.Warn("HEP queue of %s is full, dropped %d messages so far", ho.addr, n)
+		}
+	}
+}
+
+// Dropped returns the number of messages dropped because the queue was full.
+func (ho *HEPOutputer) Dropped() uint64 {
+	return atomic.LoadUint64(&ho.dropped)
 }
 
 func (ho *HEPOutputer) Send(msg []byte) {
+	if config.Cfg.Network != "udp" {
+		ho.sendStream(msg)
+		return
+	}
 	_, err := ho.writer.Write(msg)
 	err = ho.writer.Flush()
 	if err != nil {
+		atomic.StoreInt64(&ho.lastErr, time.Now().UnixNano())
 		ho.errCnt++
 		if ho.errCnt%64 == 0 {
 			ho.errCnt = 0
@@ -102,6 +173,41 @@ func (ho *HEPOutputer) Send(msg []byte) {
 	}
 }
 
+// sendStream writes msg over the persistent TCP or TLS connection. The length
+// field of the HEP3 header delimits the messages on the stream. bufio takes
+// care of partial writes. HEPv2 has no length field and is written as it is.
+// On an error the connection is reestablished and msg is sent again,
+// meanwhile the following messages wait in the hepQueue.
+func (ho *HEPOutputer) sendStream(msg []byte) {
+	if !config.Cfg.Protobuf && config.Cfg.HepVersion != 2 && (len(msg) < 6 || int(binary.BigEndian.Uint16(msg[4:6])) != len(msg)) {
+		logp.Warn("drop HEP message with invalid length header")
+		return
+	}
+
+	for {
+		_, err := ho.writer.Write(msg)
+		if err == nil {
+			err = ho.writer.Flush()
+		}
+		if err == nil {
+			return
+		}
+		atomic.StoreInt64(&ho.lastErr, time.Now().UnixNano())
+		logp.Err("send to %s failed: %v", ho.addr, err)
+		ho.reconnect()
+	}
+}
+
+// IsDown reports whether the HEP server is being reconnected
+// or sending to it failed recently.
+func (ho *HEPOutputer) IsDown() bool {
+	if atomic.LoadInt32(&ho.down) == 1 {
+		return true
+	}
+	lastErr := atomic.LoadInt64(&ho.lastErr)
+	return lastErr != 0 && time.Since(time.Unix(0, lastErr)) < downTime
+}
+
 func (ho *HEPOutputer) Start() {
 	for {
 		select {
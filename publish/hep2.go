@@ -0,0 +1,100 @@
+package publish
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/negbie/heplify/decoder"
+	"github.com/negbie/logp"
+)
+
+/* HEPv2 of captagent and the Kamailio sipcapture module
+0                   1                   2                   3
+0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|    Version    | Header length |    Family     |   Protocol    |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|          Source port          |       Destination port        |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|          Source and destination IPv4 or IPv6 address          |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                       Timestamp, seconds                      |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                    Timestamp, microseconds                    |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|          Capture ID           |  SIP payload ...
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+The ports are big endian, the timestamp and capture ID are little
+endian like captagent writes them on x86.
+*/
+
+const (
+	hep2Version = 2
+	hep2AFInet  = 2
+	hep2AFInet6 = 10
+)
+
+// makeHEP2 will construct a HEPv2 packet. HEPv2 has no payload type and
+// no authentication key, so only SIP is sent and the node ID is cut to 16 bit.
+func makeHEP2(h *decoder.Packet) []byte {
+	if h.ProtoType != 1 {
+		logp.Debug("hep", "Skip payload type %d which HEPv2 can't carry", h.ProtoType)
+		return nil
+	}
+	var family byte
+	var srcIP, dstIP net.IP
+	switch h.Version {
+	case 0x02:
+		family, srcIP, dstIP = hep2AFInet, h.SrcIP.To4(), h.DstIP.To4()
+	case 0x0a:
+		family, srcIP, dstIP = hep2AFInet6, h.SrcIP.To16(), h.DstIP.To16()
+	}
+	if srcIP == nil || dstIP == nil {
+		logp.Debug("hep", "Skip packet without IP addresses for HEPv2")
+		return nil
+	}
+
+	hdrLen := 8 + 2*len(srcIP) + 10
+	b := make([]byte, hdrLen, hdrLen+len(h.Payload))
+	b[0] = hep2Version
+	b[1] = byte(hdrLen)
+	b[2] = family
+	b[3] = h.Protocol
+	binary.BigEndian.PutUint16(b[4:], h.SrcPort)
+	binary.BigEndian.PutUint16(b[6:], h.DstPort)
+	copy(b[8:], srcIP)
+	copy(b[8+len(srcIP):], dstIP)
+	offset := 8 + 2*len(srcIP)
+	binary.LittleEndian.PutUint32(b[offset:], h.Tsec)
+	binary.LittleEndian.PutUint32(b[offset+4:], h.Tmsec)
+	binary.LittleEndian.PutUint16(b[offset+8:], uint16(h.NodeID))
+	return append(b, h.Payload...)
+}
+
+func (h *HepMsg) parseHep2(packet []byte) error {
+	if len(packet) < 8 || int(packet[1]) > len(packet) {
+		return fmt.Errorf("HEPv2 packet of %d bytes is too short", len(packet))
+	}
+	ipLen := 4
+	h.Version = 0x02
+	if packet[2] == hep2AFInet6 {
+		ipLen = 16
+		h.Version = 0x0a
+	}
+	if int(packet[1]) != 8+2*ipLen+10 {
+		return fmt.Errorf("HEPv2 header length is %d but should be %d", packet[1], 8+2*ipLen+10)
+	}
+	h.Protocol = packet[3]
+	h.SrcPort = binary.BigEndian.Uint16(packet[4:])
+	h.DstPort = binary.BigEndian.Uint16(packet[6:])
+	h.SrcIP = packet[8 : 8+ipLen]
+	h.DstIP = packet[8+ipLen : 8+2*ipLen]
+	offset := 8 + 2*ipLen
+	h.Tsec = binary.LittleEndian.Uint32(packet[offset:])
+	h.Tmsec = binary.LittleEndian.Uint32(packet[offset+4:])
+	h.NodeID = uint32(binary.LittleEndian.Uint16(packet[offset+8:]))
+	h.ProtoType = 1
+	h.Payload = packet[offset+10:]
+	return nil
+}
@@ -0,0 +1,166 @@
+package publish
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/heplify/decoder"
+)
+
+func hepFrame(payload string) []byte {
+	msg := append([]byte{0x48, 0x45, 0x50, 0x33, 0, 0}, payload...)
+	binary.BigEndian.PutUint16(msg[4:6], uint16(len(msg)))
+	return msg
+}
+
+func readHEPFrame(conn net.Conn) (string, error) {
+	head := make([]byte, 6)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return "", err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(head[4:6])-6)
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+func TestHEPOutputerTCPReconnect(t *testing.T) {
+	config.Cfg.Network = "tcp"
+	defer func() { config.Cfg.Network = "udp" }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ho, err := NewHEPOutputer(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ho.Output(hepFrame("first"))
+	ho.Output(hepFrame("second"))
+	for _, want := range []string{"first", "second"} {
+		if got, err := readHEPFrame(conn); err != nil || got != want {
+			t.Fatalf("readHEPFrame() = %q, %v, want %q", got, err, want)
+		}
+	}
+	conn.Close()
+
+	// Keep sending until the outputer notices the closed connection and reconnects
+	accepted := make(chan net.Conn)
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	timeout := time.After(10 * time.Second)
+	for {
+		ho.Output(hepFrame("again"))
+		select {
+		case conn = <-accepted:
+			defer conn.Close()
+			if got, err := readHEPFrame(conn); err != nil || got != "again" {
+				t.Fatalf("readHEPFrame() after reconnect = %q, %v", got, err)
+			}
+			return
+		case <-timeout:
+			t.Fatal("HEPOutputer did not reconnect")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestHEPOutputerTCPHEPv2(t *testing.T) {
+	hepVersion := config.Cfg.HepVersion
+	config.Cfg.Network, config.Cfg.HepVersion = "tcp", 2
+	defer func() { config.Cfg.Network, config.Cfg.HepVersion = "udp", hepVersion }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ho, err := NewHEPOutputer(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Bytes 4-6 of HEPv2 hold the source port 5060 and not the length
+	msg := makeHEP2(&decoder.Packet{Version: 0x02, Protocol: 0x11, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2},
+		SrcPort: 5060, DstPort: 5060, ProtoType: 1, Payload: []byte("OPTIONS sip:bob@b SIP/2.0\r\n\r\n")})
+	ho.Output(msg)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != string(msg) {
+		t.Fatalf("read %x, %v, want %x", got, err, msg)
+	}
+}
+
+func TestHEPOutputerDownWhileReconnecting(t *testing.T) {
+	config.Cfg.Network = "tcp"
+	defer func() { config.Cfg.Network = "udp" }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ho, err := NewHEPOutputer(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	ln.Close()
+
+	timeout := time.After(10 * time.Second)
+	for !ho.IsDown() {
+		ho.Output(hepFrame("lost"))
+		select {
+		case <-timeout:
+			t.Fatal("HEPOutputer did not notice the closed server")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// Long after the last send error the server is still down until it's reconnected
+	atomic.StoreInt64(&ho.lastErr, time.Now().Add(-2*downTime).UnixNano())
+	if !ho.IsDown() {
+		t.Error("HEPOutputer is up again while reconnecting")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < cap(ho.hepQueue)+100; i++ {
+			ho.Output(hepFrame("queued"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Output blocked on the full queue")
+	}
+	if ho.Dropped() == 0 {
+		t.Error("full queue dropped nothing")
+	}
+}
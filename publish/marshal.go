@@ -44,6 +44,11 @@ const (
 	Payload   = 15 // Chunk 0x000f Captured packet payload
 	CID       = 17 // Chunk 0x0011 Correlation ID
 	Vlan      = 18 // Chunk 0x0012 VLAN
+	CorrID    = 48 // Chunk 0x0030 Correlation ID selected with -cid
+	Fragments = 49 // Chunk 0x0031 Fragment count of reassembled IPv4 packets
+	Hash      = 50 // Chunk 0x0032 SHA1 of the SIP message for dedup
+	WireLen   = 51 // Chunk 0x0033 Length of the captured frame on the wire
+	Metadata  = 52 // Chunk 0x0034 JSON of the packet fields without a chunk
 )
 
 // HepMsg represents a parsed HEP packet
@@ -62,13 +67,22 @@ type HepMsg struct {
 	Payload   []byte
 	CID       []byte
 	Vlan      uint16
+	CorrID    []byte
+	Fragments uint16
+	Hash      []byte
+	WireLen   uint32
+	Metadata  []byte
 }
 
 // EncodeHEP creates the HEP Packet which
-// will be send to wire
+// will be send to wire. It returns nil for
+// packets which HEPv2 can't carry.
 func EncodeHEP(h *decoder.Packet) []byte {
 	var hepMsg []byte
 	var err error
+	if config.Cfg.MaxPayloadExport > 0 {
+		h = truncatePayload(h, config.Cfg.MaxPayloadExport)
+	}
 	if config.Cfg.Protobuf {
 		hep := &HEP{
 			Version:   uint32(h.Version),
@@ -90,6 +104,8 @@ func EncodeHEP(h *decoder.Packet) []byte {
 		if err != nil {
 			logp.Warn("%v", err)
 		}
+	} else if config.Cfg.HepVersion == 2 {
+		hepMsg = makeHEP2(h)
 	} else {
 		hepMsg = makeHEPChuncks(h)
 		binary.BigEndian.PutUint16(hepMsg[4:6], uint16(len(hepMsg)))
@@ -97,6 +113,68 @@ func EncodeHEP(h *decoder.Packet) []byte {
 	return hepMsg
 }
 
+// truncatePayload returns a copy of h whose SIP payload is cut after max bytes
+// and ends with a marker telling how many bytes were dropped. The Content-Length
+// is set to the remaining body with the marker, so the message stays parsable.
+// Other payloads like JSON are returned as is. The decoder correlated the
+// packet before with its full payload.
+func truncatePayload(h *decoder.Packet, max int) *decoder.Packet {
+	payload := h.Payload
+	if len(payload) <= max || h.ProtoType != 1 {
+		return h
+	}
+	sep := []byte("\r\n\r\n")
+	hdrEnd := bytes.Index(payload, sep)
+	if hdrEnd < 0 {
+		sep = []byte("\n\n")
+		hdrEnd = bytes.Index(payload, sep)
+	}
+
+	var head, body []byte
+	if bodyStart := hdrEnd + len(sep); hdrEnd >= 0 && bodyStart <= max {
+		head = payload[:bodyStart]
+		body = append(body, payload[bodyStart:max]...)
+		body = append(body, "\r\n"...)
+		body = append(body, fmt.Sprintf("[truncated %d bytes]", len(payload)-max)...)
+	} else {
+		// Keep the complete header lines and send only the marker as body
+		cut := bytes.LastIndexByte(payload[:max], '\n') + 1
+		head = append(append([]byte{}, payload[:cut]...), "\r\n"...)
+		body = []byte(fmt.Sprintf("[truncated %d bytes]", len(payload)-cut))
+	}
+
+	c := *h
+	c.Payload = append(setContentLength(head, len(body)), body...)
+	logp.Debug("payload", "Truncate payload of %s from %d to %d bytes", string(h.CID), len(payload), len(c.Payload))
+	return &c
+}
+
+// setContentLength returns a copy of the SIP headers head with the value
+// of the Content-Length header or its compact form l replaced by n.
+func setContentLength(head []byte, n int) []byte {
+	b := make([]byte, 0, len(head)+8)
+	for len(head) > 0 {
+		end := bytes.IndexByte(head, '\n') + 1
+		if end == 0 {
+			end = len(head)
+		}
+		line := head[:end]
+		head = head[end:]
+		if colon := bytes.IndexByte(line, ':'); colon > 0 {
+			name := bytes.TrimSpace(line[:colon])
+			if bytes.EqualFold(name, []byte("Content-Length")) || bytes.EqualFold(name, []byte("l")) {
+				b = append(b, line[:colon+1]...)
+				b = append(b, ' ')
+				b = strconv.AppendInt(b, int64(n), 10)
+				b = append(b, "\r\n"...)
+				continue
+			}
+		}
+		b = append(b, line...)
+	}
+	return b
+}
+
 // makeHEPChuncks will construct the respective HEP chunck
 func makeHEPChuncks(h *decoder.Packet) []byte {
 	var b bytes.Buffer
@@ -200,6 +278,58 @@ func makeHEPChuncks(h *decoder.Packet) []byte {
 		b.Write(hepLen)
 		b.Write(h.CID)
 	}
+
+	if h.CorrID != nil {
+		// Chunk correlation id selected with -cid
+		b.Write([]byte{0x00, 0x00, 0x00, 0x30})
+		binary.BigEndian.PutUint16(hepLen, 6+uint16(len(h.CorrID)))
+		b.Write(hepLen)
+		b.Write(h.CorrID)
+	}
+
+	for _, hc := range h.HeaderChunks {
+		// Chunk SIP header selected with -hhc
+		b.Write([]byte{0x00, 0x00})
+		binary.BigEndian.PutUint16(chunck16, hc.Type)
+		b.Write(chunck16)
+		binary.BigEndian.PutUint16(hepLen, 6+uint16(len(hc.Value)))
+		b.Write(hepLen)
+		b.Write(hc.Value)
+	}
+
+	if h.Reassembled && config.Cfg.ExportFragments {
+		// Chunk fragment count of reassembled packets
+		b.Write([]byte{0x00, 0x00, 0x00, 0x31})
+		b.Write(hepLen8)
+		binary.BigEndian.PutUint16(chunck16, uint16(h.Fragments))
+		b.Write(chunck16)
+	}
+
+	if h.Hash != nil {
+		// Chunk SHA1 of the SIP message selected with -hmh
+		b.Write([]byte{0x00, 0x00, 0x00, 0x32})
+		binary.BigEndian.PutUint16(hepLen, 6+uint16(len(h.Hash)))
+		b.Write(hepLen)
+		b.Write(h.Hash)
+	}
+
+	if h.WireLength > 0 && config.Cfg.ExportWireLength {
+		// Chunk wire length of the captured frame selected with -hwl
+		b.Write([]byte{0x00, 0x00, 0x00, 0x33})
+		b.Write(hepLen10)
+		binary.BigEndian.PutUint32(chunck32, h.WireLength)
+		b.Write(chunck32)
+	}
+
+	if config.Cfg.ExportMetadata {
+		if md := h.Metadata(); md != nil {
+			// Chunk JSON of the packet fields without a chunk selected with -hmd
+			b.Write([]byte{0x00, 0x00, 0x00, 0x34})
+			binary.BigEndian.PutUint16(hepLen, 6+uint16(len(md)))
+			b.Write(hepLen)
+			b.Write(md)
+		}
+	}
 	/*
 		// Chunk VLAN
 		b.Write([]byte{0x00, 0x00, 0x00, 0x12})
@@ -230,7 +360,10 @@ func (h *HepMsg) parse(packet []byte) error {
 	if packet[0] == 0x48 && packet[3] == 0x33 {
 		return h.parseHep(packet)
 	}
-	return errors.New("Not a valid HEP3 packet")
+	if packet[0] == hep2Version {
+		return h.parseHep2(packet)
+	}
+	return errors.New("Not a valid HEP2 or HEP3 packet")
 }
 
 func (h *HepMsg) parseHep(packet []byte) error {
@@ -283,6 +416,16 @@ func (h *HepMsg) parseHep(packet []byte) error {
 			h.CID = chunkBody
 		case Vlan:
 			h.Vlan = binary.BigEndian.Uint16(chunkBody)
+		case CorrID:
+			h.CorrID = chunkBody
+		case Fragments:
+			h.Fragments = binary.BigEndian.Uint16(chunkBody)
+		case Hash:
+			h.Hash = chunkBody
+		case WireLen:
+			h.WireLen = binary.BigEndian.Uint32(chunkBody)
+		case Metadata:
+			h.Metadata = chunkBody
 		default:
 		}
 		currentByte += chunkLength
@@ -308,6 +451,11 @@ func (h *HepMsg) String() string {
 		`Payload:` + fmt.Sprintf("%s", strconv.Quote(string(h.Payload))) + `,`,
 		`CID:` + fmt.Sprintf("%s", h.CID) + `,`,
 		`Vlan:` + fmt.Sprintf("%v", h.Vlan) + `,`,
+		`CorrID:` + fmt.Sprintf("%s", h.CorrID) + `,`,
+		`Fragments:` + fmt.Sprintf("%v", h.Fragments) + `,`,
+		`Hash:` + fmt.Sprintf("%x", h.Hash) + `,`,
+		`WireLen:` + fmt.Sprintf("%v", h.WireLen) + `,`,
+		`Metadata:` + fmt.Sprintf("%s", h.Metadata) + `,`,
 		`}`,
 	}, "")
 	return s
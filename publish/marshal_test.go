@@ -1,11 +1,13 @@
 package publish
 
 import (
+	"net"
 	"testing"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/negbie/heplify/config"
 	"github.com/negbie/heplify/decoder"
 	"github.com/stretchr/testify/assert"
 )
@@ -21,6 +23,12 @@ func TestEncodeDecodeHEP(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
+	assert.Equal(t, []byte("BC099884@6dfcffe8"), pktIn.CID)
+	pktIn.CorrID = []byte("4a8d3c1f0b2e6d57")
+	pktIn.Reassembled, pktIn.Fragments = true, 3
+	pktIn.Hash = []byte{0xde, 0xad, 0xbe, 0xef}
+	config.Cfg.ExportFragments, config.Cfg.ExportWireLength = true, true
+	defer func() { config.Cfg.ExportFragments, config.Cfg.ExportWireLength = false, false }()
 	for i := 0; i < 10000; i++ {
 		hep := EncodeHEP(pktIn)
 		pktOut, err := DecodeHEP(hep)
@@ -42,9 +50,89 @@ func TestEncodeDecodeHEP(t *testing.T) {
 		assert.Equal(t, pktIn.Payload, pktOut.Payload)
 		assert.Equal(t, pktIn.CID, pktOut.CID)
 		assert.Equal(t, pktIn.Vlan, pktOut.Vlan)
+		assert.Equal(t, pktIn.CorrID, pktOut.CorrID)
+		assert.Equal(t, uint16(pktIn.Fragments), pktOut.Fragments)
+		assert.Equal(t, pktIn.Hash, pktOut.Hash)
+		assert.Equal(t, uint32(715), pktOut.WireLen)
 	}
 }
 
+func TestEncodeHEPSnaplen(t *testing.T) {
+	config.Cfg.ExportWireLength = true
+	defer func() { config.Cfg.ExportWireLength = false }()
+
+	d := decoder.NewDecoder(layers.LinkTypeEthernet)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: 300, Length: 715}
+	pktIn, err := d.Process(rawPacket[:300], &ci)
+	if err != nil || pktIn == nil {
+		t.Fatalf("Process() = %v, %v", pktIn, err)
+	}
+	pktOut, err := DecodeHEP(EncodeHEP(pktIn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint32(715), pktOut.WireLen)
+	assert.Equal(t, rawPacket[42:300], pktOut.Payload)
+}
+
+func TestEncodeHEPMetadata(t *testing.T) {
+	pktIn := &decoder.Packet{Version: 0x02, Protocol: 0x11, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2},
+		SrcPort: 5060, DstPort: 5060, ProtoType: 1, Payload: []byte("OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n"),
+		IfaceName: "eth1", IfaceIndex: 2, TTL: 64, NoMagicCookie: true}
+	pktOut, err := DecodeHEP(EncodeHEP(pktIn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, pktOut.Metadata)
+
+	config.Cfg.ExportMetadata = true
+	defer func() { config.Cfg.ExportMetadata = false }()
+	if pktOut, err = DecodeHEP(EncodeHEP(pktIn)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `{"iface_name":"eth1","iface_index":2,"ttl":64,"no_magic_cookie":true}`, string(pktOut.Metadata))
+
+	// Packets without any of the fields get no chunk
+	pktIn.IfaceName, pktIn.IfaceIndex, pktIn.TTL, pktIn.NoMagicCookie = "", 0, 0, false
+	if pktOut, err = DecodeHEP(EncodeHEP(pktIn)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, pktOut.Metadata)
+}
+
+func TestEncodeDecodeHEP2(t *testing.T) {
+	config.Cfg.HepVersion = 2
+	defer func() { config.Cfg.HepVersion = 0 }()
+
+	pktIn := &decoder.Packet{Version: 0x0a, Protocol: 0x11, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2"),
+		SrcPort: 5060, DstPort: 5080, Tsec: 1600000000, Tmsec: 123456, ProtoType: 1, NodeID: 2002, Payload: []byte("OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n")}
+	for _, ip := range []net.IP{nil, net.IP{10, 0, 0, 1}} {
+		if ip != nil {
+			pktIn.Version, pktIn.SrcIP, pktIn.DstIP = 0x02, ip, net.IP{10, 0, 0, 2}
+		}
+		hep := EncodeHEP(pktIn)
+		pktOut, err := DecodeHEP(hep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, byte(2), hep[0])
+		assert.Equal(t, pktIn.Version, pktOut.Version)
+		assert.Equal(t, pktIn.Protocol, pktOut.Protocol)
+		assert.True(t, pktIn.SrcIP.Equal(pktOut.SrcIP))
+		assert.True(t, pktIn.DstIP.Equal(pktOut.DstIP))
+		assert.Equal(t, pktIn.SrcPort, pktOut.SrcPort)
+		assert.Equal(t, pktIn.DstPort, pktOut.DstPort)
+		assert.Equal(t, pktIn.Tsec, pktOut.Tsec)
+		assert.Equal(t, pktIn.Tmsec, pktOut.Tmsec)
+		assert.Equal(t, pktIn.NodeID, pktOut.NodeID)
+		assert.Equal(t, pktIn.ProtoType, pktOut.ProtoType)
+		assert.Equal(t, pktIn.Payload, pktOut.Payload)
+	}
+
+	pktIn.ProtoType = 5
+	assert.Nil(t, EncodeHEP(pktIn), "HEPv2 can't carry RTCP")
+}
+
 func BenchmarkEncodeHEP(b *testing.B) {
 	d := decoder.NewDecoder(layers.LinkTypeEthernet)
 	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: 715, Length: 715, InterfaceIndex: 4}
@@ -62,3 +150,29 @@ func BenchmarkDecodeHEP(b *testing.B) {
 		_ = val
 	}
 }
+
+func TestTruncatePayload(t *testing.T) {
+	sip := "NOTIFY sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: big@host\r\n" +
+		"l: 26\r\n\r\n" +
+		"abcdefghijklmnopqrstuvwxyz"
+	h := &decoder.Packet{ProtoType: 1, Payload: []byte(sip)}
+
+	// The body is cut and the Content-Length covers it with the marker
+	c := truncatePayload(h, len(sip)-16)
+	want := "NOTIFY sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: big@host\r\n" +
+		"l: 32\r\n\r\n" +
+		"abcdefghij\r\n[truncated 16 bytes]"
+	assert.Equal(t, want, string(c.Payload))
+	assert.Equal(t, sip, string(h.Payload))
+
+	// A cut inside the headers keeps the complete header lines
+	c = truncatePayload(h, 40)
+	assert.Equal(t, "NOTIFY sip:bob@example.com SIP/2.0\r\n\r\n[truncated 54 bytes]", string(c.Payload))
+
+	// Short and non SIP payloads are sent as is
+	assert.Equal(t, h, truncatePayload(h, len(sip)))
+	log := &decoder.Packet{ProtoType: 100, Payload: []byte(`{"type":"mos","mos":4.1}`)}
+	assert.Equal(t, log, truncatePayload(log, 10))
+}
@@ -11,6 +11,12 @@ type Outputer interface {
 	Output(msg []byte)
 }
 
+// KeyOutputer is an Outputer which routes messages by a correlation key.
+type KeyOutputer interface {
+	Outputer
+	OutputKey(key []byte, msg []byte)
+}
+
 type Publisher struct {
 	pktQueue chan *decoder.Packet
 	pubCount int
@@ -33,22 +39,41 @@ func (pub *Publisher) PublishEvent(pkt *decoder.Packet) {
 	pub.pktQueue <- pkt
 }
 
-func (pub *Publisher) output(msg []byte) {
+func (pub *Publisher) output(pkt *decoder.Packet, msg []byte) {
 	defer func() {
 		if err := recover(); err != nil {
 			logp.Err("recover %v", err)
 		}
 	}()
+	if ko, ok := pub.outputer.(KeyOutputer); ok {
+		ko.OutputKey(correlationKey(pkt), msg)
+		return
+	}
 	pub.outputer.Output(msg)
 }
 
+// correlationKey returns the Call-ID of a packet. RTCP and logs
+// carry it as CID, SIP messages inside their payload.
+func correlationKey(pkt *decoder.Packet) []byte {
+	if pkt.CID != nil {
+		return pkt.CID
+	}
+	if pkt.ProtoType == 1 {
+		return decoder.ExtractCallID(pkt.Payload)
+	}
+	return nil
+}
+
 func (pub *Publisher) Start() {
 	for {
 		select {
 		case pkt := <-pub.pktQueue:
 			pub.pubCount++
 			msg := EncodeHEP(pkt)
-			pub.output(msg)
+			if msg == nil {
+				continue
+			}
+			pub.output(pkt, msg)
 		}
 	}
 }
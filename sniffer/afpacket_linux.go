@@ -3,6 +3,8 @@
 package sniffer
 
 import (
+	"fmt"
+	"os"
 	"time"
 
 	"golang.org/x/net/bpf"
@@ -11,6 +13,7 @@ import (
 	"github.com/google/gopacket/afpacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/negbie/heplify/config"
 	"github.com/negbie/logp"
 )
 
@@ -18,6 +21,34 @@ type afpacketHandle struct {
 	TPacket *afpacket.TPacket
 }
 
+func init() {
+	captureBackends["af_packet"] = openAfpacket
+}
+
+// openAfpacket captures with a memory mapped af_packet socket of about -b MB.
+func openAfpacket(cfg *config.InterfacesConfig, filter string) (captureHandle, error) {
+	if cfg.BufferSizeMb <= 0 {
+		cfg.BufferSizeMb = 32
+	}
+
+	szFrame, szBlock, numBlocks, err := afpacketComputeSize(cfg.BufferSizeMb, cfg.Snaplen, os.Getpagesize())
+	if err != nil {
+		return nil, fmt.Errorf("setting af_packet computesize: %v", err)
+	}
+
+	h, err := newAfpacketHandle(cfg.Device, szFrame, szBlock, numBlocks, pcap.BlockForever)
+	if err != nil {
+		return nil, fmt.Errorf("setting af_packet handle: %v", err)
+	}
+
+	err = h.SetBPFFilter(filter, cfg.Snaplen)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("SetBPFFilter '%s' for af_packet: %v", filter, err)
+	}
+	return h, nil
+}
+
 func newAfpacketHandle(device string, snaplen int, block_size int, num_blocks int,
 	timeout time.Duration) (*afpacketHandle, error) {
 
@@ -85,8 +116,12 @@ func (h *afpacketHandle) Close() {
 	h.TPacket.Close()
 }
 
-func (h *afpacketHandle) Stats() (stats afpacket.Stats, err error) {
-	return h.TPacket.Stats()
+func (h *afpacketHandle) Stats() (string, error) {
+	_, stats, err := h.TPacket.SocketStats()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Stats {received dropped queue-freeze}: %d", stats), nil
 }
 
 func (h *afpacketHandle) SocketStats() (ass afpacket.SocketStats, asss afpacket.SocketStatsV3, err error) {
@@ -4,31 +4,14 @@ package sniffer
 
 import (
 	"fmt"
-	"time"
 
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
+	"github.com/negbie/heplify/config"
 )
 
-type afpacketHandle struct {
+func init() {
+	captureBackends["af_packet"] = openAfpacket
 }
 
-func newAfpacketHandle(device string, snaplen int, blockSize int, numBlocks int,
-	timeout time.Duration) (*afpacketHandle, error) {
-	return nil, fmt.Errorf("Afpacket MMAP sniffing is only available on Linux")
-}
-
-func (h *afpacketHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
-	return data, ci, fmt.Errorf("Afpacket MMAP sniffing is only available on Linux")
-}
-
-func (h *afpacketHandle) SetBPFFilter(expr string) (_ error) {
-	return fmt.Errorf("Afpacket MMAP sniffing is only available on Linux")
-}
-
-func (h *afpacketHandle) LinkType() layers.LinkType {
-	return layers.LinkTypeEthernet
-}
-
-func (h *afpacketHandle) Close() {
+func openAfpacket(cfg *config.InterfacesConfig, filter string) (captureHandle, error) {
+	return nil, fmt.Errorf("Afpacket MMAP sniffing is only available on Linux, use -t pcap")
 }
@@ -0,0 +1,68 @@
+package sniffer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/negbie/heplify/config"
+)
+
+// captureHandle is a live capture backend selected with -t.
+type captureHandle interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+	// Stats returns the capture counters of the backend as log line
+	Stats() (string, error)
+	Close()
+}
+
+// captureBackends opens the live capture of the backend by its -t name
+// with the BPF filter. Platform specific backends add themselves in init.
+var captureBackends = map[string]func(cfg *config.InterfacesConfig, filter string) (captureHandle, error){
+	"pcap": openPcap,
+}
+
+// openCapture opens the live capture of the -t backend.
+func openCapture(cfg *config.InterfacesConfig, filter string) (captureHandle, error) {
+	open, ok := captureBackends[cfg.Type]
+	if !ok {
+		names := make([]string, 0, len(captureBackends))
+		for name := range captureBackends {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown sniffer type: %s, use one of %s", cfg.Type, strings.Join(names, ", "))
+	}
+	return open(cfg, filter)
+}
+
+// pcapCapture captures with libpcap or with npcap on Windows. The npcap
+// loopback adapter delivers its packets with the link type NULL.
+type pcapCapture struct {
+	*pcap.Handle
+}
+
+func openPcap(cfg *config.InterfacesConfig, filter string) (captureHandle, error) {
+	handle, err := pcap.OpenLive(cfg.Device, int32(cfg.Snaplen), true, pcap.BlockForever)
+	if err != nil {
+		return nil, fmt.Errorf("setting pcap live mode: %v", err)
+	}
+	if err = handle.SetBPFFilter(filter); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("SetBPFFilter '%s' for pcap: %v", filter, err)
+	}
+	return pcapCapture{handle}, nil
+}
+
+func (h pcapCapture) Stats() (string, error) {
+	stats, err := h.Handle.Stats()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Stats {received dropped-os dropped-int}: {%d %d %d}",
+		stats.PacketsReceived, stats.PacketsDropped, stats.PacketsIfDropped), nil
+}
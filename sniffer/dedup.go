@@ -0,0 +1,112 @@
+package sniffer
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// frameDedup drops identical frames seen twice within a short window,
+// like the same traffic mirrored by two SPAN sessions. It works on the
+// raw frame before decoding. The link layer header, the IPv4 TTL and
+// checksum and the IPv6 hop limit are ignored because they change with
+// the mirror path.
+type frameDedup struct {
+	window    time.Duration
+	linkType  layers.LinkType
+	seen      map[uint64]time.Time
+	lastClean time.Time
+	dropped   uint64
+}
+
+func newFrameDedup(window time.Duration, linkType layers.LinkType) *frameDedup {
+	return &frameDedup{
+		window:   window,
+		linkType: linkType,
+		seen:     make(map[uint64]time.Time),
+	}
+}
+
+// networkOffset returns the start of the IP header or -1.
+func (fd *frameDedup) networkOffset(data []byte) int {
+	var offset int
+	switch fd.linkType {
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4, layers.LinkTypeIPv6, 12, 14:
+		// Raw IP, live captures report DLT_RAW as 12 or 14
+		offset = 0
+	case layers.LinkTypeLinuxSLL:
+		offset = 16
+	case layers.LinkTypeEthernet:
+		offset = 14
+		// Skip VLAN tags, mirrors often add or strip them
+		for len(data) >= offset {
+			etherType := binary.BigEndian.Uint16(data[offset-2 : offset])
+			if etherType != uint16(layers.EthernetTypeDot1Q) && etherType != uint16(layers.EthernetTypeQinQ) {
+				break
+			}
+			offset += 4
+		}
+		// Skip the PPPoE session and PPP protocol headers
+		if len(data) >= offset && binary.BigEndian.Uint16(data[offset-2:offset]) == uint16(layers.EthernetTypePPPoESession) {
+			offset += 8
+		}
+	default:
+		return -1
+	}
+	if offset >= len(data) {
+		return -1
+	}
+	return offset
+}
+
+// hash returns a hash of the frame without the volatile fields.
+func (fd *frameDedup) hash(data []byte) uint64 {
+	h := fnv.New64a()
+	offset := fd.networkOffset(data)
+	if offset < 0 {
+		h.Write(data)
+		return h.Sum64()
+	}
+
+	ip := data[offset:]
+	switch {
+	case ip[0]>>4 == 4 && len(ip) >= 20:
+		h.Write(ip[:8])
+		h.Write(ip[9:10])
+		h.Write(ip[12:])
+	case ip[0]>>4 == 6 && len(ip) >= 40:
+		h.Write(ip[:7])
+		h.Write(ip[8:])
+	default:
+		h.Write(ip)
+	}
+	return h.Sum64()
+}
+
+// isDuplicate reports whether the same frame was seen within the window before ts.
+func (fd *frameDedup) isDuplicate(data []byte, ts time.Time) bool {
+	if ts.Sub(fd.lastClean) > fd.window {
+		for key, seen := range fd.seen {
+			if ts.Sub(seen) > fd.window {
+				delete(fd.seen, key)
+			}
+		}
+		fd.lastClean = ts
+	}
+
+	key := fd.hash(data)
+	if seen, ok := fd.seen[key]; ok && ts.Sub(seen) <= fd.window {
+		atomic.AddUint64(&fd.dropped, 1)
+		return true
+	}
+	fd.seen[key] = ts
+	return false
+}
+
+// Dropped returns the number of dropped duplicate frames.
+func (fd *frameDedup) Dropped() uint64 {
+	return atomic.LoadUint64(&fd.dropped)
+}
@@ -0,0 +1,51 @@
+package sniffer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestFrameDedup(t *testing.T) {
+	fd := newFrameDedup(10*time.Millisecond, layers.LinkTypeEthernet)
+	frame := func(ttl byte, vlan bool) []byte {
+		eth := []byte{0, 1, 2, 3, 4, 5, 0, 1, 2, 3, 4, 6, 0x08, 0x00}
+		if vlan {
+			eth = []byte{0, 1, 2, 3, 4, 5, 0, 1, 2, 3, 4, 7, 0x81, 0x00, 0x00, 0x64, 0x08, 0x00}
+		}
+		ip := []byte{0x45, 0, 0, 28, 0, 1, 0, 0, ttl, 17, ttl, ttl, 10, 0, 0, 1, 10, 0, 0, 2, 0x13, 0xc4, 0x13, 0xc4, 0, 8, 0, 0}
+		return append(eth, ip...)
+	}
+
+	now := time.Now()
+	if fd.isDuplicate(frame(64, false), now) {
+		t.Fatal("first frame is a duplicate")
+	}
+	if !fd.isDuplicate(frame(63, true), now.Add(time.Millisecond)) {
+		t.Error("mirrored copy with other TTL and VLAN tag was not dropped")
+	}
+	if fd.isDuplicate(frame(64, false), now.Add(50*time.Millisecond)) {
+		t.Error("frame outside the window was dropped")
+	}
+	if fd.Dropped() != 1 {
+		t.Errorf("Dropped() = %d, want 1", fd.Dropped())
+	}
+}
+
+func TestFrameDedupPPPoE(t *testing.T) {
+	fd := newFrameDedup(10*time.Millisecond, layers.LinkTypeEthernet)
+	frame := func(ttl byte) []byte {
+		eth := []byte{0, 1, 2, 3, 4, 5, 0, 1, 2, 3, 4, 6, 0x88, 0x64, 0x11, 0x00, 0x12, 0x34, 0x00, 30, 0x00, 0x21}
+		ip := []byte{0x45, 0, 0, 28, 0, 1, 0, 0, ttl, 17, ttl, ttl, 10, 0, 0, 1, 10, 0, 0, 2, 0x13, 0xc4, 0x13, 0xc4, 0, 8, 0, 0}
+		return append(eth, ip...)
+	}
+
+	now := time.Now()
+	if fd.isDuplicate(frame(64), now) {
+		t.Fatal("first frame is a duplicate")
+	}
+	if !fd.isDuplicate(frame(63), now.Add(time.Millisecond)) {
+		t.Error("copy with other TTL inside PPPoE was not dropped")
+	}
+}
@@ -2,9 +2,13 @@ package sniffer
 
 import (
 	"fmt"
+	"net"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/google/gopacket/pcap"
 
@@ -97,3 +101,104 @@ func filterDeviceName(name []string) {
 		fmt.Printf("-i %s\n", d)
 	}
 }
+
+// PrintDevices prints the capture devices with their addresses and link type.
+func PrintDevices() error {
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return fmt.Errorf("Error getting devices list: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tLINK TYPE\tADDRESSES")
+	for _, dev := range devices {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", dev.Name, deviceLinkType(dev.Name), deviceAddresses(dev))
+	}
+	return w.Flush()
+}
+
+// deviceLinkType opens the device shortly because pcap
+// only reports the link type of an open handle.
+func deviceLinkType(name string) string {
+	handle, err := pcap.OpenLive(name, 64, false, time.Millisecond)
+	if err != nil {
+		return "unknown"
+	}
+	defer handle.Close()
+	return handle.LinkType().String()
+}
+
+func deviceAddresses(dev pcap.Interface) string {
+	if len(dev.Addresses) == 0 {
+		return "-"
+	}
+	ips := make([]string, 0, len(dev.Addresses))
+	for _, address := range dev.Addresses {
+		ips = append(ips, address.IP.String())
+	}
+	return strings.Join(ips, " ")
+}
+
+// deviceIndex returns the OS interface index of a capture device or 0.
+// npcap names devices like \Device\NPF_{GUID}, so on Windows the
+// interface is found by the addresses pcap reports for the device.
+func deviceIndex(name string) int {
+	if iface, err := net.InterfaceByName(name); err == nil {
+		return iface.Index
+	}
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return 0
+	}
+	for _, dev := range devices {
+		if dev.Name != name {
+			continue
+		}
+		for _, address := range dev.Addresses {
+			if iface := interfaceByIP(address.IP); iface != nil {
+				return iface.Index
+			}
+		}
+	}
+	return 0
+}
+
+// interfaceByIP returns the OS interface with the address ip or nil.
+func interfaceByIP(ip net.IP) *net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return &ifaces[i]
+			}
+		}
+	}
+	return nil
+}
+
+// checkDevice returns an error with the valid device names if the
+// capture device doesn't exist or is down.
+func checkDevice(name string, devices []pcap.Interface) error {
+	names := make([]string, 0, len(devices))
+	found := false
+	for _, dev := range devices {
+		names = append(names, dev.Name)
+		if dev.Name == name {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("interface %q doesn't exist, use one of: %s", name, strings.Join(names, ", "))
+	}
+	// pcap device names on Windows are no OS interface names
+	if iface, err := net.InterfaceByName(name); err == nil && iface.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("interface %q is down, use one of: %s", name, strings.Join(names, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package sniffer
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/pcap"
+	"github.com/negbie/heplify/config"
+)
+
+func TestCheckDevice(t *testing.T) {
+	devices := []pcap.Interface{{Name: "heplify0"}, {Name: "heplify1"}}
+
+	if err := checkDevice("heplify1", devices); err != nil {
+		t.Errorf("checkDevice(heplify1) = %v", err)
+	}
+
+	err := checkDevice("heplfy0", devices)
+	if err == nil {
+		t.Fatal("checkDevice accepted a mistyped interface")
+	}
+	if !strings.Contains(err.Error(), "heplify0, heplify1") {
+		t.Errorf("error %q doesn't list the valid interfaces", err)
+	}
+}
+
+func TestOpenCapture(t *testing.T) {
+	_, err := openCapture(&config.InterfacesConfig{Type: "pf_ring"}, "")
+	if err == nil || !strings.Contains(err.Error(), "af_packet, pcap") {
+		t.Errorf("openCapture() of an unknown type = %v", err)
+	}
+	if iface := interfaceByIP(net.IP{127, 0, 0, 1}); iface != nil && deviceIndex(iface.Name) != iface.Index {
+		t.Errorf("deviceIndex(%s) = %d, want %d", iface.Name, deviceIndex(iface.Name), iface.Index)
+	}
+}
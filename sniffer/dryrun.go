@@ -0,0 +1,86 @@
+package sniffer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/negbie/heplify/decoder"
+)
+
+// hepTypeNames maps the HEP protocol types set by the decoder to a readable name
+var hepTypeNames = map[byte]string{
+	1:   "SIP",
+	5:   "RTCP",
+	53:  "DNS",
+	100: "LOG",
+}
+
+// DryRunWorker runs the full decode and correlation path but only counts
+// what would be sent. It never connects to a HEP server.
+type DryRunWorker struct {
+	decoder *decoder.Decoder
+	packets int
+	types   map[byte]int
+	drops   map[string]int
+}
+
+func NewDryRunWorker(lt layers.LinkType) *DryRunWorker {
+	return &DryRunWorker{
+		decoder: decoder.NewDecoder(lt),
+		types:   make(map[byte]int),
+		drops:   make(map[string]int),
+	}
+}
+
+func (dw *DryRunWorker) SetInterface(index int, name string, linkType layers.LinkType) {
+	dw.decoder.SetInterface(index, name, linkType)
+}
+
+func (dw *DryRunWorker) OnPacket(data []byte, ci *gopacket.CaptureInfo) {
+	dw.packets++
+	if pkt, _ := dw.decoder.Process(data, ci); pkt != nil {
+		dw.types[pkt.ProtoType]++
+	}
+	for _, stacked := range dw.decoder.StackedPackets() {
+		dw.types[stacked.ProtoType]++
+	}
+	// The decoder resets its counts when it logs them every minute
+	for reason, count := range dw.decoder.DropCounts() {
+		dw.drops[reason] += count
+	}
+}
+
+// Summary returns a printable summary of the decoded packets.
+func (dw *DryRunWorker) Summary() string {
+	var sent int
+	protoTypes := make([]int, 0, len(dw.types))
+	for protoType, count := range dw.types {
+		protoTypes = append(protoTypes, int(protoType))
+		sent += count
+	}
+	sort.Ints(protoTypes)
+
+	var dropped int
+	reasons := make([]string, 0, len(dw.drops))
+	for reason, count := range dw.drops {
+		reasons = append(reasons, reason)
+		dropped += count
+	}
+	sort.Strings(reasons)
+
+	s := fmt.Sprintf("Dry run decoded %d packets, would send: %d, dropped: %d\n",
+		dw.packets, sent, dropped)
+	for _, protoType := range protoTypes {
+		name, ok := hepTypeNames[byte(protoType)]
+		if !ok {
+			name = "unknown"
+		}
+		s += fmt.Sprintf("  HEP type %3d %-8s %d\n", protoType, name, dw.types[byte(protoType)])
+	}
+	for _, reason := range reasons {
+		s += fmt.Sprintf("  dropped %-24s %d\n", reason, dw.drops[reason])
+	}
+	return s
+}
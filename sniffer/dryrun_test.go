@@ -0,0 +1,27 @@
+package sniffer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestDryRunSummary(t *testing.T) {
+	invite := extractFrames(t)[0]
+	dw := NewDryRunWorker(layers.LinkTypeEthernet)
+	// The cut frame fails the IPv4 length check
+	for _, data := range [][]byte{invite, invite[:60]} {
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+		dw.OnPacket(data, &ci)
+	}
+
+	summary := dw.Summary()
+	for _, want := range []string{"decoded 2 packets, would send: 1, dropped: 1", "HEP type   1 SIP      1", "dropped parse error"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Summary() = %q, want %q", summary, want)
+		}
+	}
+}
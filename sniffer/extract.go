@@ -0,0 +1,131 @@
+package sniffer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/heplify/decoder"
+	"github.com/negbie/heplify/publish"
+	"github.com/negbie/logp"
+)
+
+// ExtractWorker writes all packets of one call into its own file. These are
+// the SIP messages with the Call-ID, the RTCP correlated to it and the RTP
+// and RTCP streams announced in its SDP. Files ending with .hep get the HEP
+// encoded SIP and RTCP, all others get the original frames as pcap.
+// Fragmented SIP is only written as the last fragment.
+type ExtractWorker struct {
+	decoder  *decoder.Decoder
+	callID   []byte
+	file     *os.File
+	pcap     *pcapgo.Writer
+	packets  int
+	captured int
+}
+
+func NewExtractWorker(lt layers.LinkType) (*ExtractWorker, error) {
+	f, err := os.Create(config.Cfg.ExtractFile)
+	if err != nil {
+		return nil, err
+	}
+	ew := &ExtractWorker{
+		decoder: decoder.NewDecoder(lt),
+		callID:  []byte(config.Cfg.ExtractCall),
+		file:    f,
+	}
+	if !strings.HasSuffix(config.Cfg.ExtractFile, ".hep") {
+		ew.pcap = pcapgo.NewWriter(f)
+		if err := ew.pcap.WriteFileHeader(uint32(config.Cfg.Iface.Snaplen), lt); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return ew, nil
+}
+
+func (ew *ExtractWorker) SetInterface(index int, name string, linkType layers.LinkType) {
+	ew.decoder.SetInterface(index, name, linkType)
+}
+
+func (ew *ExtractWorker) OnPacket(data []byte, ci *gopacket.CaptureInfo) {
+	ew.packets++
+	pkt, err := ew.decoder.Process(data, ci)
+	if err != nil {
+		return
+	}
+
+	var match bool
+	if pkt != nil {
+		if pkt.CID != nil {
+			match = bytes.Equal(decoder.DialogCallID(pkt.CID), ew.callID)
+		} else if pkt.ProtoType == 1 {
+			match = bytes.Equal(decoder.ExtractCallID(pkt.Payload), ew.callID)
+		}
+	}
+	if ew.pcap == nil {
+		// Dropped packets are nil and never match
+		if !match {
+			return
+		}
+		if msg := publish.EncodeHEP(pkt); msg != nil {
+			ew.write(msg)
+		}
+		return
+	}
+	if !match {
+		match = ew.isMedia(data, ci.InterfaceIndex)
+	}
+	if match {
+		if err := ew.pcap.WritePacket(*ci, data); err != nil {
+			logp.Err("write %s: %v", config.Cfg.ExtractFile, err)
+			return
+		}
+		ew.captured++
+	}
+}
+
+// isMedia tells if an UDP packet belongs to a media stream of the call.
+func (ew *ExtractWorker) isMedia(data []byte, index int) bool {
+	packet := gopacket.NewPacket(data, ew.decoder.LinkLayer(index), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	udpLayer := packet.Layer(layers.LayerTypeUDP)
+	if udpLayer == nil || packet.NetworkLayer() == nil {
+		return false
+	}
+	udp, ok := udpLayer.(*layers.UDP)
+	if !ok {
+		return false
+	}
+	src, dst := packet.NetworkLayer().NetworkFlow().Endpoints()
+	for _, ep := range []struct {
+		ip   []byte
+		port layers.UDPPort
+	}{{src.Raw(), udp.SrcPort}, {dst.Raw(), udp.DstPort}} {
+		if callID := ew.decoder.MediaCallID(ep.ip, uint16(ep.port)); bytes.Equal(callID, ew.callID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ew *ExtractWorker) write(msg []byte) {
+	if _, err := ew.file.Write(msg); err != nil {
+		logp.Err("write %s: %v", config.Cfg.ExtractFile, err)
+		return
+	}
+	ew.captured++
+}
+
+// Close flushes the file and returns a printable summary.
+func (ew *ExtractWorker) Close() string {
+	if err := ew.file.Close(); err != nil {
+		logp.Err("close %s: %v", config.Cfg.ExtractFile, err)
+	}
+	return fmt.Sprintf("Extracted %d of %d packets of call %s into %s\n",
+		ew.captured, ew.packets, ew.callID, config.Cfg.ExtractFile)
+}
@@ -0,0 +1,130 @@
+package sniffer
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/heplify/publish"
+)
+
+func udpFrame(t *testing.T, src, dst net.IP, srcPort, dstPort layers.UDPPort, payload []byte) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	udp := &layers.UDP{SrcPort: srcPort, DstPort: dstPort}
+	udp.SetNetworkLayerForChecksum(ip4)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip4, udp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extractFrames returns the SIP and RTP of call-1@host and call-2@host.
+func extractFrames(t *testing.T) [][]byte {
+	alice, bob := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	invite := func(callID, port string) []byte {
+		return udpFrame(t, alice, bob, 5060, 5060, []byte("INVITE sip:bob@10.0.0.2 SIP/2.0\r\n"+
+			"Call-ID: "+callID+"\r\n"+
+			"CSeq: 1 INVITE\r\n"+
+			"Content-Type: application/sdp\r\n\r\n"+
+			"v=0\r\n"+
+			"o=- 1 1 IN IP4 10.0.0.1\r\n"+
+			"c=IN IP4 10.0.0.1\r\n"+
+			"m=audio "+port+" RTP/AVP 0\r\n"))
+	}
+	rtp := []byte{0x80, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1, 0xff}
+
+	return [][]byte{
+		invite("call-1@host", "40000"),
+		invite("call-2@host", "30000"),
+		udpFrame(t, alice, bob, 40000, 50000, rtp),
+		udpFrame(t, bob, alice, 50000, 40000, rtp),
+		udpFrame(t, bob, alice, 50000, 30000, rtp),
+	}
+}
+
+// runExtract runs the frames through an ExtractWorker writing to file.
+func runExtract(t *testing.T, file string) {
+	iface, extractCall, extractFile := config.Cfg.Iface, config.Cfg.ExtractCall, config.Cfg.ExtractFile
+	defer func() {
+		config.Cfg.Iface, config.Cfg.ExtractCall, config.Cfg.ExtractFile = iface, extractCall, extractFile
+	}()
+	config.Cfg.Iface = &config.InterfacesConfig{Snaplen: 8192}
+	config.Cfg.ExtractCall = "call-1@host"
+	config.Cfg.ExtractFile = file
+
+	ew, err := NewExtractWorker(layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range extractFrames(t) {
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(frame), Length: len(frame)}
+		ew.OnPacket(frame, &ci)
+	}
+	ew.Close()
+}
+
+func TestExtractWorker(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "call.pcap")
+	runExtract(t, file)
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for {
+		if _, _, err := r.ReadPacketData(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	if count != 3 {
+		t.Errorf("extracted %d packets, want 3", count)
+	}
+}
+
+func TestExtractWorkerHEP(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "call.hep")
+	// The dropped RTP packets must not be encoded
+	runExtract(t, file)
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	for len(data) >= 6 {
+		n := int(binary.BigEndian.Uint16(data[4:6]))
+		msg, err := publish.DecodeHEP(data[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls = append(calls, string(msg.CID))
+		data = data[n:]
+	}
+	if len(calls) != 1 || calls[0] != "call-1@host" {
+		t.Errorf("extracted HEP of %q, want only call-1@host", calls)
+	}
+}
@@ -0,0 +1,80 @@
+package sniffer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/google/gopacket/pcap"
+	"github.com/negbie/logp"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// openOffline opens a pcap file for reading. Gzipped files like archived
+// .pcap.gz captures are decompressed on the fly and handed to libpcap
+// through a pipe, so BPF filters and the original timestamps still work.
+func openOffline(file string) (*pcap.Handle, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	gzipped, err := isGzip(f)
+	if err != nil || !gzipped {
+		f.Close()
+		if strings.HasSuffix(file, ".gz") {
+			logp.Warn("%s has no gzip header, reading it as plain pcap", file)
+		}
+		return pcap.OpenOffline(file)
+	}
+
+	r, err := gunzipPipe(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// libpcap works on its own copy of the descriptor
+	defer r.Close()
+	return pcap.OpenOfflineFile(r)
+}
+
+// isGzip checks the magic bytes of f and rewinds it.
+func isGzip(f *os.File) (bool, error) {
+	magic := make([]byte, len(gzipMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return bytes.Equal(magic[:n], gzipMagic), nil
+}
+
+// gunzipPipe decompresses f in the background and returns the read end of
+// a pipe with the plain content. f is closed when the copy is done.
+func gunzipPipe(f *os.File) (*os.File, error) {
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		gz.Close()
+		return nil, err
+	}
+	go func() {
+		// EPIPE means the reader was closed before the end of file
+		if _, err := io.Copy(w, gz); err != nil && !errors.Is(err, syscall.EPIPE) {
+			logp.Warn("gunzip %s: %v", f.Name(), err)
+		}
+		w.Close()
+		gz.Close()
+		f.Close()
+	}()
+	return r, nil
+}
@@ -0,0 +1,53 @@
+package sniffer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGunzipPipe(t *testing.T) {
+	content := bytes.Repeat([]byte{0xd4, 0xc3, 0xb2, 0xa1, 0x02, 0x00}, 20000)
+	name := filepath.Join(t.TempDir(), "capture.pcap.gz")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(content)
+	gz.Close()
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gzipped, err := isGzip(f); err != nil || !gzipped {
+		t.Fatalf("isGzip() = %v, %v", gzipped, err)
+	}
+	r, err := gunzipPipe(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	plain, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(plain, content) {
+		t.Errorf("gunzipPipe() returned %d bytes, %v", len(plain), err)
+	}
+
+	plainName := filepath.Join(t.TempDir(), "capture.pcap")
+	if err := os.WriteFile(plainName, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.Open(plainName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if gzipped, err := isGzip(f); err != nil || gzipped {
+		t.Errorf("isGzip() on plain pcap = %v, %v", gzipped, err)
+	}
+}
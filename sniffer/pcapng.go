@@ -0,0 +1,189 @@
+package sniffer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/negbie/logp"
+)
+
+const (
+	ngSectionHeader      = 0x0a0d0d0a
+	ngInterfaceBlock     = 0x00000001
+	ngByteOrderMagic     = 0x1a2b3c4d
+	ngMaxLinkTypeScanLen = 1 << 20
+)
+
+// pcapngLinkTypes returns the link types of the interface description blocks
+// in front of the first packet of a pcapng file. It returns nil for pcap files.
+func pcapngLinkTypes(r io.Reader) ([]layers.LinkType, error) {
+	var (
+		linkTypes []layers.LinkType
+		order     binary.ByteOrder = binary.LittleEndian
+		header    [12]byte
+		read      int
+	)
+	for read < ngMaxLinkTypeScanLen {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			if len(linkTypes) > 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+				return linkTypes, nil
+			}
+			return nil, err
+		}
+		blockType := order.Uint32(header[:4])
+		if read == 0 {
+			if binary.LittleEndian.Uint32(header[:4]) != ngSectionHeader {
+				return nil, nil
+			}
+			if _, err := io.ReadFull(r, header[8:12]); err != nil {
+				return nil, err
+			}
+			if binary.BigEndian.Uint32(header[8:12]) == ngByteOrderMagic {
+				order = binary.BigEndian
+			}
+			blockType = ngSectionHeader
+		}
+		length := int(order.Uint32(header[4:8]))
+		if length < 12 || length%4 != 0 {
+			return nil, fmt.Errorf("pcapng block length %d", length)
+		}
+		rest := length - 8
+		switch blockType {
+		case ngSectionHeader:
+			if read == 0 {
+				rest -= 4
+			}
+		case ngInterfaceBlock:
+			if _, err := io.ReadFull(r, header[:2]); err != nil {
+				return nil, err
+			}
+			linkTypes = append(linkTypes, layers.LinkType(order.Uint16(header[:2])))
+			rest -= 2
+		default:
+			// The interfaces are described before their first packet
+			return linkTypes, nil
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, int64(rest)); err != nil {
+			return nil, err
+		}
+		read += length
+	}
+	return linkTypes, nil
+}
+
+func mixedLinkTypes(linkTypes []layers.LinkType) bool {
+	for _, lt := range linkTypes {
+		if lt != linkTypes[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// openFile opens the pcap file and decompresses gzipped ones.
+func openFile(file string) (io.Reader, io.Closer, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if gzipped, err := isGzip(f); err != nil || !gzipped {
+		return bufio.NewReader(f), f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return gz, f, nil
+}
+
+// ngSource reads pcapng files with interfaces of different link types, which
+// libpcap refuses. Each interface gets its own BPF filter and is announced to
+// onInterface before its first packet. Interface indexes start at 1 like
+// the ones of live captures.
+type ngSource struct {
+	reader      *pcapgo.NgReader
+	closer      io.Closer
+	linkType    layers.LinkType
+	filter      string
+	snaplen     int
+	bpfs        map[int]*pcap.BPF
+	onInterface func(index int, name string, linkType layers.LinkType)
+}
+
+// openNg returns a ngSource if file is a pcapng file with mixed link types.
+func openNg(file, filter string, snaplen int) (*ngSource, error) {
+	r, c, err := openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	linkTypes, err := pcapngLinkTypes(r)
+	c.Close()
+	if err != nil || !mixedLinkTypes(linkTypes) {
+		return nil, nil
+	}
+
+	if r, c, err = openFile(file); err != nil {
+		return nil, err
+	}
+	reader, err := pcapgo.NewNgReader(r, pcapgo.NgReaderOptions{WantMixedLinkType: true, SkipUnknownVersion: true})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	logp.Info("Read %s with the link types %v of its interfaces", file, linkTypes)
+	return &ngSource{reader: reader, closer: c, linkType: linkTypes[0], filter: filter, snaplen: snaplen, bpfs: make(map[int]*pcap.BPF)}, nil
+}
+
+func (ng *ngSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := ng.reader.ReadPacketData()
+		if err != nil {
+			return data, ci, err
+		}
+		bpf, ok := ng.bpfs[ci.InterfaceIndex]
+		if !ok {
+			bpf = ng.newInterface(ci.InterfaceIndex)
+		}
+		ci.InterfaceIndex++
+		if bpf == nil || bpf.Matches(ci, data) {
+			return data, ci, nil
+		}
+	}
+}
+
+func (ng *ngSource) newInterface(index int) *pcap.BPF {
+	iface, err := ng.reader.Interface(index)
+	if err != nil {
+		logp.Warn("pcapng interface %d: %v", index, err)
+		ng.bpfs[index] = nil
+		return nil
+	}
+	bpf, err := pcap.NewBPF(iface.LinkType, ng.snaplen, ng.filter)
+	if err != nil {
+		logp.Warn("BPF '%s' for pcapng interface %s with link type %v: %v", ng.filter, iface.Name, iface.LinkType, err)
+	}
+	ng.bpfs[index] = bpf
+	if ng.onInterface != nil {
+		ng.onInterface(index+1, iface.Name, iface.LinkType)
+	}
+	return bpf
+}
+
+// LinkType returns the link type of the first interface.
+func (ng *ngSource) LinkType() layers.LinkType {
+	return ng.linkType
+}
+
+func (ng *ngSource) Close() error {
+	return ng.closer.Close()
+}
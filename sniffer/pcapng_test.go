@@ -0,0 +1,92 @@
+package sniffer
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/negbie/heplify/config"
+	"github.com/negbie/heplify/decoder"
+)
+
+func TestPcapngMixedLinkTypes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mixed.pcapng")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := pcapgo.NewNgWriterInterface(f, pcapgo.NgInterface{Name: "eth0", LinkType: layers.LinkTypeEthernet, SnapLength: 65535}, pcapgo.DefaultNgWriterOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sll, err := w.AddInterface(pcapgo.NgInterface{Name: "any", LinkType: layers.LinkTypeLinuxSLL, SnapLength: 65535})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sip := []byte("OPTIONS sip:bob@example.com SIP/2.0\r\nCall-ID: ng@host\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	eth := udpFrame(t, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 5060, 5060, sip)
+	// Linux cooked header with the IPv4 protocol instead of the ethernet header
+	cooked := append([]byte{0, 0, 0, 1, 0, 6, 0, 1, 2, 3, 4, 5, 0, 0, 0x08, 0x00}, eth[14:]...)
+	for i, frame := range [][]byte{eth, cooked} {
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(frame), Length: len(frame), InterfaceIndex: []int{0, sll}[i]}
+		if err := w.WritePacket(ci, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	ng, err := openNg(file, "", 65535)
+	if err != nil || ng == nil {
+		t.Fatalf("openNg() = %v, %v", ng, err)
+	}
+	defer ng.Close()
+	if ng.LinkType() != layers.LinkTypeEthernet {
+		t.Errorf("LinkType() = %v", ng.LinkType())
+	}
+
+	nodeIDs := config.Cfg.HepNodeIDs
+	defer func() { config.Cfg.HepNodeIDs = nodeIDs }()
+	config.Cfg.HepNodeIDs = "eth0=2003,any=2004"
+	d := decoder.NewDecoder(ng.LinkType())
+	ng.onInterface = d.SetInterface
+	for i, iface := range []string{"eth0", "any"} {
+		data, ci, err := ng.ReadPacketData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt, err := d.Process(data, &ci)
+		if err != nil || pkt == nil {
+			t.Fatalf("Process() of %s packet = %v, %v", iface, pkt, err)
+		}
+		if pkt.IfaceName != iface || string(pkt.Payload) != string(sip) {
+			t.Errorf("packet of %s decoded as %s with payload %q", iface, pkt.IfaceName, pkt.Payload)
+		}
+		// The first pcapng interface 0 is announced and sent as index 1
+		if pkt.IfaceIndex != i+1 || pkt.NodeID != uint32(2003+i) {
+			t.Errorf("packet of %s has interface index %d and node ID %d", iface, pkt.IfaceIndex, pkt.NodeID)
+		}
+	}
+}
+
+func TestPcapngLinkTypesOfPcap(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "plain.pcap")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcapgo.NewWriter(f).WriteFileHeader(65535, layers.LinkTypeEthernet)
+	f.Close()
+
+	if ng, err := openNg(file, "", 65535); ng != nil || err != nil {
+		t.Errorf("openNg() of a pcap file = %v, %v", ng, err)
+	}
+}
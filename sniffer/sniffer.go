@@ -6,11 +6,11 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/afpacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/negbie/heplify/config"
@@ -19,16 +19,23 @@ import (
 	"github.com/negbie/logp"
 )
 
+// minSIPSnaplen is the smallest snaplen which doesn't cut
+// a SIP message sent in a full sized ethernet frame.
+const minSIPSnaplen = 1600
+
 type SnifferSetup struct {
-	pcapHandle     *pcap.Handle
-	afpacketHandle *afpacketHandle
-	config         *config.InterfacesConfig
-	isAlive        bool
-	dumpChan       chan DumpPacket
-	mode           string
-	filter         string
-	worker         Worker
-	DataSource     gopacket.PacketDataSource
+	pcapHandle *pcap.Handle
+	handle     captureHandle
+	config     *config.InterfacesConfig
+	isAlive    bool
+	dumpChan   chan DumpPacket
+	mode       string
+	filter     string
+	worker     Worker
+	ifaceIndex int
+	dedup      *frameDedup
+	DataSource gopacket.PacketDataSource
+	ngSource   *ngSource
 }
 
 type DumpPacket struct {
@@ -39,20 +46,43 @@ type DumpPacket struct {
 type MainWorker struct {
 	publisher *publish.Publisher
 	decoder   *decoder.Decoder
+	warmup    time.Time
 }
 
 type Worker interface {
 	OnPacket(data []byte, ci *gopacket.CaptureInfo)
 }
 
+// interfaceWorker is a Worker which can decode packets of capture
+// interfaces with different link types.
+type interfaceWorker interface {
+	SetInterface(index int, name string, linkType layers.LinkType)
+}
+
 type WorkerFactory func(layers.LinkType) (Worker, error)
 
 func NewWorker(lt layers.LinkType) (Worker, error) {
 	var o publish.Outputer
 	var err error
 
-	if config.Cfg.HepServer != "" {
-		o, err = publish.NewHEPOutputer(config.Cfg.HepServer)
+	if config.Cfg.DryRun > 0 {
+		return NewDryRunWorker(lt), nil
+	}
+	if config.Cfg.ExtractCall != "" {
+		return NewExtractWorker(lt)
+	}
+
+	var hash *publish.HashOutputer
+	if strings.Contains(config.Cfg.HepServer, ",") {
+		if hash, err = publish.NewHashOutputer(strings.Split(config.Cfg.HepServer, ",")); err == nil {
+			o = hash
+		}
+	} else if config.Cfg.HepServer != "" {
+		// The weight of a single server doesn't matter
+		var addr string
+		if addr, _, err = publish.ParseWeightedAddr(config.Cfg.HepServer); err == nil {
+			o, err = publish.NewHEPOutputer(addr)
+		}
 	} else {
 		o, err = publish.NewFileOutputer()
 	}
@@ -63,17 +93,45 @@ func NewWorker(lt layers.LinkType) (Worker, error) {
 	p := publish.NewPublisher(o)
 	d := decoder.NewDecoder(lt)
 	w := &MainWorker{publisher: p, decoder: d}
+	d.SendEvents(p.PublishEvent)
+	if hash != nil {
+		d.ReloadWeights(hash.SetWeights)
+	}
+	if config.Cfg.WarmupSeconds > 0 {
+		w.warmup = time.Now().Add(time.Duration(config.Cfg.WarmupSeconds) * time.Second)
+		logp.Info("Warmup for %d seconds, HEP output starts at %v", config.Cfg.WarmupSeconds, w.warmup)
+	}
 	return w, nil
 }
 
+func (mw *MainWorker) SetInterface(index int, name string, linkType layers.LinkType) {
+	mw.decoder.SetInterface(index, name, linkType)
+}
+
 func (mw *MainWorker) OnPacket(data []byte, ci *gopacket.CaptureInfo) {
 	pkt, err := mw.decoder.Process(data, ci)
 	if err != nil {
 		logp.Err("OnPacket %v", err)
 	}
 	if pkt != nil {
+		// During warmup only the correlation caches are filled
+		if !mw.warmup.IsZero() {
+			if time.Now().Before(mw.warmup) {
+				return
+			}
+			mw.warmup = time.Time{}
+			logp.Info("Warmup finished, start sending HEP")
+		}
 		mw.publisher.PublishEvent(pkt)
 	}
+	for _, stacked := range mw.decoder.StackedPackets() {
+		if mw.warmup.IsZero() {
+			mw.publisher.PublishEvent(stacked)
+		}
+	}
+	for _, report := range mw.decoder.MOSReports() {
+		mw.publisher.PublishEvent(report)
+	}
 }
 
 func (sniffer *SnifferSetup) setFromConfig() error {
@@ -83,7 +141,7 @@ func (sniffer *SnifferSetup) setFromConfig() error {
 		sniffer.config.Snaplen = 65535
 	}
 
-	if sniffer.config.Type != "af_packet" {
+	if _, ok := captureBackends[sniffer.config.Type]; !ok {
 		sniffer.config.Type = "pcap"
 	}
 
@@ -101,67 +159,102 @@ func (sniffer *SnifferSetup) setFromConfig() error {
 		sniffer.filter = "(greater 256 and portrange " + sniffer.config.PortRange + " or ip[6:2] & 0x1fff != 0) or (ip and ip[6] & 0x2 = 0 and ip[6:2] & 0x1fff = 0 and udp and udp[8] & 0xc0 = 0x80 and udp[9] >= 0xc8 && udp[9] <= 0xcc)"
 	}
 
+	switch config.Cfg.CaptureMode {
+	case "", "both", "media":
+	case "signaling":
+		if sniffer.mode == "SIPRTCP" || sniffer.mode == "SIPRTP" {
+			// Don't capture media which the decoder would skip anyway
+			sniffer.filter = "(greater 256 and portrange " + sniffer.config.PortRange + " or ip[6:2] & 0x1fff != 0)"
+		}
+	default:
+		return fmt.Errorf("unknown capture mode: %s", config.Cfg.CaptureMode)
+	}
+
+	if config.Cfg.SIPPorts != "" {
+		sniffer.filter = fmt.Sprintf("%s or (greater 256 and (%s))", sniffer.filter, sipPortsFilter(config.Cfg.SIPPorts))
+	}
+	if config.Cfg.Radius {
+		sniffer.filter = fmt.Sprintf("%s or udp port 1813", sniffer.filter)
+	}
 	if sniffer.config.WithErspan {
 		sniffer.filter = fmt.Sprintf("%s or proto 47", sniffer.filter)
 	}
+	if sniffer.config.WithL2TP {
+		sniffer.filter = fmt.Sprintf("%s or udp port 1701 or proto 115", sniffer.filter)
+	}
 	if sniffer.config.WithVlan {
 		sniffer.filter = fmt.Sprintf("%s or (vlan and (%s))", sniffer.filter, sniffer.filter)
 	}
 
-	logp.Info("Sniffer [type:%s, device:%s, mode:%s] OS [type:%s, arch:%s]",
-		sniffer.config.Type, sniffer.config.Device, sniffer.mode, runtime.GOOS, runtime.GOARCH)
-
-	switch sniffer.config.Type {
-	case "pcap":
-		if sniffer.config.ReadFile != "" {
-			sniffer.pcapHandle, err = pcap.OpenOffline(sniffer.config.ReadFile)
-			if err != nil {
-				return fmt.Errorf("couldn't open file %v! %v", sniffer.config.ReadFile, err)
-			}
-			err = sniffer.pcapHandle.SetBPFFilter(sniffer.filter)
-			if err != nil {
-				return fmt.Errorf("SetBPFFilter '%s' for ReadFile pcap: %v", sniffer.filter, err)
-			}
-		} else {
-			sniffer.pcapHandle, err = pcap.OpenLive(sniffer.config.Device, int32(sniffer.config.Snaplen), true, pcap.BlockForever)
-			if err != nil {
-				return fmt.Errorf("setting pcap live mode: %v", err)
-			}
-			err = sniffer.pcapHandle.SetBPFFilter(sniffer.filter)
-			if err != nil {
-				return fmt.Errorf("SetBPFFilter '%s' for pcap: %v", sniffer.filter, err)
-			}
-		}
+	logp.Info("Sniffer [type:%s, device:%s, mode:%s, snaplen:%d] OS [type:%s, arch:%s]",
+		sniffer.config.Type, sniffer.config.Device, sniffer.mode, sniffer.config.Snaplen, runtime.GOOS, runtime.GOARCH)
 
-		sniffer.DataSource = gopacket.PacketDataSource(sniffer.pcapHandle)
+	if sniffer.config.ReadFile == "" && sniffer.config.Snaplen < minSIPSnaplen {
+		logp.Warn("Snaplen %d is below %d, SIP packets might get truncated", sniffer.config.Snaplen, minSIPSnaplen)
+	}
 
-	case "af_packet":
-		if sniffer.config.BufferSizeMb <= 0 {
-			sniffer.config.BufferSizeMb = 32
-		}
+	if sniffer.config.ReadFile != "" {
+		return sniffer.openReadFile()
+	}
 
-		szFrame, szBlock, numBlocks, err := afpacketComputeSize(sniffer.config.BufferSizeMb, sniffer.config.Snaplen, os.Getpagesize())
-		if err != nil {
-			return fmt.Errorf("setting af_packet computesize: %v", err)
-		}
+	sniffer.handle, err = openCapture(sniffer.config, sniffer.filter)
+	if err != nil {
+		return err
+	}
+	sniffer.DataSource = sniffer.handle
 
-		sniffer.afpacketHandle, err = newAfpacketHandle(sniffer.config.Device, szFrame, szBlock, numBlocks, pcap.BlockForever)
-		if err != nil {
-			return fmt.Errorf("setting af_packet handle: %v", err)
-		}
+	return nil
+}
 
-		err = sniffer.afpacketHandle.SetBPFFilter(sniffer.filter, sniffer.config.Snaplen)
-		if err != nil {
-			return fmt.Errorf("SetBPFFilter '%s' for af_packet: %v", sniffer.filter, err)
-		}
+// openReadFile opens the ReadFile with libpcap unless it is a pcapng
+// file with interfaces of different link types.
+func (sniffer *SnifferSetup) openReadFile() error {
+	ng, err := openNg(sniffer.config.ReadFile, sniffer.filter, sniffer.config.Snaplen)
+	if err != nil {
+		return fmt.Errorf("couldn't open file %v! %v", sniffer.config.ReadFile, err)
+	}
+	if ng != nil {
+		ng.onInterface = sniffer.setInterface
+		sniffer.ngSource = ng
+		sniffer.DataSource = gopacket.PacketDataSource(ng)
+		return nil
+	}
 
-		sniffer.DataSource = gopacket.PacketDataSource(sniffer.afpacketHandle)
+	sniffer.pcapHandle, err = openOffline(sniffer.config.ReadFile)
+	if err != nil {
+		return fmt.Errorf("couldn't open file %v! %v", sniffer.config.ReadFile, err)
+	}
+	err = sniffer.pcapHandle.SetBPFFilter(sniffer.filter)
+	if err != nil {
+		return fmt.Errorf("SetBPFFilter '%s' for ReadFile pcap: %v", sniffer.filter, err)
+	}
+	sniffer.DataSource = gopacket.PacketDataSource(sniffer.pcapHandle)
+	return nil
+}
 
-	default:
-		return fmt.Errorf("unknown sniffer type: %s", sniffer.config.Type)
+// setInterface tells the worker the name and link type of a pcapng interface.
+func (sniffer *SnifferSetup) setInterface(index int, name string, linkType layers.LinkType) {
+	if w, ok := sniffer.worker.(interfaceWorker); ok {
+		w.SetInterface(index, name, linkType)
 	}
+}
 
-	return nil
+// sipPortsFilter turns a list of ports and port ranges
+// like "5070,8080-8090" into a BPF expression.
+func sipPortsFilter(ports string) string {
+	var exprs []string
+	for _, port := range strings.Split(ports, ",") {
+		port = strings.TrimSpace(port)
+		if port == "" {
+			continue
+		}
+		if strings.Contains(port, "-") {
+			exprs = append(exprs, "portrange "+port)
+		} else {
+			exprs = append(exprs, "port "+port)
+		}
+	}
+	return strings.Join(exprs, " or ")
 }
 
 func New(mode string, cfg *config.InterfacesConfig) (*SnifferSetup, error) {
@@ -171,7 +264,7 @@ func New(mode string, cfg *config.InterfacesConfig) (*SnifferSetup, error) {
 	sniffer.mode = mode
 
 	if sniffer.config.ReadFile == "" {
-		if sniffer.config.Device == "any" && runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		if sniffer.config.Device == "any" && (runtime.GOOS == "windows" || runtime.GOOS == "darwin") {
 			_, err := ListDeviceNames(false, false)
 			return nil, fmt.Errorf("%v -i any is not supported on %s\nPlease use one of the above devices", err, runtime.GOOS)
 		}
@@ -182,6 +275,24 @@ func New(mode string, cfg *config.InterfacesConfig) (*SnifferSetup, error) {
 		return nil, fmt.Errorf("%v Please use one of the above devices", err)
 	}
 
+	if sniffer.config.ReadFile == "" && sniffer.config.Device != "any" {
+		devices, err := pcap.FindAllDevs()
+		if err != nil {
+			return nil, fmt.Errorf("Error getting devices list: %v", err)
+		}
+		if err = checkDevice(sniffer.config.Device, devices); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Cfg.ExtractCall != "" {
+		if sniffer.config.ReadFile == "" {
+			return nil, fmt.Errorf("extracting a call needs a pcap file to read")
+		}
+		// Keep the original timestamps and don't replay in real time
+		sniffer.config.ReadSpeed = true
+	}
+
 	err = sniffer.setFromConfig()
 	if err != nil {
 		return nil, err
@@ -197,6 +308,15 @@ func New(mode string, cfg *config.InterfacesConfig) (*SnifferSetup, error) {
 		go sniffer.dumpPcap()
 	}
 
+	// pcap doesn't report the interface of a packet, so use the capture device
+	if sniffer.config.ReadFile == "" && sniffer.config.Device != "any" {
+		sniffer.ifaceIndex = deviceIndex(sniffer.config.Device)
+	}
+
+	if config.Cfg.DedupWindow > 0 {
+		sniffer.dedup = newFrameDedup(config.Cfg.DedupWindow, sniffer.Datalink())
+	}
+
 	sniffer.isAlive = true
 	go sniffer.printStats()
 
@@ -230,7 +350,7 @@ func (sniffer *SnifferSetup) Run() error {
 		if err == io.EOF {
 			logp.Debug("sniffer", "End of file")
 			loopCount++
-			if sniffer.config.Loop > 0 && loopCount > sniffer.config.Loop {
+			if config.Cfg.DryRun > 0 || config.Cfg.ExtractCall != "" || sniffer.config.Loop > 0 && loopCount > sniffer.config.Loop {
 				// Give the publish goroutine 200 ms to flush
 				time.Sleep(200 * time.Millisecond)
 				sniffer.isAlive = false
@@ -260,6 +380,10 @@ func (sniffer *SnifferSetup) Run() error {
 			continue
 		}
 
+		if sniffer.dedup != nil && sniffer.dedup.isDuplicate(data, ci.Timestamp) {
+			continue
+		}
+
 		if sniffer.config.ReadFile != "" {
 			if lastPktTime != nil && !sniffer.config.ReadSpeed {
 				sleep := ci.Timestamp.Sub(*lastPktTime)
@@ -279,24 +403,37 @@ func (sniffer *SnifferSetup) Run() error {
 			sniffer.dumpChan <- DumpPacket{ci, data}
 		}
 
+		if ci.InterfaceIndex == 0 {
+			ci.InterfaceIndex = sniffer.ifaceIndex
+		}
 		sniffer.worker.OnPacket(data, &ci)
+
+		if dw, ok := sniffer.worker.(*DryRunWorker); ok && dw.packets >= config.Cfg.DryRun {
+			sniffer.isAlive = false
+		}
+	}
+	if dw, ok := sniffer.worker.(*DryRunWorker); ok {
+		fmt.Print(dw.Summary())
+	}
+	if ew, ok := sniffer.worker.(*ExtractWorker); ok {
+		fmt.Print(ew.Close())
 	}
 	sniffer.Close()
 	return retError
 }
 
 func (sniffer *SnifferSetup) Close() error {
-	switch sniffer.config.Type {
-	case "pcap":
+	if sniffer.handle != nil {
+		sniffer.handle.Close()
+	} else if sniffer.ngSource != nil {
+		sniffer.ngSource.Close()
+	} else if sniffer.pcapHandle != nil {
 		sniffer.pcapHandle.Close()
-	case "af_packet":
-		sniffer.afpacketHandle.Close()
 	}
 	return nil
 }
 
 func (sniffer *SnifferSetup) Reopen() error {
-	var err error
 	time.Sleep(250 * time.Millisecond)
 
 	if sniffer.config.Type != "pcap" || sniffer.config.ReadFile == "" {
@@ -304,14 +441,7 @@ func (sniffer *SnifferSetup) Reopen() error {
 	}
 
 	sniffer.Close()
-	sniffer.pcapHandle, err = pcap.OpenOffline(sniffer.config.ReadFile)
-	if err != nil {
-		return err
-	}
-
-	sniffer.DataSource = gopacket.PacketDataSource(sniffer.pcapHandle)
-
-	return nil
+	return sniffer.openReadFile()
 }
 
 func (sniffer *SnifferSetup) Stop() error {
@@ -320,10 +450,12 @@ func (sniffer *SnifferSetup) Stop() error {
 }
 
 func (sniffer *SnifferSetup) Datalink() layers.LinkType {
-	if sniffer.config.Type == "pcap" {
+	if sniffer.handle != nil {
+		return sniffer.handle.LinkType()
+	} else if sniffer.ngSource != nil {
+		return sniffer.ngSource.LinkType()
+	} else if sniffer.pcapHandle != nil {
 		return sniffer.pcapHandle.LinkType()
-	} else if sniffer.config.Type == "af_packet" {
-		return sniffer.afpacketHandle.LinkType()
 	}
 	return layers.LinkTypeEthernet
 }
@@ -341,28 +473,16 @@ func (sniffer *SnifferSetup) printStats() {
 	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	ticker := time.NewTicker(1 * time.Minute)
 
-	var err error
-	var pcapStats *pcap.Stats
-	var afpacketStats afpacket.SocketStatsV3
-
 	for {
 		select {
 		case <-ticker.C:
-			switch sniffer.config.Type {
-			case "pcap":
-				pcapStats, err = sniffer.pcapHandle.Stats()
-				if err != nil {
-					logp.Warn("Stats err: %v", err)
-				}
-				logp.Info("Stats {received dropped-os dropped-int}: {%d %d %d}",
-					pcapStats.PacketsReceived, pcapStats.PacketsDropped, pcapStats.PacketsIfDropped)
-
-			case "af_packet":
-				_, afpacketStats, err = sniffer.afpacketHandle.SocketStats()
-				if err != nil {
-					logp.Warn("Stats err: %v", err)
-				}
-				logp.Info("Stats {received dropped queue-freeze}: %d", afpacketStats)
+			if stats, err := sniffer.handle.Stats(); err != nil {
+				logp.Warn("Stats err: %v", err)
+			} else {
+				logp.Info("%s", stats)
+			}
+			if sniffer.dedup != nil {
+				logp.Info("Mirrored duplicate frames dropped: %d", sniffer.dedup.Dropped())
 			}
 
 		case <-signals: